@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"webhookrelay/fixtures"
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/journal"
+	"webhookrelay/internal/redact"
+)
+
+const fixturesUsage = `usage: webhookrelay fixtures generate -from recordings.jsonl -relay NAME [-out DIR] [-config PATH] [-redact-fields a,b] [-limit N]
+
+Writes one sanitized fixture file per event recorded for relay NAME.
+Recordings use the intake journal format, so a journal file can be used
+directly. Credential headers are always dropped; the relay's redact rules
+from -config and any -redact-fields are applied on top. Replay the files in
+Go tests with the webhookrelay/fixtures package.`
+
+// credentialHeaders never end up in fixture files.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// runFixtures implements "webhookrelay fixtures ...". It returns the exit code.
+func runFixtures(args []string) int {
+	if len(args) == 0 || args[0] != "generate" {
+		fmt.Fprintln(os.Stderr, fixturesUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("fixtures generate", flag.ContinueOnError)
+	from := fs.String("from", "", "Recordings file (intake journal format)")
+	relayName := fs.String("relay", "", "Relay name to generate fixtures for")
+	out := fs.String("out", "", "Output directory (default testdata/fixtures/<relay>)")
+	configPath := fs.String("config", "", "Config file whose relay redact rules are applied (optional)")
+	fields := fs.String("redact-fields", "", "Extra comma-separated field patterns to mask")
+	limit := fs.Int("limit", 0, "Maximum number of fixtures to write (0 = all)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *from == "" || *relayName == "" {
+		fmt.Fprintln(os.Stderr, fixturesUsage)
+		return 2
+	}
+	if *out == "" {
+		*out = filepath.Join("testdata", "fixtures", *relayName)
+	}
+
+	rules := config.RedactConfig{Action: config.RedactMask}
+	if *configPath != "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "load config:", err)
+			return 1
+		}
+		found := false
+		for _, r := range cfg.Relays {
+			if r.Name == *relayName {
+				rules, found = r.Redact, true
+			}
+		}
+		if !found {
+			fmt.Fprintf(os.Stderr, "relay %q is not in %s\n", *relayName, *configPath)
+			return 1
+		}
+	}
+	for _, f := range strings.Split(*fields, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			rules.Fields = append(rules.Fields, f)
+		}
+	}
+	rules.Headers = append(rules.Headers, credentialHeaders...)
+
+	recs, err := journal.ReadFile(*from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read recordings:", err)
+		return 1
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, "create output directory:", err)
+		return 1
+	}
+
+	n := 0
+	for _, rec := range recs {
+		if rec.Op != journal.OpAccept || rec.Relay != *relayName {
+			continue
+		}
+		if *limit > 0 && n >= *limit {
+			break
+		}
+		n++
+
+		f := fixtures.Fixture{
+			Name:       fmt.Sprintf("%04d-%s", n, rec.RequestID),
+			Relay:      rec.Relay,
+			Method:     rec.Method,
+			Path:       rec.Path,
+			Header:     redact.Header(rec.Header, rules),
+			ReceivedAt: rec.ReceivedAt,
+		}
+		if len(rec.Jobs) > 0 {
+			f.Query = redact.Query(rec.Jobs[0].Query, rules)
+		}
+		f.SetBody(redact.Body(rec.Body, rules))
+		if err := fixtures.Write(*out, f); err != nil {
+			fmt.Fprintln(os.Stderr, "write fixture:", err)
+			return 1
+		}
+	}
+
+	fmt.Printf("wrote %d fixtures for relay %q to %s\n", n, *relayName, *out)
+	if n == 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+
+	"webhookrelay/internal/provider"
+)
+
+const initUsage = `usage: webhookrelay init [-provider NAME] [-dest URL]... [-out PATH] [-force]
+
+Prints a commented example config (YAML) that works as it is: one relay
+forwarding to the -dest URLs (a local placeholder without any). With
+-provider, the relay is named after the provider, listens on /hooks/NAME
+and tags events with their event type. -out writes the config to a file
+instead, which must not exist unless -force is given.
+
+Providers: %s.`
+
+// exampleDestination is the destination of an init config without -dest.
+const exampleDestination = "http://localhost:9000/webhook"
+
+// runInit implements "webhookrelay init". It returns the exit code.
+func runInit(args []string) int {
+	usage := fmt.Sprintf(initUsage, strings.Join(provider.Names(), ", "))
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	prov := fs.String("provider", "", "Provider the relay receives webhooks from, e.g. github")
+	var dests repeatedFlag
+	fs.Var(&dests, "dest", "Destination URL (repeatable)")
+	out := fs.String("out", "", "File to write the config to (default: stdout)")
+	force := fs.Bool("force", false, "Overwrite the -out file if it exists")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	b, err := exampleConfig(strings.ToLower(strings.TrimSpace(*prov)), dests)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *out == "" {
+		os.Stdout.Write(b)
+		return 0
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(*out, flags, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		fmt.Fprintf(os.Stderr, "%s already exists; pass -force to overwrite it\n", *out)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s; check it with: webhookrelay validate -config %s\n", *out, *out)
+	return 0
+}
+
+// exampleConfig renders the init config for a relay receiving from prov
+// (none if empty) and forwarding to dests.
+func exampleConfig(prov string, dests []string) ([]byte, error) {
+	if prov != "" && !slices.Contains(provider.Names(), prov) {
+		return nil, fmt.Errorf("unknown provider %q (known: %s)", prov, strings.Join(provider.Names(), ", "))
+	}
+	for _, d := range dests {
+		u, err := url.Parse(d)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("-dest %q is not an http(s) URL", d)
+		}
+	}
+	data := struct {
+		Name, Provider string
+		Destinations   []string
+		Example        bool
+	}{Name: "example", Provider: prov, Destinations: dests}
+	if prov != "" {
+		data.Name = prov
+	}
+	if len(dests) == 0 {
+		data.Destinations, data.Example = []string{exampleDestination}, true
+	}
+	var buf bytes.Buffer
+	if err := exampleTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var exampleTemplate = template.Must(template.New("init").Parse(`# WebhookRelay config, written by "webhookrelay init".
+#
+# Check changes with "webhookrelay validate -config <this file>" and see
+# what the relay will serve with "webhookrelay --config <this file> --dry-run".
+# Every option is described in the README; "webhookrelay schema" prints a
+# JSON Schema editors can complete and check this file with.
+
+server:
+  # Address webhooks are received on.
+  listen_addr: ":8099"
+  # Prefix of every relay's listen path.
+  base_path: /hooks
+  # How long a destination has to answer, and how many deliveries run at
+  # once.
+  forward_timeout_ms: 10000
+  concurrency: 50
+
+# Where queued deliveries, the delivery log and the dead-letter queue are
+# kept. "memory" loses them on restart; "sqlite" keeps them in a file:
+#   backend: sqlite
+#   path: webhookrelay.db
+storage:
+  backend: memory
+
+# Admin API and dashboard (dead-letter queue, replays, live tail):
+# admin:
+#   listen_addr: "127.0.0.1:8098"
+#   token: change-me
+
+relays:
+  # Events sent to /hooks/{{.Name}} are accepted with a 202 and delivered to
+  # every destination; failed deliveries go to the dead-letter queue.
+  - name: {{.Name}}
+    listen_path: /{{.Name}}
+    methods: [POST]
+{{- if .Provider}}
+    # Tag events with their provider and event type, for routing
+    # conditions, templates and the delivery log.
+    detect_provider: true
+{{- end}}
+    destinations:
+{{- if .Example}}
+      # Replace with where events should go.
+{{- end}}
+{{- range .Destinations}}
+      - url: {{printf "%q" .}}
+{{- end}}
+        # Headers sent with every delivery, e.g. for authentication:
+        # headers:
+        #   Authorization: "Bearer ..."
+`))
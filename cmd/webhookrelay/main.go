@@ -1,38 +1,128 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"webhookrelay/internal/alert"
+	"webhookrelay/internal/buildinfo"
+	"webhookrelay/internal/capture"
 	"webhookrelay/internal/config"
+	"webhookrelay/internal/deliverylog"
+	"webhookrelay/internal/health"
+	"webhookrelay/internal/journal"
+	"webhookrelay/internal/loglevel"
+	"webhookrelay/internal/metrics"
+	"webhookrelay/internal/plugin"
 	"webhookrelay/internal/relay"
+	"webhookrelay/internal/script"
+	"webhookrelay/internal/sentry"
 	"webhookrelay/internal/server"
+	"webhookrelay/internal/store"
+	"webhookrelay/internal/tail"
+	"webhookrelay/internal/tracing"
 )
 
 func main() {
-	var configPath string
-	flag.StringVar(&configPath, "config", "", "Path to JSON config file (or set WEBHOOKRELAY_CONFIG)")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "store":
+			os.Exit(runStore(os.Args[2:]))
+		case "fixtures":
+			os.Exit(runFixtures(os.Args[2:]))
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "send":
+			os.Exit(runSend(os.Args[2:]))
+		case "init":
+			os.Exit(runInit(os.Args[2:]))
+		case "schema":
+			os.Stdout.Write(config.Schema())
+			return
+		case "version":
+			fmt.Println(buildinfo.Get())
+			return
+		}
+	}
+
+	var configPath, configFormat, configDir, logLevel, listenAddr, concurrency, forwardTimeout string
+	flag.StringVar(&configPath, "config", "", "Path or http(s) URL of the config file, JSON, YAML or TOML (or set WEBHOOKRELAY_CONFIG)")
+	flag.StringVar(&configFormat, "config-format", "", "Config file format: json, yaml or toml (default: from the file extension, json otherwise)")
+	flag.StringVar(&configDir, "config-dir", "", "Directory of config files merged after --config, e.g. one per team (or set WEBHOOKRELAY_CONFIG_DIR)")
+	var overlays, configHeaders repeatedFlag
+	flag.Var(&overlays, "overlay", "Config file deep-merged over --config, e.g. per environment (repeatable; or set WEBHOOKRELAY_CONFIG_OVERLAY)")
+	flag.Var(&configHeaders, "config-header", `Header sent when fetching a remote config, "Name: value" (repeatable; or set WEBHOOKRELAY_CONFIG_HEADER)`)
+	flag.StringVar(&logLevel, "log-level", "", "Log level: debug, info, warn or error (or set WEBHOOKRELAY_LOG_LEVEL; overrides log_level in the config)")
+	flag.StringVar(&listenAddr, "listen-addr", "", "Override server.listen_addr (or set WEBHOOKRELAY_LISTEN_ADDR)")
+	flag.StringVar(&concurrency, "concurrency", "", "Override server.concurrency, the number of delivery workers (or set WEBHOOKRELAY_CONCURRENCY)")
+	flag.StringVar(&forwardTimeout, "forward-timeout", "", "Override server.forward_timeout_ms, as a duration such as 15s (or set WEBHOOKRELAY_FORWARD_TIMEOUT)")
+	showVersion := flag.Bool("version", false, "Print the version and build information and exit")
+	dryRun := flag.Bool("dry-run", false, "Load the config, print the relays with their listen paths and destinations, and exit")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(buildinfo.Get())
+		return
+	}
+
 	if configPath == "" {
 		configPath = os.Getenv("WEBHOOKRELAY_CONFIG")
 	}
-	if configPath == "" {
-		_, _ = fmt.Fprintln(os.Stderr, "missing config: pass --config or set WEBHOOKRELAY_CONFIG")
+	if configDir == "" {
+		configDir = os.Getenv("WEBHOOKRELAY_CONFIG_DIR")
+	}
+	src, err := configFlags{
+		path: configPath, format: configFormat, dir: configDir, overlays: overlays, headers: configHeaders,
+		listenAddr: listenAddr, concurrency: concurrency, forwardTimeout: forwardTimeout,
+	}.source()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
 		os.Exit(2)
 	}
+	if *dryRun {
+		os.Exit(runDryRun(src))
+	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	if logLevel == "" {
+		logLevel = os.Getenv("WEBHOOKRELAY_LOG_LEVEL")
+	}
+	base := slog.LevelInfo
+	if logLevel != "" {
+		l, err := loglevel.Parse(logLevel)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "invalid log level %q: %v\n", logLevel, err)
+			os.Exit(2)
+		}
+		base = l
+	}
+	logger, level := loglevel.NewJSON(os.Stdout, base)
+	logger.Info("webhookrelay starting", buildinfo.Get().Attrs()...)
 
-	cfg, err := config.Load(configPath)
+	cfg, configFiles, err := src.LoadFiles()
 	if err != nil {
 		logger.Error("failed to load config", "error", err)
 		os.Exit(1)
 	}
+	if logLevel == "" && cfg.LogLevel != "" {
+		l, _ := loglevel.Parse(cfg.LogLevel)
+		level.SetBase(l)
+	}
+	logWarnings(logger, cfg)
 
 	resolved, err := config.ResolveRelays(cfg)
 	if err != nil {
@@ -40,20 +130,259 @@ func main() {
 		os.Exit(1)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st, err := store.Open(ctx, cfg.Storage)
+	if err != nil {
+		logger.Error("failed to open storage", "backend", cfg.Storage.Backend, "error", err)
+		os.Exit(1)
+	}
+	defer st.Close()
+
+	if cfg.Storage.SpoolDir != "" {
+		sp, err := store.NewSpool(st, cfg.Storage.SpoolDir, cfg.Storage.SpoolMaxBytes, logger)
+		if err != nil {
+			logger.Error("failed to open spool", "dir", cfg.Storage.SpoolDir, "error", err)
+			os.Exit(1)
+		}
+		go sp.Run(ctx)
+		st = sp
+	}
+
+	if err := saveSnapshot(ctx, st, cfg); err != nil {
+		logger.Warn("failed to save config snapshot", "error", err)
+	}
+	go store.RunRetention(ctx, st, cfg.Storage.Retention(), logger)
+
+	alerts := alert.New(cfg.Alerts, st, logger)
+	go alerts.Run(ctx)
+
+	if cfg.Tracing.Enabled {
+		shutdown, err := tracing.Setup(ctx, cfg.Tracing)
+		if err != nil {
+			logger.Error("failed to set up tracing", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Warn("failed to flush traces", "error", err)
+			}
+		}()
+		logger.Info("tracing enabled", "protocol", cfg.Tracing.OTLP.Protocol, "endpoint", cfg.Tracing.OTLP.Endpoint, "service_name", cfg.Tracing.ServiceName)
+	}
+
+	var mtr *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		mtr = metrics.New(st.QueueLen, cfg.Metrics.Labels)
+		if cfg.Tracing.Enabled && cfg.Telemetry.Prometheus() {
+			mtr.EnableExemplars()
+		}
+	}
+	if mtr != nil && cfg.Telemetry.PushOTLP() {
+		shutdown, err := mtr.PushOTLP(ctx, cfg.Telemetry)
+		if err != nil {
+			logger.Error("failed to set up otlp metrics", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Warn("failed to push final metrics", "error", err)
+			}
+		}()
+		logger.Info("otlp metrics enabled", "endpoint", cfg.Telemetry.OTLP.Endpoint, "interval_ms", cfg.Telemetry.IntervalMS)
+	}
+	if mtr != nil && cfg.Telemetry.PushStatsD() {
+		shutdown, err := mtr.PushStatsD(ctx, cfg.Telemetry)
+		if err != nil {
+			logger.Error("failed to set up statsd metrics", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Warn("failed to stop statsd metrics", "error", err)
+			}
+		}()
+		logger.Info("statsd metrics enabled", "addr", cfg.Telemetry.StatsD.Addr(), "prefix", cfg.Telemetry.StatsD.Prefix)
+	}
+	// MetricsConfig only tells the server where to serve metrics for
+	// scraping.
+	metricsCfg := cfg.Metrics
+	if !cfg.Telemetry.Prometheus() {
+		metricsCfg = config.MetricsConfig{}
+	}
+
+	var jnl *journal.Journal
+	if cfg.Journal.Path != "" {
+		var pending []journal.Record
+		jnl, pending, err = journal.Open(journal.Options{
+			Path:     cfg.Journal.Path,
+			Fsync:    cfg.Journal.Fsync,
+			Interval: cfg.Journal.FsyncInterval(),
+			MaxBytes: cfg.Journal.MaxBytes,
+			Logger:   logger,
+		})
+		if err != nil {
+			logger.Error("failed to open journal", "path", cfg.Journal.Path, "error", err)
+			os.Exit(1)
+		}
+		defer jnl.Close()
+
+		// Events accepted but never queued before the last shutdown.
+		for _, rec := range pending {
+			if err := st.Enqueue(ctx, rec.QueueJobs()...); err != nil {
+				logger.Error("failed to replay journal", "request_id", rec.RequestID, "error", err)
+				os.Exit(1)
+			}
+			if err := jnl.Commit(rec.RequestID); err != nil {
+				logger.Error("failed to replay journal", "request_id", rec.RequestID, "error", err)
+				os.Exit(1)
+			}
+		}
+		if len(pending) > 0 {
+			logger.Info("replayed journal", "events", len(pending))
+		}
+	}
+
+	var dlog *deliverylog.Writer
+	if dl := cfg.DeliveryLogFile; dl.Path != "" {
+		dlog, err = deliverylog.Open(deliverylog.Options{
+			Path:     dl.Path,
+			MaxBytes: dl.MaxBytes,
+			MaxAge:   dl.MaxAge(),
+			MaxFiles: dl.MaxFiles,
+			Compress: dl.Compress,
+			Logger:   logger,
+		})
+		if err != nil {
+			logger.Error("failed to open delivery log file", "path", dl.Path, "error", err)
+			os.Exit(1)
+		}
+		defer dlog.Close()
+	}
+
+	reporter, err := sentry.New(cfg.Sentry, logger)
+	if err != nil {
+		logger.Error("failed to set up sentry", "error", err)
+		os.Exit(1)
+	}
+	if reporter != nil {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			reporter.Close(ctx)
+		}()
+		logger.Info("sentry reporting enabled", "environment", cfg.Sentry.Environment)
+	}
+
+	checker := health.New(resolved, logger)
+	go checker.Run(ctx)
+
+	var hub *tail.Hub
+	var capt *capture.Capture
+	if cfg.Admin.ListenAddr != "" {
+		hub = tail.New()
+		capt = capture.New(logger, cfg.Admin.InspectMaxBodyBytes)
+	}
 	fwd := relay.NewForwarder(relay.ForwarderConfig{
 		Logger:         logger,
+		Store:          st,
+		Journal:        jnl,
+		Alerts:         alerts,
+		Metrics:        mtr,
+		Tail:           hub,
+		DeliveryLog:    dlog,
+		Capture:        capt,
+		Health:         checker,
+		Sentry:         reporter,
 		Concurrency:    cfg.Server.Concurrency,
 		ForwardTimeout: cfg.Server.ForwardTimeout(),
+		SlowForward:    cfg.Server.SlowForward,
 	})
+	plugins, err := plugin.LoadAll(ctx, resolved)
+	if err != nil {
+		logger.Error("failed to load plugins", "error", err)
+		os.Exit(1)
+	}
+
+	scripts, err := script.LoadAll(resolved, logger)
+	if err != nil {
+		logger.Error("failed to load scripts", "error", err)
+		os.Exit(1)
+	}
+
+	var accessLogger *slog.Logger
+	if al := cfg.Server.AccessLog; al.Enabled {
+		accessLogger = logger
+		if al.Path != "" {
+			f, err := os.OpenFile(al.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				logger.Error("failed to open access log", "path", al.Path, "error", err)
+				os.Exit(1)
+			}
+			defer f.Close()
+			accessLogger = slog.New(slog.NewJSONHandler(f, nil))
+		}
+	}
 
 	srv := server.New(server.Config{
-		Logger:     logger,
-		ListenAddr: cfg.Server.ListenAddr,
-		Relays:     resolved,
-		Forwarder:  fwd,
+		Logger:        logger,
+		ListenAddr:    cfg.Server.ListenAddr,
+		TLS:           cfg.Server.TLS,
+		SocketMode:    cfg.Server.SocketMode,
+		Listeners:     cfg.Server.Listeners,
+		Relays:        resolved,
+		Forwarder:     fwd,
+		Deliveries:    st,
+		Queue:         st,
+		Tail:          hub,
+		Health:        checker,
+		Capture:       capt,
+		Sentry:        reporter,
+		LogLevel:      level,
+		Deadline:      cfg.Server.Deadline,
+		AccessLog:     accessLogger,
+		Admin:         cfg.Admin,
+		Plugins:       plugins,
+		Scripts:       scripts,
+		Metrics:       mtr,
+		MetricsConfig: metricsCfg,
 	})
+	fwd.SetLocalRelays(srv.Handler(), resolved)
+	fwd.UpdateTimeouts(resolved)
+	fwd.Start()
+	defer fwd.Stop()
 
-	logger.Info("starting server", "listen_addr", cfg.Server.ListenAddr, "relay_count", len(resolved))
+	rl := &reloader{src: src, files: configFiles, log: logger, srv: srv, fwd: fwd, st: st, health: checker, cfg: cfg, resolved: resolved, plugins: plugins}
+	if logLevel == "" {
+		rl.level = level
+	}
+	if cfg.Admin.RelaysFile != "" {
+		srv.SetRelayEditor(rl)
+	}
+	defer rl.close(ctx)
+	go rl.run(ctx)
+
+	logger.Info("starting server", "listen_addr", cfg.Server.ListenAddr, "tls", cfg.Server.TLS.Enabled(), "relay_count", len(resolved), "storage", cfg.Storage.Backend)
+	for _, l := range cfg.Server.Listeners {
+		logger.Info("listener", "name", l.Name, "listen_addr", l.ListenAddr, "tls", l.TLS.Enabled(), "relays", l.Relays)
+	}
+	if cfg.Admin.ListenAddr != "" {
+		logger.Info("admin api enabled", "listen_addr", cfg.Admin.ListenAddr, "token", cfg.Admin.Token != "")
+	}
+	if metricsCfg.Enabled {
+		addr := cfg.Metrics.ListenAddr
+		if addr == "" {
+			addr = cfg.Admin.ListenAddr
+		}
+		logger.Info("prometheus metrics enabled", "listen_addr", addr, "path", cfg.Metrics.Path)
+	}
 	for _, r := range resolved {
 		logger.Info("relay", "name", r.Name, "id", r.ID, "path", r.ListenPath, "methods", r.Methods, "destinations", len(r.Destinations))
 	}
@@ -63,3 +392,125 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// configFlags are the flags, or environment variables, that say where the
+// config comes from and which of its settings to override.
+type configFlags struct {
+	path, format, dir string
+	overlays, headers []string
+	// Server settings that override the config's; empty ones fall back to
+	// their environment variable.
+	listenAddr, concurrency, forwardTimeout string
+}
+
+// source returns the config source of the flags or, when they name no file
+// or directory, of the environment (see config.EnvSource). Overlays default
+// to the paths in WEBHOOKRELAY_CONFIG_OVERLAY.
+func (f configFlags) source() (config.Source, error) {
+	overlays := f.overlays
+	if len(overlays) == 0 {
+		overlays = filepath.SplitList(os.Getenv("WEBHOOKRELAY_CONFIG_OVERLAY"))
+	}
+	overrides, err := f.overrides()
+	if err != nil {
+		return config.Source{}, err
+	}
+	if f.path == "" && f.dir == "" {
+		src, ok, err := config.EnvSource(os.Environ())
+		if err != nil {
+			return config.Source{}, fmt.Errorf("config from the environment: %w", err)
+		}
+		if !ok {
+			return config.Source{}, errors.New("missing config: pass --config or --config-dir, or set WEBHOOKRELAY_CONFIG, WEBHOOKRELAY_CONFIG_DIR, WEBHOOKRELAY_CONFIG_JSON or WEBHOOKRELAY_RELAY_0_*")
+		}
+		src.Overlays, src.Overrides = overlays, overrides
+		return src, nil
+	}
+	header, err := configHeader(f.headers)
+	if err != nil {
+		return config.Source{}, err
+	}
+	return config.Source{Path: f.path, Format: f.format, Dir: f.dir, Overlays: overlays, Overrides: overrides, Header: header}, nil
+}
+
+// overrides returns the server settings of the flags and environment as a
+// config fragment, nil when there are none.
+func (f configFlags) overrides() (map[string]any, error) {
+	or := func(v, env string) string {
+		if v == "" {
+			return os.Getenv(env)
+		}
+		return v
+	}
+	server := map[string]any{}
+	if v := or(f.listenAddr, config.EnvListenAddr); v != "" {
+		server["listen_addr"] = v
+	}
+	if v := or(f.concurrency, "WEBHOOKRELAY_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid concurrency %q: want a positive number", v)
+		}
+		server["concurrency"] = n
+	}
+	if v := or(f.forwardTimeout, "WEBHOOKRELAY_FORWARD_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Millisecond {
+			return nil, fmt.Errorf("invalid forward timeout %q: want a duration such as 15s", v)
+		}
+		server["forward_timeout_ms"] = d.Milliseconds()
+	}
+	if len(server) == 0 {
+		return nil, nil
+	}
+	return map[string]any{"server": server}, nil
+}
+
+// configHeader returns the headers to fetch a remote config with: the
+// "Name: value" flags, then the lines of WEBHOOKRELAY_CONFIG_HEADER.
+func configHeader(flags []string) (http.Header, error) {
+	lines := append([]string(nil), flags...)
+	for _, l := range strings.Split(os.Getenv("WEBHOOKRELAY_CONFIG_HEADER"), "\n") {
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+	h := http.Header{}
+	for _, l := range lines {
+		k, v, ok := strings.Cut(l, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("config header %q: want \"Name: value\"", l)
+		}
+		h.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	return h, nil
+}
+
+// snapshotsKept is how many config snapshots the store keeps.
+const snapshotsKept = 50
+
+// saveSnapshot records the effective config, its credentials removed, in the
+// store so operators can see what a relay instance was running with. A
+// config the same as the latest snapshot's is not recorded again, and of a
+// config decrypted on load only the hash is: any of its values may be one
+// that was encrypted.
+func saveSnapshot(ctx context.Context, st store.Store, cfg config.Config) error {
+	b, err := config.RedactedJSON(cfg)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+	if latest, err := st.LatestSnapshot(ctx); err == nil && latest.Hash == hash {
+		return nil
+	}
+	snap := store.Snapshot{Hash: hash, Config: b, At: time.Now().UTC()}
+	if cfg.Encrypted {
+		snap.Config = nil
+	}
+	if err := st.SaveSnapshot(ctx, snap); err != nil {
+		return err
+	}
+	_, err = st.PruneSnapshots(ctx, snapshotsKept)
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// runDryRun implements --dry-run: it loads the config in src, resolves its
+// relays and prints the routing table, without opening storage or starting
+// to listen. It returns the exit code.
+func runDryRun(src config.Source) int {
+	cfg, err := src.Load()
+	if err != nil {
+		printProblems(errorProblems(err))
+		return 1
+	}
+	resolved, err := config.ResolveRelays(cfg)
+	if err != nil {
+		printProblems(errorProblems(err))
+		return 1
+	}
+	printPlan(os.Stdout, cfg, resolved)
+	return 0
+}
+
+func printProblems(problems []string) {
+	fmt.Fprintf(os.Stderr, "%d problem(s):\n", len(problems))
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "  - %s\n", p)
+	}
+}
+
+// printPlan prints the listeners, the destination defaults and the relays
+// of cfg with their effective destinations. Header values are left out,
+// since they often hold credentials.
+func printPlan(w io.Writer, cfg config.Config, resolved []config.ResolvedRelay) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	s := cfg.Server
+	fmt.Fprintln(tw, "Server")
+	fmt.Fprintf(tw, "  listen_addr\t%s\n", listenAddrText(s.ListenAddr, s.TLS.Enabled()))
+	for _, l := range s.Listeners {
+		relays := "all relays"
+		if len(l.Relays) > 0 {
+			relays = strings.Join(l.Relays, ", ")
+		}
+		fmt.Fprintf(tw, "  listener %s\t%s (%s)\n", l.Name, listenAddrText(l.ListenAddr, l.TLS.Enabled()), relays)
+	}
+	if s.BasePath != "" {
+		fmt.Fprintf(tw, "  base_path\t%s\n", s.BasePath)
+	}
+	fmt.Fprintf(tw, "  concurrency\t%d\n", s.Concurrency)
+	fmt.Fprintf(tw, "  forward_timeout\t%s\n", s.ForwardTimeout())
+	if cfg.Admin.ListenAddr != "" {
+		fmt.Fprintf(tw, "  admin\t%s\n", listenAddrText(cfg.Admin.ListenAddr, cfg.Admin.TLS.Enabled()))
+	}
+
+	d := cfg.Defaults.Destination
+	fmt.Fprintln(tw, "\nDestination defaults")
+	fmt.Fprintf(tw, "  method\t%s\n", methodText(d.Method))
+	timeout := s.ForwardTimeout()
+	if d.TimeoutMS > 0 {
+		timeout = time.Duration(d.TimeoutMS) * time.Millisecond
+	}
+	fmt.Fprintf(tw, "  timeout\t%s\n", timeout)
+	fmt.Fprintf(tw, "  headers\t%s\n", headerNames(d.Headers))
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nRelays (%d)\n", len(resolved))
+	generated := false
+	for i, r := range resolved {
+		name := r.Name
+		if name == "" {
+			name = "(unnamed)"
+		}
+		path := r.ListenPath
+		switch {
+		case r.PathRegex != nil:
+			path = "regex " + path
+		case cfg.Relays[i].ListenPath == "":
+			path += " (generated)"
+			generated = true
+		}
+		var limits string
+		if cfg.Relays[i].ForwardTimeoutMS > 0 {
+			limits += fmt.Sprintf("  forward_timeout=%s", r.ForwardTimeout)
+		}
+		if r.Concurrency > 0 {
+			limits += fmt.Sprintf("  concurrency=%d", r.Concurrency)
+		}
+		if r.Disabled {
+			limits += fmt.Sprintf("  disabled (answers %d)", r.DisabledStatus)
+		}
+		fmt.Fprintf(w, "  %s  %s  %s  id=%s%s\n", name, strings.Join(r.Methods, ","), path, r.ID, limits)
+		dw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		printDestinations(dw, "    -> ", r.Destinations, r.ForwardTimeout)
+		for _, rt := range r.Routes {
+			route := rt.Name
+			if route == "" {
+				route = "(unnamed)"
+			}
+			fmt.Fprintf(dw, "    route %s\n", route)
+			printDestinations(dw, "      -> ", rt.Destinations, r.ForwardTimeout)
+		}
+		dw.Flush()
+	}
+	if generated {
+		fmt.Fprintln(w, "\nGenerated listen paths are new on every start (a reload keeps them); set listen_path to keep one.")
+	}
+}
+
+// printDestinations prints a line per destination: its type, method, URL
+// (or target relay), timeout and what else sets it apart.
+func printDestinations(w io.Writer, indent string, dests []config.DestinationConfig, forwardTimeout time.Duration) {
+	for _, d := range dests {
+		target := d.URL
+		switch d.Type {
+		case config.DestinationRelay:
+			target = "relay " + d.Relay
+		case config.DestinationDrop:
+			target = "-"
+		}
+		timeout := forwardTimeout
+		if d.TimeoutMS > 0 {
+			timeout = time.Duration(d.TimeoutMS) * time.Millisecond
+		}
+		var notes []string
+		if d.Shadow {
+			notes = append(notes, "shadow")
+		}
+		if d.Fallback {
+			notes = append(notes, "fallback")
+		}
+		if d.When != nil {
+			notes = append(notes, "conditional")
+		}
+		if d.Weight > 0 {
+			notes = append(notes, fmt.Sprintf("weight %d", d.Weight))
+		}
+		if len(d.Headers) > 0 {
+			notes = append(notes, "headers "+headerNames(d.Headers))
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%s", indent, d.Type, methodText(d.Method), target, timeout)
+		if len(notes) > 0 {
+			fmt.Fprintf(w, "  %s", strings.Join(notes, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+func listenAddrText(addr string, tls bool) string {
+	if addr == "" {
+		return "-"
+	}
+	if tls {
+		return addr + " (tls)"
+	}
+	return addr
+}
+
+// methodText is the method a destination sends with: its own, or the
+// inbound request's.
+func methodText(m string) string {
+	if m == "" {
+		return "(inbound)"
+	}
+	return m
+}
+
+func headerNames(h map[string]string) string {
+	if len(h) == 0 {
+		return "-"
+	}
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/server"
+)
+
+// The reloader edits the relays of admin.relays_file for the admin API (see
+// server.RelayEditor): the file is changed and the config reloaded, and the
+// file is put back as it was when the new config does not apply.
+
+func (r *reloader) CreateRelay(ctx context.Context, relay map[string]any) error {
+	return r.editRelays(ctx, func(m *config.ManagedRelays) error {
+		if slices.ContainsFunc(r.cfg.Relays, func(rc config.RelayConfig) bool { return rc.Name == relay["name"] }) {
+			return server.ErrRelayExists
+		}
+		m.Relays = append(m.Relays, relay)
+		return nil
+	})
+}
+
+func (r *reloader) UpdateRelay(ctx context.Context, name string, relay map[string]any) error {
+	return r.editRelays(ctx, func(m *config.ManagedRelays) error {
+		i, err := r.managedRelay(*m, name)
+		if err != nil {
+			return err
+		}
+		m.Relays[i] = relay
+		return nil
+	})
+}
+
+func (r *reloader) DeleteRelay(ctx context.Context, name string) error {
+	return r.editRelays(ctx, func(m *config.ManagedRelays) error {
+		i, err := r.managedRelay(*m, name)
+		if err != nil {
+			return err
+		}
+		m.Relays = slices.Delete(m.Relays, i, i+1)
+		m.Stopped = slices.DeleteFunc(m.Stopped, func(s string) bool { return s == name })
+		return nil
+	})
+}
+
+// SetStopped records the state of a relay; the running config does not
+// change.
+func (r *reloader) SetStopped(name string, stopped bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path := r.src.RelaysFilePath(r.cfg.Admin.RelaysFile)
+	m, err := config.ReadManagedRelays(path)
+	if err != nil {
+		return err
+	}
+	m.Stopped = slices.DeleteFunc(m.Stopped, func(s string) bool { return s == name })
+	if stopped {
+		m.Stopped = append(m.Stopped, name)
+	}
+	return config.WriteManagedRelays(path, m)
+}
+
+// managedRelay returns the position of the relay named name in m.
+func (r *reloader) managedRelay(m config.ManagedRelays, name string) (int, error) {
+	if i := m.Index(name); i >= 0 {
+		return i, nil
+	}
+	if slices.ContainsFunc(r.cfg.Relays, func(rc config.RelayConfig) bool { return rc.Name == name }) {
+		return -1, server.ErrRelayNotManaged
+	}
+	return -1, server.ErrRelayNotFound
+}
+
+// editRelays changes the relays file with edit and applies the config.
+// Problems loading or applying it are returned as config.Problems.
+func (r *reloader) editRelays(ctx context.Context, edit func(*config.ManagedRelays) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	path := r.src.RelaysFilePath(r.cfg.Admin.RelaysFile)
+	prev, err := config.ReadManagedRelays(path)
+	if err != nil {
+		return err
+	}
+	_, statErr := os.Stat(path)
+	// Edits replace, append and delete relays but do not change them in
+	// place, so copies of the lists keep prev as it was.
+	m := config.ManagedRelays{Relays: slices.Clone(prev.Relays), Stopped: slices.Clone(prev.Stopped)}
+	if err := edit(&m); err != nil {
+		return err
+	}
+	if err := config.WriteManagedRelays(path, m); err != nil {
+		return err
+	}
+	if err := r.apply(ctx, "admin api"); err != nil {
+		if errors.Is(statErr, os.ErrNotExist) {
+			_ = os.Remove(path)
+		} else if werr := config.WriteManagedRelays(path, prev); werr != nil {
+			r.log.Error("admin: failed to restore the relays file", "path", path, "error", werr)
+		}
+		return config.Problems(errorProblems(err))
+	}
+	return nil
+}
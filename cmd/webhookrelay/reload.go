@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/health"
+	"webhookrelay/internal/loglevel"
+	"webhookrelay/internal/plugin"
+	"webhookrelay/internal/registry"
+	"webhookrelay/internal/relay"
+	"webhookrelay/internal/script"
+	"webhookrelay/internal/server"
+	"webhookrelay/internal/store"
+)
+
+// configPollInterval is how often server.watch_config checks the config
+// files by default; remoteConfigPollInterval, a config fetched from a URL.
+const (
+	configPollInterval       = 2 * time.Second
+	remoteConfigPollInterval = 30 * time.Second
+)
+
+// registryRetryDelay is how long watching the registry waits after it
+// failed to answer.
+const registryRetryDelay = 5 * time.Second
+
+// pluginCloseDelay lets requests still running a replaced plugin finish
+// before it is closed.
+const pluginCloseDelay = time.Minute
+
+// reloadable are the top-level config sections a reload applies; changes
+// to the others are reported and wait for a restart.
+var reloadable = map[string]bool{"relays": true, "destination_groups": true, "defaults": true, "header_sets": true, "log_level": true, "include": true}
+
+// reloader applies a changed config file to the running relay: relays are
+// added, removed and changed between two requests, while queued and
+// in-flight deliveries carry on with the config they were accepted with.
+type reloader struct {
+	src config.Source
+	log *slog.Logger
+	// level is nil when the log level was set by flag or environment.
+	level *loglevel.Level
+	srv   *server.Server
+	fwd   *relay.Forwarder
+	st    store.Store
+
+	health *health.Checker
+
+	mu sync.Mutex
+	// files are the config files and directories last read.
+	files    []string
+	cfg      config.Config
+	resolved []config.ResolvedRelay
+	plugins  map[string]*plugin.WASM
+}
+
+// run reloads on SIGHUP and, with server.watch_config, when a config file
+// or the remote config changes, until ctx is done.
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	if r.cfg.Registry.Backend != "" {
+		go r.watchRegistry(ctx, r.cfg.Registry)
+	}
+
+	var poll <-chan time.Time
+	var last map[string]fileState
+	if r.cfg.Server.WatchConfig {
+		interval := configPollInterval
+		if config.IsRemote(r.src.Path) {
+			interval = remoteConfigPollInterval
+		}
+		if ms := r.cfg.Server.WatchIntervalMS; ms > 0 {
+			interval = time.Duration(ms) * time.Millisecond
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		poll = t.C
+		last = r.stat(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload(ctx, "sighup")
+		case <-poll:
+			cur := r.stat(ctx)
+			if maps.Equal(cur, last) {
+				continue
+			}
+			last = cur
+			r.reload(ctx, "file changed")
+			// A reload may read other files.
+			last = r.stat(ctx)
+		}
+	}
+}
+
+// watchRegistry reloads when the relays in the registry change, until ctx
+// is done. The registry section itself only changes with a restart.
+func (r *reloader) watchRegistry(ctx context.Context, reg config.RegistryConfig) {
+	client, err := reg.Client()
+	if err != nil {
+		// The config was loaded with it.
+		return
+	}
+	var digest string
+	retry := func(msg string, err error) {
+		r.log.Warn(msg, "backend", reg.Backend, "error", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(registryRetryDelay):
+		}
+	}
+	for ctx.Err() == nil {
+		entries, version, err := client.List(ctx)
+		if err != nil {
+			retry("registry: list failed, retrying", err)
+			continue
+		}
+		// The first listing follows the load at startup.
+		if d := registry.Digest(entries); d != digest {
+			if digest != "" {
+				r.reload(ctx, "registry changed")
+			}
+			digest = d
+		}
+		if err := client.Wait(ctx, version); err != nil && ctx.Err() == nil {
+			retry("registry: watch failed, retrying", err)
+		}
+	}
+}
+
+// fileState is what polling compares to notice a changed file, or version
+// for the remote config.
+type fileState struct {
+	mod     time.Time
+	size    int64
+	version string
+}
+
+// stat returns the state of the config files and directories last read.
+// Directories change when files are added to or removed from them. A
+// remote config that cannot be fetched is left out, so the reload that
+// follows reports why.
+func (r *reloader) stat(ctx context.Context) map[string]fileState {
+	r.mu.Lock()
+	files := r.files
+	r.mu.Unlock()
+	out := make(map[string]fileState, len(files))
+	for _, f := range files {
+		if config.IsRemote(f) {
+			fctx, cancel := context.WithTimeout(ctx, config.RemoteTimeout)
+			v, err := r.src.RemoteVersion(fctx)
+			cancel()
+			if err == nil {
+				out[f] = fileState{version: v}
+			}
+			continue
+		}
+		if fi, err := os.Stat(f); err == nil {
+			out[f] = fileState{mod: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return out
+}
+
+// reload loads the config file again and applies it. A config that does
+// not load leaves the running one in place.
+func (r *reloader) reload(ctx context.Context, trigger string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_ = r.apply(ctx, trigger)
+}
+
+// apply is reload with r.mu held. It returns why the config was not
+// applied.
+func (r *reloader) apply(ctx context.Context, trigger string) error {
+	log := r.log.With("trigger", trigger)
+
+	cfg, files, err := r.src.LoadFiles()
+	if err != nil {
+		log.Error("config reload failed, keeping the running config", "error", err)
+		return err
+	}
+	config.KeepGeneratedPaths(&cfg, r.cfg, r.resolved)
+	logWarnings(log, cfg)
+	resolved, err := config.ResolveRelays(cfg)
+	if err != nil {
+		log.Error("config reload failed, keeping the running config", "error", err)
+		return err
+	}
+	plugins, replaced, err := r.loadPlugins(ctx, resolved)
+	if err != nil {
+		log.Error("config reload failed, keeping the running config", "error", err)
+		return err
+	}
+	scripts, err := script.LoadAll(resolved, r.log)
+	if err != nil {
+		for id, p := range plugins {
+			if r.plugins[id] != p {
+				_ = p.Close(ctx)
+			}
+		}
+		log.Error("config reload failed, keeping the running config", "error", err)
+		return err
+	}
+
+	// Leases must cover longer timeouts before the relays take events.
+	r.fwd.UpdateTimeouts(resolved)
+	r.srv.SetRelays(resolved, plugins, scripts)
+	r.fwd.UpdateLocalRelays(resolved)
+	r.health.Update(resolved)
+	if len(replaced) > 0 {
+		time.AfterFunc(pluginCloseDelay, func() {
+			for _, p := range replaced {
+				_ = p.Close(context.Background())
+			}
+		})
+	}
+	if r.level != nil {
+		base := slog.LevelInfo
+		if cfg.LogLevel != "" {
+			base, _ = loglevel.Parse(cfg.LogLevel)
+		}
+		r.level.SetBase(base)
+	}
+
+	added, removed, changed := diffRelays(r.resolved, resolved)
+	for _, rr := range added {
+		log.Info("config reload: relay added", "name", rr.Name, "id", rr.ID, "path", rr.ListenPath)
+	}
+	for _, rr := range removed {
+		log.Info("config reload: relay removed", "name", rr.Name, "id", rr.ID, "path", rr.ListenPath)
+	}
+	for _, rr := range changed {
+		log.Info("config reload: relay changed", "name", rr.Name, "id", rr.ID, "path", rr.ListenPath)
+	}
+	if pending := restartSections(r.cfg, cfg); len(pending) > 0 {
+		log.Warn("config reload: changes to these sections need a restart", "sections", pending)
+	}
+	log.Info("config reloaded", "relay_count", len(resolved), "added", len(added), "removed", len(removed), "changed", len(changed))
+
+	// Until a restart, the other sections keep running as they were.
+	r.cfg, r.resolved, r.plugins, r.files = runningConfig(r.cfg, cfg), resolved, plugins, files
+	if err := saveSnapshot(ctx, r.st, r.cfg); err != nil {
+		log.Warn("failed to save config snapshot", "error", err)
+	}
+	return nil
+}
+
+// loadPlugins keeps the running plugin of relays whose plugin config did not
+// change and loads the others. replaced are the running plugins no longer
+// used.
+func (r *reloader) loadPlugins(ctx context.Context, resolved []config.ResolvedRelay) (plugins map[string]*plugin.WASM, replaced []*plugin.WASM, err error) {
+	old := map[string]config.PluginConfig{}
+	for _, rr := range r.resolved {
+		old[rr.ID] = rr.Plugin
+	}
+	plugins = map[string]*plugin.WASM{}
+	var load []config.ResolvedRelay
+	for _, rr := range resolved {
+		if p, ok := r.plugins[rr.ID]; ok && reflect.DeepEqual(old[rr.ID], rr.Plugin) {
+			plugins[rr.ID] = p
+		} else if rr.Plugin.Path != "" {
+			load = append(load, rr)
+		}
+	}
+	loaded, err := plugin.LoadAll(ctx, load)
+	if err != nil {
+		return nil, nil, err
+	}
+	for id, p := range loaded {
+		plugins[id] = p
+	}
+	for id, p := range r.plugins {
+		if plugins[id] != p {
+			replaced = append(replaced, p)
+		}
+	}
+	return plugins, replaced, nil
+}
+
+// close closes the running plugins.
+func (r *reloader) close(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.plugins {
+		_ = p.Close(ctx)
+	}
+}
+
+// diffRelays compares two relay sets by relay ID (which follows the listen
+// path).
+func diffRelays(before, after []config.ResolvedRelay) (added, removed, changed []config.ResolvedRelay) {
+	prev := map[string][]byte{}
+	for _, rr := range before {
+		prev[rr.ID], _ = json.Marshal(rr)
+	}
+	seen := map[string]bool{}
+	for _, rr := range after {
+		seen[rr.ID] = true
+		b, ok := prev[rr.ID]
+		if !ok {
+			added = append(added, rr)
+			continue
+		}
+		if cur, _ := json.Marshal(rr); !bytes.Equal(b, cur) {
+			changed = append(changed, rr)
+		}
+	}
+	for _, rr := range before {
+		if !seen[rr.ID] {
+			removed = append(removed, rr)
+		}
+	}
+	return added, removed, changed
+}
+
+// restartSections lists the top-level sections, other than the reloadable
+// ones, that differ between before and after.
+func restartSections(before, after config.Config) []string {
+	var out []string
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := 0; i < b.NumField(); i++ {
+		name, _, _ := strings.Cut(b.Type().Field(i).Tag.Get("json"), ",")
+		if name == "-" || reloadable[name] {
+			continue
+		}
+		x, _ := json.Marshal(b.Field(i).Interface())
+		y, _ := json.Marshal(a.Field(i).Interface())
+		if !bytes.Equal(x, y) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// runningConfig returns running with the reloadable sections, and the
+// fields that are not config, of loaded.
+func runningConfig(running, loaded config.Config) config.Config {
+	out := running
+	o, l := reflect.ValueOf(&out).Elem(), reflect.ValueOf(loaded)
+	for i := 0; i < o.NumField(); i++ {
+		name, _, _ := strings.Cut(o.Type().Field(i).Tag.Get("json"), ",")
+		if name == "-" || reloadable[name] {
+			o.Field(i).Set(l.Field(i))
+		}
+	}
+	return out
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/relay"
+	"webhookrelay/internal/store"
+)
+
+const replayUsage = `usage: webhookrelay replay -config PATH [-relay NAME] [-since T] [-until T] [-reason R] [-limit N] [-dry-run]
+       webhookrelay replay -config PATH -capture FILE [-to URL] [-failed] [-relay NAME] [-since T] [-until T] [-dry-run]
+
+Replays events after a downstream outage, against the current config.
+
+By default, dead-lettered deliveries are read from the configured storage
+and queued again for their destination, with its current settings; the
+running instance delivers them. Storage must be persistent (not memory).
+Dead letters whose relay or destination is gone are skipped, and so are
+those kept with the relay's redact rules applied, whose masked bodies and
+headers would be sent. Replayed dead letters are removed from the DLQ.
+
+With -capture, the inbound requests logged by debug capture ("debug:
+inbound" lines of the operational log) are sent to their relay's path
+again, as -to or server.listen_addr on this host, reaching every current
+destination of the relay. -failed keeps those with a failed "debug:
+outbound" line. Bodies and headers are as logged, with the relay's redact
+rules applied; requests with a cut body are skipped.
+
+-since and -until take an RFC 3339 time or a duration before now (e.g. 6h).`
+
+// runReplay implements "webhookrelay replay". It returns the exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("WEBHOOKRELAY_CONFIG"), "Path or http(s) URL of the config file, JSON, YAML or TOML (or set WEBHOOKRELAY_CONFIG)")
+	configFormat := fs.String("config-format", "", "Config file format: json, yaml or toml (default: from the file extension, json otherwise)")
+	configDir := fs.String("config-dir", os.Getenv("WEBHOOKRELAY_CONFIG_DIR"), "Directory of config files merged after -config (or set WEBHOOKRELAY_CONFIG_DIR)")
+	var overlays repeatedFlag
+	fs.Var(&overlays, "overlay", "Config file deep-merged over the config (repeatable; or set WEBHOOKRELAY_CONFIG_OVERLAY)")
+	capturePath := fs.String("capture", "", "Replay the inbound requests of this debug capture log instead of the DLQ")
+	to := fs.String("to", "", "With -capture: base URL of the relay instance (default: from server.listen_addr)")
+	failed := fs.Bool("failed", false, "With -capture: only requests with a failed delivery")
+	relayName := fs.String("relay", "", "Only events of this relay")
+	since := fs.String("since", "", "Only events from this time on")
+	until := fs.String("until", "", "Only events before this time")
+	reason := fs.String("reason", "", "Only dead letters with this reason, e.g. failed or destination_unhealthy")
+	limit := fs.Int("limit", 10000, "Most recent dead letters to consider")
+	dryRun := fs.Bool("dry-run", false, "List what would be replayed without replaying it")
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, replayUsage) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	now := time.Now()
+	from, err := parseReplayTime(*since, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay: -since:", err)
+		return 2
+	}
+	before, err := parseReplayTime(*until, now)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay: -until:", err)
+		return 2
+	}
+	if *capturePath == "" && (*failed || *to != "") {
+		fmt.Fprintln(os.Stderr, "replay: -failed and -to need -capture")
+		return 2
+	}
+
+	src, err := configFlags{path: *configPath, format: *configFormat, dir: *configDir, overlays: overlays}.source()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	cfg, err := src.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		return 1
+	}
+	sel := replayFilter{relay: *relayName, since: from, until: before}
+	ctx := context.Background()
+	if *capturePath != "" {
+		return replayCapture(ctx, cfg, *capturePath, *to, sel, *failed, *dryRun)
+	}
+	sel.reason = *reason
+	return replayDeadLetters(ctx, cfg, sel, *limit, *dryRun)
+}
+
+// replayFilter selects the events to replay; zero fields match everything.
+type replayFilter struct {
+	relay        string
+	since, until time.Time
+	reason       string
+}
+
+func (f replayFilter) match(relay string, at time.Time, reason string) bool {
+	return (f.relay == "" || f.relay == relay) &&
+		(f.since.IsZero() || !at.Before(f.since)) &&
+		(f.until.IsZero() || at.Before(f.until)) &&
+		(f.reason == "" || f.reason == reason)
+}
+
+// parseReplayTime reads an RFC 3339 time, or a duration back from now.
+func parseReplayTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func replayDeadLetters(ctx context.Context, cfg config.Config, sel replayFilter, limit int, dryRun bool) int {
+	if cfg.Storage.Backend == "" || cfg.Storage.Backend == config.StorageMemory {
+		fmt.Fprintln(os.Stderr, "replay: the DLQ of memory storage is only in the running process; use POST /admin/events/{id}/replay or a persistent storage backend")
+		return 1
+	}
+	resolved, err := config.ResolveRelays(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 1
+	}
+	st, err := store.Open(ctx, cfg.Storage)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open storage:", err)
+		return 1
+	}
+	defer st.Close()
+	dls, err := st.ListDeadLetters(ctx, limit)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay: list dead letters:", err)
+		return 1
+	}
+
+	now := time.Now()
+	var (
+		jobs     []store.Job
+		replayed []store.DeadLetter
+	)
+	skipped := 0
+	for i := len(dls) - 1; i >= 0; i-- {
+		dl := dls[i]
+		if !sel.match(dl.Job.Relay, dl.At, dl.Reason) {
+			continue
+		}
+		job, err := relay.ReplayJob(dl, resolved, now)
+		if err != nil {
+			skipped++
+			fmt.Printf("skip    %s  %s  %s: %v\n", dl.Job.RequestID, dl.Job.Relay, dl.Job.Destination.URL, err)
+			continue
+		}
+		jobs, replayed = append(jobs, job), append(replayed, dl)
+		fmt.Printf("%-7s %s  %s  %s  (%s at %s)\n", replayVerb(dryRun), job.RequestID, job.Relay, job.Destination.URL, dl.Reason, dl.At.Format(time.RFC3339))
+	}
+	if !dryRun && len(jobs) > 0 {
+		if err := st.Enqueue(ctx, jobs...); err != nil {
+			fmt.Fprintln(os.Stderr, "replay: enqueue:", err)
+			return 1
+		}
+		// Left in the DLQ, they would be replayed again by the next run.
+		for _, dl := range replayed {
+			if err := st.DeleteDeadLetter(ctx, dl); err != nil {
+				fmt.Fprintln(os.Stderr, "replay: the dead letters were queued again, but removing them from the DLQ failed:", err)
+				return 1
+			}
+		}
+	}
+	fmt.Printf("\n%d %s, %d skipped\n", len(jobs), replayDone(dryRun), skipped)
+	return 0
+}
+
+// capturedInbound is a "debug: inbound" line of the operational log.
+type capturedInbound struct {
+	Time          time.Time         `json:"time"`
+	Msg           string            `json:"msg"`
+	RequestID     string            `json:"request_id"`
+	Relay         string            `json:"relay"`
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Query         string            `json:"query"`
+	Header        map[string]string `json:"header"`
+	Body          string            `json:"body"`
+	BodyEncoding  string            `json:"body_encoding"`
+	BodyTruncated bool              `json:"body_truncated"`
+	// Outbound lines.
+	ResponseStatus int    `json:"response_status"`
+	Error          string `json:"error"`
+}
+
+func replayCapture(ctx context.Context, cfg config.Config, path, base string, sel replayFilter, onlyFailed, dryRun bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		return 1
+	}
+	defer f.Close()
+
+	var inbound []capturedInbound
+	failed := map[string]bool{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 64<<20)
+	for sc.Scan() {
+		var l capturedInbound
+		if json.Unmarshal(sc.Bytes(), &l) != nil {
+			continue
+		}
+		switch l.Msg {
+		case "debug: inbound":
+			if sel.match(l.Relay, l.Time, "") {
+				inbound = append(inbound, l)
+			}
+		case "debug: outbound":
+			if l.Error != "" || l.ResponseStatus/100 != 2 {
+				failed[l.RequestID] = true
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, "replay: read capture:", err)
+		return 1
+	}
+
+	if base == "" {
+		// The captured path already holds the relay's.
+		if base, err = listenBaseURL(cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "replay:", err)
+			return 1
+		}
+	}
+	client := &http.Client{Timeout: sendTimeout}
+	sent, skipped, errs := 0, 0, 0
+	for _, l := range inbound {
+		if onlyFailed && !failed[l.RequestID] {
+			continue
+		}
+		if l.BodyTruncated {
+			skipped++
+			fmt.Printf("skip    %s  %s: body was cut by the capture\n", l.RequestID, l.Relay)
+			continue
+		}
+		body := []byte(l.Body)
+		if l.BodyEncoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(l.Body); err != nil {
+				skipped++
+				fmt.Printf("skip    %s  %s: %v\n", l.RequestID, l.Relay, err)
+				continue
+			}
+		}
+		u := strings.TrimSuffix(base, "/") + l.Path
+		if l.Query != "" {
+			u += "?" + l.Query
+		}
+		if dryRun {
+			sent++
+			fmt.Printf("%-7s %s  %s  %s %s\n", replayVerb(true), l.RequestID, l.Relay, l.Method, u)
+			continue
+		}
+		status, newID, err := resend(ctx, client, l, u, body)
+		if err != nil {
+			errs++
+			fmt.Printf("error   %s  %s  %s %s: %v\n", l.RequestID, l.Relay, l.Method, u, err)
+			continue
+		}
+		sent++
+		fmt.Printf("%-7s %s  %s  %s %s -> %d %s\n", replayVerb(false), l.RequestID, l.Relay, l.Method, u, status, newID)
+	}
+	fmt.Printf("\n%d %s, %d skipped, %d failed\n", sent, replayDone(dryRun), skipped, errs)
+	if errs > 0 {
+		return 1
+	}
+	return 0
+}
+
+// resend sends a captured request and returns the response status and the
+// new request ID.
+func resend(ctx context.Context, client *http.Client, l capturedInbound, u string, body []byte) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, l.Method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	for k, v := range l.Header {
+		switch http.CanonicalHeaderKey(k) {
+		case "Content-Length", "Host", "Connection", "Accept-Encoding":
+			continue
+		}
+		req.Header.Set(k, v)
+	}
+	// The captured body is decompressed.
+	req.Header.Del("Content-Encoding")
+	req.Header.Set("X-WebhookRelay-Replay", l.RequestID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, "", errors.New(resp.Status)
+	}
+	return resp.StatusCode, resp.Header.Get("X-Relay-Request-Id"), nil
+}
+
+func replayVerb(dryRun bool) string {
+	if dryRun {
+		return "would"
+	}
+	return "replay"
+}
+
+func replayDone(dryRun bool) string {
+	if dryRun {
+		return "to replay"
+	}
+	return "replayed"
+}
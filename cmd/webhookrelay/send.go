@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/provider"
+)
+
+const sendUsage = `usage: webhookrelay send -relay NAME [-config PATH] [-to URL] [-file payload.json] [options]
+       webhookrelay send -url URL [-file payload.json] [options]
+
+Sends a test event to a relay: the payload file (stdin without -file) is
+sent to the relay's path on a running instance, by default the one
+server.listen_addr of the config points at on this host. -to sets another
+base URL (e.g. https://hooks.example.com), -url a full URL without reading
+the config.
+
+With -provider, the request carries that provider's headers (see
+detect_provider) and -event as its event type; with -secret too (or
+WEBHOOKRELAY_SEND_SECRET), the signature the provider would compute, for
+destinations that verify it. Signing is supported for: ` + "%s" + `.
+
+Prints the response and exits 1 unless it is a 2xx.`
+
+// sendTimeout bounds the test request.
+const sendTimeout = 30 * time.Second
+
+// repeatedFlag collects the values of a flag given several times, such as
+// -H "Name: value".
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string     { return strings.Join(*f, ", ") }
+func (f *repeatedFlag) Set(v string) error { *f = append(*f, v); return nil }
+
+// runSend implements "webhookrelay send". It returns the exit code.
+func runSend(args []string) int {
+	usage := fmt.Sprintf(sendUsage, strings.Join(provider.SignedProviders, ", "))
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("WEBHOOKRELAY_CONFIG"), "Path or http(s) URL of the config file, JSON, YAML or TOML (or set WEBHOOKRELAY_CONFIG)")
+	configFormat := fs.String("config-format", "", "Config file format: json, yaml or toml (default: from the file extension, json otherwise)")
+	configDir := fs.String("config-dir", os.Getenv("WEBHOOKRELAY_CONFIG_DIR"), "Directory of config files merged after -config (or set WEBHOOKRELAY_CONFIG_DIR)")
+	var overlays repeatedFlag
+	fs.Var(&overlays, "overlay", "Config file deep-merged over the config (repeatable; or set WEBHOOKRELAY_CONFIG_OVERLAY)")
+	relayName := fs.String("relay", "", "Name of the relay to send to")
+	to := fs.String("to", "", "Base URL of the relay instance (default: from server.listen_addr)")
+	target := fs.String("url", "", "Full URL to send to, instead of -relay")
+	file := fs.String("file", "", "Payload file (default: stdin)")
+	method := fs.String("method", "", "HTTP method (default: the relay's first method, or POST)")
+	contentType := fs.String("content-type", "", "Content-Type (default: from the file extension, application/json otherwise)")
+	prov := fs.String("provider", "", "Send the headers of this provider, e.g. github")
+	event := fs.String("event", "", "Event type sent in the provider's event header")
+	secret := fs.String("secret", os.Getenv("WEBHOOKRELAY_SEND_SECRET"), "Signing secret of the provider (or set WEBHOOKRELAY_SEND_SECRET)")
+	var headers repeatedFlag
+	fs.Var(&headers, "H", `Extra header, "Name: value" (repeatable)`)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*relayName == "") == (*target == "") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	if *secret != "" && *prov == "" {
+		fmt.Fprintln(os.Stderr, "send: -secret needs -provider")
+		return 2
+	}
+
+	u := *target
+	m := *method
+	if u == "" {
+		src, err := configFlags{path: *configPath, format: *configFormat, dir: *configDir, overlays: overlays}.source()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		u, m, err = relayTarget(src, *relayName, *to, m)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "send:", err)
+			return 1
+		}
+	}
+	if m == "" {
+		m = http.MethodPost
+	}
+
+	var body []byte
+	var err error
+	if *file == "" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "send: read payload:", err)
+		return 1
+	}
+
+	req, err := http.NewRequest(m, u, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "send:", err)
+		return 1
+	}
+	ct := *contentType
+	if ct == "" {
+		ct = "application/json"
+		if t := mime.TypeByExtension(filepath.Ext(*file)); *file != "" && t != "" {
+			ct = t
+		}
+	}
+	req.Header.Set("Content-Type", ct)
+	if *prov != "" {
+		h, err := provider.Headers(*prov, *event, *secret, body, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "send:", err)
+			return 2
+		}
+		for k, v := range h {
+			req.Header[k] = v
+		}
+	}
+	for _, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			fmt.Fprintf(os.Stderr, "send: -H %q: want \"Name: value\"\n", h)
+			return 2
+		}
+		req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+
+	resp, err := (&http.Client{Timeout: sendTimeout}).Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "send:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	out, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	fmt.Printf("%s %s\n%s\n", m, u, resp.Status)
+	if id := resp.Header.Get("X-Relay-Request-Id"); id != "" {
+		fmt.Printf("request id: %s\n", id)
+	}
+	if len(out) > 0 {
+		fmt.Printf("\n%s\n", bytes.TrimRight(out, "\n"))
+	}
+	if resp.StatusCode/100 != 2 {
+		return 1
+	}
+	return 0
+}
+
+// relayTarget returns the URL of the named relay, under base or the
+// address of server.listen_addr, and its method (method when set).
+func relayTarget(src config.Source, name, base, method string) (string, string, error) {
+	cfg, err := src.Load()
+	if err != nil {
+		return "", "", fmt.Errorf("load config: %w", err)
+	}
+	var relay *config.RelayConfig
+	for i := range cfg.Relays {
+		if cfg.Relays[i].Name == name {
+			relay = &cfg.Relays[i]
+			break
+		}
+	}
+	if relay == nil {
+		return "", "", fmt.Errorf("no relay named %q", name)
+	}
+	resolved, err := config.ResolveRelays(config.Config{Server: cfg.Server, Relays: []config.RelayConfig{*relay}})
+	if err != nil {
+		return "", "", err
+	}
+	rr := resolved[0]
+	switch {
+	case rr.PathRegex != nil:
+		return "", "", fmt.Errorf("relay %q has a listen_path_regex; pass its full URL with -url", name)
+	case strings.TrimSpace(relay.ListenPath) == "":
+		return "", "", fmt.Errorf("relay %q has a generated path, which only the running instance knows (see its startup log); pass it with -url", name)
+	case rr.Wildcard() || strings.Contains(rr.ListenPath, "{"):
+		return "", "", fmt.Errorf("relay %q listens on a wildcard path or one with parameters; pass a full URL with -url", name)
+	}
+	if method == "" && len(rr.Methods) > 0 {
+		method = rr.Methods[0]
+	}
+
+	if base == "" {
+		if base, err = listenBaseURL(cfg); err != nil {
+			return "", "", err
+		}
+	}
+	return strings.TrimSuffix(base, "/") + rr.ListenPath, method, nil
+}
+
+// listenBaseURL returns the URL of the instance server.listen_addr points
+// at on this host.
+func listenBaseURL(cfg config.Config) (string, error) {
+	addr := cfg.Server.ListenAddr
+	if addr == "" || strings.HasPrefix(addr, "unix:") {
+		return "", fmt.Errorf("server.listen_addr %q is not a TCP address; pass the instance's URL with -to", addr)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("server.listen_addr: %w", err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	scheme := "http"
+	if cfg.Server.TLS.Enabled() {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port), nil
+}
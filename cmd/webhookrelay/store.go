@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/journal"
+	"webhookrelay/internal/store"
+)
+
+const storeUsage = `usage: webhookrelay store <vacuum|check|repair> --config PATH
+
+Offline maintenance for the configured storage backend (sqlite, postgres)
+and the intake journal. Stop the relay before running these.`
+
+// runStore implements "webhookrelay store ...". It returns the exit code.
+func runStore(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, storeUsage)
+		return 2
+	}
+	op := args[0]
+
+	fs := flag.NewFlagSet("store "+op, flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("WEBHOOKRELAY_CONFIG"), "Path to config file, JSON, YAML or TOML (or set WEBHOOKRELAY_CONFIG)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "missing config: pass --config or set WEBHOOKRELAY_CONFIG")
+		return 2
+	}
+	switch op {
+	case "vacuum", "check", "repair":
+	default:
+		fmt.Fprintln(os.Stderr, storeUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		return 1
+	}
+
+	ctx := context.Background()
+	code := 0
+
+	if cfg.Storage.Backend != config.StorageMemory {
+		st, err := store.Open(ctx, cfg.Storage)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "open storage:", err)
+			return 1
+		}
+		defer st.Close()
+
+		m, ok := st.(store.Maintainer)
+		if !ok {
+			fmt.Printf("storage (%s): no maintenance operations for this backend\n", cfg.Storage.Backend)
+		} else if c := maintainStore(ctx, op, cfg.Storage.Backend, m); c > code {
+			code = c
+		}
+	}
+
+	if cfg.Journal.Path != "" {
+		if c := maintainJournal(op, cfg.Journal.Path); c > code {
+			code = c
+		}
+	}
+	return code
+}
+
+func maintainStore(ctx context.Context, op, backend string, m store.Maintainer) int {
+	switch op {
+	case "vacuum":
+		if err := m.Vacuum(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "storage (%s): vacuum failed: %v\n", backend, err)
+			return 1
+		}
+		fmt.Printf("storage (%s): vacuumed\n", backend)
+	case "check":
+		problems, err := m.Check(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "storage (%s): check failed: %v\n", backend, err)
+			return 1
+		}
+		if len(problems) == 0 {
+			fmt.Printf("storage (%s): ok\n", backend)
+			return 0
+		}
+		for _, p := range problems {
+			fmt.Printf("storage (%s): %s\n", backend, p)
+		}
+		return 1
+	case "repair":
+		actions, err := m.Repair(ctx)
+		for _, a := range actions {
+			fmt.Printf("storage (%s): %s\n", backend, a)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "storage (%s): repair failed: %v\n", backend, err)
+			return 1
+		}
+		if len(actions) == 0 {
+			fmt.Printf("storage (%s): nothing to repair\n", backend)
+		}
+	}
+	return 0
+}
+
+func maintainJournal(op, path string) int {
+	switch op {
+	case "vacuum", "repair":
+		// Rewriting the journal drops committed records and any torn tail,
+		// which is both the vacuum and the repair.
+		before, err := journal.Check(path)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "journal %s: %v\n", path, err)
+			return 1
+		}
+		after, err := journal.Repair(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "journal %s: %s failed: %v\n", path, op, err)
+			return 1
+		}
+		fmt.Printf("journal %s: %d record(s) -> %d, dropped %d corrupt line(s) and %d torn byte(s), %d uncommitted event(s) kept\n", path, before.Records, after.Records, before.Corrupt, before.TornBytes, after.Pending)
+	case "check":
+		res, err := journal.Check(path)
+		if os.IsNotExist(err) {
+			fmt.Printf("journal %s: does not exist yet\n", path)
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "journal %s: %v\n", path, err)
+			return 1
+		}
+		fmt.Printf("journal %s: %d record(s), %d uncommitted event(s)\n", path, res.Records, res.Pending)
+		if res.Corrupt > 0 {
+			fmt.Printf("journal %s: %d line(s) are not a record and are skipped on start (run repair)\n", path, res.Corrupt)
+		}
+		if res.TornBytes > 0 {
+			fmt.Printf("journal %s: %d trailing byte(s) are not a complete record (run repair)\n", path, res.TornBytes)
+		}
+		if res.Corrupt > 0 || res.TornBytes > 0 {
+			return 1
+		}
+	}
+	return 0
+}
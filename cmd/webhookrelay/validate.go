@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/plugin"
+	"webhookrelay/internal/script"
+)
+
+const validateUsage = `usage: webhookrelay validate [-config PATH] [-config-dir DIR] [-config-format FORMAT] [-dns]
+
+Loads the config as the relay does at startup, without starting it: every
+file is parsed and validated, relays are resolved (duplicate or conflicting
+listen paths are reported) and WASM plugins and Lua scripts are loaded.
+With -dns, the host of every destination URL must resolve too. Prints each
+problem found and exits 1 if there are any. Warnings about likely mistakes
+are printed too but do not fail validation.`
+
+// dnsTimeout bounds each destination host lookup of validate -dns.
+const dnsTimeout = 5 * time.Second
+
+// runValidate implements "webhookrelay validate". It returns the exit code.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("WEBHOOKRELAY_CONFIG"), "Path or http(s) URL of the config file, JSON, YAML or TOML (or set WEBHOOKRELAY_CONFIG)")
+	configFormat := fs.String("config-format", "", "Config file format: json, yaml or toml (default: from the file extension, json otherwise)")
+	configDir := fs.String("config-dir", os.Getenv("WEBHOOKRELAY_CONFIG_DIR"), "Directory of config files merged after -config (or set WEBHOOKRELAY_CONFIG_DIR)")
+	var overlays repeatedFlag
+	fs.Var(&overlays, "overlay", "Config file deep-merged over the config (repeatable; or set WEBHOOKRELAY_CONFIG_OVERLAY)")
+	dns := fs.Bool("dns", false, "Check that destination hosts resolve")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	src, err := configFlags{path: *configPath, format: *configFormat, dir: *configDir, overlays: overlays}.source()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	problems, warnings, relays := validateConfig(context.Background(), src, *dns)
+	if len(warnings) > 0 {
+		fmt.Printf("%d warning(s):\n", len(warnings))
+		for _, w := range warnings {
+			fmt.Printf("  - %s\n", w)
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problem(s):\n", len(problems))
+		for _, p := range problems {
+			fmt.Printf("  - %s\n", p)
+		}
+		return 1
+	}
+	fmt.Printf("OK: %d relay(s)\n", relays)
+	return 0
+}
+
+// validateConfig returns the problems and warnings of the config in src and
+// how many relays it has. Later checks need the earlier ones to pass, so
+// problems come from the first check that fails, except for DNS lookups.
+func validateConfig(ctx context.Context, src config.Source, dns bool) (problems, warnings []string, relays int) {
+	cfg, err := src.Load()
+	if err != nil {
+		return errorProblems(err), nil, 0
+	}
+	resolved, err := config.ResolveRelays(cfg)
+	if err != nil {
+		return errorProblems(err), cfg.Warnings, 0
+	}
+
+	plugins, err := plugin.LoadAll(ctx, resolved)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, p := range plugins {
+		_ = p.Close(ctx)
+	}
+	if _, err := script.LoadAll(resolved, slog.New(slog.NewJSONHandler(io.Discard, nil))); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if dns {
+		problems = append(problems, lookupDestinations(ctx, resolved)...)
+	}
+	return problems, cfg.Warnings, len(resolved)
+}
+
+// logWarnings logs the likely mistakes found in cfg.
+func logWarnings(log *slog.Logger, cfg config.Config) {
+	for _, w := range cfg.Warnings {
+		log.Warn("config warning", "warning", w)
+	}
+}
+
+// errorProblems lists the problems err stands for.
+func errorProblems(err error) []string {
+	var problems config.Problems
+	if errors.As(err, &problems) {
+		return problems
+	}
+	return []string{err.Error()}
+}
+
+// lookupDestinations resolves the host of every destination URL that is not
+// templated or an IP address, once per host.
+func lookupDestinations(ctx context.Context, relays []config.ResolvedRelay) []string {
+	users := map[string][]string{}
+	add := func(relay string, dests []config.DestinationConfig) {
+		for _, d := range dests {
+			switch d.Type {
+			case config.DestinationRelay, config.DestinationDrop:
+				continue
+			}
+			if d.URL == "" || config.URLTemplated(d.URL) {
+				continue
+			}
+			u, err := url.Parse(d.URL)
+			if err != nil || u.Hostname() == "" {
+				continue
+			}
+			if _, err := netip.ParseAddr(u.Hostname()); err == nil {
+				continue
+			}
+			users[u.Hostname()] = append(users[u.Hostname()], relay)
+		}
+	}
+	for _, r := range relays {
+		add(r.Name, r.Destinations)
+		for _, rt := range r.Routes {
+			add(r.Name, rt.Destinations)
+		}
+	}
+
+	hosts := make([]string, 0, len(users))
+	for h := range users {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	var problems []string
+	for _, h := range hosts {
+		lctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+		_, err := net.DefaultResolver.LookupHost(lctx, h)
+		cancel()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("destination host %q (relay %q) does not resolve: %v", h, users[h][0], err))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/verify"
+)
+
+const verifyUsage = `usage: webhookrelay verify -config PATH -scenario scenario.yaml [-v]
+
+Runs each scenario's inbound request through the relay configuration and
+checks the deliveries captured by an embedded receiver. Nothing is sent to
+the real destinations and no storage, journal or alerting is used.
+Exits 1 if any scenario fails.`
+
+// runVerify implements "webhookrelay verify". It returns the exit code.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("WEBHOOKRELAY_CONFIG"), "Path to config file, JSON, YAML or TOML (or set WEBHOOKRELAY_CONFIG)")
+	scenarioPath := fs.String("scenario", "", "Scenario file (YAML)")
+	verbose := fs.Bool("v", false, "Log relay activity to stderr")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *configPath == "" || *scenarioPath == "" {
+		fmt.Fprintln(os.Stderr, verifyUsage)
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load config:", err)
+		return 1
+	}
+	sc, err := verify.Load(*scenarioPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load scenarios:", err)
+		return 1
+	}
+
+	var w io.Writer = io.Discard
+	if *verbose {
+		w = os.Stderr
+	}
+	logger := slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	results, err := verify.Run(cfg, sc, logger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify:", err)
+		return 1
+	}
+
+	failed := 0
+	for _, r := range results {
+		if len(r.Problems) == 0 {
+			fmt.Printf("PASS  %s\n", r.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s\n", r.Name)
+		for _, p := range r.Problems {
+			fmt.Printf("      - %s\n", p)
+		}
+	}
+	fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,181 @@
+// Package fixtures reads fixture files produced by
+// "webhookrelay fixtures generate" and replays them against an http.Handler,
+// so consumers can test against realistic (sanitized) webhook traffic.
+package fixtures
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// Fixture is one recorded inbound event. Exactly one of Body, RawBody and
+// BodyBase64 is set, depending on whether the body was JSON, other text or
+// binary.
+type Fixture struct {
+	Name       string          `json:"name"`
+	Relay      string          `json:"relay"`
+	Method     string          `json:"method"`
+	Path       string          `json:"path"`
+	Query      string          `json:"query,omitempty"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	RawBody    string          `json:"raw_body,omitempty"`
+	BodyBase64 string          `json:"body_base64,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// SetBody stores b in whichever body field represents it faithfully.
+func (f *Fixture) SetBody(b []byte) {
+	f.Body, f.RawBody, f.BodyBase64 = nil, "", ""
+	switch {
+	case len(b) == 0:
+	case json.Valid(b):
+		var buf bytes.Buffer
+		if json.Indent(&buf, b, "", "  ") == nil {
+			b = buf.Bytes()
+		}
+		f.Body = b
+	case isText(b):
+		f.RawBody = string(b)
+	default:
+		f.BodyBase64 = base64.StdEncoding.EncodeToString(b)
+	}
+}
+
+// Payload returns the recorded request body.
+func (f Fixture) Payload() ([]byte, error) {
+	switch {
+	case len(f.Body) > 0:
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, f.Body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case f.BodyBase64 != "":
+		return base64.StdEncoding.DecodeString(f.BodyBase64)
+	default:
+		return []byte(f.RawBody), nil
+	}
+}
+
+// Request builds the recorded request, for use with an http.Handler or a
+// client pointed at a test server.
+func (f Fixture) Request() (*http.Request, error) {
+	body, err := f.Payload()
+	if err != nil {
+		return nil, fmt.Errorf("fixture %s: %w", f.Name, err)
+	}
+	target := f.Path
+	if target == "" {
+		target = "/"
+	}
+	if f.Query != "" {
+		target += "?" + f.Query
+	}
+	req := httptest.NewRequest(f.Method, target, bytes.NewReader(body))
+	for k, vs := range f.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	return req, nil
+}
+
+// Load reads one fixture file.
+func Load(path string) (Fixture, error) {
+	var f Fixture
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.Name == "" {
+		f.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	return f, nil
+}
+
+// LoadDir reads every *.json fixture in dir, in file name order (which is
+// the order the events were recorded in).
+func LoadDir(dir string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	out := make([]Fixture, 0, len(paths))
+	for _, p := range paths {
+		f, err := Load(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+// Write stores f as dir/<f.Name>.json.
+func Write(dir string, f Fixture) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, f.Name+".json"), buf.Bytes(), 0o644)
+}
+
+// Replay serves every fixture in dir to h as a subtest named after the
+// fixture, calling check with the response. A nil check only requires a 2xx
+// status.
+func Replay(t *testing.T, dir string, h http.Handler, check func(t *testing.T, f Fixture, resp *httptest.ResponseRecorder)) {
+	t.Helper()
+	all, err := LoadDir(dir)
+	if err != nil {
+		t.Fatalf("load fixtures: %v", err)
+	}
+	if len(all) == 0 {
+		t.Fatalf("no fixtures in %s", dir)
+	}
+	for _, f := range all {
+		t.Run(f.Name, func(t *testing.T) {
+			req, err := f.Request()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if check != nil {
+				check(t, f, rec)
+				return
+			}
+			if rec.Code/100 != 2 {
+				t.Errorf("%s %s: status %d: %s", f.Method, f.Path, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
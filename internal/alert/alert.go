@@ -0,0 +1,377 @@
+// Package alert notifies on-call integrations (PagerDuty, Opsgenie), a
+// webhook, Slack or the log when deliveries keep failing, and again once
+// they recover.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/store"
+)
+
+// Alert is one firing (or resolved) rule. Key is stable per rule so the
+// integration deduplicates repeated triggers into one incident.
+type Alert struct {
+	Key     string
+	Summary string
+	Details map[string]any
+}
+
+// Notifier is an incident integration.
+type Notifier interface {
+	Trigger(ctx context.Context, a Alert) error
+	Resolve(ctx context.Context, a Alert) error
+}
+
+// notifyTimeout bounds each call to an integration.
+const notifyTimeout = 10 * time.Second
+
+type event struct {
+	resolve bool
+	alert   Alert
+	// rule and since are kept in the store with the firing rules.
+	rule  string
+	since time.Time
+}
+
+// Store is where the monitor counts dead letters and keeps the rules that
+// are firing, so that a restart still resolves them.
+type Store interface {
+	store.DeadLetters
+	store.AlertStates
+}
+
+// Monitor evaluates the configured rules: DLQ rules on a timer, delivery
+// rules on every delivery reported through Observe.
+type Monitor struct {
+	log       *slog.Logger
+	store     Store
+	notifiers []Notifier
+	rules     []config.AlertRule
+	interval  time.Duration
+
+	mu    sync.Mutex
+	state map[string]*ruleState // by rule name
+
+	events chan event
+}
+
+type ruleState struct {
+	failures int         // in a row, for consecutive_failures
+	window   *rateWindow // for failure_rate
+	firing   bool
+	// notified is set once the current firing was notified; a rule that
+	// fires again within its cooldown is held until the cooldown ends.
+	notified    bool
+	lastTrigger time.Time
+	details     map[string]any
+	// growth is for dlq_count with window_ms.
+	growth *growthWindow
+}
+
+// New returns nil when no rules are configured; a nil *Monitor ignores
+// Observe and Run.
+func New(cfg config.AlertsConfig, st Store, log *slog.Logger) *Monitor {
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	var ns []Notifier
+	if cfg.PagerDuty.RoutingKey != "" {
+		ns = append(ns, &PagerDuty{Client: client, URL: cfg.PagerDuty.URL, RoutingKey: cfg.PagerDuty.RoutingKey, Severity: cfg.PagerDuty.Severity})
+	}
+	if cfg.Opsgenie.APIKey != "" {
+		ns = append(ns, &Opsgenie{Client: client, URL: cfg.Opsgenie.URL, APIKey: cfg.Opsgenie.APIKey, Priority: cfg.Opsgenie.Priority})
+	}
+	if cfg.Webhook.URL != "" {
+		ns = append(ns, &Webhook{Client: client, URL: cfg.Webhook.URL, Headers: cfg.Webhook.Headers})
+	}
+	if cfg.Slack.WebhookURL != "" {
+		ns = append(ns, &Slack{Client: client, URL: cfg.Slack.WebhookURL})
+	}
+	if cfg.Log {
+		ns = append(ns, &Log{Logger: log})
+	}
+	state := make(map[string]*ruleState, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		rs := &ruleState{}
+		switch {
+		case r.FailureRate > 0:
+			rs.window = newRateWindow(r.Window())
+		case r.DLQCount > 0 && r.WindowMS > 0:
+			rs.growth = newGrowthWindow(r.Window())
+		}
+		state[r.Name] = rs
+	}
+	return &Monitor{
+		log:       log,
+		store:     st,
+		notifiers: ns,
+		rules:     cfg.Rules,
+		interval:  cfg.CheckInterval(),
+		state:     state,
+		events:    make(chan event, 64),
+	}
+}
+
+// Observe records the outcome of one delivery for relay to destURL.
+func (m *Monitor) Observe(relay, destURL, outcome string) {
+	// Events a transform service dropped on purpose are neither failures
+	// nor successes.
+	if m == nil || outcome == store.OutcomeDropped {
+		return
+	}
+	now := time.Now()
+	failed := outcome != store.OutcomeDelivered
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.rules {
+		if r.DLQCount > 0 || (r.Relay != "" && r.Relay != relay) || (r.Destination != "" && r.Destination != destURL) {
+			continue
+		}
+		st := m.state[r.Name]
+		if r.FailureRate > 0 {
+			st.window.add(now, failed)
+			m.checkRateLocked(r, now)
+			continue
+		}
+		if !failed {
+			st.failures = 0
+			m.setLocked(r, false, nil)
+			continue
+		}
+		st.failures++
+		if st.failures >= r.ConsecutiveFailures {
+			m.setLocked(r, true, map[string]any{"relay": relay, "destination": destURL, "consecutive_failures": st.failures, "last_outcome": outcome})
+		}
+	}
+}
+
+// checkRateLocked fires or resolves a failure_rate rule. With fewer than
+// min_attempts deliveries in the window it stays as it is.
+func (m *Monitor) checkRateLocked(r config.AlertRule, now time.Time) {
+	attempts, failures := m.state[r.Name].window.counts(now)
+	if attempts == 0 || attempts < r.MinAttempts {
+		return
+	}
+	rate := float64(failures) / float64(attempts)
+	details := map[string]any{"failure_rate": rate, "attempts": attempts, "failures": failures, "window_ms": r.WindowMS}
+	if r.Relay != "" {
+		details["relay"] = r.Relay
+	}
+	if r.Destination != "" {
+		details["destination"] = r.Destination
+	}
+	m.setLocked(r, rate >= r.FailureRate, details)
+}
+
+// Run checks DLQ rules every interval and sends notifications until ctx is
+// done.
+func (m *Monitor) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.restore(ctx)
+	go m.send(ctx)
+
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		m.checkDLQ(ctx)
+		m.tick()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// restore takes up the rules that were firing when the relay last stopped,
+// so they resolve once their condition clears, and resolves those no longer
+// configured.
+func (m *Monitor) restore(ctx context.Context) {
+	states, err := m.store.ListAlertStates(ctx)
+	if err != nil {
+		m.log.Error("alert: loading firing rules failed", "error", err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range states {
+		st, ok := m.state[s.Rule]
+		if !ok {
+			m.queueLocked(event{resolve: true, rule: s.Rule, alert: Alert{
+				Key:     alertKey(s.Rule),
+				Summary: fmt.Sprintf("webhookrelay: alert rule %s was removed", s.Rule),
+			}})
+			continue
+		}
+		st.firing, st.notified, st.lastTrigger, st.details = true, true, s.Since, s.Details
+	}
+}
+
+func (m *Monitor) checkDLQ(ctx context.Context) {
+	n, err := m.store.CountDeadLetters(ctx)
+	if err != nil {
+		m.log.Error("alert: count dead letters failed", "error", err)
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.rules {
+		if r.DLQCount == 0 {
+			continue
+		}
+		details := map[string]any{"dlq_count": n, "threshold": r.DLQCount}
+		st := m.state[r.Name]
+		if st.growth == nil {
+			m.setLocked(r, n >= r.DLQCount, details)
+			continue
+		}
+		added, full := st.growth.add(now, n)
+		details["added"], details["window_ms"] = added, r.WindowMS
+		// A rule restored as firing only clears once a whole window was
+		// watched.
+		if added >= r.DLQCount || full {
+			m.setLocked(r, added >= r.DLQCount, details)
+		}
+	}
+}
+
+// tick re-evaluates failure rates as their window slides and sends the
+// triggers held back by a cooldown that has ended.
+func (m *Monitor) tick() {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.rules {
+		if r.FailureRate > 0 {
+			m.checkRateLocked(r, now)
+		}
+		if st := m.state[r.Name]; st.firing && !st.notified && now.Sub(st.lastTrigger) >= r.Cooldown() {
+			m.notifyLocked(r, st, true)
+		}
+	}
+}
+
+// setLocked notifies a trigger or resolve when rule r changes state.
+func (m *Monitor) setLocked(r config.AlertRule, firing bool, details map[string]any) {
+	st := m.state[r.Name]
+	if st.firing == firing {
+		return
+	}
+	st.firing = firing
+	if !firing {
+		// A trigger held back by the cooldown needs no resolve.
+		if st.notified {
+			m.notifyLocked(r, st, false)
+		}
+		return
+	}
+	st.details = details
+	if !st.lastTrigger.IsZero() && time.Since(st.lastTrigger) < r.Cooldown() {
+		m.log.Info("alert: firing within cooldown, notification held", "rule", r.Name, "cooldown_ms", r.CooldownMS)
+		return
+	}
+	m.notifyLocked(r, st, true)
+}
+
+func (m *Monitor) notifyLocked(r config.AlertRule, st *ruleState, firing bool) {
+	st.notified = firing
+	if firing {
+		st.lastTrigger = time.Now()
+	}
+	a := Alert{Key: alertKey(r.Name), Summary: summary(r, st.details), Details: st.details}
+	m.queueLocked(event{resolve: !firing, alert: a, rule: r.Name, since: st.lastTrigger})
+}
+
+func (m *Monitor) queueLocked(ev event) {
+	select {
+	case m.events <- ev:
+	default:
+		m.log.Error("alert: notification queue full, dropping", "rule", ev.rule, "firing", !ev.resolve)
+	}
+}
+
+// alertKey is the incident key of rule.
+func alertKey(rule string) string {
+	return "webhookrelay-" + rule
+}
+
+func summary(r config.AlertRule, details map[string]any) string {
+	scope := ""
+	switch {
+	case r.Relay != "" && r.Destination != "":
+		scope = fmt.Sprintf("relay %s to %s", r.Relay, r.Destination)
+	case r.Relay != "":
+		scope = "relay " + r.Relay
+	default:
+		scope = "destination " + r.Destination
+	}
+	switch {
+	case r.DLQCount > 0 && r.WindowMS > 0:
+		return fmt.Sprintf("webhookrelay: dead letter queue grew by %v events in the last %s (rule %s, threshold %d)", details["added"], r.Window(), r.Name, r.DLQCount)
+	case r.DLQCount > 0:
+		return fmt.Sprintf("webhookrelay: dead letter queue holds %v events (rule %s, threshold %d)", details["dlq_count"], r.Name, r.DLQCount)
+	case r.FailureRate > 0:
+		return fmt.Sprintf("webhookrelay: %s failed %v of %v deliveries in the last %s (rule %s, threshold %g%%)",
+			scope, details["failures"], details["attempts"], r.Window(), r.Name, r.FailureRate*100)
+	}
+	return fmt.Sprintf("webhookrelay: %s failed %d deliveries in a row (rule %s)", scope, r.ConsecutiveFailures, r.Name)
+}
+
+func (m *Monitor) send(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-m.events:
+			m.save(ctx, ev)
+			for _, n := range m.notifiers {
+				var err error
+				if ev.resolve {
+					err = n.Resolve(ctx, ev.alert)
+				} else {
+					err = n.Trigger(ctx, ev.alert)
+				}
+				if err != nil {
+					m.log.Error("alert: notify failed", "key", ev.alert.Key, "resolve", ev.resolve, "error", err)
+					continue
+				}
+				m.log.Info("alert: notified", "key", ev.alert.Key, "resolve", ev.resolve)
+			}
+		}
+	}
+}
+
+// save records a trigger in the store, or removes its record on resolve.
+func (m *Monitor) save(ctx context.Context, ev event) {
+	var err error
+	if ev.resolve {
+		err = m.store.DeleteAlertState(ctx, ev.rule)
+	} else {
+		err = m.store.PutAlertState(ctx, store.AlertState{Rule: ev.rule, Since: ev.since, Details: ev.alert.Details})
+	}
+	if err != nil {
+		m.log.Error("alert: saving rule state failed", "rule", ev.rule, "resolve", ev.resolve, "error", err)
+	}
+}
+
+func checkStatus(resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("status %d: %s", resp.StatusCode, msg)
+}
@@ -0,0 +1,67 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Opsgenie creates and closes alerts through the Opsgenie Alert API, using
+// the alert key as the alias.
+type Opsgenie struct {
+	Client   *http.Client
+	URL      string
+	APIKey   string
+	Priority string
+}
+
+func (o *Opsgenie) Trigger(ctx context.Context, a Alert) error {
+	details := make(map[string]string, len(a.Details))
+	for k, v := range a.Details {
+		details[k] = fmt.Sprint(v)
+	}
+	return o.post(ctx, "/v2/alerts", map[string]any{
+		"message":  truncate(a.Summary, 130),
+		"alias":    a.Key,
+		"priority": o.Priority,
+		"source":   "webhookrelay",
+		"details":  details,
+	})
+}
+
+func (o *Opsgenie) Resolve(ctx context.Context, a Alert) error {
+	return o.post(ctx, "/v2/alerts/"+url.PathEscape(a.Key)+"/close?identifierType=alias", map[string]any{
+		"source": "webhookrelay",
+	})
+}
+
+func (o *Opsgenie) post(ctx context.Context, path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(o.URL, "/")+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+o.APIKey)
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkStatus(resp)
+}
+
+// truncate caps s at n runes; Opsgenie rejects longer messages.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
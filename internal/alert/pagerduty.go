@@ -0,0 +1,61 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+)
+
+// PagerDuty sends events to the PagerDuty Events API v2.
+type PagerDuty struct {
+	Client     *http.Client
+	URL        string
+	RoutingKey string
+	Severity   string
+}
+
+func (p *PagerDuty) Trigger(ctx context.Context, a Alert) error {
+	source, _ := os.Hostname()
+	if source == "" {
+		source = "webhookrelay"
+	}
+	return p.post(ctx, map[string]any{
+		"routing_key":  p.RoutingKey,
+		"event_action": "trigger",
+		"dedup_key":    a.Key,
+		"payload": map[string]any{
+			"summary":        a.Summary,
+			"source":         source,
+			"severity":       p.Severity,
+			"component":      "webhookrelay",
+			"custom_details": a.Details,
+		},
+	})
+}
+
+func (p *PagerDuty) Resolve(ctx context.Context, a Alert) error {
+	return p.post(ctx, map[string]any{
+		"routing_key":  p.RoutingKey,
+		"event_action": "resolve",
+		"dedup_key":    a.Key,
+	})
+}
+
+func (p *PagerDuty) post(ctx context.Context, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkStatus(resp)
+}
@@ -0,0 +1,114 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Webhook posts every trigger and resolve as JSON to a URL of your own.
+type Webhook struct {
+	Client  *http.Client
+	URL     string
+	Headers map[string]string
+}
+
+func (w *Webhook) Trigger(ctx context.Context, a Alert) error {
+	return w.post(ctx, "firing", a)
+}
+
+func (w *Webhook) Resolve(ctx context.Context, a Alert) error {
+	return w.post(ctx, "resolved", a)
+}
+
+func (w *Webhook) post(ctx context.Context, status string, a Alert) error {
+	rule := strings.TrimPrefix(a.Key, "webhookrelay-")
+	msg := map[string]any{
+		"status":  status,
+		"key":     a.Key,
+		"rule":    rule,
+		"summary": a.Summary,
+		"at":      time.Now().UTC(),
+	}
+	if status == "firing" {
+		msg["details"] = a.Details
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Headers, msg)
+}
+
+// Slack posts to a Slack incoming webhook.
+type Slack struct {
+	Client *http.Client
+	URL    string
+}
+
+func (s *Slack) Trigger(ctx context.Context, a Alert) error {
+	return postJSON(ctx, s.Client, s.URL, nil, map[string]string{"text": ":rotating_light: " + a.Summary + detailText(a.Details)})
+}
+
+func (s *Slack) Resolve(ctx context.Context, a Alert) error {
+	return postJSON(ctx, s.Client, s.URL, nil, map[string]string{"text": ":white_check_mark: resolved: " + a.Summary})
+}
+
+// detailText lists details as "\n• key: value" lines, in key order.
+func detailText(details map[string]any) string {
+	var b strings.Builder
+	for _, k := range sortedKeys(details) {
+		fmt.Fprintf(&b, "\n• %s: %v", k, details[k])
+	}
+	return b.String()
+}
+
+// Log writes alerts to the log, for setups that alert on log lines.
+type Log struct {
+	Logger *slog.Logger
+}
+
+func (l *Log) Trigger(ctx context.Context, a Alert) error {
+	args := []any{"key", a.Key, "summary", a.Summary}
+	for _, k := range sortedKeys(a.Details) {
+		args = append(args, k, a.Details[k])
+	}
+	l.Logger.WarnContext(ctx, "alert: firing", args...)
+	return nil
+}
+
+func (l *Log) Resolve(ctx context.Context, a Alert) error {
+	l.Logger.InfoContext(ctx, "alert: resolved", "key", a.Key, "summary", a.Summary)
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	return checkStatus(resp)
+}
@@ -0,0 +1,87 @@
+package alert
+
+import "time"
+
+// windowBuckets is how finely a failure rate window slides.
+const windowBuckets = 60
+
+// rateWindow counts delivery attempts and failures over a sliding window,
+// in windowBuckets buckets.
+type rateWindow struct {
+	width    time.Duration
+	attempts [windowBuckets]int
+	failures [windowBuckets]int
+	// start of the newest bucket, in bucket widths since the zero time
+	newest int64
+}
+
+func newRateWindow(d time.Duration) *rateWindow {
+	w := d / windowBuckets
+	if w <= 0 {
+		w = 1
+	}
+	return &rateWindow{width: w}
+}
+
+func (w *rateWindow) add(now time.Time, failed bool) {
+	i := w.advance(now)
+	w.attempts[i]++
+	if failed {
+		w.failures[i]++
+	}
+}
+
+// counts returns the attempts and failures within the window ending now.
+func (w *rateWindow) counts(now time.Time) (attempts, failures int) {
+	w.advance(now)
+	for i := range w.attempts {
+		attempts += w.attempts[i]
+		failures += w.failures[i]
+	}
+	return attempts, failures
+}
+
+// advance clears the buckets that fell out of the window and returns the
+// index of the bucket for now.
+func (w *rateWindow) advance(now time.Time) int {
+	n := now.UnixNano() / int64(w.width)
+	if n > w.newest {
+		for k := w.newest + 1; k <= n && k <= w.newest+windowBuckets; k++ {
+			w.attempts[k%windowBuckets], w.failures[k%windowBuckets] = 0, 0
+		}
+		w.newest = n
+	}
+	return int(w.newest % windowBuckets)
+}
+
+// growthWindow samples a count, such as the dead letter queue's, to tell
+// how much it grew over a sliding window. A count that drops, as dead
+// letters are replayed, grows again from its low.
+type growthWindow struct {
+	width   time.Duration
+	samples []growthSample // oldest first; the first is at or before the window
+}
+
+type growthSample struct {
+	at time.Time
+	n  int
+}
+
+func newGrowthWindow(d time.Duration) *growthWindow {
+	return &growthWindow{width: d}
+}
+
+// add records count n and returns how far it rose above its lowest point in
+// the window ending now, and whether the samples cover the whole window.
+func (w *growthWindow) add(now time.Time, n int) (growth int, full bool) {
+	w.samples = append(w.samples, growthSample{at: now, n: n})
+	start := now.Add(-w.width)
+	for len(w.samples) > 1 && !w.samples[1].at.After(start) {
+		w.samples = w.samples[1:]
+	}
+	low := n
+	for _, s := range w.samples {
+		low = min(low, s.n)
+	}
+	return n - low, !w.samples[0].at.After(start)
+}
@@ -0,0 +1,92 @@
+// Package buildinfo describes the running build. Version, Commit and Date
+// are set at link time, e.g.
+//
+//	go build -ldflags "-X webhookrelay/internal/buildinfo.Version=1.4.0 \
+//	  -X webhookrelay/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X webhookrelay/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/webhookrelay
+//
+// Without them, the commit and date the Go toolchain stamps from a git
+// checkout are used.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// Info is what is known of the running build; unknown fields are empty,
+// except Version, which is "dev".
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Modified is set when the stamped commit had uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Get returns the build's Info.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		if info.Commit == "" {
+			for _, s := range bi.Settings {
+				switch s.Key {
+				case "vcs.revision":
+					info.Commit = s.Value
+				case "vcs.time":
+					if info.Date == "" {
+						info.Date = s.Value
+					}
+				case "vcs.modified":
+					info.Modified = s.Value == "true"
+				}
+			}
+		}
+	}
+	if info.Version == "" {
+		info.Version = "dev"
+	}
+	return info
+}
+
+// String formats i for "webhookrelay version".
+func (i Info) String() string {
+	s := "webhookrelay " + i.Version
+	if i.Commit != "" {
+		commit := i.Commit
+		if i.Modified {
+			commit += "-dirty"
+		}
+		s += fmt.Sprintf("\n  commit:     %s", commit)
+	}
+	if i.Date != "" {
+		s += fmt.Sprintf("\n  built:      %s", i.Date)
+	}
+	return s + fmt.Sprintf("\n  go version: %s", i.GoVersion)
+}
+
+// Attrs returns i as key-value pairs for a log line.
+func (i Info) Attrs() []any {
+	attrs := []any{"version", i.Version}
+	if i.Commit != "" {
+		attrs = append(attrs, "commit", i.Commit)
+	}
+	if i.Modified {
+		attrs = append(attrs, "modified", true)
+	}
+	if i.Date != "" {
+		attrs = append(attrs, "build_date", i.Date)
+	}
+	return append(attrs, "go_version", i.GoVersion)
+}
@@ -0,0 +1,147 @@
+// Package capture logs the full traffic of relays put in debug mode through
+// the admin API: inbound requests with their headers and body, and every
+// request sent to a destination with the response it got. Debug mode turns
+// itself off after a while so it is not left on by accident. A nil
+// *Capture captures nothing.
+package capture
+
+import (
+	"encoding/base64"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/redact"
+)
+
+type Capture struct {
+	log     *slog.Logger
+	maxBody int64
+
+	mu    sync.Mutex
+	until map[string]time.Time // relay ID -> end of debug mode
+}
+
+// New returns a Capture logging to log, with bodies cut at maxBody bytes.
+func New(log *slog.Logger, maxBody int64) *Capture {
+	return &Capture{log: log, maxBody: maxBody, until: map[string]time.Time{}}
+}
+
+// Enable puts the relay in debug mode for d, extending or shortening an
+// earlier period, and returns when it ends.
+func (c *Capture) Enable(relayID string, d time.Duration) time.Time {
+	until := time.Now().Add(d)
+	c.mu.Lock()
+	c.until[relayID] = until
+	c.mu.Unlock()
+	return until
+}
+
+// Disable ends debug mode for the relay.
+func (c *Capture) Disable(relayID string) {
+	c.mu.Lock()
+	delete(c.until, relayID)
+	c.mu.Unlock()
+}
+
+// Until returns when the relay's debug mode ends, if it is on.
+func (c *Capture) Until(relayID string) (time.Time, bool) {
+	if c == nil {
+		return time.Time{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	until, ok := c.until[relayID]
+	if ok && !time.Now().Before(until) {
+		delete(c.until, relayID)
+		c.log.Info("debug: capture expired", "relay_id", relayID)
+		return time.Time{}, false
+	}
+	return until, ok
+}
+
+// Active reports whether the relay is in debug mode.
+func (c *Capture) Active(relayID string) bool {
+	_, ok := c.Until(relayID)
+	return ok
+}
+
+// Inbound logs an inbound request of relay and its (decompressed) body,
+// with the relay's redact rules applied.
+func (c *Capture) Inbound(relay config.ResolvedRelay, req *http.Request, body []byte, requestID string) {
+	if !c.Active(relay.ID) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("request_id", requestID),
+		slog.String("relay", relay.Name),
+		slog.String("method", req.Method),
+		slog.String("path", req.URL.Path),
+		slog.String("query", redact.Query(req.URL.RawQuery, relay.Redact)),
+		slog.String("remote_addr", req.RemoteAddr),
+		headerAttr("header", redact.Header(req.Header, relay.Redact)),
+	}
+	attrs = append(attrs, c.bodyAttrs("body", redact.Body(body, relay.Redact))...)
+	c.log.LogAttrs(req.Context(), slog.LevelInfo, "debug: inbound", attrs...)
+}
+
+// Outbound logs a request sent to a destination of the relay, with its body
+// as sent, and the response (or error), applying the relay's redact rules
+// (rc). It reads what it logs of the response body; the caller still closes
+// it.
+func (c *Capture) Outbound(log *slog.Logger, relayID string, rc config.RedactConfig, req *http.Request, body []byte, resp *http.Response, err error) {
+	if !c.Active(relayID) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("method", req.Method),
+		slog.String("url", req.URL.String()),
+		headerAttr("header", redact.Header(req.Header, rc)),
+	}
+	attrs = append(attrs, c.bodyAttrs("body", redact.Body(body, rc))...)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, c.maxBody+1))
+		attrs = append(attrs,
+			slog.Int("response_status", resp.StatusCode),
+			headerAttr("response_header", redact.Header(resp.Header, rc)))
+		attrs = append(attrs, c.bodyAttrs("response_body", redact.Body(respBody, rc))...)
+	}
+	log.LogAttrs(req.Context(), slog.LevelInfo, "debug: outbound", attrs...)
+}
+
+// bodyAttrs logs body as text, or base64 when it is not UTF-8, cut at
+// maxBody.
+func (c *Capture) bodyAttrs(key string, body []byte) []slog.Attr {
+	var attrs []slog.Attr
+	if int64(len(body)) > c.maxBody {
+		body = body[:c.maxBody]
+		attrs = append(attrs, slog.Bool(key+"_truncated", true))
+	}
+	if utf8.Valid(body) {
+		return append(attrs, slog.String(key, string(body)))
+	}
+	return append(attrs, slog.String(key, base64.StdEncoding.EncodeToString(body)), slog.String(key+"_encoding", "base64"))
+}
+
+// headerAttr logs h as an object of "Name": "value" (repeated values joined
+// with ", "), in name order.
+func headerAttr(key string, h http.Header) slog.Attr {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	attrs := make([]any, 0, len(names))
+	for _, name := range names {
+		attrs = append(attrs, slog.String(name, strings.Join(h[name], ", ")))
+	}
+	return slog.Group(key, attrs...)
+}
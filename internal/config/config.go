@@ -1,27 +1,336 @@
 package config
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
 	"time"
+
+	"webhookrelay/internal/loglevel"
+	"webhookrelay/internal/payload"
+	"webhookrelay/internal/provider"
+	"webhookrelay/internal/registry"
+	"webhookrelay/internal/schema"
+	"webhookrelay/internal/tmpl"
 )
 
 type Config struct {
-	Server ServerConfig  `json:"server"`
-	Relays []RelayConfig `json:"relays"`
+	Server  ServerConfig  `json:"server"`
+	Storage StorageConfig `json:"storage"`
+	Journal JournalConfig `json:"journal"`
+	Alerts  AlertsConfig  `json:"alerts"`
+	Admin   AdminConfig   `json:"admin"`
+	Metrics MetricsConfig `json:"metrics"`
+	Tracing TracingConfig `json:"tracing"`
+	Sentry  SentryConfig  `json:"sentry"`
+	// Telemetry selects the exporter for Metrics.
+	Telemetry TelemetryConfig `json:"telemetry"`
+	Relays    []RelayConfig   `json:"relays"`
+	// DeliveryLogFile writes every delivery attempt to an NDJSON file.
+	DeliveryLogFile DeliveryLogFileConfig `json:"delivery_log_file"`
+	// DestinationGroups are shared destination lists that relays and
+	// routes include with a {"group": name} destination.
+	DestinationGroups map[string][]DestinationConfig `json:"destination_groups,omitempty"`
+	// Defaults are settings inherited where they are left unset.
+	Defaults DefaultsConfig `json:"defaults"`
+	// HeaderSets are named header bundles that destinations add with
+	// header_sets.
+	HeaderSets map[string]map[string]string `json:"header_sets,omitempty"`
+	// LogLevel is the level of the operational log: debug, info (default),
+	// warn or error. The --log-level flag and WEBHOOKRELAY_LOG_LEVEL take
+	// precedence, and the admin API can change it at runtime.
+	LogLevel string `json:"log_level,omitempty"`
+	// Include lists more config files merged into this one: paths relative
+	// to this file, globs, or directories of config files.
+	Include []string `json:"include,omitempty"`
+	// Registry reads more relays from etcd or Consul and watches them.
+	Registry RegistryConfig `json:"registry"`
+	// Warnings are likely mistakes found by validation that do not stop the
+	// config from loading.
+	Warnings []string `json:"-"`
+	// Encrypted is set when a config file was decrypted on load.
+	Encrypted bool `json:"-"`
+}
+
+// RegistryConfig reads relays from a key-value store: every key under
+// Prefix holds one relay config as a JSON object. They are merged after
+// the config files and reloaded when the keys change.
+type RegistryConfig struct {
+	// Backend is "etcd" or "consul"; empty turns the registry off.
+	Backend string `json:"backend,omitempty"`
+	// Address is the base URL of the store's HTTP API, e.g.
+	// "http://127.0.0.1:2379" for etcd or "http://127.0.0.1:8500" for
+	// Consul.
+	Address string `json:"address,omitempty"`
+	// Prefix is the key prefix of the relays, e.g. "webhookrelay/relays/".
+	Prefix string `json:"prefix,omitempty"`
+	// Token is a Consul ACL token; Username and Password authenticate with
+	// etcd.
+	Token    string `json:"token,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// Client returns a client for the registry.
+func (r RegistryConfig) Client() (registry.Client, error) {
+	return registry.New(registry.Options{
+		Backend:  r.Backend,
+		Address:  r.Address,
+		Prefix:   r.Prefix,
+		Token:    r.Token,
+		Username: r.Username,
+		Password: r.Password,
+	})
+}
+
+// AdminConfig enables the admin API on its own listener. When Token is set,
+// requests must send it as a bearer token; it is required unless ListenAddr
+// is a loopback address or Unix socket.
+type AdminConfig struct {
+	ListenAddr string    `json:"listen_addr,omitempty"`
+	Token      string    `json:"token,omitempty"`
+	TLS        TLSConfig `json:"tls"`
+	SocketMode string    `json:"socket_mode,omitempty"`
+	// InspectEvents is how many recent inbound requests the dashboard's
+	// inspector keeps (default 100, -1 keeps none); bodies are cut at
+	// InspectMaxBodyBytes (default 64 KiB).
+	InspectEvents       int   `json:"inspect_events,omitempty"`
+	InspectMaxBodyBytes int64 `json:"inspect_max_body_bytes,omitempty"`
+	// Debug serves net/http/pprof under /debug/pprof/ and expvar at
+	// /debug/vars.
+	Debug bool `json:"debug,omitempty"`
+	// RelaysFile keeps the relays created through the admin API, and the
+	// names of the relays it stopped (see ManagedRelays). It is read with
+	// the config files.
+	RelaysFile string `json:"relays_file,omitempty"`
+	// StoppedRelays are the names of the relays stopped through the admin
+	// API, as read from RelaysFile.
+	StoppedRelays []string `json:"-"`
+}
+
+// MetricsConfig serves Prometheus metrics at Path, on their own listener
+// when ListenAddr is set and on the admin listener otherwise.
+type MetricsConfig struct {
+	Enabled    bool      `json:"enabled,omitempty"`
+	ListenAddr string    `json:"listen_addr,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	TLS        TLSConfig `json:"tls"`
+	SocketMode string    `json:"socket_mode,omitempty"`
+	// Labels chooses the labels of the relay's metrics, for every exporter.
+	Labels MetricLabelsConfig `json:"labels"`
+}
+
+// AlertsConfig notifies PagerDuty, Opsgenie, a webhook, Slack and/or the
+// log when one of Rules fires, and again once the condition clears.
+type AlertsConfig struct {
+	PagerDuty       PagerDutyConfig    `json:"pagerduty"`
+	Opsgenie        OpsgenieConfig     `json:"opsgenie"`
+	Webhook         AlertWebhookConfig `json:"webhook"`
+	Slack           AlertSlackConfig   `json:"slack"`
+	Log             bool               `json:"log,omitempty"`
+	CheckIntervalMS int                `json:"check_interval_ms,omitempty"`
+	Rules           []AlertRule        `json:"rules,omitempty"`
+}
+
+func (a AlertsConfig) CheckInterval() time.Duration {
+	return time.Duration(a.CheckIntervalMS) * time.Millisecond
+}
+
+type PagerDutyConfig struct {
+	RoutingKey string `json:"routing_key,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+type OpsgenieConfig struct {
+	APIKey   string `json:"api_key,omitempty"`
+	Priority string `json:"priority,omitempty"`
+	URL      string `json:"url,omitempty"`
+}
+
+// AlertWebhookConfig posts alerts as JSON to URL.
+type AlertWebhookConfig struct {
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// AlertSlackConfig posts alerts to a Slack incoming webhook.
+type AlertSlackConfig struct {
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// AlertRule sets exactly one condition: DLQCount fires once the dead letter
+// queue holds at least that many entries or, with WindowMS, once it grew by
+// that many within the window; ConsecutiveFailures fires once that many
+// deliveries in a row failed; FailureRate (0-1) fires once that share of at
+// least MinAttempts deliveries in the last WindowMS failed. Delivery
+// conditions apply to Relay (a relay name), Destination (a destination URL)
+// or both. A rule does not trigger again within CooldownMS of its last
+// notification.
+type AlertRule struct {
+	Name                string  `json:"name"`
+	DLQCount            int     `json:"dlq_count,omitempty"`
+	Relay               string  `json:"relay,omitempty"`
+	Destination         string  `json:"destination,omitempty"`
+	ConsecutiveFailures int     `json:"consecutive_failures,omitempty"`
+	FailureRate         float64 `json:"failure_rate,omitempty"`
+	WindowMS            int     `json:"window_ms,omitempty"`
+	MinAttempts         int     `json:"min_attempts,omitempty"`
+	CooldownMS          int     `json:"cooldown_ms,omitempty"`
+}
+
+func (r AlertRule) Window() time.Duration {
+	return time.Duration(r.WindowMS) * time.Millisecond
+}
+
+func (r AlertRule) Cooldown() time.Duration {
+	return time.Duration(r.CooldownMS) * time.Millisecond
+}
+
+// JournalConfig enables the write-ahead intake journal. Fsync is one of
+// "always" (default), "interval" or "never".
+type JournalConfig struct {
+	Path            string `json:"path,omitempty"`
+	Fsync           string `json:"fsync,omitempty"`
+	FsyncIntervalMS int    `json:"fsync_interval_ms,omitempty"`
+	MaxBytes        int64  `json:"max_bytes,omitempty"`
+}
+
+func (j JournalConfig) FsyncInterval() time.Duration {
+	return time.Duration(j.FsyncIntervalMS) * time.Millisecond
+}
+
+// DeliveryLogFileConfig enables the NDJSON delivery log file at Path. It is
+// rotated once it would grow past MaxBytes (default 100 MiB) or has been
+// open for MaxAgeHours (0: never); MaxFiles rotated files (default 10) are
+// kept, gzipped if Compress is set.
+type DeliveryLogFileConfig struct {
+	Path        string `json:"path,omitempty"`
+	MaxBytes    int64  `json:"max_bytes,omitempty"`
+	MaxAgeHours int    `json:"max_age_hours,omitempty"`
+	MaxFiles    int    `json:"max_files,omitempty"`
+	Compress    bool   `json:"compress,omitempty"`
+}
+
+func (d DeliveryLogFileConfig) MaxAge() time.Duration {
+	return time.Duration(d.MaxAgeHours) * time.Hour
+}
+
+// Storage backends.
+const (
+	StorageMemory   = "memory"
+	StorageSQLite   = "sqlite"
+	StoragePostgres = "postgres"
+	StorageS3       = "s3"
+)
+
+// StorageConfig selects where the delivery queue, delivery log, dead letters
+// and config snapshots are kept.
+type StorageConfig struct {
+	Backend        string   `json:"backend,omitempty"`
+	Path           string   `json:"path,omitempty"`
+	DSN            string   `json:"dsn,omitempty"`
+	S3             S3Config `json:"s3"`
+	RetentionHours int      `json:"retention_hours,omitempty"`
+	// SpoolDir, when set, buffers accepted jobs on local disk while the
+	// backend is unavailable instead of failing inbound requests.
+	SpoolDir      string `json:"spool_dir,omitempty"`
+	SpoolMaxBytes int64  `json:"spool_max_bytes,omitempty"`
+}
+
+// Retention is how long delivery log entries are kept.
+func (s StorageConfig) Retention() time.Duration {
+	h := s.RetentionHours
+	if h <= 0 {
+		h = 168
+	}
+	return time.Duration(h) * time.Hour
+}
+
+type S3Config struct {
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	PathStyle       bool   `json:"path_style,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
 }
 
 type ServerConfig struct {
-	ListenAddr       string `json:"listen_addr"`
+	ListenAddr string    `json:"listen_addr"`
+	TLS        TLSConfig `json:"tls"`
+	// SocketMode sets the permissions of a Unix socket ListenAddr.
+	SocketMode       string `json:"socket_mode,omitempty"`
 	BasePath         string `json:"base_path,omitempty"`
 	ForwardTimeoutMS int    `json:"forward_timeout_ms,omitempty"`
 	Concurrency      int    `json:"concurrency,omitempty"`
+	// CompileCacheDir keeps the machine code of compiled plugin modules so
+	// restarts and reloads do not compile unchanged ones again.
+	CompileCacheDir string `json:"compile_cache_dir,omitempty"`
+
+	Deadline DeadlineConfig `json:"deadline"`
+	// AccessLog logs every request the relay listeners answer.
+	AccessLog AccessLogConfig `json:"access_log"`
+	// SlowForward flags destinations that keep answering slowly.
+	SlowForward SlowForwardConfig `json:"slow_forward"`
+	// Listeners are served next to ListenAddr, e.g. to bind public hook
+	// ingestion and internal relays to different interfaces.
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+	// WatchConfig reloads the config file when it changes, as on SIGHUP.
+	WatchConfig bool `json:"watch_config,omitempty"`
+	// WatchIntervalMS is how often WatchConfig checks for changes: 2 s by
+	// default, 30 s for a config fetched from a URL.
+	WatchIntervalMS int `json:"watch_interval_ms,omitempty"`
+}
+
+// AccessLogConfig enables the access log, written as JSON lines to Path
+// (appended to) or, without one, to the operational log.
+type AccessLogConfig struct {
+	Enabled bool   `json:"enabled,omitempty"`
+	Path    string `json:"path,omitempty"`
+}
+
+// SlowForwardConfig marks a destination slow once Consecutive deliveries in
+// a row took ThresholdMS or longer, and fast again after as many quicker
+// ones. While slow, at most MaxConcurrency workers deliver to it (0: no
+// limit).
+type SlowForwardConfig struct {
+	ThresholdMS    int `json:"threshold_ms,omitempty"`
+	Consecutive    int `json:"consecutive,omitempty"`
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+}
+
+func (s SlowForwardConfig) Threshold() time.Duration {
+	return time.Duration(s.ThresholdMS) * time.Millisecond
+}
+
+// DeadlineConfig lets trusted internal producers bound the whole delivery of an
+// event by sending an absolute deadline header. Deadlines are only honored for
+// requests whose remote address falls inside TrustedCIDRs.
+type DeadlineConfig struct {
+	Header       string   `json:"header,omitempty"`
+	MaxMS        int      `json:"max_ms,omitempty"`
+	TrustedCIDRs []string `json:"trusted_cidrs,omitempty"`
+}
+
+func (d DeadlineConfig) Max() time.Duration {
+	ms := d.MaxMS
+	if ms <= 0 {
+		ms = 60_000
+	}
+	return time.Duration(ms) * time.Millisecond
 }
 
 func (s ServerConfig) ForwardTimeout() time.Duration {
@@ -33,49 +342,605 @@ func (s ServerConfig) ForwardTimeout() time.Duration {
 }
 
 type RelayConfig struct {
+	Name       string `json:"name,omitempty"`
+	ListenPath string `json:"listen_path,omitempty"`
+	// ListenPathRegex matches the request path (below server.base_path)
+	// against a regular expression instead; named groups become params.
+	ListenPathRegex string              `json:"listen_path_regex,omitempty"`
+	Methods         []string            `json:"methods,omitempty"`
+	Destinations    []DestinationConfig `json:"destinations"`
+	EventTTLMS      int                 `json:"event_ttl_ms,omitempty"`
+	// Redact applies to copies of events persisted in history (the DLQ) and
+	// to event data written to logs.
+	Redact RedactConfig `json:"redact"`
+	// Backfill lets senders supply the original event time in the
+	// X-WebhookRelay-Backfill-Timestamp header, e.g. when migrating history.
+	Backfill bool `json:"backfill,omitempty"`
+	// MaxBodyBytes rejects larger inbound bodies (after gzip decoding) with
+	// 413. Zero means no limit.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// ContentTypes rejects requests with other media types with 415, before
+	// the body is read. Entries may be "type/*" or "*+suffix".
+	ContentTypes []string     `json:"content_types,omitempty"`
+	Plugin       PluginConfig `json:"plugin"`
+	Script       ScriptConfig `json:"script"`
+	// Transform hands the body to an external service before each delivery.
+	Transform RelayTransformConfig `json:"transform"`
+	// Strategy is how an event is delivered to its destinations: to all of
+	// them ("fan_out", the default) or to the first that accepts it, trying
+	// them in order ("first_success").
+	Strategy string `json:"strategy,omitempty"`
+	// Routes are tried in order; the first whose match holds supplies the
+	// destinations. Events no route matches go to Destinations.
+	Routes []RouteConfig `json:"routes,omitempty"`
+	// DetectProvider recognizes well-known senders (GitHub, Stripe, ...) by
+	// their headers, for routing on provider and event type.
+	DetectProvider bool `json:"detect_provider,omitempty"`
+	// LogSampleRate logs one in this many successful deliveries of the
+	// relay, for high-volume relays; failures are always logged. Zero or 1
+	// logs every delivery.
+	LogSampleRate int `json:"log_sample_rate,omitempty"`
+	// ForwardTimeoutMS replaces server.forward_timeout_ms for the relay's
+	// deliveries, e.g. for a slow batch consumer, and Concurrency caps how
+	// many of them are in flight at once (within server.concurrency).
+	ForwardTimeoutMS int `json:"forward_timeout_ms,omitempty"`
+	Concurrency      int `json:"concurrency,omitempty"`
+	// Enabled false takes the relay out of service, keeping its config,
+	// path and history: requests are answered with disabled_status and
+	// nothing is queued.
+	Enabled *bool `json:"enabled,omitempty"`
+	// DisabledStatus is the status a disabled relay answers with: 503 (the
+	// default), or 404 as if no relay served the path.
+	DisabledStatus int `json:"disabled_status,omitempty"`
+}
+
+// Disabled reports whether the relay is out of service.
+func (r RelayConfig) Disabled() bool {
+	return r.Enabled != nil && !*r.Enabled
+}
+
+// ForwardTimeout is the relay's forward timeout, or else the server's.
+func (r RelayConfig) ForwardTimeout(s ServerConfig) time.Duration {
+	if r.ForwardTimeoutMS > 0 {
+		return time.Duration(r.ForwardTimeoutMS) * time.Millisecond
+	}
+	return s.ForwardTimeout()
+}
+
+// Delivery strategies.
+const (
+	StrategyFanOut       = "fan_out"
+	StrategyFirstSuccess = "first_success"
+)
+
+// RouteConfig sends the events that satisfy Match to its own destinations.
+type RouteConfig struct {
 	Name         string              `json:"name,omitempty"`
-	ListenPath   string              `json:"listen_path,omitempty"`
-	Methods      []string            `json:"methods,omitempty"`
+	Match        MatchConfig         `json:"match"`
 	Destinations []DestinationConfig `json:"destinations"`
 }
 
+// RelayTransformConfig holds relay-level body transforms.
+type RelayTransformConfig struct {
+	HTTP TransformHTTPConfig `json:"http"`
+}
+
+// Transform service failure policies.
+const (
+	TransformFailDLQ             = "dlq"
+	TransformFailDrop            = "drop"
+	TransformFailForwardOriginal = "forward_original"
+)
+
+// TransformHTTPConfig POSTs the event body to URL before each delivery and
+// forwards the response instead. A 204 response drops the event; errors,
+// timeouts and other non-2xx responses are handled by OnFailure: "dlq"
+// (default), "drop" or "forward_original".
+type TransformHTTPConfig struct {
+	URL       string            `json:"url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	TimeoutMS int               `json:"timeout_ms,omitempty"`
+	OnFailure string            `json:"on_failure,omitempty"`
+}
+
+func (t TransformHTTPConfig) Timeout() time.Duration {
+	return time.Duration(t.TimeoutMS) * time.Millisecond
+}
+
+// ScriptConfig runs a Lua script over every inbound event of a relay to
+// filter, route or edit it (see package script).
+type ScriptConfig struct {
+	Path      string `json:"path,omitempty"`
+	TimeoutMS int    `json:"timeout_ms,omitempty"`
+	// FailOpen forwards the event unchanged to every destination when the
+	// script fails, instead of answering 503.
+	FailOpen bool `json:"fail_open,omitempty"`
+}
+
+func (s ScriptConfig) Timeout() time.Duration {
+	return time.Duration(s.TimeoutMS) * time.Millisecond
+}
+
+// PluginConfig runs a WebAssembly module over every inbound event of a
+// relay before it is queued (see package plugin for the ABI).
+type PluginConfig struct {
+	Path          string `json:"path,omitempty"`
+	TimeoutMS     int    `json:"timeout_ms,omitempty"`
+	MemoryLimitMB int    `json:"memory_limit_mb,omitempty"`
+	// FailOpen forwards the event unchanged when the plugin fails, instead
+	// of answering 503.
+	FailOpen bool `json:"fail_open,omitempty"`
+	// CacheDir is server.compile_cache_dir.
+	CacheDir string `json:"-"`
+}
+
+func (p PluginConfig) Timeout() time.Duration {
+	return time.Duration(p.TimeoutMS) * time.Millisecond
+}
+
+// EventTTL is how long an accepted event may wait for delivery before it is
+// expired. Zero means events never expire.
+func (r RelayConfig) EventTTL() time.Duration {
+	if r.EventTTLMS <= 0 {
+		return 0
+	}
+	return time.Duration(r.EventTTLMS) * time.Millisecond
+}
+
+// HashKeyConfig is where the key of an event's entity (order, repo,
+// tenant, ...) is read from: a header or a dotted body path.
+type HashKeyConfig struct {
+	Header string `json:"header,omitempty"`
+	Field  string `json:"field,omitempty"`
+}
+
 type DestinationConfig struct {
+	// Group stands for the destinations of the named destination group; it
+	// is replaced by them when the config is loaded.
+	Group string `json:"group,omitempty"`
+	// HashKey, on a group reference, sends each event to only one of the
+	// group's destinations, chosen by consistent hashing of the key.
+	HashKey *HashKeyConfig `json:"hash_key,omitempty"`
+	// HashSet identifies the group reference a destination with a HashKey
+	// was expanded from; one destination of each set receives an event.
+	HashSet string `json:"-"`
+	// OutlierDetection, on a group reference, takes group destinations that
+	// keep failing out of the hash set or failover order for a while.
+	OutlierDetection *OutlierDetectionConfig `json:"outlier_detection,omitempty"`
+	// OutlierSet identifies the group reference a destination with
+	// OutlierDetection was expanded from.
+	OutlierSet string `json:"-"`
+	// Relay names the target of a "relay" destination, which has no URL.
+	Relay       string            `json:"relay,omitempty"`
+	Type        string            `json:"type,omitempty"`
 	URL         string            `json:"url"`
 	Method      string            `json:"method,omitempty"`
 	Headers     map[string]string `json:"headers,omitempty"`
 	Description string            `json:"description,omitempty"`
+	// HeaderSets names entries of the top-level header_sets whose headers
+	// are added to Headers, which win; a later set wins over an earlier one.
+	HeaderSets []string `json:"header_sets,omitempty"`
+	// When restricts the destination to matching events. Fallback
+	// destinations receive only events no When destination matched.
+	When     *MatchConfig `json:"when,omitempty"`
+	Fallback bool         `json:"fallback,omitempty"`
+	// Weight splits traffic: of the selected destinations that have a
+	// weight, each event goes to exactly one, chosen in proportion to the
+	// weights from its request ID (5 and 95 send 5% to the first).
+	Weight int `json:"weight,omitempty"`
+	// Shadow mirrors events to the destination without letting its results
+	// matter: failures are logged but never dead-lettered or alerted on.
+	Shadow bool `json:"shadow,omitempty"`
+	// SampleRate (0.0-1.0) is the fraction of events the destination
+	// receives; unset means all of them.
+	SampleRate *float64 `json:"sample_rate,omitempty"`
+	// Decode overrides how the inbound body is parsed ("json", "form",
+	// "xml", "raw"); by default it follows the inbound Content-Type.
+	Decode    string          `json:"decode,omitempty"`
+	Transform []TransformStep `json:"transform,omitempty"`
+	// EncodeAs re-encodes the body as "json", "form", "xml" or "protobuf"
+	// after enrichment and transforms.
+	EncodeAs  string          `json:"encode_as,omitempty"`
+	XML       XMLConfig       `json:"xml"`
+	Protobuf  ProtobufConfig  `json:"protobuf"`
+	Multipart MultipartConfig `json:"multipart"`
+	Slack     SlackConfig     `json:"slack"`
+	Discord   DiscordConfig   `json:"discord"`
+	Teams     TeamsConfig     `json:"teams"`
+	Enrich    EnrichConfig    `json:"enrich"`
+	Redact    RedactConfig    `json:"redact"`
+	// Envelope wraps the body as {"id", "relay", "received_at", "headers",
+	// "payload"} so consumers of many relays see one structure.
+	Envelope    bool              `json:"envelope,omitempty"`
+	CloudEvents CloudEventsConfig `json:"cloudevents"`
+	// Compress gzips the outgoing body and sets Content-Encoding: gzip.
+	Compress bool        `json:"compress,omitempty"`
+	Query    QueryConfig `json:"query"`
+	// AllowedURLs lists the patterns a templated URL must match once
+	// rendered: "*" matches one or more characters within a path segment,
+	// "**" matches anything. Looked-up URLs must match them too.
+	AllowedURLs []string `json:"allowed_urls,omitempty"`
+	// Lookup resolves the URL per event from a table instead of URL.
+	Lookup *LookupConfig `json:"lookup,omitempty"`
+	// HealthCheck probes the destination so failover can skip it while it
+	// is down.
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+	// TimeoutMS bounds each delivery to the destination; it is at most, and
+	// by default, server.forward_timeout_ms.
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// SkipDefaults leaves defaults.destination out, e.g. for a third-party
+	// destination that must not get internal headers.
+	SkipDefaults bool `json:"skip_defaults,omitempty"`
 }
 
-func Load(configPath string) (Config, error) {
-	b, err := os.ReadFile(configPath)
+// DefaultsConfig holds settings that config items inherit unless they set
+// their own.
+type DefaultsConfig struct {
+	Destination DestinationDefaults `json:"destination"`
+}
+
+// DestinationDefaults apply to every http destination (relays', routes'
+// and groups') without skip_defaults: Headers are added to the
+// destination's own, which win, and Method and TimeoutMS apply when the
+// destination sets none.
+type DestinationDefaults struct {
+	Headers   map[string]string `json:"headers,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	TimeoutMS int               `json:"timeout_ms,omitempty"`
+}
+
+// LookupConfig finds a destination URL by a key rendered from the event
+// (a template such as "{{ .body.customer_id }}"). The table is either File,
+// a JSON object of key -> URL re-read when it changes, or an HTTP service:
+// GET HTTP with "{key}" replaced by the escaped key, answering 200 with the
+// URL (as text or {"url": ...}) or 404 for unknown keys. Service answers
+// are cached for CacheTTLMS (default 60000).
+type LookupConfig struct {
+	Key        string `json:"key"`
+	File       string `json:"file,omitempty"`
+	HTTP       string `json:"http,omitempty"`
+	CacheTTLMS int    `json:"cache_ttl_ms,omitempty"`
+}
+
+// LookupURLPrefix starts the URL recorded for lookup destinations, e.g.
+// "lookup:callbacks.json".
+const LookupURLPrefix = "lookup:"
+
+func (l LookupConfig) CacheTTL() time.Duration {
+	return time.Duration(l.CacheTTLMS) * time.Millisecond
+}
+
+// LoadLookupFile reads a lookup table: a JSON object of key -> URL.
+func LoadLookupFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		return Config{}, fmt.Errorf("read config: %w", err)
+		return nil, err
+	}
+	var table map[string]string
+	if err := json.Unmarshal(b, &table); err != nil {
+		return nil, fmt.Errorf("%s: must be a JSON object of key to URL: %w", path, err)
 	}
+	return table, nil
+}
 
-	dec := json.NewDecoder(bytes.NewReader(b))
-	dec.DisallowUnknownFields()
+// URLTemplated reports whether a destination URL contains template actions.
+func URLTemplated(u string) bool {
+	return strings.Contains(u, "{{")
+}
 
-	var cfg Config
-	if err := dec.Decode(&cfg); err != nil {
-		return Config{}, fmt.Errorf("parse config json: %w", err)
+// CompileURLPattern turns an allowed_urls entry into an anchored regexp.
+func CompileURLPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, errors.New("empty pattern")
 	}
-	// Ensure no trailing tokens.
-	if err := dec.Decode(&struct{}{}); err != io.EOF {
-		return Config{}, fmt.Errorf("parse config json: extra data after first JSON object")
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			b.WriteString("[^/?#]+")
+			i++
+		default:
+			j := i
+			for j < len(pattern) && pattern[j] != '*' {
+				j++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i:j]))
+			i = j
+		}
 	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Query string modes.
+const (
+	QueryDrop   = "drop"
+	QueryAppend = "append"
+	QueryMerge  = "merge"
+)
+
+// QueryConfig controls what of the inbound query string reaches a
+// destination. Mode is "drop" (default), "append" (inbound query added after
+// the destination URL's own) or "merge" (inbound parameters added unless the
+// destination URL already sets them). Params copies selected inbound
+// parameters under a new name (destination name -> inbound name) in any mode.
+type QueryConfig struct {
+	Mode   string            `json:"mode,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Redaction actions.
+const (
+	RedactMask   = "mask"
+	RedactRemove = "remove"
+)
+
+// RedactConfig masks or removes sensitive data. Fields are case-insensitive
+// key patterns (path.Match syntax, e.g. "*token*") matched at any depth;
+// Paths address specific values ($.card.number, $.items[*].email); Headers
+// are header names to drop.
+type RedactConfig struct {
+	Fields  []string `json:"fields,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	Headers []string `json:"headers,omitempty"`
+	Action  string   `json:"action,omitempty"`
+	Mask    string   `json:"mask,omitempty"`
+}
+
+// EnrichConfig injects fields into a JSON object body before it is forwarded.
+// Fields are static values; Metadata maps a target field name to one of the
+// EnrichMetadata sources.
+type EnrichConfig struct {
+	Fields   map[string]any    `json:"fields,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// EnrichMetadata lists the relay metadata an enrich block can inject.
+var EnrichMetadata = []string{"relay", "request_id", "received_at", "source_ip", "method"}
+
+// Destination types. The zero value forwards the request as-is over HTTP.
+const (
+	DestinationHTTP    = "http"
+	DestinationSlack   = "slack"
+	DestinationDiscord = "discord"
+	DestinationTeams   = "teams"
+	// DestinationRelay hands the event to another relay in process.
+	DestinationRelay = "relay"
+	// DestinationDrop runs the event through the destination's pipeline and
+	// records it as delivered without sending anything.
+	DestinationDrop = "drop"
+)
+
+// DropURL is recorded for drop destinations that have no URL.
+const DropURL = "drop:"
 
-	if err := validateAndDefault(&cfg); err != nil {
-		return Config{}, err
+// RelayURLPrefix starts the URL recorded for relay destinations, e.g.
+// "relay://stage2".
+const RelayURLPrefix = "relay://"
+
+// SlackConfig builds a Slack incoming-webhook message from the inbound event.
+// Text, Blocks and Attachments are text/template strings rendered against the
+// event; Blocks and Attachments must render to JSON arrays.
+type SlackConfig struct {
+	Text        string `json:"text,omitempty"`
+	Blocks      string `json:"blocks,omitempty"`
+	Attachments string `json:"attachments,omitempty"`
+	Username    string `json:"username,omitempty"`
+	IconEmoji   string `json:"icon_emoji,omitempty"`
+	IconURL     string `json:"icon_url,omitempty"`
+	Channel     string `json:"channel,omitempty"`
+}
+
+// DiscordConfig builds a Discord webhook message from the inbound event.
+// Content, Title and Description are text/template strings; Embeds must render
+// to a JSON array of embed objects and replaces the single Title/Description
+// embed when set.
+type DiscordConfig struct {
+	Content     string `json:"content,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Color       int    `json:"color,omitempty"`
+	Embeds      string `json:"embeds,omitempty"`
+	Username    string `json:"username,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// TeamsConfig builds a Microsoft Teams message carrying an Adaptive Card.
+// Card is a text/template that must render to an AdaptiveCard JSON object;
+// without it, Title and Text templates are rendered into a simple card.
+type TeamsConfig struct {
+	Card  string `json:"card,omitempty"`
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"`
+}
+
+// ProtobufConfig selects the message a body is encoded as when EncodeAs is
+// "protobuf": Message is its full name in the compiled DescriptorSet.
+// Payload fields the message does not have are an error unless
+// DiscardUnknown is set.
+type ProtobufConfig struct {
+	DescriptorSet  string `json:"descriptor_set,omitempty"`
+	Message        string `json:"message,omitempty"`
+	DiscardUnknown bool   `json:"discard_unknown,omitempty"`
+}
+
+// MatchConfig is a condition on an inbound event; every condition that is
+// set must hold. Headers maps a header name to accepted values, any of
+// which may match one of the header's values; "*" accepts any value as long
+// as the header is present.
+//
+// Fields does the same for dotted paths into the decoded body: scalars are
+// compared as text (42, true) and arrays match when any element does.
+// HeaderRegex and FieldRegex map a header or path to a regular expression
+// that one of its values must match. Query does the same as Headers for
+// query parameters; Methods lists accepted request methods. Schedule holds
+// during its time window.
+type MatchConfig struct {
+	Methods     []string            `json:"methods,omitempty"`
+	Query       map[string][]string `json:"query,omitempty"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Fields      map[string][]string `json:"fields,omitempty"`
+	HeaderRegex map[string]string   `json:"header_regex,omitempty"`
+	FieldRegex  map[string]string   `json:"field_regex,omitempty"`
+	Schedule    *ScheduleConfig     `json:"schedule,omitempty"`
+	// Schema is the path of a JSON Schema file the decoded body must
+	// validate against.
+	Schema string `json:"schema,omitempty"`
+	// Providers and EventTypes match what detect_provider found.
+	Providers  []string `json:"providers,omitempty"`
+	EventTypes []string `json:"event_types,omitempty"`
+}
+
+func (m MatchConfig) usesProvider() bool {
+	return len(m.Providers)+len(m.EventTypes) > 0
+}
+
+// ScheduleConfig is a weekly time window in Timezone (an IANA name, default
+// UTC). Days are "mon" to "sun", empty meaning every day; From and To are
+// "HH:MM" with To exclusive, and a To before From wraps past midnight (the
+// window then belongs to the day it starts on).
+type ScheduleConfig struct {
+	Timezone string   `json:"timezone,omitempty"`
+	Days     []string `json:"days,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       string   `json:"to,omitempty"`
+}
+
+// ScheduleDays are the accepted ScheduleConfig.Days, indexed by
+// time.Weekday.
+var ScheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleMinutes parses an "HH:MM" schedule time into minutes after
+// midnight; "24:00" is allowed as the end of the day.
+func ScheduleMinutes(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		if hhmm == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("%q is not an HH:MM time", hhmm)
 	}
+	return t.Hour()*60 + t.Minute(), nil
+}
 
-	return cfg, nil
+// CloudEvents modes.
+const (
+	CloudEventsStructured = "structured"
+	CloudEventsBinary     = "binary"
+	CloudEventsUnwrap     = "unwrap"
+)
+
+// CloudEventsConfig converts between plain webhooks and CloudEvents 1.0
+// over HTTP. "structured" and "binary" wrap the outgoing body in the
+// respective content mode; "unwrap" turns an inbound CloudEvent back into
+// its data before the payload pipeline runs. Source, Type, Subject and
+// Extensions are templates; Source and Type default to
+// "/webhookrelay/<relay>" and "webhookrelay.<relay>".
+type CloudEventsConfig struct {
+	Mode       string            `json:"mode,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Type       string            `json:"type,omitempty"`
+	Subject    string            `json:"subject,omitempty"`
+	Extensions map[string]string `json:"extensions,omitempty"`
 }
 
+// Multipart modes.
+const (
+	MultipartForward          = "forward"
+	MultipartStripAttachments = "strip_attachments"
+	MultipartJSON             = "json"
+)
+
+// MultipartConfig controls multipart/form-data bodies. Mode is "forward"
+// (default: as received), "strip_attachments" (file parts removed) or "json"
+// (parts converted to a JSON object). Attachment content is only kept in
+// "json" mode with IncludeAttachments, and attachments over
+// MaxAttachmentBytes lose their content in every mode that decodes the body.
+type MultipartConfig struct {
+	Mode               string `json:"mode,omitempty"`
+	IncludeAttachments bool   `json:"include_attachments,omitempty"`
+	MaxAttachmentBytes int64  `json:"max_attachment_bytes,omitempty"`
+}
+
+// Transform operations.
+const (
+	TransformSet    = "set"
+	TransformDelete = "delete"
+	TransformRename = "rename"
+	TransformCopy   = "copy"
+)
+
+// TransformStep edits the decoded body. Path and To are dotted paths such
+// as "user.email" or "items.0.id"; Value is used by "set".
+type TransformStep struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	To    string `json:"to,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// XMLConfig controls how a decoded payload is rendered when a destination
+// sets encode_as to "xml".
+type XMLConfig struct {
+	RootElement string   `json:"root_element,omitempty"`
+	ItemElement string   `json:"item_element,omitempty"`
+	Attributes  []string `json:"attributes,omitempty"`
+}
+
+// Load reads a config file in the format its extension implies, see
+// FormatOf, with the files it includes.
+func Load(configPath string) (Config, error) {
+	return Source{Path: configPath}.Load()
+}
+
+// LoadFormat reads a config file in format, FormatJSON, FormatYAML or
+// FormatTOML; an empty format is taken from the file's extension.
+func LoadFormat(configPath, format string) (Config, error) {
+	return Source{Path: configPath, Format: format}.Load()
+}
+
+// validateAndDefault checks cfg and fills in defaults.
 func validateAndDefault(cfg *Config) error {
 	var problems []string
 
-	if strings.TrimSpace(cfg.Server.ListenAddr) == "" {
-		problems = append(problems, "server.listen_addr is required")
+	problems = append(problems, validateTelemetry(&cfg.Telemetry)...)
+	if (cfg.Telemetry.PushOTLP() || cfg.Telemetry.PushStatsD()) && !cfg.Metrics.Enabled {
+		problems = append(problems, fmt.Sprintf("telemetry.metrics_exporter %q requires metrics.enabled", cfg.Telemetry.MetricsExporter))
+	}
+	problems = append(problems, validateMetricLabels(&cfg.Metrics.Labels)...)
+	problems = append(problems, validateListeners(cfg)...)
+	problems = append(problems, validateTracing(&cfg.Tracing)...)
+	problems = append(problems, validateSentry(&cfg.Sentry)...)
+	if cfg.LogLevel != "" {
+		if _, err := loglevel.Parse(cfg.LogLevel); err != nil {
+			problems = append(problems, fmt.Sprintf("log_level %q: %v", cfg.LogLevel, err))
+		}
+	}
+
+	switch {
+	case cfg.Admin.InspectEvents == 0:
+		cfg.Admin.InspectEvents = 100
+	case cfg.Admin.InspectEvents < -1:
+		problems = append(problems, fmt.Sprintf("admin.inspect_events must be positive, or -1 to disable the inspector (got %d)", cfg.Admin.InspectEvents))
+	}
+	switch {
+	case cfg.Admin.InspectMaxBodyBytes == 0:
+		cfg.Admin.InspectMaxBodyBytes = 64 << 10
+	case cfg.Admin.InspectMaxBodyBytes < 0:
+		problems = append(problems, fmt.Sprintf("admin.inspect_max_body_bytes must be positive (got %d)", cfg.Admin.InspectMaxBodyBytes))
+	}
+	if cfg.Admin.Debug && cfg.Admin.ListenAddr == "" {
+		problems = append(problems, "admin.debug requires admin.listen_addr; the debug endpoints are only served on the admin listener")
+	}
+	if cfg.Admin.ListenAddr != "" && cfg.Admin.Token == "" && !LocalAddr(cfg.Admin.ListenAddr) {
+		problems = append(problems, fmt.Sprintf("admin.token is required with admin.listen_addr %q, which is not a loopback address or Unix socket; anyone who can reach it could change the relay", cfg.Admin.ListenAddr))
+	}
+	if cfg.Admin.RelaysFile != "" && (cfg.Admin.ListenAddr == "" || cfg.Admin.Token == "") {
+		problems = append(problems, "admin.relays_file requires admin.listen_addr and admin.token; relays are only changed through the admin API, with the token")
 	}
 
 	if cfg.Server.Concurrency <= 0 {
@@ -87,10 +952,134 @@ func validateAndDefault(cfg *Config) error {
 
 	cfg.Server.BasePath = normalizeBasePath(cfg.Server.BasePath)
 
-	if len(cfg.Relays) == 0 {
+	cfg.Server.Deadline.Header = strings.TrimSpace(cfg.Server.Deadline.Header)
+	if cfg.Server.Deadline.Header == "" {
+		cfg.Server.Deadline.Header = "X-WebhookRelay-Deadline"
+	}
+	if cfg.Server.Deadline.MaxMS <= 0 {
+		cfg.Server.Deadline.MaxMS = 60_000
+	}
+	for i, c := range cfg.Server.Deadline.TrustedCIDRs {
+		if _, err := netip.ParsePrefix(strings.TrimSpace(c)); err != nil {
+			problems = append(problems, fmt.Sprintf("server.deadline.trusted_cidrs[%d] is not a valid CIDR (got %q)", i, c))
+		}
+	}
+
+	if cfg.Server.WatchIntervalMS < 0 {
+		problems = append(problems, fmt.Sprintf("server.watch_interval_ms must not be negative (got %d)", cfg.Server.WatchIntervalMS))
+	}
+	if al := cfg.Server.AccessLog; al.Path != "" && !al.Enabled {
+		problems = append(problems, "server.access_log.path is set but server.access_log.enabled is not")
+	}
+	if sf := &cfg.Server.SlowForward; sf.ThresholdMS < 0 || sf.Consecutive < 0 || sf.MaxConcurrency < 0 {
+		problems = append(problems, "server.slow_forward values must not be negative")
+	} else if sf.ThresholdMS == 0 && (sf.Consecutive != 0 || sf.MaxConcurrency != 0) {
+		problems = append(problems, "server.slow_forward needs threshold_ms")
+	} else if sf.ThresholdMS > 0 && sf.Consecutive == 0 {
+		sf.Consecutive = 5
+	}
+
+	cfg.Storage.Backend = strings.ToLower(strings.TrimSpace(cfg.Storage.Backend))
+	if cfg.Storage.Backend == "" {
+		cfg.Storage.Backend = StorageMemory
+	}
+	switch cfg.Storage.Backend {
+	case StorageMemory:
+	case StorageSQLite:
+		if strings.TrimSpace(cfg.Storage.Path) == "" {
+			problems = append(problems, "storage.path is required for the sqlite backend")
+		}
+	case StoragePostgres:
+		if strings.TrimSpace(cfg.Storage.DSN) == "" {
+			problems = append(problems, "storage.dsn is required for the postgres backend")
+		}
+	case StorageS3:
+		if strings.TrimSpace(cfg.Storage.S3.Bucket) == "" {
+			problems = append(problems, "storage.s3.bucket is required for the s3 backend")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("storage.backend must be one of \"memory\", \"sqlite\", \"postgres\", \"s3\" (got %q)", cfg.Storage.Backend))
+	}
+	if cfg.Storage.RetentionHours <= 0 {
+		cfg.Storage.RetentionHours = 168
+	}
+	if cfg.Storage.SpoolMaxBytes <= 0 {
+		cfg.Storage.SpoolMaxBytes = 1 << 30
+	}
+
+	cfg.Journal.Fsync = strings.ToLower(strings.TrimSpace(cfg.Journal.Fsync))
+	if cfg.Journal.Fsync == "" {
+		cfg.Journal.Fsync = "always"
+	}
+	switch cfg.Journal.Fsync {
+	case "always", "interval", "never":
+	default:
+		problems = append(problems, fmt.Sprintf("journal.fsync must be one of \"always\", \"interval\", \"never\" (got %q)", cfg.Journal.Fsync))
+	}
+	if cfg.Journal.FsyncIntervalMS <= 0 {
+		cfg.Journal.FsyncIntervalMS = 100
+	}
+	if cfg.Journal.MaxBytes <= 0 {
+		cfg.Journal.MaxBytes = 64 << 20
+	}
+
+	if dl := &cfg.DeliveryLogFile; dl.Path != "" {
+		if dl.MaxBytes < 0 {
+			problems = append(problems, "delivery_log_file.max_bytes must not be negative")
+		} else if dl.MaxBytes == 0 {
+			dl.MaxBytes = 100 << 20
+		}
+		if dl.MaxAgeHours < 0 {
+			problems = append(problems, "delivery_log_file.max_age_hours must not be negative")
+		}
+		if dl.MaxFiles < 0 {
+			problems = append(problems, "delivery_log_file.max_files must not be negative")
+		} else if dl.MaxFiles == 0 {
+			dl.MaxFiles = 10
+		}
+	}
+
+	// Relays may all come from the admin API or the registry.
+	if len(cfg.Relays) == 0 && cfg.Admin.RelaysFile == "" && cfg.Registry.Backend == "" {
 		problems = append(problems, "relays must be a non-empty array")
 	}
 
+	problems = append(problems, validateAlerts(cfg)...)
+
+	sets := make([]string, 0, len(cfg.HeaderSets))
+	for name := range cfg.HeaderSets {
+		sets = append(sets, name)
+	}
+	sort.Strings(sets)
+	for _, name := range sets {
+		if len(cfg.HeaderSets[name]) == 0 {
+			problems = append(problems, fmt.Sprintf("header_sets.%s must be non-empty", name))
+		}
+	}
+
+	groups := make([]string, 0, len(cfg.DestinationGroups))
+	for name := range cfg.DestinationGroups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		dests := cfg.DestinationGroups[name]
+		if len(dests) == 0 {
+			problems = append(problems, fmt.Sprintf("destination_groups.%s must be non-empty", name))
+		}
+		for di := range dests {
+			prefix := fmt.Sprintf("destination_groups.%s[%d]", name, di)
+			if dests[di].Group != "" {
+				problems = append(problems, fmt.Sprintf("%s cannot include another group", prefix))
+				continue
+			}
+			problems = append(problems, applyHeaderSets(cfg.HeaderSets, &dests[di], prefix)...)
+			applyDestinationDefaults(cfg.Defaults.Destination, &dests[di])
+			problems = append(problems, validateDestination(&dests[di], prefix)...)
+			problems = append(problems, checkDestinationTimeout(dests[di], serverTimeout(cfg), prefix)...)
+		}
+	}
+
 	for i := range cfg.Relays {
 		r := &cfg.Relays[i]
 
@@ -104,30 +1093,900 @@ func validateAndDefault(cfg *Config) error {
 			}
 		}
 
-		if len(r.Destinations) == 0 {
+		if t := &r.Transform.HTTP; t.URL != "" {
+			if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("relays[%d].transform.http.url must be an absolute http(s) URL (got %q)", i, t.URL))
+			}
+			if t.TimeoutMS < 0 {
+				problems = append(problems, fmt.Sprintf("relays[%d].transform.http.timeout_ms must not be negative", i))
+			}
+			if t.TimeoutMS == 0 {
+				t.TimeoutMS = 5000
+			}
+			t.OnFailure = strings.ToLower(strings.TrimSpace(t.OnFailure))
+			if t.OnFailure == "" {
+				t.OnFailure = TransformFailDLQ
+			}
+			switch t.OnFailure {
+			case TransformFailDLQ, TransformFailDrop, TransformFailForwardOriginal:
+			default:
+				problems = append(problems, fmt.Sprintf("relays[%d].transform.http.on_failure must be one of \"dlq\", \"drop\", \"forward_original\" (got %q)", i, t.OnFailure))
+			}
+		}
+
+		if r.Plugin.Path != "" {
+			if r.Plugin.TimeoutMS < 0 || r.Plugin.MemoryLimitMB < 0 {
+				problems = append(problems, fmt.Sprintf("relays[%d].plugin limits must not be negative", i))
+			}
+			r.Plugin.CacheDir = cfg.Server.CompileCacheDir
+			if r.Plugin.TimeoutMS == 0 {
+				r.Plugin.TimeoutMS = 100
+			}
+			if r.Plugin.MemoryLimitMB == 0 {
+				r.Plugin.MemoryLimitMB = 64
+			}
+			if r.Plugin.MemoryLimitMB > 4096 {
+				problems = append(problems, fmt.Sprintf("relays[%d].plugin.memory_limit_mb must be at most 4096", i))
+			}
+		}
+		if r.Script.Path != "" {
+			if r.Script.TimeoutMS < 0 {
+				problems = append(problems, fmt.Sprintf("relays[%d].script.timeout_ms must not be negative", i))
+			}
+			if r.Script.TimeoutMS == 0 {
+				r.Script.TimeoutMS = 100
+			}
+		}
+		r.Strategy = strings.ToLower(strings.TrimSpace(r.Strategy))
+		if r.Strategy == "" {
+			r.Strategy = StrategyFanOut
+		}
+		switch r.Strategy {
+		case StrategyFanOut, StrategyFirstSuccess:
+		default:
+			problems = append(problems, fmt.Sprintf("relays[%d].strategy must be one of \"fan_out\", \"first_success\" (got %q)", i, r.Strategy))
+		}
+		for ci, ct := range r.ContentTypes {
+			ct = strings.ToLower(strings.TrimSpace(ct))
+			r.ContentTypes[ci] = ct
+			if strings.HasPrefix(ct, "*+") && len(ct) > 2 && !strings.ContainsAny(ct, "/ ;") {
+				continue
+			}
+			if typ, sub, ok := strings.Cut(ct, "/"); !ok || typ == "" || sub == "" || strings.ContainsAny(ct, " ;") {
+				problems = append(problems, fmt.Sprintf("relays[%d].content_types[%d] must be a media type such as \"application/json\" (got %q)", i, ci, ct))
+			}
+		}
+		if r.MaxBodyBytes < 0 {
+			problems = append(problems, fmt.Sprintf("relays[%d].max_body_bytes must not be negative", i))
+		}
+		if r.EventTTLMS < 0 {
+			problems = append(problems, fmt.Sprintf("relays[%d].event_ttl_ms must not be negative", i))
+		}
+		if r.LogSampleRate < 0 {
+			problems = append(problems, fmt.Sprintf("relays[%d].log_sample_rate must not be negative", i))
+		}
+		limit := serverTimeout(cfg)
+		switch {
+		case r.ForwardTimeoutMS < 0:
+			problems = append(problems, fmt.Sprintf("relays[%d].forward_timeout_ms must not be negative", i))
+		case r.ForwardTimeoutMS > 0:
+			limit = timeoutLimit{ms: r.ForwardTimeoutMS, setting: fmt.Sprintf("relays[%d].forward_timeout_ms", i)}
+		}
+		switch r.DisabledStatus {
+		case 0:
+			r.DisabledStatus = http.StatusServiceUnavailable
+		case http.StatusServiceUnavailable, http.StatusNotFound:
+		default:
+			problems = append(problems, fmt.Sprintf("relays[%d].disabled_status must be 503 or 404 (got %d)", i, r.DisabledStatus))
+		}
+		if r.Concurrency < 0 || r.Concurrency > cfg.Server.Concurrency {
+			problems = append(problems, fmt.Sprintf("relays[%d].concurrency must be between 0 and server.concurrency (%d, got %d)", i, cfg.Server.Concurrency, r.Concurrency))
+		}
+
+		problems = append(problems, validateRedact(&r.Redact, fmt.Sprintf("relays[%d].redact", i))...)
+
+		if len(r.Destinations) == 0 && len(r.Routes) == 0 {
 			problems = append(problems, fmt.Sprintf("relays[%d].destinations must be non-empty", i))
 			continue
 		}
-		for di := range r.Destinations {
-			d := &r.Destinations[di]
-			if strings.TrimSpace(d.URL) == "" {
-				problems = append(problems, fmt.Sprintf("relays[%d].destinations[%d].url is required", i, di))
+		var more []string
+		r.Destinations, more = expandDestinations(cfg, r.Destinations, fmt.Sprintf("relays[%d].destinations", i), limit)
+		problems = append(problems, more...)
+		for ri := range r.Routes {
+			rt := &r.Routes[ri]
+			problems = append(problems, validateMatch(&rt.Match, fmt.Sprintf("relays[%d].routes[%d].match", i, ri))...)
+			if len(rt.Destinations) == 0 {
+				problems = append(problems, fmt.Sprintf("relays[%d].routes[%d].destinations must be non-empty", i, ri))
+			}
+			rt.Destinations, more = expandDestinations(cfg, rt.Destinations, fmt.Sprintf("relays[%d].routes[%d].destinations", i, ri), limit)
+			problems = append(problems, more...)
+		}
+		if !r.DetectProvider {
+			uses := false
+			for _, rt := range r.Routes {
+				uses = uses || rt.Match.usesProvider()
+				for _, d := range rt.Destinations {
+					uses = uses || (d.When != nil && d.When.usesProvider())
+				}
+			}
+			for _, d := range r.Destinations {
+				uses = uses || (d.When != nil && d.When.usesProvider())
+			}
+			if uses {
+				problems = append(problems, fmt.Sprintf("relays[%d] matches on providers or event_types but does not set detect_provider", i))
 			}
-			d.Method = strings.ToUpper(strings.TrimSpace(d.Method))
 		}
 
+		if r.ListenPathRegex != "" {
+			if r.ListenPath != "" {
+				problems = append(problems, fmt.Sprintf("relays[%d] cannot have both listen_path and listen_path_regex", i))
+			}
+			re, err := regexp.Compile(r.ListenPathRegex)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("relays[%d].listen_path_regex: %v", i, err))
+				continue
+			}
+			for _, name := range re.SubexpNames() {
+				if name != "" && !pathParamName.MatchString(name) {
+					problems = append(problems, fmt.Sprintf("relays[%d].listen_path_regex group name %q must be a letter or underscore followed by letters, digits or underscores", i, name))
+				}
+			}
+		}
 		if r.ListenPath != "" && !strings.HasPrefix(r.ListenPath, "/") {
 			// Keep it simple: require leading slash if user sets it.
 			problems = append(problems, fmt.Sprintf("relays[%d].listen_path must start with '/' (got %q)", i, r.ListenPath))
 		}
+		segs := pathSegments(strings.TrimSuffix(r.ListenPath, WildcardSuffix))
+		params := map[string]bool{}
+		for si, seg := range segs {
+			if !strings.ContainsAny(seg, "{}") {
+				if seg == "..." {
+					problems = append(problems, fmt.Sprintf("relays[%d].listen_path may only end in %q (got %q)", i, WildcardSuffix, r.ListenPath))
+				}
+				continue
+			}
+			m := pathParam.FindStringSubmatch(seg)
+			switch {
+			case m == nil:
+				problems = append(problems, fmt.Sprintf("relays[%d].listen_path segment %q must be a literal or a whole {name} parameter", i, seg))
+			case params[m[1]]:
+				problems = append(problems, fmt.Sprintf("relays[%d].listen_path repeats parameter %q", i, m[1]))
+			case m[2] != "" && (si != len(segs)-1 || strings.HasSuffix(r.ListenPath, WildcardSuffix)):
+				problems = append(problems, fmt.Sprintf("relays[%d].listen_path parameter {%s...} must be the last segment", i, m[1]))
+			}
+			if m != nil {
+				params[m[1]] = true
+			}
+		}
 	}
+	problems = append(problems, validateRelayChains(cfg)...)
 
 	if len(problems) > 0 {
-		return errors.New(strings.Join(problems, "; "))
+		return Problems(problems)
+	}
+	cfg.Warnings = lint(cfg)
+	return nil
+}
+
+// Problems is the error of a config that does not validate, one entry per
+// problem found.
+type Problems []string
+
+func (p Problems) Error() string { return strings.Join(p, "; ") }
+
+// validateRelayChains checks that "relay" destinations name exactly one
+// other relay with a fixed listen_path to send to.
+func validateRelayChains(cfg *Config) []string {
+	var problems []string
+	byName := map[string][]int{}
+	for i, r := range cfg.Relays {
+		if r.Name != "" {
+			byName[r.Name] = append(byName[r.Name], i)
+		}
+	}
+	check := func(from int, dests []DestinationConfig, prefix string) {
+		for di, d := range dests {
+			if d.Type != DestinationRelay || d.Relay == "" {
+				continue
+			}
+			where := fmt.Sprintf("%s[%d].relay", prefix, di)
+			targets := byName[d.Relay]
+			switch {
+			case len(targets) == 0:
+				problems = append(problems, fmt.Sprintf("%s: no relay named %q", where, d.Relay))
+			case len(targets) > 1:
+				problems = append(problems, fmt.Sprintf("%s: more than one relay is named %q", where, d.Relay))
+			case targets[0] == from:
+				problems = append(problems, fmt.Sprintf("%s: a relay cannot send to itself", where))
+			default:
+				t := cfg.Relays[targets[0]]
+				if t.ListenPath == "" || t.ListenPathRegex != "" || strings.ContainsAny(t.ListenPath, "{}") || strings.HasSuffix(t.ListenPath, WildcardSuffix) {
+					problems = append(problems, fmt.Sprintf("%s: relay %q needs a fixed listen_path (no parameters, wildcard or regex)", where, d.Relay))
+				}
+			}
+		}
+	}
+	for i, r := range cfg.Relays {
+		check(i, r.Destinations, fmt.Sprintf("relays[%d].destinations", i))
+		for ri, rt := range r.Routes {
+			check(i, rt.Destinations, fmt.Sprintf("relays[%d].routes[%d].destinations", i, ri))
+		}
+	}
+	return problems
+}
+
+func validateAlerts(cfg *Config) []string {
+	var problems []string
+	a := &cfg.Alerts
+	if len(a.Rules) == 0 {
+		return nil
+	}
+	if a.PagerDuty.RoutingKey == "" && a.Opsgenie.APIKey == "" && a.Webhook.URL == "" && a.Slack.WebhookURL == "" && !a.Log {
+		problems = append(problems, "alerts.rules needs a channel: alerts.pagerduty.routing_key, alerts.opsgenie.api_key, alerts.webhook.url, alerts.slack.webhook_url or alerts.log")
+	}
+	for _, c := range []struct{ name, url string }{{"alerts.webhook.url", a.Webhook.URL}, {"alerts.slack.webhook_url", a.Slack.WebhookURL}} {
+		if c.url == "" {
+			continue
+		}
+		if u, err := url.Parse(c.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s must be an absolute http(s) URL (got %q)", c.name, c.url))
+		}
+	}
+	if a.CheckIntervalMS <= 0 {
+		a.CheckIntervalMS = 30_000
+	}
+
+	a.PagerDuty.Severity = strings.ToLower(strings.TrimSpace(a.PagerDuty.Severity))
+	if a.PagerDuty.Severity == "" {
+		a.PagerDuty.Severity = "critical"
+	}
+	switch a.PagerDuty.Severity {
+	case "critical", "error", "warning", "info":
+	default:
+		problems = append(problems, fmt.Sprintf("alerts.pagerduty.severity must be one of \"critical\", \"error\", \"warning\", \"info\" (got %q)", a.PagerDuty.Severity))
+	}
+	if a.PagerDuty.URL == "" {
+		a.PagerDuty.URL = "https://events.pagerduty.com/v2/enqueue"
+	}
+
+	a.Opsgenie.Priority = strings.ToUpper(strings.TrimSpace(a.Opsgenie.Priority))
+	if a.Opsgenie.Priority == "" {
+		a.Opsgenie.Priority = "P1"
+	}
+	switch a.Opsgenie.Priority {
+	case "P1", "P2", "P3", "P4", "P5":
+	default:
+		problems = append(problems, fmt.Sprintf("alerts.opsgenie.priority must be one of P1-P5 (got %q)", a.Opsgenie.Priority))
+	}
+	if a.Opsgenie.URL == "" {
+		a.Opsgenie.URL = "https://api.opsgenie.com"
+	}
+
+	names := map[string]bool{}
+	for _, r := range cfg.Relays {
+		if r.Name != "" {
+			names[r.Name] = true
+		}
+	}
+	seen := map[string]bool{}
+	for i := range a.Rules {
+		r := &a.Rules[i]
+		if strings.TrimSpace(r.Name) == "" {
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].name is required", i))
+		} else if seen[r.Name] {
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].name %q is used more than once", i, r.Name))
+		}
+		seen[r.Name] = true
+
+		conditions := 0
+		for _, set := range []bool{r.DLQCount > 0, r.ConsecutiveFailures > 0, r.FailureRate > 0} {
+			if set {
+				conditions++
+			}
+		}
+		switch {
+		case r.DLQCount < 0 || r.ConsecutiveFailures < 0 || r.FailureRate < 0 || r.WindowMS < 0 || r.MinAttempts < 0 || r.CooldownMS < 0:
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d] thresholds must not be negative", i))
+		case conditions != 1:
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d] must set exactly one of dlq_count, consecutive_failures or failure_rate", i))
+		case r.FailureRate > 1:
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].failure_rate must be between 0 and 1 (got %v)", i, r.FailureRate))
+		case r.DLQCount > 0 && (r.Relay != "" || r.Destination != ""):
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].relay and destination are not used with dlq_count", i))
+		case r.DLQCount == 0 && r.Relay == "" && r.Destination == "":
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d] needs relay, destination or both", i))
+		case r.Relay != "" && !names[r.Relay]:
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].relay must name a configured relay (got %q)", i, r.Relay))
+		}
+		if r.FailureRate > 0 {
+			if r.WindowMS == 0 {
+				r.WindowMS = 300_000
+			}
+			if r.MinAttempts == 0 {
+				r.MinAttempts = 10
+			}
+		} else if r.MinAttempts > 0 {
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].min_attempts is only used with failure_rate", i))
+		} else if r.WindowMS > 0 && r.DLQCount == 0 {
+			problems = append(problems, fmt.Sprintf("alerts.rules[%d].window_ms is only used with failure_rate and dlq_count", i))
+		}
+	}
+	return problems
+}
+
+func validateTransform(steps []TransformStep, prefix string) []string {
+	var problems []string
+	for i := range steps {
+		st := &steps[i]
+		st.Op = strings.ToLower(strings.TrimSpace(st.Op))
+		if _, err := payload.SplitPath(st.Path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s[%d].path is not a valid path (got %q)", prefix, i, st.Path))
+		}
+		switch st.Op {
+		case TransformSet, TransformDelete:
+		case TransformRename, TransformCopy:
+			if _, err := payload.SplitPath(st.To); err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d].to is not a valid path (got %q)", prefix, i, st.To))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s[%d].op must be one of \"set\", \"delete\", \"rename\", \"copy\" (got %q)", prefix, i, st.Op))
+		}
+	}
+	return problems
+}
+
+func validateSchedule(sc *ScheduleConfig, prefix string) []string {
+	var problems []string
+	if len(sc.Days) == 0 && sc.From == "" && sc.To == "" {
+		problems = append(problems, fmt.Sprintf("%s needs days or from/to", prefix))
+	}
+	if sc.Timezone == "" {
+		sc.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(sc.Timezone); err != nil {
+		problems = append(problems, fmt.Sprintf("%s.timezone: %v", prefix, err))
+	}
+	for di, day := range sc.Days {
+		// Full names ("monday") are accepted too.
+		sc.Days[di] = strings.ToLower(strings.TrimSpace(day))
+		if len(sc.Days[di]) > 3 {
+			sc.Days[di] = sc.Days[di][:3]
+		}
+		if !slices.Contains(ScheduleDays, sc.Days[di]) {
+			problems = append(problems, fmt.Sprintf("%s.days[%d] must be one of %s (got %q)", prefix, di, strings.Join(ScheduleDays, ", "), day))
+		}
+	}
+	if sc.From == "" {
+		sc.From = "00:00"
+	}
+	if sc.To == "" {
+		sc.To = "24:00"
+	}
+	for name, v := range map[string]string{"from": sc.From, "to": sc.To} {
+		if _, err := ScheduleMinutes(v); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.%s: %v", prefix, name, err))
+		}
+	}
+	return problems
+}
+
+// expandDestinations replaces group references in dests with copies of the
+// (already validated) group destinations and validates the others. The
+// timeouts of all of them must be within limit.
+func expandDestinations(cfg *Config, dests []DestinationConfig, prefix string, limit timeoutLimit) ([]DestinationConfig, []string) {
+	var problems []string
+	out := make([]DestinationConfig, 0, len(dests))
+	for di, d := range dests {
+		where := fmt.Sprintf("%s[%d]", prefix, di)
+		if d.Group == "" {
+			problems = append(problems, applyHeaderSets(cfg.HeaderSets, &d, where)...)
+			applyDestinationDefaults(cfg.Defaults.Destination, &d)
+			problems = append(problems, validateDestination(&d, where)...)
+			problems = append(problems, checkDestinationTimeout(d, limit, where)...)
+			out = append(out, d)
+			continue
+		}
+		group, ok := cfg.DestinationGroups[d.Group]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s.group %q is not defined in destination_groups", where, d.Group))
+			continue
+		case !reflect.DeepEqual(d, DestinationConfig{Group: d.Group, HashKey: d.HashKey, OutlierDetection: d.OutlierDetection}):
+			problems = append(problems, fmt.Sprintf("%s: a group reference cannot set other fields than hash_key and outlier_detection", where))
+			continue
+		}
+		if d.HashKey != nil {
+			if more := validateHashKey(d.HashKey, where+".hash_key"); len(more) > 0 {
+				problems = append(problems, more...)
+				continue
+			}
+		}
+		if d.OutlierDetection != nil {
+			if more := validateOutlierDetection(d.OutlierDetection, where+".outlier_detection"); len(more) > 0 {
+				problems = append(problems, more...)
+				continue
+			}
+		}
+		for gi, gd := range group {
+			// Checked against server.forward_timeout_ms already.
+			if limit.ms < cfg.Server.ForwardTimeoutMS {
+				problems = append(problems, checkDestinationTimeout(gd, limit, fmt.Sprintf("%s: destination_groups.%s[%d]", where, d.Group, gi))...)
+			}
+			gd = cloneDestination(gd)
+			if d.HashKey != nil {
+				key := *d.HashKey
+				gd.HashKey, gd.HashSet = &key, where
+			}
+			if d.OutlierDetection != nil {
+				od := *d.OutlierDetection
+				gd.OutlierDetection, gd.OutlierSet = &od, where
+			}
+			out = append(out, gd)
+		}
+	}
+	return out, problems
+}
+
+// applyHeaderSets adds the headers of the sets d names to its own.
+func applyHeaderSets(sets map[string]map[string]string, d *DestinationConfig, prefix string) []string {
+	if len(d.HeaderSets) == 0 {
+		return nil
+	}
+	var problems []string
+	own := make(map[string]bool, len(d.Headers))
+	headers := make(map[string]string, len(d.Headers))
+	for k, v := range d.Headers {
+		own[http.CanonicalHeaderKey(k)] = true
+		headers[k] = v
+	}
+	// From the last set back, so that it wins.
+	for i := len(d.HeaderSets) - 1; i >= 0; i-- {
+		set, ok := sets[d.HeaderSets[i]]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s.header_sets[%d]: %q is not defined in header_sets", prefix, i, d.HeaderSets[i]))
+			continue
+		}
+		for k, v := range set {
+			if !own[http.CanonicalHeaderKey(k)] {
+				own[http.CanonicalHeaderKey(k)] = true
+				headers[k] = v
+			}
+		}
+	}
+	d.Headers = headers
+	return problems
+}
+
+// applyDestinationDefaults fills in the settings d inherits from defs.
+func applyDestinationDefaults(defs DestinationDefaults, d *DestinationConfig) {
+	if d.SkipDefaults {
+		return
+	}
+	if t := strings.ToLower(strings.TrimSpace(d.Type)); t != "" && t != DestinationHTTP {
+		return
+	}
+	if len(defs.Headers) > 0 {
+		own := make(map[string]bool, len(d.Headers))
+		headers := make(map[string]string, len(defs.Headers)+len(d.Headers))
+		for k, v := range d.Headers {
+			own[http.CanonicalHeaderKey(k)] = true
+			headers[k] = v
+		}
+		for k, v := range defs.Headers {
+			if !own[http.CanonicalHeaderKey(k)] {
+				headers[k] = v
+			}
+		}
+		d.Headers = headers
+	}
+	if d.Method == "" {
+		d.Method = defs.Method
+	}
+	if d.TimeoutMS == 0 {
+		d.TimeoutMS = defs.TimeoutMS
+	}
+}
+
+// timeoutLimit is the forward timeout destination timeouts must be within,
+// and the setting it comes from.
+type timeoutLimit struct {
+	ms      int
+	setting string
+}
+
+func serverTimeout(cfg *Config) timeoutLimit {
+	return timeoutLimit{ms: cfg.Server.ForwardTimeoutMS, setting: "server.forward_timeout_ms"}
+}
+
+// checkDestinationTimeout checks d's timeout_ms against the forward timeout,
+// which the queue lease is based on.
+func checkDestinationTimeout(d DestinationConfig, limit timeoutLimit, prefix string) []string {
+	switch {
+	case d.TimeoutMS < 0:
+		return []string{fmt.Sprintf("%s.timeout_ms must not be negative", prefix)}
+	case d.TimeoutMS > limit.ms:
+		return []string{fmt.Sprintf("%s.timeout_ms must be at most %s (%d, got %d)", prefix, limit.setting, limit.ms, d.TimeoutMS)}
+	}
+	return nil
+}
+
+// cloneDestination deep-copies d so that relays sharing a group do not share
+// its maps and pointers.
+func validateHashKey(k *HashKeyConfig, prefix string) []string {
+	k.Header = strings.TrimSpace(k.Header)
+	k.Field = strings.TrimSpace(k.Field)
+	if (k.Header == "") == (k.Field == "") {
+		return []string{prefix + " needs exactly one of header or field"}
+	}
+	if k.Header != "" {
+		k.Header = http.CanonicalHeaderKey(k.Header)
+		return nil
+	}
+	if _, err := payload.SplitPath(k.Field); err != nil {
+		return []string{fmt.Sprintf("%s.field: %v", prefix, err)}
 	}
 	return nil
 }
 
+func cloneDestination(d DestinationConfig) DestinationConfig {
+	var out DestinationConfig
+	b, err := json.Marshal(d)
+	if err == nil {
+		err = json.Unmarshal(b, &out)
+	}
+	if err != nil {
+		return d
+	}
+	return out
+}
+
+func validateDestination(d *DestinationConfig, prefix string) []string {
+	var problems []string
+
+	if strings.EqualFold(strings.TrimSpace(d.Type), DestinationRelay) {
+		switch {
+		case d.Relay == "":
+			problems = append(problems, fmt.Sprintf("%s.relay is required for \"relay\" destinations", prefix))
+		case d.URL != "" && d.URL != RelayURLPrefix+d.Relay:
+			problems = append(problems, fmt.Sprintf("%s.url must be empty for \"relay\" destinations", prefix))
+		}
+		d.URL = RelayURLPrefix + d.Relay
+	} else if d.Relay != "" {
+		problems = append(problems, fmt.Sprintf("%s.relay only applies to \"relay\" destinations", prefix))
+	}
+	if l := d.Lookup; l != nil {
+		where := prefix + ".lookup"
+		source := l.File + l.HTTP
+		switch {
+		case (l.File == "") == (l.HTTP == ""):
+			problems = append(problems, fmt.Sprintf("%s needs exactly one of file and http", where))
+		case l.File != "":
+			if _, err := LoadLookupFile(l.File); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.file: %v", where, err))
+			}
+		default:
+			if u, err := url.Parse(strings.ReplaceAll(l.HTTP, "{key}", "k")); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || !strings.Contains(l.HTTP, "{key}") {
+				problems = append(problems, fmt.Sprintf("%s.http must be an absolute http(s) URL containing {key} (got %q)", where, l.HTTP))
+			}
+		}
+		if strings.TrimSpace(l.Key) == "" {
+			problems = append(problems, fmt.Sprintf("%s.key is required", where))
+		}
+		problems = append(problems, checkTemplates(where, map[string]string{"key": l.Key})...)
+		if l.CacheTTLMS < 0 {
+			problems = append(problems, fmt.Sprintf("%s.cache_ttl_ms must not be negative", where))
+		}
+		if l.CacheTTLMS == 0 {
+			l.CacheTTLMS = 60_000
+		}
+		if len(d.AllowedURLs) == 0 {
+			problems = append(problems, fmt.Sprintf("%s needs allowed_urls", where))
+		}
+		if d.URL != "" && d.URL != LookupURLPrefix+source {
+			problems = append(problems, fmt.Sprintf("%s: url must be empty for lookup destinations", where))
+		}
+		d.URL = LookupURLPrefix + source
+	}
+	if d.HealthCheck != nil {
+		problems = append(problems, validateHealthCheck(d.HealthCheck, prefix+".health_check")...)
+	}
+	if strings.EqualFold(strings.TrimSpace(d.Type), DestinationDrop) && strings.TrimSpace(d.URL) == "" {
+		d.URL = DropURL
+	}
+	if strings.TrimSpace(d.URL) == "" {
+		problems = append(problems, fmt.Sprintf("%s.url is required", prefix))
+	}
+	if URLTemplated(d.URL) {
+		problems = append(problems, checkTemplates(prefix, map[string]string{"url": d.URL})...)
+		if len(d.AllowedURLs) == 0 {
+			problems = append(problems, fmt.Sprintf("%s.allowed_urls is required when url is a template", prefix))
+		}
+	}
+	for ai, p := range d.AllowedURLs {
+		if _, err := CompileURLPattern(p); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.allowed_urls[%d] is not a valid pattern (got %q)", prefix, ai, p))
+		}
+	}
+	d.Method = strings.ToUpper(strings.TrimSpace(d.Method))
+	problems = append(problems, checkTemplates(fmt.Sprintf("%s.headers", prefix), d.Headers)...)
+
+	d.EncodeAs = strings.ToLower(strings.TrimSpace(d.EncodeAs))
+	switch d.EncodeAs {
+	case "", payload.FormatJSON, payload.FormatForm, payload.FormatXML:
+	case payload.FormatProtobuf:
+		if d.Protobuf.DescriptorSet == "" || d.Protobuf.Message == "" {
+			problems = append(problems, fmt.Sprintf("%s.protobuf.descriptor_set and message are required with encode_as \"protobuf\"", prefix))
+		} else if _, err := payload.ProtoMessage(d.Protobuf.DescriptorSet, d.Protobuf.Message); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.protobuf: %v", prefix, err))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("%s.encode_as must be one of \"json\", \"form\", \"xml\", \"protobuf\" (got %q)", prefix, d.EncodeAs))
+	}
+	d.Decode = strings.ToLower(strings.TrimSpace(d.Decode))
+	if d.Decode != "" && !slices.Contains(payload.Formats, d.Decode) {
+		problems = append(problems, fmt.Sprintf("%s.decode must be one of %s (got %q)", prefix, strings.Join(payload.Formats, ", "), d.Decode))
+	}
+	d.Multipart.Mode = strings.ToLower(strings.TrimSpace(d.Multipart.Mode))
+	if d.Multipart.Mode == "" {
+		d.Multipart.Mode = MultipartForward
+	}
+	switch d.Multipart.Mode {
+	case MultipartForward, MultipartStripAttachments, MultipartJSON:
+	default:
+		problems = append(problems, fmt.Sprintf("%s.multipart.mode must be one of \"forward\", \"strip_attachments\", \"json\" (got %q)", prefix, d.Multipart.Mode))
+	}
+	if d.Multipart.MaxAttachmentBytes < 0 {
+		problems = append(problems, fmt.Sprintf("%s.multipart.max_attachment_bytes must not be negative", prefix))
+	}
+	problems = append(problems, validateTransform(d.Transform, fmt.Sprintf("%s.transform", prefix))...)
+	if d.Weight < 0 {
+		problems = append(problems, fmt.Sprintf("%s.weight must not be negative", prefix))
+	}
+	if d.HashKey != nil {
+		problems = append(problems, fmt.Sprintf("%s.hash_key only applies to group references", prefix))
+	}
+	if d.OutlierDetection != nil {
+		problems = append(problems, fmt.Sprintf("%s.outlier_detection only applies to group references", prefix))
+	}
+	if d.SampleRate != nil && (*d.SampleRate < 0 || *d.SampleRate > 1) {
+		problems = append(problems, fmt.Sprintf("%s.sample_rate must be between 0 and 1 (got %v)", prefix, *d.SampleRate))
+	}
+	if d.When != nil {
+		problems = append(problems, validateMatch(d.When, fmt.Sprintf("%s.when", prefix))...)
+		if d.Fallback {
+			problems = append(problems, fmt.Sprintf("%s cannot have both when and fallback", prefix))
+		}
+	}
+	d.Type = strings.ToLower(strings.TrimSpace(d.Type))
+	if d.Type == "" {
+		d.Type = DestinationHTTP
+	}
+	switch d.Type {
+	case DestinationHTTP, DestinationRelay, DestinationDrop:
+	case DestinationSlack:
+		if d.Slack.Text == "" && d.Slack.Blocks == "" && d.Slack.Attachments == "" {
+			problems = append(problems, fmt.Sprintf("%s.slack needs text, blocks or attachments", prefix))
+		}
+		problems = append(problems, checkTemplates(fmt.Sprintf("%s.slack", prefix), map[string]string{
+			"text":        d.Slack.Text,
+			"blocks":      d.Slack.Blocks,
+			"attachments": d.Slack.Attachments,
+		})...)
+	case DestinationDiscord:
+		if d.Discord.Content == "" && d.Discord.Title == "" && d.Discord.Description == "" && d.Discord.Embeds == "" {
+			problems = append(problems, fmt.Sprintf("%s.discord needs content, title, description or embeds", prefix))
+		}
+		problems = append(problems, checkTemplates(fmt.Sprintf("%s.discord", prefix), map[string]string{
+			"content":     d.Discord.Content,
+			"title":       d.Discord.Title,
+			"description": d.Discord.Description,
+			"embeds":      d.Discord.Embeds,
+		})...)
+	case DestinationTeams:
+		if d.Teams.Card == "" && d.Teams.Title == "" && d.Teams.Text == "" {
+			problems = append(problems, fmt.Sprintf("%s.teams needs card, title or text", prefix))
+		}
+		problems = append(problems, checkTemplates(fmt.Sprintf("%s.teams", prefix), map[string]string{
+			"card":  d.Teams.Card,
+			"title": d.Teams.Title,
+			"text":  d.Teams.Text,
+		})...)
+	default:
+		problems = append(problems, fmt.Sprintf("%s.type must be one of \"http\", \"slack\", \"discord\", \"teams\", \"relay\", \"drop\" (got %q)", prefix, d.Type))
+	}
+
+	if d.Envelope && d.Type != DestinationHTTP && d.Type != DestinationDrop {
+		problems = append(problems, fmt.Sprintf("%s.envelope only applies to \"http\" destinations", prefix))
+	}
+
+	if ce := &d.CloudEvents; ce.Mode != "" || ce.Source != "" || ce.Type != "" || ce.Subject != "" || len(ce.Extensions) > 0 {
+		destType := d.Type
+		if destType == DestinationDrop {
+			// Options of the http destination it stands in for still apply.
+			destType = DestinationHTTP
+		}
+		problems = append(problems, validateCloudEvents(ce, destType, fmt.Sprintf("%s.cloudevents", prefix))...)
+		templates := map[string]string{"source": ce.Source, "type": ce.Type, "subject": ce.Subject}
+		for name, text := range ce.Extensions {
+			templates["extensions."+name] = text
+		}
+		problems = append(problems, checkTemplates(fmt.Sprintf("%s.cloudevents", prefix), templates)...)
+	}
+
+	problems = append(problems, validateRedact(&d.Redact, fmt.Sprintf("%s.redact", prefix))...)
+
+	d.Query.Mode = strings.ToLower(strings.TrimSpace(d.Query.Mode))
+	if d.Query.Mode == "" {
+		d.Query.Mode = QueryDrop
+	}
+	switch d.Query.Mode {
+	case QueryDrop, QueryAppend, QueryMerge:
+	default:
+		problems = append(problems, fmt.Sprintf("%s.query.mode must be one of \"drop\", \"append\", \"merge\" (got %q)", prefix, d.Query.Mode))
+	}
+	for to, from := range d.Query.Params {
+		if to == "" || from == "" {
+			problems = append(problems, fmt.Sprintf("%s.query.params must map non-empty names (got %q: %q)", prefix, to, from))
+		}
+	}
+
+	for field, src := range d.Enrich.Metadata {
+		if !slices.Contains(EnrichMetadata, src) {
+			problems = append(problems, fmt.Sprintf("%s.enrich.metadata.%s must be one of %s (got %q)", prefix, field, strings.Join(EnrichMetadata, ", "), src))
+		}
+	}
+
+	if d.XML.ItemElement == "" {
+		d.XML.ItemElement = "item"
+	}
+	return problems
+}
+
+func validateMatch(m *MatchConfig, prefix string) []string {
+	var problems []string
+	if len(m.Methods)+len(m.Query)+len(m.Headers)+len(m.Fields)+len(m.HeaderRegex)+len(m.FieldRegex) == 0 && m.Schedule == nil && m.Schema == "" && !m.usesProvider() {
+		problems = append(problems, fmt.Sprintf("%s needs at least one condition", prefix))
+	}
+	for mi := range m.Methods {
+		m.Methods[mi] = strings.ToUpper(strings.TrimSpace(m.Methods[mi]))
+		if m.Methods[mi] == "" {
+			problems = append(problems, fmt.Sprintf("%s.methods[%d] must be non-empty", prefix, mi))
+		}
+	}
+	for pi := range m.Providers {
+		m.Providers[pi] = strings.ToLower(strings.TrimSpace(m.Providers[pi]))
+		if !slices.Contains(provider.Names(), m.Providers[pi]) {
+			problems = append(problems, fmt.Sprintf("%s.providers[%d] must be one of %q (got %q)", prefix, pi, provider.Names(), m.Providers[pi]))
+		}
+	}
+	for ei, et := range m.EventTypes {
+		if strings.TrimSpace(et) == "" {
+			problems = append(problems, fmt.Sprintf("%s.event_types[%d] must be non-empty", prefix, ei))
+		}
+	}
+	headers := make(map[string][]string, len(m.Headers))
+	for name, values := range m.Headers {
+		if strings.TrimSpace(name) == "" || len(values) == 0 {
+			problems = append(problems, fmt.Sprintf("%s.headers needs a name and at least one value (got %q)", prefix, name))
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = values
+	}
+	m.Headers = headers
+	if m.Schedule != nil {
+		problems = append(problems, validateSchedule(m.Schedule, prefix+".schedule")...)
+	}
+	if m.Schema != "" {
+		if _, err := schema.Load(m.Schema); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.schema: %v", prefix, err))
+		}
+	}
+	for name, values := range m.Query {
+		if name == "" || len(values) == 0 {
+			problems = append(problems, fmt.Sprintf("%s.query needs a name and at least one value (got %q)", prefix, name))
+		}
+	}
+	for path, values := range m.Fields {
+		if _, err := payload.SplitPath(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.fields: %v", prefix, err))
+		}
+		if len(values) == 0 {
+			problems = append(problems, fmt.Sprintf("%s.fields.%s needs at least one value", prefix, path))
+		}
+	}
+	headerRegex := make(map[string]string, len(m.HeaderRegex))
+	for name, expr := range m.HeaderRegex {
+		if strings.TrimSpace(name) == "" {
+			problems = append(problems, fmt.Sprintf("%s.header_regex needs header names", prefix))
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.header_regex.%s: %v", prefix, name, err))
+		}
+		headerRegex[http.CanonicalHeaderKey(strings.TrimSpace(name))] = expr
+	}
+	m.HeaderRegex = headerRegex
+	for path, expr := range m.FieldRegex {
+		if _, err := payload.SplitPath(path); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.field_regex: %v", prefix, err))
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.field_regex.%s: %v", prefix, path, err))
+		}
+	}
+	return problems
+}
+
+// cloudEventsExtension matches extension attribute names allowed by the
+// CloudEvents spec.
+var cloudEventsExtension = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+func validateCloudEvents(ce *CloudEventsConfig, destType, prefix string) []string {
+	var problems []string
+	ce.Mode = strings.ToLower(strings.TrimSpace(ce.Mode))
+	switch ce.Mode {
+	case CloudEventsStructured, CloudEventsBinary, CloudEventsUnwrap:
+	default:
+		problems = append(problems, fmt.Sprintf("%s.mode must be one of \"structured\", \"binary\", \"unwrap\" (got %q)", prefix, ce.Mode))
+	}
+	if destType != DestinationHTTP {
+		problems = append(problems, fmt.Sprintf("%s only applies to \"http\" destinations", prefix))
+	}
+	for name := range ce.Extensions {
+		switch {
+		case !cloudEventsExtension.MatchString(name):
+			problems = append(problems, fmt.Sprintf("%s.extensions name must be 1-20 lower-case letters or digits (got %q)", prefix, name))
+		case slices.Contains([]string{"specversion", "id", "source", "type", "subject", "time", "datacontenttype", "dataschema", "data", "data_base64"}, name):
+			problems = append(problems, fmt.Sprintf("%s.extensions must not redefine the %q attribute", prefix, name))
+		}
+	}
+	return problems
+}
+
+func validateRedact(r *RedactConfig, prefix string) []string {
+	var problems []string
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	if r.Action == "" {
+		r.Action = RedactMask
+	}
+	if r.Action != RedactMask && r.Action != RedactRemove {
+		problems = append(problems, fmt.Sprintf("%s.action must be \"mask\" or \"remove\" (got %q)", prefix, r.Action))
+	}
+	for i, f := range r.Fields {
+		if _, err := path.Match(strings.ToLower(f), ""); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.fields[%d] is not a valid pattern (got %q)", prefix, i, f))
+		}
+	}
+	for i, p := range r.Paths {
+		p = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(p), "$"), ".")
+		if p == "" || strings.Contains(p, "..") {
+			problems = append(problems, fmt.Sprintf("%s.paths[%d] is not a valid path (got %q)", prefix, i, r.Paths[i]))
+		}
+	}
+	return problems
+}
+
+// checkTemplates parses each non-empty template and reports the ones that fail.
+func checkTemplates(prefix string, templates map[string]string) []string {
+	var problems []string
+	for _, name := range sortedKeys(templates) {
+		if templates[name] == "" {
+			continue
+		}
+		if _, err := tmpl.Parse(templates[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s.%s is not a valid template: %v", prefix, name, err))
+		}
+	}
+	return problems
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func normalizeBasePath(p string) string {
 	p = strings.TrimSpace(p)
 	if p == "" || p == "/" {
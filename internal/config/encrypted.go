@@ -0,0 +1,368 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+// Encrypted config files are decrypted with the age identities that SOPS
+// reads too: those in SOPS_AGE_KEY, in the file SOPS_AGE_KEY_FILE names, or
+// else in sops/age/keys.txt under the user's config directory.
+const (
+	envAgeKey     = "SOPS_AGE_KEY"
+	envAgeKeyFile = "SOPS_AGE_KEY_FILE"
+)
+
+// ageSuffix marks a config file encrypted as a whole with age, e.g.
+// secrets.yaml.age; the extension before it gives the format.
+const ageSuffix = ".age"
+
+// decryptConfig decrypts b, the contents of the config file at path, when
+// it is encrypted: as a whole with age (binary or armored), or value by
+// value with SOPS using age recipients. Other files are returned as they
+// are, and decrypted reports which it was. The format of the result may
+// differ from format: SOPS files come out as YAML.
+func decryptConfig(path, format string, b []byte) (out []byte, outFormat string, decrypted bool, err error) {
+	if bytes.HasPrefix(b, []byte("age-encryption.org/")) || bytes.HasPrefix(bytes.TrimSpace(b), []byte(armor.Header)) {
+		out, err := ageDecrypt(b)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("%s: decrypt age: %w", path, err)
+		}
+		return out, format, true, nil
+	}
+	if (format == FormatYAML || format == FormatJSON) && bytes.Contains(b, []byte(`sops`)) {
+		out, ok, err := sopsDecrypt(b)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("%s: decrypt sops: %w", path, err)
+		}
+		if ok {
+			return out, FormatYAML, true, nil
+		}
+	}
+	return b, format, false, nil
+}
+
+// ageIdentities reads the age identities to decrypt with.
+func ageIdentities() ([]age.Identity, error) {
+	var ids []age.Identity
+	if key := os.Getenv(envAgeKey); key != "" {
+		parsed, err := age.ParseIdentities(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", envAgeKey, err)
+		}
+		ids = append(ids, parsed...)
+	}
+	file := os.Getenv(envAgeKeyFile)
+	if file == "" && len(ids) == 0 {
+		if dir, err := os.UserConfigDir(); err == nil {
+			file = filepath.Join(dir, "sops", "age", "keys.txt")
+		}
+	}
+	if file != "" {
+		f, err := os.Open(file)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && os.Getenv(envAgeKeyFile) == "":
+		case err != nil:
+			return nil, fmt.Errorf("age key file: %w", err)
+		default:
+			parsed, err := age.ParseIdentities(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("age key file %s: %w", file, err)
+			}
+			ids = append(ids, parsed...)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no age key: set %s or %s", envAgeKey, envAgeKeyFile)
+	}
+	return ids, nil
+}
+
+// ageDecrypt decrypts an age file, armored or not.
+func ageDecrypt(b []byte) ([]byte, error) {
+	ids, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = bytes.NewReader(b)
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(b)))
+	}
+	r, err := age.Decrypt(src, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// sopsValue is a value SOPS encrypted with the data key.
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+
+// sopsDecrypt decrypts a YAML or JSON document encrypted by SOPS, returning
+// it without its sops metadata. ok is false when b is not a SOPS document.
+// The data key must be encrypted to an age recipient; the document's MAC is
+// checked, so values cannot be added, removed or swapped unnoticed.
+func sopsDecrypt(b []byte) (out []byte, ok bool, err error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		// Not for this function to report.
+		return nil, false, nil
+	}
+	root := doc.Content[0]
+	var meta *yaml.Node
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "sops" {
+			meta = root.Content[i+1]
+			root.Content = append(root.Content[:i:i], root.Content[i+2:]...)
+			break
+		}
+	}
+	if meta == nil {
+		return nil, false, nil
+	}
+	var md struct {
+		Age []struct {
+			Recipient string `yaml:"recipient"`
+			Enc       string `yaml:"enc"`
+		} `yaml:"age"`
+		LastModified     string `yaml:"lastmodified"`
+		MAC              string `yaml:"mac"`
+		MACOnlyEncrypted bool   `yaml:"mac_only_encrypted"`
+		sopsSelection    `yaml:",inline"`
+	}
+	if err := meta.Decode(&md); err != nil {
+		return nil, true, fmt.Errorf("metadata: %w", err)
+	}
+	encrypts, err := md.sopsSelection.encrypts()
+	if err != nil {
+		return nil, true, fmt.Errorf("metadata: %w", err)
+	}
+	if len(md.Age) == 0 {
+		return nil, true, errors.New("the data key is not encrypted to an age recipient (only age is supported)")
+	}
+	ids, err := ageIdentities()
+	if err != nil {
+		return nil, true, err
+	}
+	var key []byte
+	for _, a := range md.Age {
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(a.Enc))), ids...)
+		if err != nil {
+			continue
+		}
+		if key, err = io.ReadAll(r); err == nil {
+			break
+		}
+	}
+	if key == nil {
+		return nil, true, errors.New("none of the age keys can decrypt the data key")
+	}
+
+	mac := sha512.New()
+	if md.MACOnlyEncrypted {
+		mac.Write(sopsMACOnlyEncryptedInit)
+	}
+	if err := sopsWalk(root, nil, func(n *yaml.Node, path []string) error {
+		encrypted := encrypts(path)
+		if encrypted {
+			v, tag, err := sopsDecryptValue(n.Value, key, strings.Join(path, ":")+":")
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+			}
+			n.Value, n.Tag, n.Style = v, tag, 0
+			if tag == "!!str" {
+				n.Style = yaml.DoubleQuotedStyle
+			}
+		}
+		if encrypted || !md.MACOnlyEncrypted {
+			mac.Write(sopsMACBytes(n))
+		}
+		return nil
+	}); err != nil {
+		return nil, true, err
+	}
+
+	last, err := time.Parse(time.RFC3339, md.LastModified)
+	if err != nil {
+		return nil, true, fmt.Errorf("metadata: lastmodified: %w", err)
+	}
+	want, _, err := sopsDecryptValue(md.MAC, key, last.Format(time.RFC3339))
+	if err != nil {
+		return nil, true, fmt.Errorf("metadata: mac: %w", err)
+	}
+	got := fmt.Sprintf("%X", mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return nil, true, errors.New("MAC mismatch: the file was changed after it was encrypted")
+	}
+	out, err = yaml.Marshal(&doc)
+	return out, true, err
+}
+
+// sopsMACOnlyEncryptedInit starts the MAC of files encrypted with
+// mac_only_encrypted, so that it differs from the MAC of the same values
+// without it.
+var sopsMACOnlyEncryptedInit = []byte{0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b, 0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69}
+
+// sopsSelection is the metadata telling which values SOPS encrypted.
+type sopsSelection struct {
+	UnencryptedSuffix       string `yaml:"unencrypted_suffix"`
+	EncryptedSuffix         string `yaml:"encrypted_suffix"`
+	UnencryptedRegex        string `yaml:"unencrypted_regex"`
+	EncryptedRegex          string `yaml:"encrypted_regex"`
+	UnencryptedCommentRegex string `yaml:"unencrypted_comment_regex"`
+	EncryptedCommentRegex   string `yaml:"encrypted_comment_regex"`
+}
+
+// encrypts returns whether the value at a path was encrypted, deciding as
+// SOPS does: the suffixes first, then the regular expressions, each
+// matched against every key of the path.
+func (sel sopsSelection) encrypts() (func(path []string) bool, error) {
+	if sel.UnencryptedCommentRegex != "" || sel.EncryptedCommentRegex != "" {
+		return nil, errors.New("files encrypted with unencrypted_comment_regex or encrypted_comment_regex are not supported")
+	}
+	var unencrypted, encrypted *regexp.Regexp
+	var err error
+	if sel.UnencryptedRegex != "" {
+		if unencrypted, err = regexp.Compile(sel.UnencryptedRegex); err != nil {
+			return nil, fmt.Errorf("unencrypted_regex: %w", err)
+		}
+	}
+	if sel.EncryptedRegex != "" {
+		if encrypted, err = regexp.Compile(sel.EncryptedRegex); err != nil {
+			return nil, fmt.Errorf("encrypted_regex: %w", err)
+		}
+	}
+	return func(path []string) bool {
+		anyKey := func(match func(string) bool) bool { return slices.ContainsFunc(path, match) }
+		enc := true
+		if sel.UnencryptedSuffix != "" && anyKey(func(k string) bool { return strings.HasSuffix(k, sel.UnencryptedSuffix) }) {
+			enc = false
+		}
+		if sel.EncryptedSuffix != "" {
+			enc = anyKey(func(k string) bool { return strings.HasSuffix(k, sel.EncryptedSuffix) })
+		}
+		if unencrypted != nil && anyKey(unencrypted.MatchString) {
+			enc = false
+		}
+		if encrypted != nil {
+			enc = anyKey(encrypted.MatchString)
+		}
+		return enc
+	}, nil
+}
+
+// sopsWalk calls leaf for the scalars under n in document order, with the
+// keys leading to them; list items share the path of their list, as in
+// SOPS.
+func sopsWalk(n *yaml.Node, path []string, leaf func(*yaml.Node, []string) error) error {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := sopsWalk(n.Content[i+1], append(path[:len(path):len(path)], n.Content[i].Value), leaf); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		// SOPS writes the comments of a list as encrypted items of it.
+		n.Content = slices.DeleteFunc(n.Content, func(c *yaml.Node) bool {
+			m := sopsValue.FindStringSubmatch(c.Value)
+			return c.Kind == yaml.ScalarNode && m != nil && m[4] == "comment"
+		})
+		for _, c := range n.Content {
+			if err := sopsWalk(c, path, leaf); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		return leaf(n, path)
+	case yaml.AliasNode:
+		return errors.New("aliases are not supported in SOPS files")
+	}
+	return nil
+}
+
+// sopsDecryptValue decrypts an ENC[...] value, returning it as text with
+// the YAML tag of its type.
+func sopsDecryptValue(v string, key []byte, additionalData string) (string, string, error) {
+	m := sopsValue.FindStringSubmatch(v)
+	if m == nil {
+		return "", "", errors.New("not a SOPS encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		b, err := base64.StdEncoding.DecodeString(m[i+1])
+		if err != nil {
+			return "", "", fmt.Errorf("bad encrypted value: %w", err)
+		}
+		parts[i] = b
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", errors.New("cannot decrypt value (wrong key, or moved in the file)")
+	}
+	switch m[4] {
+	case "str", "bytes":
+		return string(plain), "!!str", nil
+	case "int":
+		return string(plain), "!!int", nil
+	case "float":
+		return string(plain), "!!float", nil
+	case "bool":
+		return strings.ToLower(string(plain)), "!!bool", nil
+	}
+	return "", "", fmt.Errorf("unknown value type %q", m[4])
+}
+
+// sopsMACBytes returns what SOPS hashes for a value: the text of strings,
+// and integers, floats and booleans in Go's formatting (booleans
+// capitalized).
+func sopsMACBytes(n *yaml.Node) []byte {
+	var v any
+	if n.Decode(&v) != nil {
+		return []byte(n.Value)
+	}
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case int:
+		return []byte(strconv.Itoa(v))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case nil:
+		return nil
+	}
+	return []byte(n.Value)
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Environment variables that stand for a config when no file is given.
+const (
+	EnvConfigJSON = "WEBHOOKRELAY_CONFIG_JSON"
+	EnvListenAddr = "WEBHOOKRELAY_LISTEN_ADDR"
+	envRelay      = "WEBHOOKRELAY_RELAY_"
+)
+
+// EnvSource returns the config given by the environment, for running
+// without config files: the whole config as JSON in WEBHOOKRELAY_CONFIG_JSON,
+// or a minimal one built from variables such as
+//
+//	WEBHOOKRELAY_LISTEN_ADDR=:8099 (or PORT=8099)
+//	WEBHOOKRELAY_RELAY_0_NAME=github
+//	WEBHOOKRELAY_RELAY_0_PATH=/github
+//	WEBHOOKRELAY_RELAY_0_METHODS=POST,PUT
+//	WEBHOOKRELAY_RELAY_0_DEST_0_URL=https://ci.internal/hook
+//
+// ok is false when the environment sets neither.
+func EnvSource(environ []string) (src Source, ok bool, err error) {
+	env := map[string]string{}
+	for _, kv := range environ {
+		if k, v, found := strings.Cut(kv, "="); found {
+			env[k] = v
+		}
+	}
+	var relayVars []string
+	for k := range env {
+		if strings.HasPrefix(k, envRelay) {
+			relayVars = append(relayVars, k)
+		}
+	}
+	sort.Strings(relayVars)
+
+	if raw, set := env[EnvConfigJSON]; set {
+		if len(relayVars) > 0 {
+			return Source{}, false, fmt.Errorf("%s and %s* are both set; use one", EnvConfigJSON, envRelay)
+		}
+		return Source{Inline: []byte(raw), InlineName: EnvConfigJSON}, true, nil
+	}
+	if len(relayVars) == 0 {
+		return Source{}, false, nil
+	}
+
+	relays, err := envRelays(env, relayVars)
+	if err != nil {
+		return Source{}, false, err
+	}
+	addr := env[EnvListenAddr]
+	if addr == "" && env["PORT"] != "" {
+		addr = ":" + env["PORT"]
+	}
+	raw, err := json.Marshal(map[string]any{"server": map[string]any{"listen_addr": addr}, "relays": relays})
+	if err != nil {
+		return Source{}, false, err
+	}
+	return Source{Inline: raw, InlineName: "environment"}, true, nil
+}
+
+// envRelays builds the relays of the WEBHOOKRELAY_RELAY_<i>_* variables in
+// vars, numbered from 0 without gaps, as are their destinations.
+func envRelays(env map[string]string, vars []string) ([]map[string]any, error) {
+	type entry struct {
+		cfg   map[string]any
+		dests map[int]string
+	}
+	byIndex := map[int]*entry{}
+	for _, k := range vars {
+		idx, field, _ := strings.Cut(strings.TrimPrefix(k, envRelay), "_")
+		i, err := strconv.Atoi(idx)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("%s: want %s<n>_<field>", k, envRelay)
+		}
+		r := byIndex[i]
+		if r == nil {
+			r = &entry{cfg: map[string]any{}, dests: map[int]string{}}
+			byIndex[i] = r
+		}
+		v := strings.TrimSpace(env[k])
+		switch {
+		case field == "NAME":
+			r.cfg["name"] = v
+		case field == "PATH":
+			r.cfg["listen_path"] = v
+		case field == "METHODS":
+			var methods []string
+			for _, m := range strings.Split(v, ",") {
+				if m = strings.TrimSpace(m); m != "" {
+					methods = append(methods, strings.ToUpper(m))
+				}
+			}
+			r.cfg["methods"] = methods
+		case strings.HasPrefix(field, "DEST_") && strings.HasSuffix(field, "_URL"):
+			j, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(field, "DEST_"), "_URL"))
+			if err != nil || j < 0 {
+				return nil, fmt.Errorf("%s: want %s%d_DEST_<n>_URL", k, envRelay, i)
+			}
+			r.dests[j] = v
+		default:
+			return nil, fmt.Errorf("%s: unknown field %q (known: NAME, PATH, METHODS, DEST_<n>_URL)", k, field)
+		}
+	}
+
+	relays := make([]map[string]any, len(byIndex))
+	for i := range relays {
+		r := byIndex[i]
+		if r == nil {
+			return nil, fmt.Errorf("%s%d_* is missing; relays are numbered from 0", envRelay, i)
+		}
+		dests := make([]map[string]any, len(r.dests))
+		for j := range dests {
+			u, ok := r.dests[j]
+			if !ok {
+				return nil, fmt.Errorf("%s%d_DEST_%d_URL is missing; destinations are numbered from 0", envRelay, i, j)
+			}
+			dests[j] = map[string]any{"url": u}
+		}
+		r.cfg["destinations"] = dests
+		relays[i] = r.cfg
+	}
+	return relays, nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Config file formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// FormatOf picks the format of a config file from its extension: YAML for
+// ".yaml" and ".yml", TOML for ".toml", JSON otherwise. The ".age" of an
+// encrypted file is left out first.
+func FormatOf(configPath string) string {
+	configPath = strings.TrimSuffix(configPath, ageSuffix)
+	switch strings.ToLower(filepath.Ext(configPath)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// toJSON converts a config in format into the JSON it stands for, so every
+// format goes through the same decoder and validateAndDefault: the field
+// names are the JSON ones, and unknown fields are rejected alike.
+func toJSON(format string, b []byte) ([]byte, error) {
+	var raw []byte
+	var err error
+	switch format {
+	case FormatJSON:
+		return b, nil
+	case FormatYAML:
+		raw, err = yamlToJSON(b)
+	case FormatTOML:
+		raw, err = tomlToJSON(b)
+	default:
+		return nil, fmt.Errorf("unknown config format %q (want %q, %q or %q)", format, FormatJSON, FormatYAML, FormatTOML)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", format, err)
+	}
+	return raw, nil
+}
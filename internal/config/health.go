@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HealthCheckConfig probes a destination in the background, either with a
+// GET to URL (healthy on 2xx) or by opening a TCP connection to TCP
+// ("host:port"). A destination turns unhealthy after UnhealthyThreshold
+// failed probes in a row and healthy again after HealthyThreshold successes.
+type HealthCheckConfig struct {
+	URL                string `json:"url,omitempty"`
+	TCP                string `json:"tcp,omitempty"`
+	IntervalMS         int    `json:"interval_ms,omitempty"`
+	TimeoutMS          int    `json:"timeout_ms,omitempty"`
+	HealthyThreshold   int    `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold int    `json:"unhealthy_threshold,omitempty"`
+}
+
+func (h HealthCheckConfig) Interval() time.Duration {
+	return time.Duration(h.IntervalMS) * time.Millisecond
+}
+
+func (h HealthCheckConfig) Timeout() time.Duration {
+	return time.Duration(h.TimeoutMS) * time.Millisecond
+}
+
+// Target identifies the probe: destinations with the same target share it.
+func (h HealthCheckConfig) Target() string {
+	if h.TCP != "" {
+		return "tcp://" + h.TCP
+	}
+	return h.URL
+}
+
+func validateHealthCheck(h *HealthCheckConfig, prefix string) []string {
+	var problems []string
+	h.URL, h.TCP = strings.TrimSpace(h.URL), strings.TrimSpace(h.TCP)
+	switch {
+	case (h.URL == "") == (h.TCP == ""):
+		problems = append(problems, fmt.Sprintf("%s needs exactly one of url and tcp", prefix))
+	case h.URL != "":
+		if u, err := url.Parse(h.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s.url must be an absolute http(s) URL (got %q)", prefix, h.URL))
+		}
+	default:
+		if host, port, err := net.SplitHostPort(h.TCP); err != nil || host == "" || port == "" {
+			problems = append(problems, fmt.Sprintf("%s.tcp must be \"host:port\" (got %q)", prefix, h.TCP))
+		}
+	}
+	for _, v := range []struct {
+		name string
+		val  *int
+		def  int
+	}{
+		{"interval_ms", &h.IntervalMS, 10_000},
+		{"timeout_ms", &h.TimeoutMS, 2_000},
+		{"healthy_threshold", &h.HealthyThreshold, 2},
+		{"unhealthy_threshold", &h.UnhealthyThreshold, 3},
+	} {
+		if *v.val < 0 {
+			problems = append(problems, fmt.Sprintf("%s.%s must not be negative", prefix, v.name))
+		} else if *v.val == 0 {
+			*v.val = v.def
+		}
+	}
+	if h.TimeoutMS > h.IntervalMS {
+		problems = append(problems, fmt.Sprintf("%s.timeout_ms must not exceed interval_ms", prefix))
+	}
+	return problems
+}
+
+// OutlierDetectionConfig ejects a destination of a group from its hash set
+// or failover order while its deliveries fail: after ConsecutiveErrors
+// errors in a row, or once ErrorRate of at least MinRequests deliveries
+// within IntervalMS were errors. Errors are connection failures, timeouts
+// and 5xx answers. An ejected destination stays out for BaseEjectionMS
+// times the number of times it was ejected, at most MaxEjectionMS, and then
+// takes a growing share of the events over ReintroduceMS. At most
+// MaxEjectionPercent of the group's destinations are out at once, but one
+// always may be.
+type OutlierDetectionConfig struct {
+	ConsecutiveErrors  int     `json:"consecutive_errors,omitempty"`
+	ErrorRate          float64 `json:"error_rate,omitempty"`
+	MinRequests        int     `json:"min_requests,omitempty"`
+	IntervalMS         int     `json:"interval_ms,omitempty"`
+	BaseEjectionMS     int     `json:"base_ejection_ms,omitempty"`
+	MaxEjectionMS      int     `json:"max_ejection_ms,omitempty"`
+	ReintroduceMS      int     `json:"reintroduce_ms,omitempty"`
+	MaxEjectionPercent int     `json:"max_ejection_percent,omitempty"`
+}
+
+func (o OutlierDetectionConfig) Interval() time.Duration {
+	return time.Duration(o.IntervalMS) * time.Millisecond
+}
+
+// Ejection is how long a destination ejected for the nth time stays out.
+func (o OutlierDetectionConfig) Ejection(n int) time.Duration {
+	return time.Duration(min(o.BaseEjectionMS*n, o.MaxEjectionMS)) * time.Millisecond
+}
+
+func (o OutlierDetectionConfig) Reintroduce() time.Duration {
+	return time.Duration(o.ReintroduceMS) * time.Millisecond
+}
+
+func validateOutlierDetection(o *OutlierDetectionConfig, prefix string) []string {
+	var problems []string
+	for _, v := range []struct {
+		name string
+		val  *int
+		def  int
+	}{
+		{"consecutive_errors", &o.ConsecutiveErrors, 5},
+		{"min_requests", &o.MinRequests, 10},
+		{"interval_ms", &o.IntervalMS, 10_000},
+		{"base_ejection_ms", &o.BaseEjectionMS, 30_000},
+		{"max_ejection_ms", &o.MaxEjectionMS, 300_000},
+		{"reintroduce_ms", &o.ReintroduceMS, 30_000},
+		{"max_ejection_percent", &o.MaxEjectionPercent, 50},
+	} {
+		if *v.val < 0 {
+			problems = append(problems, fmt.Sprintf("%s.%s must not be negative", prefix, v.name))
+		} else if *v.val == 0 {
+			*v.val = v.def
+		}
+	}
+	if o.ErrorRate < 0 || o.ErrorRate > 1 {
+		problems = append(problems, fmt.Sprintf("%s.error_rate must be between 0 and 1 (got %v)", prefix, o.ErrorRate))
+	}
+	if o.MaxEjectionPercent > 100 {
+		problems = append(problems, fmt.Sprintf("%s.max_ejection_percent must be at most 100 (got %d)", prefix, o.MaxEjectionPercent))
+	}
+	if o.MaxEjectionMS < o.BaseEjectionMS {
+		problems = append(problems, fmt.Sprintf("%s.max_ejection_ms must not be less than base_ejection_ms", prefix))
+	}
+	return problems
+}
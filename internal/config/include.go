@@ -0,0 +1,454 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Source is where a config is read from: a main file, files it includes
+// (see Config.Include), and a directory of more files, merged in that
+// order.
+type Source struct {
+	// Path is the main config file; it may be empty when Dir is set. An
+	// http:// or https:// URL is fetched instead (see IsRemote).
+	Path string
+	// Format overrides the format Path's extension implies. Included files
+	// are always read by extension.
+	Format string
+	// Dir is a conf.d-style directory whose config files are merged after
+	// Path's.
+	Dir string
+	// Overlays are files deep-merged over the config, in order, e.g. the
+	// settings of one environment (see overlayConfig).
+	Overlays []string
+	// Overrides is a config fragment merged over everything else, like an
+	// overlay, e.g. settings given as flags.
+	Overrides map[string]any
+	// Header is sent when fetching a remote Path, e.g. Authorization.
+	Header http.Header
+	// Inline is the main config as JSON, in place of Path, e.g. from the
+	// environment (see EnvSource). InlineName stands for it in errors.
+	Inline     []byte
+	InlineName string
+}
+
+// Load reads, merges and validates the config.
+func (s Source) Load() (Config, error) {
+	cfg, _, err := s.LoadFiles()
+	return cfg, err
+}
+
+// LoadFiles is Load that also returns the files and directories read, for
+// watching them.
+func (s Source) LoadFiles() (Config, []string, error) {
+	if s.Path == "" && s.Dir == "" && s.Inline == nil {
+		return Config{}, nil, errors.New("no config file or directory")
+	}
+	var parts []configPart
+	var read []string
+	if s.Inline != nil {
+		main, err := parsePart(s.InlineName, FormatJSON, s.Inline)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		if _, ok := main.doc["include"]; ok {
+			return Config{}, nil, fmt.Errorf("%s: include is only read from a config file", s.InlineName)
+		}
+		parts = append(parts, main)
+	} else if s.Path != "" {
+		var main configPart
+		var err error
+		if IsRemote(s.Path) {
+			main, err = s.readRemotePart()
+		} else {
+			format := s.Format
+			if format == "" {
+				format = FormatOf(s.Path)
+			}
+			main, err = readPart(s.Path, format)
+		}
+		if err != nil {
+			return Config{}, nil, err
+		}
+		parts, read = append(parts, main), append(read, s.Path)
+		includes, err := includedFiles(main)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		for _, p := range includes {
+			if p.dir {
+				read = append(read, p.path)
+				continue
+			}
+			part, err := readIncludedPart(p.path)
+			if err != nil {
+				return Config{}, nil, err
+			}
+			parts, read = append(parts, part), append(read, p.path)
+		}
+	}
+	if s.Dir != "" {
+		files, err := configFilesIn(s.Dir)
+		if err != nil {
+			return Config{}, nil, fmt.Errorf("config dir: %w", err)
+		}
+		read = append(read, s.Dir)
+		for _, f := range files {
+			part, err := readIncludedPart(f)
+			if err != nil {
+				return Config{}, nil, err
+			}
+			parts, read = append(parts, part), append(read, f)
+		}
+		if len(parts) == 0 {
+			return Config{}, nil, fmt.Errorf("config dir %s has no config files", s.Dir)
+		}
+	}
+
+	merged := map[string]any{}
+	var origins []relayOrigin
+	encrypted := false
+	for _, p := range parts {
+		encrypted = encrypted || p.encrypted
+		if n := len(asList(p.doc["relays"])); n > 0 {
+			origins = append(origins, relayOrigin{path: p.path, from: len(asList(merged["relays"])), n: n})
+		}
+		if err := mergeConfig(merged, p.doc, "", p.path); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	for _, o := range s.Overlays {
+		part, err := readOverlay(o)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		overlayConfig(merged, part.doc)
+		read, encrypted = append(read, o), encrypted || part.encrypted
+	}
+	if s.Overrides != nil {
+		overlayConfig(merged, s.Overrides)
+	}
+	// The relays of the admin API and the registry come last: overlays
+	// are for the relays of the config files.
+	var stopped []string
+	if file := s.RelaysFilePath(relaysFileOf(merged)); file != "" {
+		part, names, err := readRelaysFile(file)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		if n := len(asList(part.doc["relays"])); n > 0 {
+			origins = append(origins, relayOrigin{path: file, from: len(asList(merged["relays"])), n: n})
+			if err := mergeConfig(merged, part.doc, "", file); err != nil {
+				return Config{}, nil, err
+			}
+			parts = append(parts, part)
+		}
+		stopped, read = names, append(read, file)
+	}
+	reg, err := registryOf(merged)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	var regWarnings []string
+	if reg.Backend != "" {
+		var regParts []configPart
+		regParts, regWarnings, err = readRegistryParts(reg)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		for _, p := range regParts {
+			origins = append(origins, relayOrigin{path: p.path, from: len(asList(merged["relays"])), n: 1})
+			if err := mergeConfig(merged, p.doc, "", p.path); err != nil {
+				return Config{}, nil, err
+			}
+		}
+		parts = append(parts, regParts...)
+	}
+	raw := parts[0].raw
+	if len(parts) > 1 || len(s.Overlays) > 0 || s.Overrides != nil {
+		var err error
+		if raw, err = json.Marshal(merged); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	var cfg Config
+	if err := decodeStrict(raw, &cfg); err != nil {
+		return Config{}, nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	if err := validateAndDefault(&cfg); err != nil {
+		var problems Problems
+		if len(parts) > 1 && errors.As(err, &problems) {
+			return Config{}, nil, annotateOrigins(problems, origins)
+		}
+		return Config{}, nil, err
+	}
+	if len(parts) > 1 {
+		cfg.Warnings = annotateOrigins(cfg.Warnings, origins)
+	}
+	cfg.Warnings = append(regWarnings, cfg.Warnings...)
+	cfg.Admin.StoppedRelays = stopped
+	cfg.Encrypted = encrypted
+	return cfg, read, nil
+}
+
+// configPart is one config file, as JSON.
+type configPart struct {
+	path   string
+	format string
+	raw    []byte
+	doc    map[string]any
+	// encrypted is set for a file decrypted with age or SOPS.
+	encrypted bool
+}
+
+// readPart reads one config file, rejecting unknown fields there so the
+// error names the file.
+func readPart(path, format string) (configPart, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return configPart{}, fmt.Errorf("read config: %w", err)
+	}
+	return parsePart(path, format, b)
+}
+
+// parsePart parses the contents b of the config file at path.
+func parsePart(path, format string, b []byte) (configPart, error) {
+	b, format, encrypted, err := decryptConfig(path, format, b)
+	if err != nil {
+		return configPart{}, err
+	}
+	raw, err := toJSON(format, b)
+	if err != nil {
+		return configPart{}, fmt.Errorf("%s: %w", path, err)
+	}
+	// Numbers stay as written, so large integers survive the merge.
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return configPart{}, fmt.Errorf("%s: parse config %s: %w", path, format, err)
+	}
+	if doc == nil {
+		doc = map[string]any{}
+	}
+	if problems := schemaProblems(doc); len(problems) > 0 {
+		for i, p := range problems {
+			problems[i] = path + ": " + p
+		}
+		return configPart{}, problems
+	}
+	p := configPart{path: path, format: format, raw: raw, doc: doc.(map[string]any), encrypted: encrypted}
+	if _, ok := p.doc["$schema"]; ok {
+		delete(p.doc, "$schema")
+		if p.raw, err = json.Marshal(p.doc); err != nil {
+			return configPart{}, err
+		}
+	}
+	if err := decodeStrict(p.raw, new(Config)); err != nil {
+		return configPart{}, fmt.Errorf("%s: parse config %s: %w", path, format, err)
+	}
+	return p, nil
+}
+
+// readIncludedPart reads an included or conf.d file, which may not include
+// others.
+func readIncludedPart(path string) (configPart, error) {
+	p, err := readPart(path, FormatOf(path))
+	if err != nil {
+		return configPart{}, err
+	}
+	if _, ok := p.doc["include"]; ok {
+		return configPart{}, fmt.Errorf("%s: include is only read from the main config file", path)
+	}
+	return p, nil
+}
+
+// decodeStrict decodes a single JSON object, rejecting unknown fields.
+func decodeStrict(raw []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// Ensure no trailing tokens.
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("extra data after first JSON object")
+	}
+	return nil
+}
+
+type includedPath struct {
+	path string
+	dir  bool
+}
+
+// includedFiles expands the include entries of the main config: paths
+// relative to its directory, globs, and directories standing for the config
+// files in them. Entries without glob characters must exist.
+func includedFiles(main configPart) ([]includedPath, error) {
+	v, ok := main.doc["include"]
+	if !ok {
+		return nil, nil
+	}
+	var entries []string
+	if b, err := json.Marshal(v); err != nil || json.Unmarshal(b, &entries) != nil {
+		return nil, fmt.Errorf("%s: include must be a list of paths", main.path)
+	}
+	base := filepath.Dir(main.path)
+	var out []includedPath
+	seen := map[string]bool{filepath.Clean(main.path): true}
+	for i, e := range entries {
+		if strings.TrimSpace(e) == "" {
+			return nil, fmt.Errorf("%s: include[%d] is empty", main.path, i)
+		}
+		if !filepath.IsAbs(e) {
+			e = filepath.Join(base, e)
+		}
+		var matches []string
+		if strings.ContainsAny(e, "*?[") {
+			m, err := filepath.Glob(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: include[%d]: %w", main.path, i, err)
+			}
+			matches = m
+		} else {
+			matches = []string{e}
+		}
+		for _, m := range matches {
+			fi, err := os.Stat(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s: include[%d]: %w", main.path, i, err)
+			}
+			if !fi.IsDir() {
+				if !seen[filepath.Clean(m)] {
+					seen[filepath.Clean(m)] = true
+					out = append(out, includedPath{path: m})
+				}
+				continue
+			}
+			files, err := configFilesIn(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s: include[%d]: %w", main.path, i, err)
+			}
+			out = append(out, includedPath{path: m, dir: true})
+			for _, f := range files {
+				if !seen[filepath.Clean(f)] {
+					seen[filepath.Clean(f)] = true
+					out = append(out, includedPath{path: f})
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// configFilesIn lists the JSON, YAML and TOML files in dir (age-encrypted
+// ones too) by name, leaving out hidden files such as editor swap files.
+func configFilesIn(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(strings.TrimSuffix(name, ageSuffix))) {
+		case ".json", ".yaml", ".yml", ".toml":
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// mergeConfig merges src, read from file, into dst: objects are merged key
+// by key and lists appended, so each file can add relays, listeners or
+// alert rules. A setting given different values in two files is an error,
+// and so is a destination group defined twice.
+func mergeConfig(dst, src map[string]any, path, file string) error {
+	for k, v := range src {
+		if path == "" && k == "include" {
+			continue
+		}
+		key := strings.TrimPrefix(path+"."+k, ".")
+		cur, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		if path == "destination_groups" {
+			return fmt.Errorf("%s: destination group %q is already defined", file, k)
+		}
+		switch cv := cur.(type) {
+		case map[string]any:
+			sv, ok := v.(map[string]any)
+			if !ok {
+				return fmt.Errorf("%s: %s conflicts with another config file", file, key)
+			}
+			if err := mergeConfig(cv, sv, key, file); err != nil {
+				return err
+			}
+		case []any:
+			sv, ok := v.([]any)
+			if !ok {
+				return fmt.Errorf("%s: %s conflicts with another config file", file, key)
+			}
+			dst[k] = append(cv, sv...)
+		default:
+			if !reflect.DeepEqual(cur, v) {
+				return fmt.Errorf("%s: %s is already set to a different value in another config file", file, key)
+			}
+		}
+	}
+	return nil
+}
+
+func asList(v any) []any {
+	l, _ := v.([]any)
+	return l
+}
+
+// relayOrigin records which file relays[from:from+n] came from, so
+// validation problems, which name relays by index, can be traced back.
+type relayOrigin struct {
+	path    string
+	from, n int
+}
+
+var relayIndex = regexp.MustCompile(`^relays\[(\d+)\]`)
+
+// annotateOrigins adds the file a relay came from to the problems about
+// it.
+func annotateOrigins(problems Problems, origins []relayOrigin) Problems {
+	out := make(Problems, len(problems))
+	for i, p := range problems {
+		out[i] = p
+		m := relayIndex.FindStringSubmatch(p)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		for _, o := range origins {
+			if n >= o.from && n < o.from+o.n {
+				out[i] = fmt.Sprintf("%s (in %s)", p, o.path)
+				break
+			}
+		}
+	}
+	return out
+}
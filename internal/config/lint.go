@@ -0,0 +1,161 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// lint finds likely mistakes in a valid config. They do not stop it from
+// loading; the relay logs them and validate lists them.
+func lint(cfg *Config) []string {
+	warnings := lintListenPaths(cfg.Relays)
+	plain := map[string]bool{}
+	for i, r := range cfg.Relays {
+		warnings = append(warnings, lintDestinations(r.Destinations, fmt.Sprintf("relays[%d].destinations", i), plain)...)
+		for ri, rt := range r.Routes {
+			warnings = append(warnings, lintDestinations(rt.Destinations, fmt.Sprintf("relays[%d].routes[%d].destinations", i, ri), plain)...)
+		}
+		warnings = append(warnings, lintRoutes(r, i)...)
+		warnings = append(warnings, lintOutliers(r)...)
+	}
+	return warnings
+}
+
+// lintListenPaths warns about listen paths that differ only in case or a
+// trailing slash, and wildcard paths below other wildcard paths.
+func lintListenPaths(relays []RelayConfig) []string {
+	var warnings []string
+	seen := map[string]int{}
+	for i, r := range relays {
+		lp := strings.TrimSpace(r.ListenPath)
+		if lp == "" || r.ListenPathRegex != "" {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSuffix(lp, "/"))
+		j, ok := seen[key]
+		if !ok {
+			seen[key] = i
+			continue
+		}
+		// The same path twice is an error, found when relays are resolved.
+		if other := strings.TrimSpace(relays[j].ListenPath); other != lp {
+			warnings = append(warnings, fmt.Sprintf("relays[%d].listen_path %q differs from relays[%d]'s %q only in case or a trailing slash", i, lp, j, other))
+		}
+	}
+	for i, r := range relays {
+		p, ok := strings.CutSuffix(strings.TrimSpace(r.ListenPath), WildcardSuffix)
+		if !ok {
+			continue
+		}
+		for j, o := range relays {
+			q, ok := strings.CutSuffix(strings.TrimSpace(o.ListenPath), WildcardSuffix)
+			if ok && i != j && p != q && strings.HasPrefix(p+"/", q+"/") {
+				warnings = append(warnings, fmt.Sprintf("relays[%d].listen_path %q is below relays[%d]'s %q, which no longer gets the requests below it", i, r.ListenPath, j, o.ListenPath))
+			}
+		}
+	}
+	return warnings
+}
+
+// lintDestinations warns about a destination listed twice in one list, which
+// gets every event twice, and about plain http URLs of public hosts, once
+// per URL (plain remembers them).
+func lintDestinations(dests []DestinationConfig, where string, plain map[string]bool) []string {
+	var warnings []string
+	seen := map[string]bool{}
+	for _, d := range dests {
+		// Conditional, weighted and hashed destinations need not get the
+		// same events.
+		if d.When == nil && !d.Fallback && d.Weight == 0 && d.HashSet == "" {
+			key := d.Type + " " + d.URL + " " + d.Relay
+			if seen[key] {
+				target := d.URL
+				if d.Type == DestinationRelay {
+					target = "relay " + d.Relay
+				}
+				warnings = append(warnings, fmt.Sprintf("%s lists %q twice, so it gets every event twice", where, target))
+			}
+			seen[key] = true
+		}
+		if d.Type == DestinationHTTP && !plain[d.URL] && plainPublicURL(d.URL) {
+			plain[d.URL] = true
+			warnings = append(warnings, fmt.Sprintf("%s: %q is sent unencrypted over plain http to a host that does not look internal", where, d.URL))
+		}
+	}
+	return warnings
+}
+
+// plainPublicURL reports whether raw is an http:// URL of a host that is not
+// a loopback or private address, localhost, a single-label name (such as a
+// Compose or Kubernetes service) or under .internal, .local, .localhost or
+// .svc.
+func plainPublicURL(raw string) bool {
+	if URLTemplated(raw) {
+		return false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsUnspecified()
+	}
+	if host == "localhost" || !strings.Contains(host, ".") {
+		return false
+	}
+	for _, suffix := range []string{".internal", ".local", ".localhost", ".svc"} {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// lintRoutes warns about routes that can never match: one with the same
+// match as an earlier route, which takes all of its events, or one that
+// only matches methods the relay does not accept.
+func lintRoutes(r RelayConfig, i int) []string {
+	var warnings []string
+	matches := make([]string, len(r.Routes))
+	for ri, rt := range r.Routes {
+		b, _ := json.Marshal(rt.Match)
+		matches[ri] = string(b)
+		if rj := slices.Index(matches[:ri], matches[ri]); rj >= 0 {
+			warnings = append(warnings, fmt.Sprintf("relays[%d].routes[%d] is unreachable: routes[%d] has the same match and comes first", i, ri, rj))
+			continue
+		}
+		if len(rt.Match.Methods) > 0 && !slices.ContainsFunc(rt.Match.Methods, func(m string) bool { return slices.Contains(r.Methods, m) }) {
+			warnings = append(warnings, fmt.Sprintf("relays[%d].routes[%d] is unreachable: it matches only methods %v, which the relay does not accept (methods %v)", i, ri, rt.Match.Methods, r.Methods))
+		}
+	}
+	return warnings
+}
+
+// lintOutliers warns about outlier detection on group references of a
+// fan_out relay without a hash_key: every destination gets every event, so
+// there is nothing to eject a destination from.
+func lintOutliers(r RelayConfig) []string {
+	if r.Strategy == StrategyFirstSuccess {
+		return nil
+	}
+	var warnings []string
+	seen := map[string]bool{}
+	check := func(dests []DestinationConfig) {
+		for _, d := range dests {
+			if d.OutlierSet != "" && d.HashSet == "" && !seen[d.OutlierSet] {
+				seen[d.OutlierSet] = true
+				warnings = append(warnings, fmt.Sprintf("%s.outlier_detection has no effect: without hash_key, and with strategy %q, every destination of the group gets every event", d.OutlierSet, r.Strategy))
+			}
+		}
+	}
+	check(r.Destinations)
+	for _, rt := range r.Routes {
+		check(rt.Destinations)
+	}
+	return warnings
+}
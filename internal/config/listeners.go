@@ -0,0 +1,216 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ListenerConfig is an additional listener. Relays limits it to the relays
+// with those names; without it the listener serves every relay.
+type ListenerConfig struct {
+	Name       string    `json:"name"`
+	ListenAddr string    `json:"listen_addr"`
+	TLS        TLSConfig `json:"tls"`
+	SocketMode string    `json:"socket_mode,omitempty"`
+	Relays     []string  `json:"relays,omitempty"`
+}
+
+// UnixPrefix starts a listen address that is a Unix domain socket path,
+// e.g. "unix:///var/run/webhookrelay.sock".
+const UnixPrefix = "unix://"
+
+// ListenNetwork splits a listen address into the network and address
+// net.Listen takes.
+func ListenNetwork(addr string) (network, address string) {
+	if p, ok := strings.CutPrefix(addr, UnixPrefix); ok {
+		return "unix", p
+	}
+	return "tcp", addr
+}
+
+// LocalAddr reports whether a listen address only takes connections from
+// this host: a Unix socket, or localhost or a loopback IP.
+func LocalAddr(addr string) bool {
+	if network, _ := ListenNetwork(addr); network == "unix" {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return ip.IsLoopback()
+	}
+	return strings.EqualFold(host, "localhost")
+}
+
+// ParseSocketMode parses the octal permissions of a Unix socket, e.g.
+// "0660". Empty means DefaultSocketMode.
+func ParseSocketMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return DefaultSocketMode, nil
+	}
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("must be octal permissions such as \"0660\" (got %q)", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// DefaultSocketMode lets the owner and group of the relay use its sockets.
+const DefaultSocketMode os.FileMode = 0o660
+
+// TLSConfig serves a listener over HTTPS. With ClientCAFile set, clients must
+// present a certificate signed by one of its CAs.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file,omitempty"`
+	KeyFile      string `json:"key_file,omitempty"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// Enabled reports whether the listener serves HTTPS.
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+// Load reads the certificate, key and client CAs.
+func (t TLSConfig) Load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if t.ClientCAFile != "" {
+		pem, err := os.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", t.ClientCAFile)
+		}
+		tc.ClientCAs = pool
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+func validateAddr(addr, mode *string, prefix string) []string {
+	*mode = strings.TrimSpace(*mode)
+	var problems []string
+	if network, p := ListenNetwork(*addr); network == "unix" && !strings.HasPrefix(p, "/") {
+		problems = append(problems, fmt.Sprintf("%s.listen_addr: socket path must be absolute, e.g. \"unix:///var/run/webhookrelay.sock\" (got %q)", prefix, *addr))
+	}
+	if _, err := ParseSocketMode(*mode); err != nil {
+		problems = append(problems, fmt.Sprintf("%s.socket_mode %v", prefix, err))
+	}
+	return problems
+}
+
+func validateTLS(t *TLSConfig, prefix string) []string {
+	t.CertFile = strings.TrimSpace(t.CertFile)
+	t.KeyFile = strings.TrimSpace(t.KeyFile)
+	t.ClientCAFile = strings.TrimSpace(t.ClientCAFile)
+	switch {
+	case t.CertFile == "" && t.KeyFile == "" && t.ClientCAFile == "":
+		return nil
+	case t.CertFile == "" || t.KeyFile == "":
+		return []string{prefix + ": cert_file and key_file are both required"}
+	}
+	if _, err := t.Load(); err != nil {
+		return []string{fmt.Sprintf("%s: %v", prefix, err)}
+	}
+	return nil
+}
+
+// validateListeners checks the listen addresses of the server, its
+// additional listeners, the admin API and metrics.
+func validateListeners(cfg *Config) []string {
+	var problems []string
+	cfg.Server.ListenAddr = strings.TrimSpace(cfg.Server.ListenAddr)
+	cfg.Admin.ListenAddr = strings.TrimSpace(cfg.Admin.ListenAddr)
+	if cfg.Server.ListenAddr == "" && len(cfg.Server.Listeners) == 0 {
+		problems = append(problems, "server.listen_addr is required")
+	}
+	problems = append(problems, validateAddr(&cfg.Server.ListenAddr, &cfg.Server.SocketMode, "server")...)
+	problems = append(problems, validateAddr(&cfg.Admin.ListenAddr, &cfg.Admin.SocketMode, "admin")...)
+	problems = append(problems, validateTLS(&cfg.Server.TLS, "server.tls")...)
+	problems = append(problems, validateTLS(&cfg.Admin.TLS, "admin.tls")...)
+
+	if m := &cfg.Metrics; m.Enabled && !cfg.Telemetry.Prometheus() {
+		if m.ListenAddr != "" {
+			problems = append(problems, fmt.Sprintf("metrics.listen_addr is not used with telemetry.metrics_exporter %q", cfg.Telemetry.MetricsExporter))
+		}
+	} else if m.Enabled {
+		m.ListenAddr = strings.TrimSpace(m.ListenAddr)
+		m.Path = strings.TrimSpace(m.Path)
+		if m.Path == "" {
+			m.Path = "/metrics"
+		}
+		if !strings.HasPrefix(m.Path, "/") {
+			problems = append(problems, fmt.Sprintf("metrics.path must start with '/' (got %q)", m.Path))
+		}
+		if m.ListenAddr == "" && cfg.Admin.ListenAddr == "" {
+			problems = append(problems, "metrics.listen_addr is required unless admin.listen_addr is set")
+		}
+		problems = append(problems, validateAddr(&m.ListenAddr, &m.SocketMode, "metrics")...)
+		problems = append(problems, validateTLS(&m.TLS, "metrics.tls")...)
+	}
+
+	relays := map[string]bool{}
+	for _, r := range cfg.Relays {
+		if r.Name != "" {
+			relays[r.Name] = true
+		}
+	}
+	// Names are those the admin API uses for the built-in listeners.
+	names := map[string]bool{"public": true, "admin": true, "metrics": true}
+	addrs := map[string]string{}
+	if cfg.Server.ListenAddr != "" {
+		addrs[cfg.Server.ListenAddr] = "server.listen_addr"
+	}
+	for i := range cfg.Server.Listeners {
+		l := &cfg.Server.Listeners[i]
+		prefix := fmt.Sprintf("server.listeners[%d]", i)
+		l.Name = strings.TrimSpace(l.Name)
+		l.ListenAddr = strings.TrimSpace(l.ListenAddr)
+		switch {
+		case l.Name == "":
+			problems = append(problems, prefix+".name is required")
+		case names[l.Name]:
+			problems = append(problems, fmt.Sprintf("%s.name %q is already used", prefix, l.Name))
+		}
+		names[l.Name] = true
+		if l.ListenAddr == "" {
+			problems = append(problems, prefix+".listen_addr is required")
+		} else if other, ok := addrs[l.ListenAddr]; ok {
+			problems = append(problems, fmt.Sprintf("%s.listen_addr must differ from %s", prefix, other))
+		} else {
+			addrs[l.ListenAddr] = prefix + ".listen_addr"
+		}
+		problems = append(problems, validateAddr(&l.ListenAddr, &l.SocketMode, prefix)...)
+		problems = append(problems, validateTLS(&l.TLS, prefix+".tls")...)
+		for j, name := range l.Relays {
+			if !relays[name] {
+				problems = append(problems, fmt.Sprintf("%s.relays[%d]: no relay named %q", prefix, j, name))
+			}
+		}
+	}
+	if other, ok := addrs[cfg.Admin.ListenAddr]; ok && cfg.Admin.ListenAddr != "" {
+		problems = append(problems, "admin.listen_addr must differ from "+other)
+	}
+	if cfg.Metrics.Enabled && cfg.Metrics.ListenAddr != "" {
+		if other, ok := addrs[cfg.Metrics.ListenAddr]; ok {
+			problems = append(problems, "metrics.listen_addr must differ from "+other)
+		} else if cfg.Metrics.ListenAddr == cfg.Admin.ListenAddr {
+			problems = append(problems, "metrics.listen_addr must differ from admin.listen_addr")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ManagedRelays is the content of admin.relays_file, which the admin API
+// keeps: the relays created through it, merged after every other config
+// part, and the names of the relays it stopped (from any part), which stay
+// stopped across restarts.
+type ManagedRelays struct {
+	// Relays are relay configs as the admin API received them.
+	Relays  []map[string]any `json:"relays"`
+	Stopped []string         `json:"stopped,omitempty"`
+}
+
+// Index returns the position of the relay named name, or -1.
+func (m ManagedRelays) Index(name string) int {
+	return slices.IndexFunc(m.Relays, func(r map[string]any) bool { return r["name"] == name })
+}
+
+// RelaysFilePath resolves admin.relays_file: relative paths are taken from
+// the directory of the main config file, or the working directory when
+// there is none on disk.
+func (s Source) RelaysFilePath(file string) string {
+	if file == "" || filepath.IsAbs(file) || s.Path == "" || IsRemote(s.Path) {
+		return file
+	}
+	return filepath.Join(filepath.Dir(s.Path), file)
+}
+
+// ReadManagedRelays reads a relays file; one that does not exist yet has
+// no relays.
+func ReadManagedRelays(path string) (ManagedRelays, error) {
+	var m ManagedRelays
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, fmt.Errorf("read relays file: %w", err)
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return m, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return m, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
+
+// WriteManagedRelays replaces the relays file at path with m. The file is
+// written next to it first, so a crash leaves the old or the new one.
+func WriteManagedRelays(path string, m ManagedRelays) error {
+	if m.Relays == nil {
+		m.Relays = []map[string]any{}
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	// Destination headers often hold credentials.
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("write relays file: %w", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write relays file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("write relays file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("write relays file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write relays file: %w", err)
+	}
+	return nil
+}
+
+// readRelaysFile reads the relays file at path as a config part holding
+// its relays, checked like any config file, and the names of the stopped
+// relays.
+func readRelaysFile(path string) (configPart, []string, error) {
+	m, err := ReadManagedRelays(path)
+	if err != nil {
+		return configPart{}, nil, err
+	}
+	relays := make([]any, len(m.Relays))
+	for i, r := range m.Relays {
+		relays[i] = r
+	}
+	b, err := json.Marshal(map[string]any{"relays": relays})
+	if err != nil {
+		return configPart{}, nil, err
+	}
+	part, err := parsePart(path, FormatJSON, b)
+	return part, m.Stopped, err
+}
+
+// relaysFileOf returns admin.relays_file of a merged config document.
+func relaysFileOf(doc map[string]any) string {
+	admin, _ := doc["admin"].(map[string]any)
+	file, _ := admin["relays_file"].(string)
+	return file
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+)
+
+// readOverlay reads an overlay file (see Source.Overlays).
+func readOverlay(path string) (configPart, error) {
+	p, err := readPart(path, FormatOf(path))
+	if err != nil {
+		return configPart{}, err
+	}
+	if _, ok := p.doc["include"]; ok {
+		return configPart{}, fmt.Errorf("%s: include is only read from the main config file", path)
+	}
+	return p, nil
+}
+
+// overlayConfig deep-merges the overlay over into base, over winning:
+//   - objects merge key by key, and a null removes the key;
+//   - lists of objects that all have a name (relays, server.listeners, ...)
+//     merge by name, unmatched entries of over being appended;
+//   - other lists of objects merge by position, extra entries of over being
+//     appended;
+//   - anything else in over replaces what base has.
+func overlayConfig(base, over map[string]any) {
+	for k, v := range over {
+		if v == nil {
+			delete(base, k)
+			continue
+		}
+		base[k] = overlayValue(base[k], v)
+	}
+}
+
+func overlayValue(base, over any) any {
+	switch ov := over.(type) {
+	case map[string]any:
+		if bv, ok := base.(map[string]any); ok {
+			overlayConfig(bv, ov)
+			return bv
+		}
+	case []any:
+		if bv, ok := base.([]any); ok && objectList(bv) && objectList(ov) {
+			if named(bv) && named(ov) {
+				return overlayByName(bv, ov)
+			}
+			out := append([]any(nil), bv...)
+			for i, e := range ov {
+				if i < len(out) {
+					out[i] = overlayValue(out[i], e)
+				} else {
+					out = append(out, e)
+				}
+			}
+			return out
+		}
+	}
+	return over
+}
+
+func overlayByName(base, over []any) []any {
+	out := append([]any(nil), base...)
+	index := make(map[any]int, len(out))
+	for i, e := range out {
+		index[e.(map[string]any)["name"]] = i
+	}
+	for _, e := range over {
+		if i, ok := index[e.(map[string]any)["name"]]; ok {
+			out[i] = overlayValue(out[i], e)
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// objectList reports whether l is a non-empty list of objects.
+func objectList(l []any) bool {
+	for _, e := range l {
+		if _, ok := e.(map[string]any); !ok {
+			return false
+		}
+	}
+	return len(l) > 0
+}
+
+// named reports whether every object of l has a string name.
+func named(l []any) bool {
+	for _, e := range l {
+		if n, ok := e.(map[string]any)["name"].(string); !ok || n == "" {
+			return false
+		}
+	}
+	return true
+}
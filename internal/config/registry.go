@@ -0,0 +1,78 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// RegistryTimeout bounds reading the relays of the registry.
+const RegistryTimeout = 30 * time.Second
+
+// registryOf decodes the registry section of a merged config document.
+func registryOf(doc map[string]any) (RegistryConfig, error) {
+	var reg RegistryConfig
+	v, ok := doc["registry"]
+	if !ok || v == nil {
+		return reg, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return reg, err
+	}
+	if err := decodeStrict(b, &reg); err != nil {
+		return reg, fmt.Errorf("parse config: registry: %w", err)
+	}
+	return reg, nil
+}
+
+// readRegistryParts reads the relays of the registry as config parts, one
+// per key, named after it in errors. A relay without a name is named after
+// the last segment of its key. Relays need a listen path: one generated by
+// each instance would differ between them. A key whose value is not such a
+// relay is skipped with a warning, so one bad write does not stop the rest
+// of the registry, or the config files, from loading.
+func readRegistryParts(reg RegistryConfig) (parts []configPart, warnings []string, err error) {
+	c, err := reg.Client()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), RegistryTimeout)
+	defer cancel()
+	entries, _, err := c.List(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read registry: %w", err)
+	}
+	parts = make([]configPart, 0, len(entries))
+	for _, e := range entries {
+		name := fmt.Sprintf("%s key %s", reg.Backend, e.Key)
+		var relay map[string]any
+		dec := json.NewDecoder(bytes.NewReader(e.Value))
+		dec.UseNumber()
+		if err := dec.Decode(&relay); err != nil || relay == nil {
+			warnings = append(warnings, fmt.Sprintf("%s is skipped: the value must be a relay config as a JSON object", name))
+			continue
+		}
+		if _, ok := relay["name"]; !ok {
+			relay["name"] = path.Base(strings.TrimSuffix(e.Key, "/"))
+		}
+		if relay["listen_path"] == nil && relay["listen_path_regex"] == nil {
+			warnings = append(warnings, fmt.Sprintf("%s is skipped: the relay needs a listen_path or listen_path_regex (a generated path would differ on every instance)", name))
+			continue
+		}
+		b, err := json.Marshal(map[string]any{"relays": []any{relay}})
+		if err != nil {
+			return nil, nil, err
+		}
+		part, err := parsePart(name, FormatJSON, b)
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, warnings, nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// RemoteTimeout bounds fetching a remote config.
+const RemoteTimeout = 30 * time.Second
+
+// maxRemoteConfigBytes caps the size of a remote config.
+const maxRemoteConfigBytes = 16 << 20
+
+// IsRemote reports whether a config path is an http:// or https:// URL.
+func IsRemote(configPath string) bool {
+	return strings.HasPrefix(configPath, "http://") || strings.HasPrefix(configPath, "https://")
+}
+
+// RemoteVersion returns what identifies the current version of the remote
+// config: its ETag when the server sends one, a hash of the body otherwise.
+// Polling compares it to notice changes without parsing the config.
+func (s Source) RemoteVersion(ctx context.Context) (string, error) {
+	b, etag, _, err := s.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	if etag != "" {
+		return etag, nil
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readRemotePart fetches the remote main config. Its format is Format, or
+// the one the URL's extension or the Content-Type implies, JSON otherwise.
+func (s Source) readRemotePart() (configPart, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), RemoteTimeout)
+	defer cancel()
+	b, _, contentType, err := s.fetch(ctx)
+	if err != nil {
+		return configPart{}, err
+	}
+	format := s.Format
+	if format == "" {
+		format = remoteFormat(s.Path, contentType)
+	}
+	name := redactURL(s.Path)
+	p, err := parsePart(name, format, b)
+	if err != nil {
+		return configPart{}, err
+	}
+	if _, ok := p.doc["include"]; ok {
+		return configPart{}, fmt.Errorf("%s: include is not supported in a remote config", name)
+	}
+	return p, nil
+}
+
+// fetch gets the remote config with the Source's headers.
+func (s Source) fetch(ctx context.Context) (body []byte, etag, contentType string, err error) {
+	name := redactURL(s.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.Path, nil)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("fetch config %s: %w", name, err)
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error repeats the URL, credentials included.
+		if ue, ok := err.(*url.Error); ok {
+			err = ue.Err
+		}
+		return nil, "", "", fmt.Errorf("fetch config %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("fetch config %s: %s", name, resp.Status)
+	}
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigBytes+1))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("fetch config %s: %w", name, err)
+	}
+	if len(body) > maxRemoteConfigBytes {
+		return nil, "", "", fmt.Errorf("fetch config %s: larger than %d bytes", name, maxRemoteConfigBytes)
+	}
+	return body, resp.Header.Get("ETag"), resp.Header.Get("Content-Type"), nil
+}
+
+// remoteFormat picks the format of a remote config from the extension of
+// its URL path, then from its Content-Type.
+func remoteFormat(rawURL, contentType string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		switch ext := strings.ToLower(path.Ext(strings.TrimSuffix(u.Path, ageSuffix))); ext {
+		case ".json", ".yaml", ".yml", ".toml":
+			return FormatOf(ext)
+		}
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.Contains(mt, "yaml"):
+		return FormatYAML
+	case strings.Contains(mt, "toml"):
+		return FormatTOML
+	}
+	return FormatJSON
+}
+
+// redactURL hides the password of a URL with credentials, for messages.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
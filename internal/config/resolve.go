@@ -5,24 +5,59 @@ import (
 	"crypto/sha256"
 	"encoding/base32"
 	"fmt"
+	"net/http"
 	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ResolvedRelay is the runtime representation of a relay with a concrete listen path.
 type ResolvedRelay struct {
-	ID           string
-	Name         string
-	ListenPath   string
+	ID         string
+	Name       string
+	ListenPath string
+	// PathRegex is set for relays with a listen_path_regex; ListenPath then
+	// holds its source.
+	PathRegex    *regexp.Regexp
 	Methods      []string
 	Destinations []DestinationConfig
+	EventTTL     time.Duration
+	Backfill     bool
+	Redact       RedactConfig
+	MaxBodyBytes int64
+	ContentTypes []string
+	Plugin       PluginConfig
+	Script       ScriptConfig
+	Transform    RelayTransformConfig
+	Routes       []RouteConfig
+	Strategy     string
+	// DetectProvider tags events with their provider and event type.
+	DetectProvider bool
+	// LogSampleRate logs one in this many successful deliveries.
+	LogSampleRate int
+	// ForwardTimeout bounds each delivery unless the destination sets a
+	// timeout; Concurrency, when set, caps deliveries in flight.
+	ForwardTimeout time.Duration
+	Concurrency    int
+	// Disabled relays answer DisabledStatus to every request.
+	Disabled       bool
+	DisabledStatus int
 }
 
 func ResolveRelays(cfg Config) ([]ResolvedRelay, error) {
 	res := make([]ResolvedRelay, 0, len(cfg.Relays))
 	for i, r := range cfg.Relays {
 		lp := strings.TrimSpace(r.ListenPath)
-		if lp == "" {
+		var re *regexp.Regexp
+		if r.ListenPathRegex != "" {
+			var err error
+			if re, err = compilePathRegex(cfg.Server.BasePath, r.ListenPathRegex); err != nil {
+				return nil, fmt.Errorf("relays[%d].listen_path_regex: %w", i, err)
+			}
+			lp = re.String()
+		} else if lp == "" {
 			tok, err := randomToken(16)
 			if err != nil {
 				return nil, fmt.Errorf("generate listen_path for relays[%d]: %w", i, err)
@@ -33,16 +68,243 @@ func ResolveRelays(cfg Config) ([]ResolvedRelay, error) {
 		}
 
 		res = append(res, ResolvedRelay{
-			ID:           relayID(lp),
-			Name:         r.Name,
-			ListenPath:   lp,
-			Methods:      append([]string(nil), r.Methods...),
-			Destinations: append([]DestinationConfig(nil), r.Destinations...),
+			ID:             relayID(lp),
+			Name:           r.Name,
+			ListenPath:     lp,
+			PathRegex:      re,
+			Methods:        append([]string(nil), r.Methods...),
+			Destinations:   append([]DestinationConfig(nil), r.Destinations...),
+			EventTTL:       r.EventTTL(),
+			Backfill:       r.Backfill,
+			Redact:         r.Redact,
+			MaxBodyBytes:   r.MaxBodyBytes,
+			ContentTypes:   r.ContentTypes,
+			Plugin:         r.Plugin,
+			Script:         r.Script,
+			Transform:      r.Transform,
+			Routes:         r.Routes,
+			Strategy:       r.Strategy,
+			DetectProvider: r.DetectProvider,
+			LogSampleRate:  r.LogSampleRate,
+			ForwardTimeout: r.ForwardTimeout(cfg.Server),
+			Concurrency:    r.Concurrency,
+			Disabled:       r.Disabled(),
+			DisabledStatus: r.DisabledStatus,
 		})
 	}
+	if _, err := Routes(res); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
+// WildcardSuffix ends a listen path that matches every path below it, e.g.
+// "/hooks/github/...".
+const WildcardSuffix = "/..."
+
+// Wildcard reports whether r matches a family of paths.
+func (r ResolvedRelay) Wildcard() bool {
+	return r.PathRegex == nil && strings.HasSuffix(r.ListenPath, WildcardSuffix)
+}
+
+// compilePathRegex anchors expr to the whole path below basePath.
+func compilePathRegex(basePath, expr string) (*regexp.Regexp, error) {
+	expr = strings.TrimPrefix(expr, "^")
+	if strings.HasSuffix(expr, "$") && !strings.HasSuffix(expr, `\$`) {
+		expr = strings.TrimSuffix(expr, "$")
+	}
+	return regexp.Compile("^" + regexp.QuoteMeta(basePath) + "(?:" + expr + ")$")
+}
+
+// PathPrefix is the listen path without the wildcard suffix.
+func (r ResolvedRelay) PathPrefix() string {
+	return strings.TrimSuffix(r.ListenPath, WildcardSuffix)
+}
+
+// PathSuffix returns the part of the (escaped) request path p below a
+// wildcard relay's prefix, e.g. "/push" for "/hooks/github/push". It is
+// empty for exact relays.
+func (r ResolvedRelay) PathSuffix(p string) string {
+	if !r.Wildcard() {
+		return ""
+	}
+	// Skip as many segments as the prefix has; they may be parameters.
+	rest := p
+	for range pathSegments(r.PathPrefix()) {
+		rest = strings.TrimPrefix(rest, "/")
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			return ""
+		}
+		rest = rest[i:]
+	}
+	if rest == "/" {
+		return ""
+	}
+	return rest
+}
+
+// ParamNames lists the path parameters of the listen path, e.g. tenant and
+// source for "/hooks/{tenant}/{source}", or the named groups of its regex.
+func (r ResolvedRelay) ParamNames() []string {
+	var names []string
+	if r.PathRegex != nil {
+		for _, name := range r.PathRegex.SubexpNames() {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	for _, seg := range pathSegments(r.PathPrefix()) {
+		if m := pathParam.FindStringSubmatch(seg); m != nil {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// Params returns the values of the relay's path parameters in req, which
+// must have been routed by a mux using Routes (or matched PathRegex).
+func (r ResolvedRelay) Params(req *http.Request) map[string]string {
+	names := r.ParamNames()
+	if len(names) == 0 {
+		return nil
+	}
+	if r.PathRegex != nil {
+		m := r.PathRegex.FindStringSubmatch(req.URL.Path)
+		if m == nil {
+			return nil
+		}
+		params := make(map[string]string, len(names))
+		for i, name := range r.PathRegex.SubexpNames() {
+			if name != "" {
+				params[name] = m[i]
+			}
+		}
+		return params
+	}
+	params := make(map[string]string, len(names))
+	for _, name := range names {
+		params[name] = req.PathValue(name)
+	}
+	return params
+}
+
+// pathParam matches a listen path segment that is a parameter; a trailing
+// "..." captures the rest of the path.
+var pathParam = regexp.MustCompile(`^\{([A-Za-z_][A-Za-z0-9_]*)(\.\.\.)?\}$`)
+
+// pathParamName matches names usable as parameters (and in templates).
+var pathParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func pathSegments(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// Routes returns the ServeMux patterns of each relay, in order. Wildcard
+// relays get their prefix (unless another relay has it as its exact path)
+// and the subtree below it. Regex relays get none: they are tried in order
+// on requests no pattern matched (see RegexRoute). Patterns that are
+// invalid or conflict, such as "/hooks/{a}" next to "/hooks/{b}", are an
+// error.
+func Routes(relays []ResolvedRelay) (routes [][]string, err error) {
+	if err := duplicatePaths(relays); err != nil {
+		return nil, err
+	}
+	exact := map[string]bool{}
+	regex := false
+	for _, r := range relays {
+		switch {
+		case r.PathRegex != nil:
+			regex = true
+		case !r.Wildcard():
+			exact[r.ListenPath] = true
+		}
+	}
+	for _, r := range relays {
+		if r.PathRegex != nil {
+			routes = append(routes, nil)
+			continue
+		}
+		if !r.Wildcard() {
+			routes = append(routes, []string{r.ListenPath})
+			continue
+		}
+		var patterns []string
+		prefix := r.PathPrefix()
+		if prefix != "" && prefix != "/" && !exact[prefix] {
+			patterns = append(patterns, prefix)
+		}
+		routes = append(routes, append(patterns, strings.TrimSuffix(prefix, "/")+"/"))
+	}
+
+	// Registering on a scratch mux is the only complete conflict check.
+	defer func() {
+		if p := recover(); p != nil {
+			msg := registeredAt.ReplaceAllString(fmt.Sprint(p), "")
+			routes, err = nil, fmt.Errorf("listen paths: %s", strings.ReplaceAll(msg, "\n", " "))
+		}
+	}()
+	mux := http.NewServeMux()
+	for _, patterns := range routes {
+		for _, p := range patterns {
+			mux.HandleFunc(p, func(http.ResponseWriter, *http.Request) {})
+		}
+	}
+	if regex {
+		req, _ := http.NewRequest(http.MethodPost, RegexRoute, nil)
+		if _, p := mux.Handler(req); p != "" {
+			return nil, fmt.Errorf("listen paths: relays with listen_path_regex are unreachable because %q matches every path", p)
+		}
+		mux.HandleFunc(RegexRoute, func(http.ResponseWriter, *http.Request) {})
+	}
+	return routes, nil
+}
+
+// duplicatePaths reports every listen path more than one relay uses, which
+// the scratch mux of Routes would stop at one at a time.
+func duplicatePaths(relays []ResolvedRelay) error {
+	users := map[string][]string{}
+	var paths []string
+	for i, r := range relays {
+		if r.PathRegex != nil {
+			continue
+		}
+		if users[r.ListenPath] == nil {
+			paths = append(paths, r.ListenPath)
+		}
+		users[r.ListenPath] = append(users[r.ListenPath], relayLabel(i, r.Name))
+	}
+	var problems Problems
+	for _, p := range paths {
+		if len(users[p]) > 1 {
+			problems = append(problems, fmt.Sprintf("listen path %q is used by %s", p, strings.Join(users[p], " and ")))
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func relayLabel(i int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("relays[%d]", i)
+	}
+	return fmt.Sprintf("relays[%d] (%q)", i, name)
+}
+
+// RegexRoute is the catch-all pattern under which regex relays are served.
+const RegexRoute = "/"
+
+// registeredAt matches the source locations ServeMux puts in its panics.
+var registeredAt = regexp.MustCompile(` \(registered at [^)]*\)`)
+
 func relayID(listenPath string) string {
 	// Stable for the lifetime of the process (because resolved listen paths are stable).
 	// Hash keeps the trace header compact and avoids leaking the listen path.
@@ -52,6 +314,36 @@ func relayID(listenPath string) string {
 	return strings.ToLower(enc.EncodeToString(sum[:]))[:16]
 }
 
+// KeepGeneratedPaths gives the relays of cfg without a listen path the one
+// generated for the same relay of prev (by name, or by position for
+// unnamed relays), which resolved to prevResolved, so reloading the config
+// does not move them.
+func KeepGeneratedPaths(cfg *Config, prev Config, prevResolved []ResolvedRelay) {
+	generated := map[string]string{}
+	key := func(i int, r RelayConfig) string {
+		if r.Name != "" {
+			return "name:" + r.Name
+		}
+		return "index:" + strconv.Itoa(i)
+	}
+	for i, r := range prev.Relays {
+		if strings.TrimSpace(r.ListenPath) == "" && r.ListenPathRegex == "" && i < len(prevResolved) {
+			// The generated path is base_path plus "/<token>".
+			lp := prevResolved[i].ListenPath
+			generated[key(i, r)] = lp[strings.LastIndex(lp, "/"):]
+		}
+	}
+	for i := range cfg.Relays {
+		r := &cfg.Relays[i]
+		if strings.TrimSpace(r.ListenPath) != "" || r.ListenPathRegex != "" {
+			continue
+		}
+		if p, ok := generated[key(i, *r)]; ok {
+			r.ListenPath = p
+		}
+	}
+}
+
 func joinPaths(basePath, listenPath string) string {
 	basePath = strings.TrimSpace(basePath)
 	listenPath = strings.TrimSpace(listenPath)
@@ -84,5 +376,3 @@ func randomToken(nBytes int) (string, error) {
 	// Lower-case for nicer URLs.
 	return strings.ToLower(enc.EncodeToString(b)), nil
 }
-
-
@@ -0,0 +1,176 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"webhookrelay/internal/schema"
+)
+
+//go:generate go run ./schemagen
+
+// schemaJSON is the JSON Schema of the config file, generated from Config.
+//
+//go:embed schema.json
+var schemaJSON []byte
+
+// Schema returns the JSON Schema of the config file, for editors and for
+// checking configs before they are decoded.
+func Schema() []byte {
+	return schemaJSON
+}
+
+var compiledSchema = sync.OnceValues(func() (*schema.Schema, error) {
+	return schema.Compile("webhookrelay-config.schema.json", schemaJSON)
+})
+
+// schemaProblems checks doc, a config file as decoded JSON, against the
+// schema, naming the offending value of each problem by its path, e.g.
+// relays[0].destinations[1].url. Nulls are left out first: the decoder takes
+// them as unset fields.
+func schemaProblems(doc any) Problems {
+	s, err := compiledSchema()
+	if err != nil {
+		return Problems{err.Error()}
+	}
+	var problems Problems
+	seen := map[string]bool{}
+	for _, e := range s.Errors(withoutNulls(doc)) {
+		p := e.Message
+		if _, kw, _ := strings.Cut(e.Keyword, "#"); strings.HasSuffix(kw, "/additionalProperties") {
+			p = unknownFields(e.Message, strings.TrimSuffix(kw, "/additionalProperties"))
+		}
+		if loc := pointerPath(e.Location); loc != "" {
+			p = loc + ": " + p
+		}
+		if !seen[p] {
+			seen[p] = true
+			problems = append(problems, p)
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// schemaDoc is the schema as decoded JSON, for looking up the fields of an
+// object.
+var schemaDoc = sync.OnceValue(func() map[string]any {
+	var doc map[string]any
+	_ = json.Unmarshal(schemaJSON, &doc)
+	return doc
+})
+
+// quoted finds the field names in an additionalProperties message.
+var quoted = regexp.MustCompile(`'([^']*)'`)
+
+// unknownFields rewrites an additionalProperties message about the object
+// at ptr in the schema, suggesting the field each unknown one was likely
+// meant to be.
+func unknownFields(msg, ptr string) string {
+	var known []string
+	node := any(schemaDoc())
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		m, _ := node.(map[string]any)
+		node = m[tok]
+	}
+	if m, ok := node.(map[string]any); ok {
+		props, _ := m["properties"].(map[string]any)
+		for k := range props {
+			known = append(known, k)
+		}
+		sort.Strings(known)
+	}
+	var parts []string
+	for _, sub := range quoted.FindAllStringSubmatch(msg, -1) {
+		p := fmt.Sprintf("unknown field %q", sub[1])
+		if s := closest(sub[1], known); s != "" {
+			p += fmt.Sprintf(" (did you mean %q?)", s)
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return msg
+	}
+	return strings.Join(parts, ", ")
+}
+
+// closest returns the name in names at most two edits away from s, if any.
+func closest(s string, names []string) string {
+	best, bestDist := "", 3
+	for _, n := range names {
+		if d := editDistance(strings.ToLower(s), n); d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// withoutNulls returns a copy of v without the null object members.
+func withoutNulls(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			if e != nil {
+				out[k] = withoutNulls(e)
+			}
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = withoutNulls(e)
+		}
+		return out
+	}
+	return v
+}
+
+// pointerPath turns a JSON pointer such as /relays/0/name into the path
+// validation problems use, relays[0].name. Numbers are taken as list
+// indexes, which is what they are in a config.
+func pointerPath(ptr string) string {
+	if ptr == "" || ptr == "/" {
+		return ""
+	}
+	var b strings.Builder
+	for _, tok := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		tok = strings.NewReplacer("~1", "/", "~0", "~").Replace(tok)
+		if _, err := strconv.Atoi(tok); err == nil {
+			fmt.Fprintf(&b, "[%s]", tok)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+// Schemagen writes schema.json, the JSON Schema of the config file, from the
+// config.Config type and the doc comments of its fields. Run it with
+// "go generate ./internal/config".
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"webhookrelay/internal/config"
+)
+
+func main() {
+	docs, err := fieldDocs(".")
+	if err != nil {
+		log.Fatal(err)
+	}
+	g := generator{docs: docs, defs: map[string]any{}}
+	root := g.object(reflect.TypeOf(config.Config{}))
+	props := root["properties"].(map[string]any)
+	props["$schema"] = map[string]any{
+		"type":        "string",
+		"description": "The JSON Schema editors check this file against; ignored by the relay.",
+	}
+	schema := map[string]any{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"title":   "WebhookRelay config",
+		"$defs":   g.defs,
+	}
+	for k, v := range root {
+		schema[k] = v
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("schema.json", append(b, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// fieldDocs reads the doc comments of the struct fields declared in the Go
+// files of dir, keyed by "Type.Field".
+func fieldDocs(dir string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	docs := map[string]string{}
+	fset := token.NewFileSet()
+	for _, f := range files {
+		if strings.HasSuffix(f, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, f, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok {
+				return false
+			}
+			for _, field := range st.Fields.List {
+				text := field.Doc.Text()
+				if text == "" {
+					text = field.Comment.Text()
+				}
+				for _, name := range field.Names {
+					if text != "" {
+						docs[ts.Name.Name+"."+name.Name] = text
+					}
+				}
+			}
+			return false
+		})
+	}
+	return docs, nil
+}
+
+type generator struct {
+	docs map[string]string
+	defs map[string]any
+}
+
+// schema returns the schema of a field of type t.
+func (g generator) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil // placeholder, for recursive types
+			g.defs[name] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Interface:
+		return map[string]any{}
+	}
+	panic(fmt.Sprintf("schemagen: unsupported type %s", t))
+}
+
+// object returns the schema of struct type t: its JSON fields, and no
+// others, as the config decoder allows.
+func (g generator) object(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := g.schema(f.Type)
+		if doc := description(g.docs[t.Name()+"."+f.Name], f.Name, name); doc != "" {
+			if _, ok := s["$ref"]; ok {
+				// Siblings of $ref are allowed from draft 2019-09 on.
+				s = map[string]any{"$ref": s["$ref"], "description": doc}
+			} else {
+				s["description"] = doc
+			}
+		}
+		props[name] = s
+	}
+	return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+}
+
+// description turns a field's doc comment into one line that names the
+// field as the config file does.
+func description(doc, goName, jsonName string) string {
+	doc = strings.Join(strings.Fields(doc), " ")
+	if rest, ok := strings.CutPrefix(doc, goName+" "); ok {
+		doc = jsonName + " " + rest
+	}
+	return doc
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"net/url"
+	"strings"
+)
+
+// SentryConfig reports dead-lettered deliveries and panics to Sentry.
+// Payloads sent with them have the relay's redact rules applied.
+type SentryConfig struct {
+	DSN         string `json:"dsn,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Release     string `json:"release,omitempty"`
+}
+
+func validateSentry(s *SentryConfig) []string {
+	s.DSN = strings.TrimSpace(s.DSN)
+	if s.DSN == "" {
+		if s.Environment != "" || s.Release != "" {
+			return []string{"sentry.environment and sentry.release need sentry.dsn"}
+		}
+		return nil
+	}
+	u, err := url.Parse(s.DSN)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User == nil || u.User.Username() == "" ||
+		strings.Trim(u.Path, "/") == "" {
+		return []string{"sentry.dsn must look like \"https://<key>@<host>/<project>\""}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+)
+
+// Redacted stands for a credential removed from a config snapshot.
+const Redacted = "[redacted]"
+
+// secretFields hold credentials: tokens, passwords, API and routing keys,
+// and DSNs (which carry a password).
+var secretFields = map[string]bool{
+	"token":             true,
+	"password":          true,
+	"dsn":               true,
+	"api_key":           true,
+	"routing_key":       true,
+	"access_key_id":     true,
+	"secret_access_key": true,
+	"session_token":     true,
+}
+
+// urlFields hold URLs, whose userinfo, path or query may be a credential:
+// Slack, Discord and Teams webhooks carry theirs in the path.
+var urlFields = map[string]bool{
+	"url":         true,
+	"webhook_url": true,
+	"endpoint":    true,
+	"address":     true,
+	"http":        true,
+}
+
+// headerFields hold header values, such as Authorization.
+var headerFields = map[string]bool{
+	"headers":     true,
+	"header_sets": true,
+}
+
+// RedactedJSON returns cfg as JSON with its credentials removed, to be kept
+// where the config itself is not: credential fields and header values are
+// replaced by Redacted, and URLs cut to their scheme and host.
+func RedactedJSON(cfg Config) ([]byte, error) {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactConfig(doc, ""))
+}
+
+// redactConfig redacts v, the value of the field named field.
+func redactConfig(v any, field string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		if headerFields[field] {
+			for k, e := range v {
+				v[k] = redactAll(e)
+			}
+			return v
+		}
+		for k, e := range v {
+			v[k] = redactConfig(e, k)
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = redactConfig(e, field)
+		}
+		return v
+	case string:
+		switch {
+		case v == "":
+			return v
+		case secretFields[field]:
+			return Redacted
+		case urlFields[field]:
+			return urlOrigin(v)
+		}
+	}
+	return v
+}
+
+// redactAll replaces every string in v.
+func redactAll(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = redactAll(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = redactAll(e)
+		}
+	case string:
+		return Redacted
+	}
+	return v
+}
+
+// urlOrigin keeps only the scheme and host of raw.
+func urlOrigin(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return Redacted
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OTLP protocols.
+const (
+	OTLPGRPC = "grpc"
+	OTLPHTTP = "http"
+)
+
+// OTLPConfig points an OTLP exporter at a collector. Fields left empty fall
+// back to the standard OTEL_EXPORTER_OTLP_* environment variables.
+type OTLPConfig struct {
+	Protocol string `json:"protocol,omitempty"`
+	// Endpoint is a URL: "http://collector:4317" for gRPC, or the full path
+	// such as "https://collector:4318/v1/traces" for HTTP. An "http" scheme
+	// disables TLS.
+	Endpoint string            `json:"endpoint,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// TracingConfig exports a span per inbound request and per delivery attempt.
+type TracingConfig struct {
+	Enabled     bool   `json:"enabled,omitempty"`
+	ServiceName string `json:"service_name,omitempty"`
+	// SampleRatio is the fraction of new traces recorded (default 1).
+	// Requests carrying a traceparent follow the caller's sampling decision.
+	SampleRatio *float64   `json:"sample_ratio,omitempty"`
+	OTLP        OTLPConfig `json:"otlp"`
+}
+
+// Metrics exporters.
+const (
+	ExporterPrometheus = "prometheus"
+	ExporterOTLP       = "otlp"
+	ExporterBoth       = "both"
+	ExporterStatsD     = "statsd"
+)
+
+// TelemetryConfig selects where the metrics enabled by MetricsConfig go:
+// scraped by Prometheus, pushed to an OTLP collector over gRPC, both, or
+// sent to a StatsD (DogStatsD) agent.
+type TelemetryConfig struct {
+	MetricsExporter string       `json:"metrics_exporter,omitempty"`
+	ServiceName     string       `json:"service_name,omitempty"`
+	IntervalMS      int          `json:"interval_ms,omitempty"`
+	OTLP            OTLPConfig   `json:"otlp"`
+	StatsD          StatsDConfig `json:"statsd"`
+}
+
+// StatsDConfig points the statsd exporter at an agent over UDP. Tags
+// ("key:value") are added to every metric, DogStatsD style.
+type StatsDConfig struct {
+	Host   string   `json:"host,omitempty"`
+	Port   int      `json:"port,omitempty"`
+	Prefix string   `json:"prefix,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// Addr is the agent's "host:port".
+func (s StatsDConfig) Addr() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// Prometheus reports whether metrics are served for scraping.
+func (t TelemetryConfig) Prometheus() bool {
+	return t.MetricsExporter != ExporterOTLP && t.MetricsExporter != ExporterStatsD
+}
+
+// PushStatsD reports whether metrics are sent to a StatsD agent.
+func (t TelemetryConfig) PushStatsD() bool {
+	return t.MetricsExporter == ExporterStatsD
+}
+
+// PushOTLP reports whether metrics are pushed to a collector.
+func (t TelemetryConfig) PushOTLP() bool {
+	return t.MetricsExporter == ExporterOTLP || t.MetricsExporter == ExporterBoth
+}
+
+func (t TelemetryConfig) Interval() time.Duration {
+	return time.Duration(t.IntervalMS) * time.Millisecond
+}
+
+func validateOTLP(o *OTLPConfig, prefix string) []string {
+	var problems []string
+	o.Protocol = strings.ToLower(strings.TrimSpace(o.Protocol))
+	if o.Protocol == "" {
+		o.Protocol = OTLPGRPC
+	}
+	if o.Protocol != OTLPGRPC && o.Protocol != OTLPHTTP {
+		problems = append(problems, fmt.Sprintf("%s.protocol must be %q or %q (got %q)", prefix, OTLPGRPC, OTLPHTTP, o.Protocol))
+	}
+	o.Endpoint = strings.TrimSpace(o.Endpoint)
+	if o.Endpoint != "" {
+		u, err := url.Parse(o.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("%s.endpoint must be an http(s) URL such as \"http://otel-collector:4317\" (got %q)", prefix, o.Endpoint))
+		}
+	}
+	return problems
+}
+
+func validateTracing(t *TracingConfig) []string {
+	if !t.Enabled {
+		return nil
+	}
+	var problems []string
+	t.ServiceName = strings.TrimSpace(t.ServiceName)
+	if t.ServiceName == "" {
+		t.ServiceName = "webhookrelay"
+	}
+	if t.SampleRatio != nil && (*t.SampleRatio < 0 || *t.SampleRatio > 1) {
+		problems = append(problems, fmt.Sprintf("tracing.sample_ratio must be between 0 and 1 (got %v)", *t.SampleRatio))
+	}
+	return append(problems, validateOTLP(&t.OTLP, "tracing.otlp")...)
+}
+
+func validateTelemetry(t *TelemetryConfig) []string {
+	var problems []string
+	t.MetricsExporter = strings.ToLower(strings.TrimSpace(t.MetricsExporter))
+	switch t.MetricsExporter {
+	case "":
+		t.MetricsExporter = ExporterPrometheus
+	case ExporterPrometheus, ExporterOTLP, ExporterBoth, ExporterStatsD:
+	default:
+		problems = append(problems, fmt.Sprintf("telemetry.metrics_exporter must be %q, %q, %q or %q (got %q)", ExporterPrometheus, ExporterOTLP, ExporterBoth, ExporterStatsD, t.MetricsExporter))
+	}
+	if t.PushStatsD() {
+		return append(problems, validateStatsD(t)...)
+	}
+	if t.StatsD.Host != "" || t.StatsD.Port != 0 || t.StatsD.Prefix != "" || len(t.StatsD.Tags) > 0 {
+		problems = append(problems, fmt.Sprintf("telemetry.statsd is only used with telemetry.metrics_exporter %q", ExporterStatsD))
+	}
+	if !t.PushOTLP() {
+		return problems
+	}
+	t.ServiceName = strings.TrimSpace(t.ServiceName)
+	if t.ServiceName == "" {
+		t.ServiceName = "webhookrelay"
+	}
+	if t.IntervalMS < 0 {
+		problems = append(problems, fmt.Sprintf("telemetry.interval_ms must not be negative (got %d)", t.IntervalMS))
+	} else if t.IntervalMS == 0 {
+		t.IntervalMS = 60_000
+	}
+	problems = append(problems, validateOTLP(&t.OTLP, "telemetry.otlp")...)
+	if t.OTLP.Protocol == OTLPHTTP {
+		problems = append(problems, fmt.Sprintf("telemetry.otlp.protocol must be %q; metrics are only pushed over gRPC", OTLPGRPC))
+	}
+	return problems
+}
+
+func validateStatsD(t *TelemetryConfig) []string {
+	var problems []string
+	s := &t.StatsD
+	if t.IntervalMS < 0 {
+		problems = append(problems, fmt.Sprintf("telemetry.interval_ms must not be negative (got %d)", t.IntervalMS))
+	} else if t.IntervalMS == 0 {
+		t.IntervalMS = 10_000
+	}
+	s.Host = strings.TrimSpace(s.Host)
+	if s.Host == "" {
+		s.Host = "127.0.0.1"
+	}
+	if s.Port == 0 {
+		s.Port = 8125
+	} else if s.Port < 0 || s.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("telemetry.statsd.port must be between 1 and 65535 (got %d)", s.Port))
+	}
+	if s.Prefix == "" {
+		s.Prefix = "webhookrelay."
+	}
+	if strings.ContainsAny(s.Prefix, ":|@# ") {
+		problems = append(problems, fmt.Sprintf("telemetry.statsd.prefix must not contain ':', '|', '@', '#' or spaces (got %q)", s.Prefix))
+	}
+	for i, tag := range s.Tags {
+		if tag == "" || strings.ContainsAny(tag, ",|# ") {
+			problems = append(problems, fmt.Sprintf("telemetry.statsd.tags[%d] must be a non-empty \"key:value\" without ',', '|', '#' or spaces (got %q)", i, tag))
+		}
+	}
+	return problems
+}
+
+// Metric label modes.
+const (
+	MetricLabelName  = "name"
+	MetricLabelURL   = "url"
+	MetricLabelHost  = "host"
+	MetricLabelNone  = "none"
+	MetricLabelClass = "class"
+	MetricLabelCode  = "code"
+)
+
+// MetricLabelsConfig trades detail of the metrics' labels for cardinality.
+type MetricLabelsConfig struct {
+	// Relay is "name" (default) or "none" to leave the relay label out.
+	Relay string `json:"relay,omitempty"`
+	// Destination is "host" (default: scheme and host only), "url" (without
+	// its userinfo and query) or "none".
+	Destination string `json:"destination,omitempty"`
+	// Status is "class" (default: a status_class label, "2xx") or "code"
+	// (a status_code label, "404").
+	Status string `json:"status,omitempty"`
+	// MaxDestinations caps the distinct destination label values (default
+	// 1000, -1 for no cap); destinations seen after that are labeled
+	// "other".
+	MaxDestinations int `json:"max_destinations,omitempty"`
+}
+
+func validateMetricLabels(l *MetricLabelsConfig) []string {
+	var problems []string
+	switch l.Relay {
+	case "":
+		l.Relay = MetricLabelName
+	case MetricLabelName, MetricLabelNone:
+	default:
+		problems = append(problems, fmt.Sprintf("metrics.labels.relay must be %q or %q (got %q)", MetricLabelName, MetricLabelNone, l.Relay))
+	}
+	switch l.Destination {
+	case "":
+		l.Destination = MetricLabelHost
+	case MetricLabelURL, MetricLabelHost, MetricLabelNone:
+	default:
+		problems = append(problems, fmt.Sprintf("metrics.labels.destination must be %q, %q or %q (got %q)", MetricLabelURL, MetricLabelHost, MetricLabelNone, l.Destination))
+	}
+	switch l.Status {
+	case "":
+		l.Status = MetricLabelClass
+	case MetricLabelClass, MetricLabelCode:
+	default:
+		problems = append(problems, fmt.Sprintf("metrics.labels.status must be %q or %q (got %q)", MetricLabelClass, MetricLabelCode, l.Status))
+	}
+	switch {
+	case l.MaxDestinations == 0:
+		l.MaxDestinations = 1000
+	case l.MaxDestinations < -1:
+		problems = append(problems, "metrics.labels.max_destinations must be positive, or -1 for no cap")
+	}
+	return problems
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"encoding/json"
+
+	"github.com/BurntSushi/toml"
+)
+
+// tomlToJSON converts a TOML config into the JSON it stands for, see
+// toJSON. Dates and times become RFC 3339 strings.
+func tomlToJSON(b []byte) ([]byte, error) {
+	var doc map[string]any
+	if _, err := toml.Decode(string(b), &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
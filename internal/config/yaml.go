@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlToJSON converts a YAML config into the JSON it stands for, see
+// toJSON. Anchors and aliases are expanded.
+func yamlToJSON(b []byte) ([]byte, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("empty document")
+		}
+		return nil, err
+	}
+	if err := dec.Decode(new(any)); !errors.Is(err, io.EOF) {
+		return nil, errors.New("extra data after first YAML document")
+	}
+	v, err := jsonValue(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue turns mappings with non-string keys, such as status codes, into
+// ones keyed by strings.
+func jsonValue(v any, path string) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			e, err := jsonValue(e, path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = e
+		}
+		return v, nil
+	case map[any]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			switch k.(type) {
+			case string, int, int64, uint64, float64, bool:
+			default:
+				return nil, fmt.Errorf("%s: unsupported key %v", strings.TrimPrefix(path, "."), k)
+			}
+			ks := fmt.Sprint(k)
+			e, err := jsonValue(e, path+"."+ks)
+			if err != nil {
+				return nil, err
+			}
+			out[ks] = e
+		}
+		return out, nil
+	case []any:
+		for i, e := range v {
+			e, err := jsonValue(e, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = e
+		}
+		return v, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,248 @@
+// Package deliverylog writes every delivery attempt as one JSON line to a
+// file, for log pipelines, apart from the operational log. The file is
+// rotated by size and age; rotated files are optionally gzipped and only the
+// newest are kept. A nil *Writer writes nothing.
+package deliverylog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/store"
+)
+
+type Options struct {
+	Path string
+	// MaxBytes rotates the file before a line would take it past this size.
+	MaxBytes int64
+	// MaxAge rotates the file once it has been open this long (0: never).
+	MaxAge time.Duration
+	// MaxFiles is how many rotated files are kept.
+	MaxFiles int
+	// Compress gzips rotated files.
+	Compress bool
+	Logger   *slog.Logger
+}
+
+type Writer struct {
+	opts Options
+	log  *slog.Logger
+
+	mu       sync.Mutex
+	f        *os.File
+	size     int64
+	openedAt time.Time
+
+	// compressing tracks background compression of rotated files.
+	compressing sync.WaitGroup
+	// housekeeping runs one compression and prune at a time, so that none
+	// sees a file another is still writing or removes one it is reading.
+	housekeeping sync.Mutex
+}
+
+// timeFormat stamps rotated files; it sorts in time order.
+const timeFormat = "20060102T150405.000"
+
+// Open opens (creating if needed) the file at opts.Path for appending.
+func Open(opts Options) (*Writer, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	}
+	if dir := filepath.Dir(opts.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("delivery log: %w", err)
+		}
+	}
+	w := &Writer{opts: opts, log: opts.Logger}
+	if err := w.openLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) openLocked() error {
+	f, err := os.OpenFile(w.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("delivery log: %w", err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("delivery log: %w", err)
+	}
+	w.f, w.size, w.openedAt = f, st.Size(), time.Now()
+	return nil
+}
+
+// line is how a delivery is written: the delivery log entry, plus the
+// record type so pipelines can tell it from other sources.
+type line struct {
+	Type string `json:"type"`
+	store.Delivery
+}
+
+// Write appends d. Errors are logged; the delivery itself is not affected.
+func (w *Writer) Write(d store.Delivery) {
+	if w == nil {
+		return
+	}
+	b, err := json.Marshal(line{Type: "delivery", Delivery: d})
+	if err != nil {
+		w.log.Error("delivery log: encode failed", "error", err)
+		return
+	}
+	b = append(b, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return
+	}
+	if w.size > 0 && (w.size+int64(len(b)) > w.opts.MaxBytes || (w.opts.MaxAge > 0 && time.Since(w.openedAt) >= w.opts.MaxAge)) {
+		if err := w.rotateLocked(); err != nil {
+			w.log.Error("delivery log: rotate failed", "path", w.opts.Path, "error", err)
+			if w.f == nil {
+				return
+			}
+		}
+	}
+	n, err := w.f.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		w.log.Error("delivery log: write failed", "path", w.opts.Path, "error", err)
+	}
+}
+
+// rotateLocked renames the current file aside with a timestamp and starts a
+// new one.
+func (w *Writer) rotateLocked() error {
+	if err := w.f.Close(); err != nil {
+		w.log.Warn("delivery log: close failed", "path", w.opts.Path, "error", err)
+	}
+	w.f = nil
+	rotated := w.rotatedName(time.Now())
+	if err := os.Rename(w.opts.Path, rotated); err != nil {
+		// Keep appending to the current file rather than losing lines.
+		if oerr := w.openLocked(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+	if err := w.openLocked(); err != nil {
+		return err
+	}
+	w.compressing.Add(1)
+	go func() {
+		defer w.compressing.Done()
+		w.housekeeping.Lock()
+		defer w.housekeeping.Unlock()
+		if w.opts.Compress {
+			// A later rotation may have pruned it already.
+			if err := compress(rotated); err != nil && !os.IsNotExist(err) {
+				w.log.Error("delivery log: compress failed", "path", rotated, "error", err)
+			}
+		}
+		w.prune()
+	}()
+	return nil
+}
+
+// rotatedName turns "deliveries.ndjson" into
+// "deliveries-20060102T150405.000.ndjson".
+func (w *Writer) rotatedName(t time.Time) string {
+	ext := filepath.Ext(w.opts.Path)
+	return strings.TrimSuffix(w.opts.Path, ext) + "-" + t.UTC().Format(timeFormat) + ext
+}
+
+// prune removes the oldest rotated files beyond MaxFiles. A file is
+// counted once by its timestamp, also while both it and a gzipped copy left
+// by an interrupted compression exist.
+func (w *Writer) prune() {
+	ext := filepath.Ext(w.opts.Path)
+	prefix := strings.TrimSuffix(w.opts.Path, ext) + "-"
+	matches, err := filepath.Glob(globEscape(prefix) + "*")
+	if err != nil {
+		return
+	}
+	rotated := map[string][]string{} // by timestamp
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(m, prefix), ".gz"), ext)
+		if _, err := time.Parse(timeFormat, stamp); err == nil {
+			rotated[stamp] = append(rotated[stamp], m)
+		}
+	}
+	if len(rotated) <= w.opts.MaxFiles {
+		return
+	}
+	stamps := make([]string, 0, len(rotated))
+	for stamp := range rotated {
+		stamps = append(stamps, stamp)
+	}
+	sort.Strings(stamps)
+	for _, stamp := range stamps[:len(stamps)-w.opts.MaxFiles] {
+		for _, m := range rotated[stamp] {
+			if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+				w.log.Warn("delivery log: remove old file failed", "path", m, "error", err)
+			}
+		}
+	}
+}
+
+func globEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+	return r.Replace(s)
+}
+
+// compress replaces path with path.gz.
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close waits for rotated files to be compressed and closes the file.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	f := w.f
+	w.f = nil
+	w.mu.Unlock()
+	w.compressing.Wait()
+	if f == nil {
+		return nil
+	}
+	return f.Close()
+}
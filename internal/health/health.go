@@ -0,0 +1,338 @@
+// Package health probes destinations that have a health check, so failover
+// can route around an outage before deliveries start failing, and ejects
+// destinations of groups with outlier detection while their deliveries
+// fail. A nil *Checker reports every destination healthy.
+package health
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// Checker runs one probe per distinct health check target, and tracks the
+// deliveries to destinations with outlier detection.
+type Checker struct {
+	log    *slog.Logger
+	client *http.Client
+
+	// mu guards the probes and outliers, which Update replaces, and the
+	// outliers' state.
+	mu           sync.Mutex
+	ctx          context.Context // Run's, once it is running
+	probes       map[string]*probe
+	order        []string
+	outliers     map[string]*outlier   // by outlierKey
+	outlierOrder []string              // config order
+	sets         map[string][]*outlier // by OutlierSet
+}
+
+// Destination is a destination using a probe.
+type Destination struct {
+	Relay string `json:"relay,omitempty"`
+	URL   string `json:"url"`
+}
+
+type probe struct {
+	cfg  config.HealthCheckConfig
+	stop context.CancelFunc // once it runs
+
+	mu        sync.Mutex
+	dests     []Destination
+	healthy   bool
+	since     time.Time
+	lastCheck time.Time
+	lastErr   string
+	successes int
+	failures  int
+}
+
+// New returns a Checker of the destinations of relays.
+func New(relays []config.ResolvedRelay, log *slog.Logger) *Checker {
+	c := &Checker{
+		log: log,
+		// Every probe opens a new connection, so a reused one cannot hide
+		// a destination that stopped accepting them.
+		client: &http.Client{Transport: &http.Transport{DisableKeepAlives: true}},
+	}
+	c.Update(relays)
+	return c
+}
+
+// Update replaces the destinations checked with those of relays, on a
+// reload. Targets and outlier detection references whose settings did not
+// change keep their state; probes of new targets start at once, and those
+// no longer used stop.
+func (c *Checker) Update(relays []config.ResolvedRelay) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	probes, outliers, sets := map[string]*probe{}, map[string]*outlier{}, map[string][]*outlier{}
+	var order, outlierOrder []string
+	dests := map[string][]Destination{}
+	now := time.Now()
+	add := func(relay string, ds []config.DestinationConfig) {
+		for _, d := range ds {
+			dest := Destination{Relay: relay, URL: d.URL}
+			if d.OutlierDetection != nil {
+				// Group references are told apart by where they are in the
+				// config, so each has its own state.
+				key := outlierKey(d)
+				if _, ok := outliers[key]; !ok {
+					o, ok := c.outliers[key]
+					if !ok || o.cfg != *d.OutlierDetection {
+						o = &outlier{cfg: *d.OutlierDetection, set: d.OutlierSet}
+					}
+					o.dest = dest
+					outliers[key] = o
+					outlierOrder = append(outlierOrder, key)
+					sets[d.OutlierSet] = append(sets[d.OutlierSet], o)
+				}
+			}
+			if d.HealthCheck == nil {
+				continue
+			}
+			target := d.HealthCheck.Target()
+			if _, ok := probes[target]; !ok {
+				p, ok := c.probes[target]
+				if !ok || p.cfg != *d.HealthCheck {
+					p = &probe{cfg: *d.HealthCheck, healthy: true, since: now}
+				}
+				probes[target] = p
+				order = append(order, target)
+			}
+			if !slices.Contains(dests[target], dest) {
+				dests[target] = append(dests[target], dest)
+			}
+		}
+	}
+	for _, r := range relays {
+		add(r.Name, r.Destinations)
+		for _, rt := range r.Routes {
+			add(r.Name, rt.Destinations)
+		}
+	}
+	for target, p := range c.probes {
+		if probes[target] != p && p.stop != nil {
+			p.stop()
+		}
+	}
+	for _, target := range order {
+		p := probes[target]
+		p.mu.Lock()
+		p.dests = dests[target]
+		p.mu.Unlock()
+		if p.stop == nil {
+			c.start(target, p)
+		}
+	}
+	c.probes, c.order = probes, order
+	c.outliers, c.outlierOrder, c.sets = outliers, outlierOrder, sets
+}
+
+// Run probes every target at its interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.ctx = ctx
+	for _, target := range c.order {
+		c.start(target, c.probes[target])
+	}
+	c.mu.Unlock()
+	<-ctx.Done()
+}
+
+// start probes target at its interval until Run's context is done or
+// Update stops it; before Run, it does nothing. c.mu is held.
+func (c *Checker) start(target string, p *probe) {
+	if c.ctx == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(c.ctx)
+	p.stop = cancel
+	go func() {
+		t := time.NewTicker(p.cfg.Interval())
+		defer t.Stop()
+		for {
+			c.check(ctx, target, p)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+}
+
+// Healthy reports whether d may receive events: true unless its health
+// check has failed unhealthy_threshold times in a row since it last
+// recovered, or it is ejected as an outlier. Destinations are healthy until
+// their first probe says otherwise.
+func (c *Checker) Healthy(d config.DestinationConfig) bool {
+	return c.admits(d, "", false)
+}
+
+// Admits reports whether d may receive the event with key (its hash key or
+// request ID): when it is healthy and, shortly after an ejection, when key
+// is in the share of events it has taken back.
+func (c *Checker) Admits(d config.DestinationConfig, key string) bool {
+	return c.admits(d, key, true)
+}
+
+func (c *Checker) admits(d config.DestinationConfig, key string, ramp bool) bool {
+	if c == nil || d.HealthCheck == nil && d.OutlierDetection == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.HealthCheck != nil {
+		if p, ok := c.probes[d.HealthCheck.Target()]; ok {
+			p.mu.Lock()
+			healthy := p.healthy
+			p.mu.Unlock()
+			if !healthy {
+				return false
+			}
+		}
+	}
+	o, ok := c.outliers[outlierKey(d)]
+	if d.OutlierDetection == nil || !ok {
+		return true
+	}
+	now := time.Now()
+	if !ramp {
+		return !now.Before(o.ejectedUntil)
+	}
+	return o.admits(key, now)
+}
+
+// Order returns dests with those that do not admit the event with key (see
+// Admits) moved to the end, otherwise keeping their order.
+func (c *Checker) Order(dests []config.DestinationConfig, key string) []config.DestinationConfig {
+	if c == nil || len(dests) < 2 {
+		return dests
+	}
+	out := make([]config.DestinationConfig, 0, len(dests))
+	var down []config.DestinationConfig
+	for _, d := range dests {
+		if c.Admits(d, key) {
+			out = append(out, d)
+		} else {
+			down = append(down, d)
+		}
+	}
+	return append(out, down...)
+}
+
+// Status is the state of one probe.
+type Status struct {
+	Target               string        `json:"target"`
+	Healthy              bool          `json:"healthy"`
+	Since                time.Time     `json:"since"`
+	LastCheckAt          *time.Time    `json:"last_check_at,omitempty"`
+	LastError            string        `json:"last_error,omitempty"`
+	ConsecutiveSuccesses int           `json:"consecutive_successes"`
+	ConsecutiveFailures  int           `json:"consecutive_failures"`
+	IntervalMS           int           `json:"interval_ms"`
+	Destinations         []Destination `json:"destinations"`
+}
+
+// Statuses returns the state of every probe, in config order.
+func (c *Checker) Statuses() []Status {
+	if c == nil {
+		return []Status{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Status, 0, len(c.order))
+	for _, target := range c.order {
+		p := c.probes[target]
+		p.mu.Lock()
+		st := Status{
+			Target:               target,
+			Healthy:              p.healthy,
+			Since:                p.since,
+			LastError:            p.lastErr,
+			ConsecutiveSuccesses: p.successes,
+			ConsecutiveFailures:  p.failures,
+			IntervalMS:           p.cfg.IntervalMS,
+			Destinations:         p.dests,
+		}
+		if !p.lastCheck.IsZero() {
+			at := p.lastCheck
+			st.LastCheckAt = &at
+		}
+		p.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+func (c *Checker) check(ctx context.Context, target string, p *probe) {
+	probeCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout())
+	defer cancel()
+	err := c.probe(probeCtx, p.cfg)
+	if ctx.Err() != nil {
+		// Shutting down, not a failed probe.
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastCheck = time.Now()
+	if err == nil {
+		p.lastErr = ""
+		p.successes++
+		p.failures = 0
+		if !p.healthy && p.successes >= p.cfg.HealthyThreshold {
+			p.healthy, p.since = true, p.lastCheck
+			c.log.Info("health: destination recovered", "target", target)
+		}
+		return
+	}
+	p.lastErr = err.Error()
+	p.failures++
+	p.successes = 0
+	if p.healthy && p.failures >= p.cfg.UnhealthyThreshold {
+		p.healthy, p.since = false, p.lastCheck
+		c.log.Warn("health: destination unhealthy", "target", target, "failures", p.failures, "error", err)
+	}
+}
+
+func (c *Checker) probe(ctx context.Context, h config.HealthCheckConfig) error {
+	if h.TCP != "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", h.TCP)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "webhookrelay-health-check")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
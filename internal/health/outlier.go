@@ -0,0 +1,154 @@
+package health
+
+import (
+	"hash/fnv"
+	"math"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// outlier tracks the deliveries to one destination of a group reference
+// with outlier detection. Its fields are guarded by the Checker's mu.
+type outlier struct {
+	cfg  config.OutlierDetectionConfig
+	set  string
+	dest Destination
+
+	consecutive int
+	windowStart time.Time
+	requests    int
+	errors      int
+	// ejections lengthens each ejection; it drops by one for every
+	// base_ejection_ms the destination stays in.
+	ejections    int
+	ejectedAt    time.Time
+	ejectedUntil time.Time
+	decayedAt    time.Time
+}
+
+func outlierKey(d config.DestinationConfig) string {
+	return d.OutlierSet + " " + d.URL
+}
+
+// admits reports whether o takes the events with key: none while it is
+// ejected, and then a share of the keys growing to all of them over
+// reintroduce_ms. Keys are admitted in the same order each time, so the
+// entities of a hash set move back one by one.
+func (o *outlier) admits(key string, now time.Time) bool {
+	if now.Before(o.ejectedUntil) {
+		return false
+	}
+	since, ramp := now.Sub(o.ejectedUntil), o.cfg.Reintroduce()
+	if o.ejectedUntil.IsZero() || since >= ramp {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(o.dest.URL))
+	return float64(h.Sum64())/float64(math.MaxUint64) < float64(since)/float64(ramp)
+}
+
+// Observe records the result of a delivery to d for its outlier detection;
+// failed is set for connection failures, timeouts and 5xx answers. A
+// destination that fails as often as its outlier_detection allows is
+// ejected, unless too many of its group are out already.
+func (c *Checker) Observe(d config.DestinationConfig, failed bool) {
+	if c == nil || d.OutlierDetection == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	o, ok := c.outliers[outlierKey(d)]
+	if !ok {
+		// Removed by a reload while the delivery was in flight.
+		return
+	}
+	now := time.Now()
+	if now.Before(o.ejectedUntil) {
+		// Sent while the whole group was out.
+		return
+	}
+	if o.ejections > 0 && now.Sub(o.decayedAt) >= o.cfg.Ejection(1) {
+		o.ejections--
+		o.decayedAt = now
+	}
+	if now.Sub(o.windowStart) >= o.cfg.Interval() {
+		o.windowStart, o.requests, o.errors = now, 0, 0
+	}
+	o.requests++
+	if !failed {
+		o.consecutive = 0
+		return
+	}
+	o.errors++
+	o.consecutive++
+	byRate := o.cfg.ErrorRate > 0 && o.requests >= o.cfg.MinRequests && float64(o.errors) >= o.cfg.ErrorRate*float64(o.requests)
+	if o.consecutive < o.cfg.ConsecutiveErrors && !byRate {
+		return
+	}
+	out := 0
+	for _, other := range c.sets[o.set] {
+		if now.Before(other.ejectedUntil) {
+			out++
+		}
+	}
+	if out >= max(1, len(c.sets[o.set])*o.cfg.MaxEjectionPercent/100) {
+		return
+	}
+	o.ejections++
+	o.ejectedAt, o.ejectedUntil = now, now.Add(o.cfg.Ejection(o.ejections))
+	o.decayedAt = o.ejectedUntil
+	o.consecutive, o.requests, o.errors = 0, 0, 0
+	c.log.Warn("health: destination ejected as an outlier", "relay", o.dest.Relay, "url", o.dest.URL, "reference", o.set, "until", o.ejectedUntil, "ejections", o.ejections)
+}
+
+// OutlierStatus is the outlier detection state of one destination.
+type OutlierStatus struct {
+	Relay     string `json:"relay,omitempty"`
+	URL       string `json:"url"`
+	Reference string `json:"reference"`
+	Ejected   bool   `json:"ejected"`
+	// Reintroducing is set while the destination takes back its share of
+	// events after an ejection.
+	Reintroducing     bool       `json:"reintroducing,omitempty"`
+	EjectedAt         *time.Time `json:"ejected_at,omitempty"`
+	EjectedUntil      *time.Time `json:"ejected_until,omitempty"`
+	Ejections         int        `json:"ejections"`
+	ConsecutiveErrors int        `json:"consecutive_errors"`
+	Requests          int        `json:"requests"`
+	Errors            int        `json:"errors"`
+}
+
+// Outliers returns the outlier detection state of every destination that
+// has one, in config order.
+func (c *Checker) Outliers() []OutlierStatus {
+	if c == nil {
+		return []OutlierStatus{}
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]OutlierStatus, 0, len(c.outlierOrder))
+	for _, key := range c.outlierOrder {
+		o := c.outliers[key]
+		st := OutlierStatus{
+			Relay:             o.dest.Relay,
+			URL:               o.dest.URL,
+			Reference:         o.set,
+			Ejected:           now.Before(o.ejectedUntil),
+			Reintroducing:     !o.ejectedUntil.IsZero() && !now.Before(o.ejectedUntil) && now.Sub(o.ejectedUntil) < o.cfg.Reintroduce(),
+			Ejections:         o.ejections,
+			ConsecutiveErrors: o.consecutive,
+			Requests:          o.requests,
+			Errors:            o.errors,
+		}
+		if !o.ejectedAt.IsZero() {
+			at, until := o.ejectedAt, o.ejectedUntil
+			st.EjectedAt, st.EjectedUntil = &at, &until
+		}
+		out = append(out, st)
+	}
+	return out
+}
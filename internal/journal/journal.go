@@ -0,0 +1,383 @@
+// Package journal is an append-only write-ahead log for the intake path.
+// Every accepted event is written as an "accept" record before it is queued
+// and a "commit" record once the queue has it, so events accepted but not yet
+// queued when the process died can be replayed on startup.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/store"
+)
+
+// Fsync policies.
+const (
+	// FsyncAlways syncs every accept record before the event is acknowledged.
+	FsyncAlways = "always"
+	// FsyncInterval syncs in the background every interval; a crash can lose
+	// up to one interval of accepted events.
+	FsyncInterval = "interval"
+	// FsyncNever leaves syncing to the OS; only a process crash (not a host
+	// crash) is survived.
+	FsyncNever = "never"
+)
+
+// Record operations.
+const (
+	OpAccept = "accept"
+	OpCommit = "commit"
+)
+
+// Record is one journal line. Accept records carry the inbound event once and
+// its jobs without their (identical) header and body.
+type Record struct {
+	Op         string      `json:"op"`
+	RequestID  string      `json:"request_id"`
+	Relay      string      `json:"relay,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Method     string      `json:"method,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+	ReceivedAt time.Time   `json:"received_at,omitempty"`
+	Jobs       []store.Job `json:"jobs,omitempty"`
+}
+
+// NewAccept builds the accept record for jobs, which all belong to one event.
+func NewAccept(path string, jobs []store.Job) Record {
+	rec := Record{Op: OpAccept, Path: path}
+	for i, j := range jobs {
+		if i == 0 {
+			rec.RequestID, rec.Relay, rec.Method = j.RequestID, j.Relay, j.Method
+			rec.Header, rec.Body, rec.ReceivedAt = j.Header, j.Body, j.ReceivedAt
+		}
+		j.Header, j.Body = nil, nil
+		rec.Jobs = append(rec.Jobs, j)
+	}
+	return rec
+}
+
+// QueueJobs returns the record's jobs with the event header and body restored.
+func (r Record) QueueJobs() []store.Job {
+	jobs := make([]store.Job, 0, len(r.Jobs))
+	for _, j := range r.Jobs {
+		j.Header, j.Body = r.Header, r.Body
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+type Options struct {
+	Path     string
+	Fsync    string
+	Interval time.Duration
+	// MaxBytes triggers compaction (rewriting only uncommitted records) once
+	// the file grows past it.
+	MaxBytes int64
+	Logger   *slog.Logger
+}
+
+type Journal struct {
+	opts Options
+	log  *slog.Logger
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	dirty   bool
+	pending map[string]Record
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens (creating if needed) the journal at opts.Path. It returns the
+// accept records that were never committed; the caller should re-queue them
+// and Commit each one.
+func Open(opts Options) (*Journal, []Record, error) {
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	}
+	j := &Journal{
+		opts:    opts,
+		log:     opts.Logger,
+		pending: make(map[string]Record),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	recs, bad, err := readFile(opts.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if bad > 0 {
+		j.log.Warn("journal: skipped lines that are not complete records", "path", opts.Path, "lines", bad)
+	}
+	var pending []Record
+	for _, r := range recs {
+		switch r.Op {
+		case OpAccept:
+			j.pending[r.RequestID] = r
+		case OpCommit:
+			delete(j.pending, r.RequestID)
+		}
+	}
+	for _, r := range recs {
+		if _, ok := j.pending[r.RequestID]; ok && r.Op == OpAccept {
+			pending = append(pending, r)
+		}
+	}
+
+	j.mu.Lock()
+	err = j.compactLocked()
+	j.mu.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Fsync == FsyncInterval {
+		go j.syncLoop()
+	} else {
+		close(j.done)
+	}
+	return j, pending, nil
+}
+
+// ReadFile reads every complete record in a journal file. Lines that are
+// not one, such as a torn final line from a crash mid-write or a corrupt
+// line, are skipped.
+func ReadFile(path string) ([]Record, error) {
+	recs, _, err := readFile(path)
+	return recs, err
+}
+
+// readFile is ReadFile, also returning the number of lines skipped.
+func readFile(path string) (recs []Record, bad int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<30)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			bad++
+			continue
+		}
+		recs = append(recs, r)
+	}
+	return recs, bad, sc.Err()
+}
+
+// Accept durably (per the fsync policy) records an event before it is queued.
+func (j *Journal) Accept(rec Record) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.appendLocked(rec); err != nil {
+		return err
+	}
+	j.pending[rec.RequestID] = rec
+	if j.opts.Fsync == FsyncAlways {
+		return j.f.Sync()
+	}
+	return nil
+}
+
+// Commit marks an accepted event as safely queued. Commit records are not
+// synced: losing one only means the event is queued again after a crash.
+func (j *Journal) Commit(requestID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.appendLocked(Record{Op: OpCommit, RequestID: requestID}); err != nil {
+		return err
+	}
+	delete(j.pending, requestID)
+
+	if j.opts.MaxBytes > 0 && j.size > j.opts.MaxBytes {
+		return j.compactLocked()
+	}
+	return nil
+}
+
+func (j *Journal) appendLocked(rec Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	n, err := j.f.Write(b)
+	j.size += int64(n)
+	j.dirty = true
+	if err != nil {
+		return fmt.Errorf("journal write: %w", err)
+	}
+	return nil
+}
+
+// compactLocked rewrites the journal with only the uncommitted accept records
+// and swaps it in atomically.
+func (j *Journal) compactLocked() error {
+	tmp := j.opts.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("journal compact: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, r := range j.pending {
+		b, err := json.Marshal(r)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		n, _ := w.Write(append(b, '\n'))
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("journal compact: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("journal compact: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("journal compact: %w", err)
+	}
+	if err := os.Rename(tmp, j.opts.Path); err != nil {
+		return fmt.Errorf("journal compact: %w", err)
+	}
+	syncDir(filepath.Dir(j.opts.Path))
+
+	if j.f != nil {
+		_ = j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.opts.Path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("journal reopen: %w", err)
+	}
+	j.size, j.dirty = size, false
+	return nil
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
+
+func (j *Journal) syncLoop() {
+	defer close(j.done)
+	t := time.NewTicker(j.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-t.C:
+			j.mu.Lock()
+			if j.dirty {
+				if err := j.f.Sync(); err != nil {
+					j.log.Error("journal: fsync failed", "error", err)
+				}
+				j.dirty = false
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// Close syncs and closes the journal.
+func (j *Journal) Close() error {
+	close(j.stop)
+	<-j.done
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.f.Sync(); err != nil {
+		_ = j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+// CheckResult summarizes a journal file.
+type CheckResult struct {
+	Records int
+	Pending int
+	// Corrupt counts the lines before the end that are not a record; each
+	// loses only itself.
+	Corrupt int
+	// TornBytes is the size of the trailing data that is not a complete
+	// record, usually left by a crash mid-write.
+	TornBytes int64
+}
+
+// Check reads a journal file without modifying it.
+func Check(path string) (CheckResult, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	var (
+		res     CheckResult
+		pending = make(map[string]bool)
+		off     int
+	)
+	for off < len(b) {
+		end := bytes.IndexByte(b[off:], '\n')
+		if end < 0 {
+			break
+		}
+		line := bytes.TrimSpace(b[off : off+end])
+		off += end + 1
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		if json.Unmarshal(line, &r) != nil {
+			res.Corrupt++
+			continue
+		}
+		res.Records++
+		pending[r.RequestID] = r.Op == OpAccept
+	}
+	for _, p := range pending {
+		if p {
+			res.Pending++
+		}
+	}
+	res.TornBytes = int64(len(b) - off)
+	return res, nil
+}
+
+// Repair rewrites a journal file keeping only complete, uncommitted records.
+// Corrupt lines and a torn tail are dropped; the records around them are
+// kept.
+func Repair(path string) (CheckResult, error) {
+	j, _, err := Open(Options{Path: path, Fsync: FsyncNever})
+	if err != nil {
+		return CheckResult{}, err
+	}
+	if err := j.Close(); err != nil {
+		return CheckResult{}, err
+	}
+	return Check(path)
+}
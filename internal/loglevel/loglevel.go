@@ -0,0 +1,126 @@
+// Package loglevel holds the level of the operational log, which the admin
+// API can change at runtime, for good or for a while. A nil *Level cannot be
+// changed.
+package loglevel
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknown is returned by Parse for anything but the four level names.
+var ErrUnknown = errors.New("level must be debug, info, warn or error")
+
+type Level struct {
+	v   slog.LevelVar
+	log *slog.Logger
+
+	mu    sync.Mutex
+	base  slog.Level
+	until time.Time
+	timer *time.Timer
+}
+
+// NewJSON returns a JSON logger writing to w at the returned level, which
+// starts at base.
+func NewJSON(w io.Writer, base slog.Level) (*slog.Logger, *Level) {
+	l := &Level{base: base}
+	l.v.Set(base)
+	l.log = slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: &l.v}))
+	return l.log, l
+}
+
+// Parse reads "debug", "info", "warn" (or "warning") or "error", in any
+// case.
+func Parse(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, ErrUnknown
+}
+
+// SetBase changes the level the log returns to after a temporary change,
+// and the current level unless a temporary change is in effect.
+func (l *Level) SetBase(level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.base = level
+	if l.timer == nil {
+		l.v.Set(level)
+	}
+}
+
+// Set changes the level for d, or until Reset with d = 0, and returns when
+// it ends (zero for d = 0).
+func (l *Level) Set(level slog.Level, d time.Duration) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopLocked()
+	l.v.Set(level)
+	if d <= 0 {
+		l.base = level
+		return time.Time{}
+	}
+	l.until = time.Now().Add(d)
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.timer != t {
+			return
+		}
+		l.timer, l.until = nil, time.Time{}
+		l.v.Set(l.base)
+		l.log.Info("log: level restored", "level", l.base.String())
+	})
+	l.timer = t
+	return l.until
+}
+
+// Reset ends a temporary change now.
+func (l *Level) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopLocked()
+	l.v.Set(l.base)
+}
+
+func (l *Level) stopLocked() {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer, l.until = nil, time.Time{}
+	}
+}
+
+// State is the current level and, during a temporary change, the level it
+// returns to and when.
+type State struct {
+	Level string     `json:"level"`
+	Base  string     `json:"base"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+func (l *Level) State() State {
+	if l == nil {
+		return State{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := State{Level: l.v.Level().String(), Base: l.base.String()}
+	if !l.until.IsZero() {
+		until := l.until
+		st.Until = &until
+	}
+	return st
+}
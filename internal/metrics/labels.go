@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"webhookrelay/internal/config"
+)
+
+// otherDestination labels destinations past labels.max_destinations.
+const otherDestination = "other"
+
+// labeler applies metrics.labels: it leaves out the relay and destination
+// labels when asked to, shortens destinations to their host or strips the
+// credentials a URL may carry in its userinfo and query, caps how many
+// distinct ones are seen, and turns a "status" into a status_class or
+// status_code label.
+type labeler struct {
+	cfg config.MetricLabelsConfig
+
+	mu    sync.Mutex
+	dests map[string]bool
+}
+
+// newLabeler takes cfg as validated; its zero value keeps every label, with
+// no cap.
+func newLabeler(cfg config.MetricLabelsConfig) *labeler {
+	return &labeler{cfg: cfg, dests: map[string]bool{}}
+}
+
+// names returns the label names a metric gets out of names.
+func (l *labeler) names(names ...string) []string {
+	out := make([]string, 0, len(names))
+	for _, n := range names {
+		if n, ok := l.name(n); ok {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// dropped reports whether the label n is left out.
+func (l *labeler) dropped(n string) bool {
+	_, ok := l.name(n)
+	return !ok
+}
+
+func (l *labeler) name(n string) (string, bool) {
+	switch {
+	case n == "relay" && l.cfg.Relay == config.MetricLabelNone,
+		n == "destination" && l.cfg.Destination == config.MetricLabelNone:
+		return "", false
+	case n == "status" && l.cfg.Status == config.MetricLabelCode:
+		return "status_code", true
+	case n == "status":
+		return "status_class", true
+	}
+	return n, true
+}
+
+// pairs turns alternating label names and values, as passed to names,
+// into those of the metric: "status" takes the status code as a string.
+func (l *labeler) pairs(kv ...string) []string {
+	out := make([]string, 0, len(kv))
+	for i := 0; i+1 < len(kv); i += 2 {
+		n, ok := l.name(kv[i])
+		if !ok {
+			continue
+		}
+		v := kv[i+1]
+		switch kv[i] {
+		case "destination":
+			v = l.destination(v)
+		case "status":
+			code, _ := strconv.Atoi(v)
+			if l.cfg.Status == config.MetricLabelCode {
+				if code == 0 {
+					v = "none"
+				}
+			} else {
+				v = StatusClass(code)
+			}
+		}
+		out = append(out, n, v)
+	}
+	return out
+}
+
+func (l *labeler) destination(dest string) string {
+	if u, err := url.Parse(dest); err == nil && u.Host != "" {
+		if l.cfg.Destination == config.MetricLabelHost {
+			dest = u.Scheme + "://" + u.Host
+		} else {
+			u.User, u.RawQuery, u.Fragment = nil, "", ""
+			dest = u.String()
+		}
+	}
+	if l.cfg.MaxDestinations <= 0 {
+		return dest
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dests[dest] {
+		if len(l.dests) >= l.cfg.MaxDestinations {
+			return otherDestination
+		}
+		l.dests[dest] = true
+	}
+	return dest
+}
+
+// promLabels turns pairs into Prometheus labels.
+func promLabels(kv []string) prometheus.Labels {
+	labels := make(prometheus.Labels, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		labels[kv[i]] = kv[i+1]
+	}
+	return labels
+}
@@ -0,0 +1,235 @@
+// Package metrics exposes relay activity as Prometheus metrics, which can
+// also be pushed to an OTLP collector or sent to a StatsD agent. A nil
+// *Metrics records nothing, so callers need not check whether metrics are
+// enabled.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/store"
+)
+
+// Inbound results.
+const (
+	ResultAccepted = "accepted"
+	ResultDropped  = "dropped"
+	ResultRejected = "rejected"
+)
+
+// Metrics holds the relay's collectors in their own registry.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	inbound         *prometheus.CounterVec
+	inboundDuration *prometheus.HistogramVec
+	inboundInFlight prometheus.Gauge
+	forwards        *prometheus.CounterVec
+	forwardDuration *prometheus.HistogramVec
+	forwardInFlight prometheus.Gauge
+	deadLetters     *prometheus.CounterVec
+	slowForwards    *prometheus.CounterVec
+	slowDests       *prometheus.GaugeVec
+
+	labels *labeler
+	statsd *statsd
+	// exemplars attaches trace IDs to the forward duration histogram.
+	exemplars bool
+}
+
+// New registers the relay's metrics, plus Go runtime and process metrics,
+// with the labels that labels asks for. queueLen reports the queue depth at
+// scrape time.
+func New(queueLen func(context.Context) (int, error), labels config.MetricLabelsConfig) *Metrics {
+	l := newLabeler(labels)
+	m := &Metrics{
+		reg:    prometheus.NewRegistry(),
+		labels: l,
+		inbound: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhookrelay_inbound_requests_total",
+			Help: "Inbound requests by relay, result (accepted, dropped, rejected) and response code.",
+		}, l.names("relay", "result", "code")),
+		inboundDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "webhookrelay_inbound_request_duration_seconds",
+			Help:    "Time to answer inbound requests, including reading the body and enqueueing.",
+			Buckets: prometheus.DefBuckets,
+		}, l.names("relay")),
+		inboundInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhookrelay_inbound_in_flight",
+			Help: "Inbound requests being handled.",
+		}),
+		forwards: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhookrelay_forward_attempts_total",
+			Help: "Delivery attempts by relay, destination, outcome, status class (or code) and detected provider.",
+		}, l.names("relay", "destination", "outcome", "status", "provider")),
+		forwardDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "webhookrelay_forward_duration_seconds",
+			Help:    "Duration of delivery attempts by relay and destination.",
+			Buckets: prometheus.DefBuckets,
+		}, l.names("relay", "destination")),
+		forwardInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "webhookrelay_forward_in_flight",
+			Help: "Delivery attempts in progress.",
+		}),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhookrelay_dead_letters_total",
+			Help: "Jobs moved to the dead-letter queue by relay and reason.",
+		}, l.names("relay", "reason")),
+		slowForwards: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webhookrelay_slow_forwards_total",
+			Help: "Delivery attempts that took server.slow_forward.threshold_ms or longer, by relay and destination.",
+		}, l.names("relay", "destination")),
+		slowDests: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "webhookrelay_destination_slow",
+			Help: "1 while a destination is marked slow, by destination.",
+		}, l.names("destination")),
+	}
+	m.reg.MustRegister(
+		m.inbound, m.inboundDuration, m.inboundInFlight,
+		m.forwards, m.forwardDuration, m.forwardInFlight,
+		m.deadLetters, m.slowForwards,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "webhookrelay_queue_depth",
+			Help: "Jobs waiting in the delivery queue.",
+		}, func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			n, err := queueLen(ctx)
+			if err != nil {
+				return -1
+			}
+			return float64(n)
+		}),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	// Without destinations, the slow destination gauge would mean nothing.
+	if !l.dropped("destination") {
+		m.reg.MustRegister(m.slowDests)
+	}
+	return m
+}
+
+// Registry returns the registry the metrics are in.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.reg
+}
+
+// Handler serves the metrics in the Prometheus text format, or in the
+// OpenMetrics format to scrapers asking for it when exemplars are on.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{EnableOpenMetrics: m.exemplars})
+}
+
+// EnableExemplars attaches the trace ID of each sampled delivery span to
+// its forward duration observation, so a latency spike leads to traces of
+// the slow deliveries. Call it before Handler, with tracing set up.
+func (m *Metrics) EnableExemplars() {
+	if m != nil {
+		m.exemplars = true
+	}
+}
+
+// InboundStarted counts a request as in flight until the returned function
+// is called with its result.
+func (m *Metrics) InboundStarted(relay string) func(result string, code int) {
+	if m == nil {
+		return func(string, int) {}
+	}
+	start := time.Now()
+	m.inboundInFlight.Inc()
+	return func(result string, code int) {
+		m.inboundInFlight.Dec()
+		kv := m.labels.pairs("relay", relay, "result", result, "code", strconv.Itoa(code))
+		m.inbound.With(promLabels(kv)).Inc()
+		rkv := m.labels.pairs("relay", relay)
+		m.inboundDuration.With(promLabels(rkv)).Observe(time.Since(start).Seconds())
+		m.statsd.count("inbound.requests", kv...)
+		m.statsd.timing("inbound.duration", time.Since(start), rkv...)
+	}
+}
+
+// ForwardStarted counts a delivery attempt as in flight until the returned
+// function is called with the recorded delivery. ctx carries the delivery's
+// span, for exemplars.
+func (m *Metrics) ForwardStarted(ctx context.Context) func(d store.Delivery) {
+	if m == nil {
+		return func(store.Delivery) {}
+	}
+	start := time.Now()
+	m.forwardInFlight.Inc()
+	return func(d store.Delivery) {
+		m.forwardInFlight.Dec()
+		kv := m.labels.pairs("relay", d.Relay, "destination", d.DestURL, "outcome", d.Outcome, "status", strconv.Itoa(d.Status), "provider", d.Provider)
+		m.forwards.With(promLabels(kv)).Inc()
+		dkv := m.labels.pairs("relay", d.Relay, "destination", d.DestURL)
+		observe(ctx, m.forwardDuration.With(promLabels(dkv)), time.Since(start).Seconds(), m.exemplars)
+		m.statsd.count("forward.attempts", kv...)
+		m.statsd.timing("forward.duration", time.Since(start), dkv...)
+	}
+}
+
+// DeadLettered counts a job moved to the DLQ.
+func (m *Metrics) DeadLettered(relay, reason string) {
+	if m == nil {
+		return
+	}
+	kv := m.labels.pairs("relay", relay, "reason", reason)
+	m.deadLetters.With(promLabels(kv)).Inc()
+	m.statsd.count("dead_letters", kv...)
+}
+
+// SlowForward counts a delivery attempt over the slow threshold.
+func (m *Metrics) SlowForward(relay, dest string) {
+	if m == nil {
+		return
+	}
+	kv := m.labels.pairs("relay", relay, "destination", dest)
+	m.slowForwards.With(promLabels(kv)).Inc()
+	m.statsd.count("forward.slow", kv...)
+}
+
+// SetDestinationSlow records whether dest is marked slow.
+func (m *Metrics) SetDestinationSlow(dest string, slow bool) {
+	if m == nil || m.labels.dropped("destination") {
+		return
+	}
+	v := 0.0
+	if slow {
+		v = 1
+	}
+	kv := m.labels.pairs("destination", dest)
+	m.slowDests.With(promLabels(kv)).Set(v)
+	m.statsd.send("destination.slow", strconv.FormatFloat(v, 'f', -1, 64), "g", kv...)
+}
+
+// observe records v, with the trace and span IDs of ctx as an exemplar if
+// exemplars is set and the span is sampled (and so exported).
+func observe(ctx context.Context, o prometheus.Observer, v float64, exemplars bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	eo, ok := o.(prometheus.ExemplarObserver)
+	if !exemplars || !ok || !sc.IsValid() || !sc.IsSampled() {
+		o.Observe(v)
+		return
+	}
+	eo.ObserveWithExemplar(v, prometheus.Labels{"trace_id": sc.TraceID().String(), "span_id": sc.SpanID().String()})
+}
+
+// StatusClass is "2xx" to "5xx" for an HTTP status, or "none" when no
+// response was received.
+func StatusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "none"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
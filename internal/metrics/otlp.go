@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	otelprom "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+
+	"webhookrelay/internal/config"
+)
+
+// PushOTLP periodically pushes the metrics served by Handler to an OTLP
+// collector over gRPC. The returned function pushes once more and stops.
+func (m *Metrics) PushOTLP(ctx context.Context, cfg config.TelemetryConfig) (shutdown func(context.Context) error, err error) {
+	var opts []otlpmetricgrpc.Option
+	if cfg.OTLP.Endpoint != "" {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(cfg.OTLP.Endpoint))
+	}
+	if len(cfg.OTLP.Headers) > 0 {
+		opts = append(opts, otlpmetricgrpc.WithHeaders(cfg.OTLP.Headers))
+	}
+	exp, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+	reader := sdkmetric.NewPeriodicReader(exp,
+		sdkmetric.WithInterval(cfg.Interval()),
+		sdkmetric.WithProducer(otelprom.NewMetricProducer(otelprom.WithGatherer(m.reg))),
+	)
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithResource(res))
+	return mp.Shutdown, nil
+}
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// statsdPacket caps the payload of one UDP packet; metrics are batched up
+// to this size.
+const statsdPacket = 1432
+
+// statsdFlush is how long a metric waits for its packet to fill.
+const statsdFlush = time.Second
+
+// statsd sends metrics to a StatsD agent in the DogStatsD line format,
+// with the labels of the Prometheus metrics as tags.
+type statsd struct {
+	conn   net.Conn
+	prefix string
+	tags   string // the constant tags, "k:v,k:v"
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// PushStatsD sends the relay's metrics to the StatsD agent of cfg: counters
+// and timers as they are recorded, gauges every interval. Go runtime and
+// process metrics are left to the agent. The returned function sends what
+// is buffered and stops.
+func (m *Metrics) PushStatsD(ctx context.Context, cfg config.TelemetryConfig) (shutdown func(context.Context) error, err error) {
+	conn, err := net.Dial("udp", cfg.StatsD.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %w", err)
+	}
+	s := &statsd{conn: conn, prefix: cfg.StatsD.Prefix, tags: strings.Join(cfg.StatsD.Tags, ",")}
+	m.statsd = s
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		flush := time.NewTicker(statsdFlush)
+		defer flush.Stop()
+		gauges := time.NewTicker(cfg.Interval())
+		defer gauges.Stop()
+		m.sendGauges()
+		for {
+			select {
+			case <-ctx.Done():
+				m.sendGauges()
+				s.flush()
+				return
+			case <-flush.C:
+				s.flush()
+			case <-gauges.C:
+				m.sendGauges()
+			}
+		}
+	}()
+	return func(context.Context) error {
+		cancel()
+		<-done
+		return conn.Close()
+	}, nil
+}
+
+// statsdGauges maps the Prometheus gauges to their statsd names.
+var statsdGauges = map[string]string{
+	"webhookrelay_inbound_in_flight": "inbound.in_flight",
+	"webhookrelay_forward_in_flight": "forward.in_flight",
+	"webhookrelay_queue_depth":       "queue.depth",
+}
+
+func (m *Metrics) sendGauges() {
+	families, err := m.reg.Gather()
+	if err != nil && len(families) == 0 {
+		return
+	}
+	for _, f := range families {
+		name, ok := statsdGauges[f.GetName()]
+		if !ok || len(f.GetMetric()) == 0 {
+			continue
+		}
+		m.statsd.send(name, strconv.FormatFloat(f.GetMetric()[0].GetGauge().GetValue(), 'f', -1, 64), "g")
+	}
+}
+
+func (s *statsd) count(name string, tags ...string) {
+	s.send(name, "1", "c", tags...)
+}
+
+func (s *statsd) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64), "ms", tags...)
+}
+
+// send queues "prefix.name:value|type|#tags"; tags alternate keys and
+// values, and empty values are left out.
+func (s *statsd) send(name, value, typ string, tags ...string) {
+	if s == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(typ)
+	sep := "|#"
+	if s.tags != "" {
+		b.WriteString(sep + s.tags)
+		sep = ","
+	}
+	for i := 0; i+1 < len(tags); i += 2 {
+		if tags[i+1] == "" {
+			continue
+		}
+		b.WriteString(sep + tags[i] + ":" + tagValue(tags[i+1]))
+		sep = ","
+	}
+	line := b.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > statsdPacket {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+func (s *statsd) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+// flushLocked sends the buffered lines. StatsD is fire and forget: a
+// missing agent loses metrics, not deliveries.
+func (s *statsd) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	_, _ = s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// tagValue replaces the characters that end a tag or a line.
+var tagValue = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_").Replace
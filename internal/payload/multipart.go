@@ -0,0 +1,155 @@
+package payload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strconv"
+)
+
+// FormatMultipart is multipart/form-data. It is only detected from the
+// Content-Type, which carries the boundary.
+const FormatMultipart = "multipart"
+
+// Attachment keys. A file part decodes to an object with these keys, and an
+// object with a filename is encoded back as a file part.
+const (
+	AttachmentFilename    = "filename"
+	AttachmentContentType = "content_type"
+	AttachmentSize        = "size"
+	AttachmentContent     = "content_base64"
+)
+
+// decodeMultipart decodes a multipart/form-data body: text fields become
+// strings and file parts attachment objects, both turned into arrays when a
+// name repeats.
+func decodeMultipart(boundary string, body []byte) (any, error) {
+	if boundary == "" {
+		return nil, errors.New("decode multipart: missing boundary")
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	obj := map[string]any{}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return obj, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode multipart: %w", err)
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("decode multipart: %w", err)
+		}
+
+		var v any = string(data)
+		if part.FileName() != "" {
+			ct := part.Header.Get("Content-Type")
+			if ct == "" {
+				ct = "application/octet-stream"
+			}
+			v = map[string]any{
+				AttachmentFilename:    part.FileName(),
+				AttachmentContentType: ct,
+				AttachmentSize:        json.Number(strconv.Itoa(len(data))),
+				AttachmentContent:     base64.StdEncoding.EncodeToString(data),
+			}
+		}
+
+		name := part.FormName()
+		switch prev := obj[name].(type) {
+		case nil:
+			obj[name] = v
+		case []any:
+			obj[name] = append(prev, v)
+		default:
+			obj[name] = []any{prev, v}
+		}
+	}
+}
+
+// IsAttachment reports whether v is an attachment object.
+func IsAttachment(v any) bool {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return false
+	}
+	_, ok = m[AttachmentFilename].(string)
+	return ok
+}
+
+// EncodeMultipart encodes an object as multipart/form-data and returns the
+// body with its Content-Type (which carries the new boundary). Attachments
+// without content are skipped.
+func EncodeMultipart(v any) ([]byte, string, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, "", errors.New("encode multipart: body must be an object")
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, k := range keys {
+		items, ok := obj[k].([]any)
+		if !ok {
+			items = []any{obj[k]}
+		}
+		for _, item := range items {
+			if err := writePart(mw, k, item); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), mime.FormatMediaType("multipart/form-data", map[string]string{"boundary": mw.Boundary()}), nil
+}
+
+func writePart(mw *multipart.Writer, name string, v any) error {
+	if IsAttachment(v) {
+		m := v.(map[string]any)
+		content, ok := m[AttachmentContent].(string)
+		if !ok {
+			return nil
+		}
+		data, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			return fmt.Errorf("encode multipart: %s: %w", name, err)
+		}
+		ct, _ := m[AttachmentContentType].(string)
+		if ct == "" {
+			ct = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": name, "filename": m[AttachmentFilename].(string)}))
+		h.Set("Content-Type", ct)
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	s, ok := Scalar(v)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		s = string(b)
+	}
+	return mw.WriteField(name, s)
+}
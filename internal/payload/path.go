@@ -0,0 +1,103 @@
+package payload
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SplitPath splits a dotted path such as "$.user.emails.0" into segments.
+// Numeric segments index arrays.
+func SplitPath(p string) ([]string, error) {
+	p = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(p), "$"), ".")
+	if p == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	segs := strings.Split(p, ".")
+	for _, s := range segs {
+		if s == "" {
+			return nil, fmt.Errorf("path %q has an empty segment", p)
+		}
+	}
+	return segs, nil
+}
+
+// Get returns the value at segs.
+func Get(v any, segs []string) (any, bool) {
+	for _, s := range segs {
+		switch t := v.(type) {
+		case map[string]any:
+			child, ok := t[s]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(s)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			v = t[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Set stores val at segs, creating intermediate objects as needed, and
+// returns the (possibly replaced) root.
+func Set(v any, segs []string, val any) (any, error) {
+	if len(segs) == 0 {
+		return val, nil
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		child, err := Set(t[segs[0]], segs[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		t[segs[0]] = child
+		return t, nil
+	case []any:
+		i, err := strconv.Atoi(segs[0])
+		if err != nil || i < 0 || i >= len(t) {
+			return nil, fmt.Errorf("index %q out of range", segs[0])
+		}
+		child, err := Set(t[i], segs[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		t[i] = child
+		return t, nil
+	case nil:
+		child, err := Set(nil, segs[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{segs[0]: child}, nil
+	}
+	return nil, fmt.Errorf("cannot set %q inside a scalar", segs[0])
+}
+
+// Delete removes the object key or array element at segs.
+func Delete(v any, segs []string) any {
+	if len(segs) == 0 {
+		return v
+	}
+	parent, ok := Get(v, segs[:len(segs)-1])
+	if !ok {
+		return v
+	}
+	last := segs[len(segs)-1]
+	switch t := parent.(type) {
+	case map[string]any:
+		delete(t, last)
+	case []any:
+		if i, err := strconv.Atoi(last); err == nil && i >= 0 && i < len(t) {
+			// Arrays keep their length so later indexes stay valid.
+			t[i] = nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,272 @@
+// Package payload decodes inbound bodies into a common intermediate
+// representation keyed on Content-Type, so enrichment, transforms, templates
+// and encoders work the same whether the sender posted JSON, a form or XML.
+//
+// The representation is what encoding/json produces with UseNumber:
+// map[string]any, []any, string, json.Number, bool and nil.
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats.
+const (
+	FormatJSON = "json"
+	FormatForm = "form"
+	FormatXML  = "xml"
+	// FormatRaw is an opaque body; its value is the body as a string.
+	FormatRaw = "raw"
+)
+
+// Formats lists the formats a body can be decoded from.
+var Formats = []string{FormatJSON, FormatForm, FormatXML, FormatRaw}
+
+// FormatOf maps a Content-Type onto a format. Unknown or missing types are
+// raw.
+func FormatOf(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return FormatRaw
+	}
+	switch {
+	case mt == "multipart/form-data":
+		return FormatMultipart
+	case mt == "application/json" || strings.HasSuffix(mt, "+json"):
+		return FormatJSON
+	case mt == "application/x-www-form-urlencoded":
+		return FormatForm
+	case mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml"):
+		return FormatXML
+	}
+	return FormatRaw
+}
+
+// ContentType is the Content-Type sent for a body encoded as format.
+func ContentType(format string) string {
+	switch format {
+	case FormatJSON:
+		return "application/json"
+	case FormatForm:
+		return "application/x-www-form-urlencoded"
+	case FormatXML:
+		return "application/xml; charset=utf-8"
+	case FormatProtobuf:
+		return "application/x-protobuf"
+	}
+	return ""
+}
+
+// Decode parses body according to contentType (multipart bodies need it
+// for their boundary). Bodies whose type says
+// nothing about their format are still decoded as JSON when they are valid
+// JSON, since many senders omit or misstate the type.
+func Decode(contentType string, body []byte) (any, string, error) {
+	format := FormatOf(contentType)
+	if format == FormatRaw && json.Valid(body) {
+		format = FormatJSON
+	}
+	if format == FormatMultipart {
+		_, params, _ := mime.ParseMediaType(contentType)
+		v, err := decodeMultipart(params["boundary"], body)
+		return v, format, err
+	}
+	v, err := DecodeAs(format, body)
+	return v, format, err
+}
+
+// DecodeAs parses body as format.
+func DecodeAs(format string, body []byte) (any, error) {
+	switch format {
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("decode json: %w", err)
+		}
+		return v, nil
+	case FormatForm:
+		q, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("decode form: %w", err)
+		}
+		obj := make(map[string]any, len(q))
+		for k, vs := range q {
+			if len(vs) == 1 {
+				obj[k] = vs[0]
+				continue
+			}
+			items := make([]any, len(vs))
+			for i, s := range vs {
+				items[i] = s
+			}
+			obj[k] = items
+		}
+		return obj, nil
+	case FormatXML:
+		return decodeXML(body)
+	case FormatRaw:
+		return string(body), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// XMLName is the key under which a decoded XML document keeps the name of
+// its document element, so that it can be encoded as XML again.
+const XMLName = "#name"
+
+// decodeXML returns the content of the document element: attributes become
+// "@name" keys, repeated child elements become arrays, elements holding only
+// text become strings and text next to children is kept under "#text". The
+// document element's name is kept under XMLName. Names keep their namespace
+// prefix ("soap:Body"), and namespace declarations are attributes like any
+// other ("@xmlns", "@xmlns:soap").
+func decodeXML(body []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		// Raw tokens keep prefixes as written rather than resolving them.
+		tok, err := dec.RawToken()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("no document element")
+			}
+			return nil, fmt.Errorf("decode xml: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := xmlElement(dec, start)
+			if err != nil {
+				return nil, fmt.Errorf("decode xml: %w", err)
+			}
+			if obj, ok := v.(map[string]any); ok {
+				obj[XMLName] = xmlQName(start.Name)
+			}
+			return v, nil
+		}
+	}
+}
+
+// xmlQName is a raw token's name as written, with its prefix.
+func xmlQName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+func xmlElement(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	obj := map[string]any{}
+	for _, a := range start.Attr {
+		obj["@"+xmlQName(a.Name)] = a.Value
+	}
+	var text strings.Builder
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := xmlElement(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			// Children are maps or strings, never arrays, so an array here
+			// always means a repeated element.
+			name := xmlQName(t.Name)
+			switch prev := obj[name].(type) {
+			case nil:
+				obj[name] = child
+			case []any:
+				obj[name] = append(prev, child)
+			default:
+				obj[name] = []any{prev, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			// RawToken does not match them up.
+			if t.Name != start.Name {
+				return nil, fmt.Errorf("element <%s> closed by </%s>", xmlQName(start.Name), xmlQName(t.Name))
+			}
+			s := strings.TrimSpace(text.String())
+			if len(obj) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				obj["#text"] = s
+			}
+			return obj, nil
+		}
+	}
+}
+
+// EncodeJSON encodes v as JSON.
+func EncodeJSON(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// EncodeForm encodes a flat object as application/x-www-form-urlencoded.
+// Arrays of scalars become repeated keys.
+func EncodeForm(v any) ([]byte, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("encode form: body must be an object")
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	q := url.Values{}
+	for _, k := range keys {
+		switch t := obj[k].(type) {
+		case []any:
+			for _, item := range t {
+				s, ok := Scalar(item)
+				if !ok {
+					return nil, fmt.Errorf("encode form: %s holds a nested value", k)
+				}
+				q.Add(k, s)
+			}
+		default:
+			s, ok := Scalar(t)
+			if !ok {
+				return nil, fmt.Errorf("encode form: %s holds a nested value", k)
+			}
+			q.Add(k, s)
+		}
+	}
+	return []byte(q.Encode()), nil
+}
+
+// Scalar renders a scalar value as text. ok is false for objects and arrays.
+func Scalar(v any) (string, bool) {
+	switch t := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(t), true
+	}
+	return "", false
+}
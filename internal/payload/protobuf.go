@@ -0,0 +1,83 @@
+package payload
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// FormatProtobuf is an output format only; bodies are never decoded from
+// it.
+const FormatProtobuf = "protobuf"
+
+// protoSets caches descriptor sets by path, with the modification time and
+// size of the file they were read from, so that an edited file is read
+// again.
+var protoSets sync.Map // of *protoSet
+
+type protoSet struct {
+	mod   time.Time
+	size  int64
+	files *protoregistry.Files
+}
+
+// ProtoMessage returns the message named name (fully qualified, e.g.
+// "acme.events.v1.OrderCreated") from the compiled descriptor set at path,
+// as written by protoc --include_imports --descriptor_set_out.
+func ProtoMessage(path, name string) (protoreflect.MessageDescriptor, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	ps, ok := protoSets.Load(path)
+	if !ok || !ps.(*protoSet).mod.Equal(fi.ModTime()) || ps.(*protoSet).size != fi.Size() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(b, &set); err != nil {
+			return nil, fmt.Errorf("%s: not a descriptor set: %w", path, err)
+		}
+		files, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ps = &protoSet{mod: fi.ModTime(), size: fi.Size(), files: files}
+		protoSets.Store(path, ps)
+	}
+	d, err := ps.(*protoSet).files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: message %q: %w", path, name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s: %q is not a message", path, name)
+	}
+	return md, nil
+}
+
+// EncodeProtobuf maps v onto md using the protobuf JSON mapping (field
+// names as in the .proto or their lowerCamelCase JSON names) and returns the
+// binary encoding. Fields md does not have are an error unless
+// discardUnknown is set.
+func EncodeProtobuf(v any, md protoreflect.MessageDescriptor, discardUnknown bool) ([]byte, error) {
+	j, err := EncodeJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: discardUnknown}).Unmarshal(j, msg); err != nil {
+		return nil, fmt.Errorf("encode protobuf %s: %w", md.FullName(), err)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
@@ -0,0 +1,184 @@
+// Package plugin runs user-supplied WebAssembly modules (via wazero) over
+// inbound events, so custom transforms and filters do not need a fork.
+//
+// ABI: the module exports
+//
+//	webhookrelay_alloc(size i32) i32
+//	webhookrelay_transform(ptr i32, len i32) i64
+//
+// The relay allocates a buffer with webhookrelay_alloc, writes the JSON
+// encoded Input into it and calls webhookrelay_transform, which returns the
+// location of its JSON encoded Output packed as ptr<<32 | len. Each call gets
+// a fresh instance; WASI is available without filesystem or network access.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"webhookrelay/internal/config"
+)
+
+// Exported function names.
+const (
+	FuncAlloc     = "webhookrelay_alloc"
+	FuncTransform = "webhookrelay_transform"
+)
+
+// Input is what a plugin receives. Body is base64 in JSON.
+type Input struct {
+	Relay     string `json:"relay"`
+	RequestID string `json:"request_id"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Query     string `json:"query,omitempty"`
+	// Params are the relay's path parameters.
+	Params  map[string]string `json:"params,omitempty"`
+	Headers http.Header       `json:"headers"`
+	Body    []byte            `json:"body"`
+}
+
+// Output is what a plugin returns. Nil Headers or Body leave the inbound
+// ones unchanged.
+type Output struct {
+	Drop    bool        `json:"drop,omitempty"`
+	Reason  string      `json:"reason,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+// WASM is a compiled plugin module.
+type WASM struct {
+	path     string
+	timeout  time.Duration
+	rt       wazero.Runtime
+	compiled wazero.CompiledModule
+	// cache, if set, holds the compiling engine, which outlives rt.
+	cache wazero.CompilationCache
+}
+
+// Load compiles the module at cfg.Path. The memory limit and timeout bound
+// every call; a call that runs past the timeout is aborted.
+func Load(ctx context.Context, cfg config.PluginConfig) (*WASM, error) {
+	code, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("plugin: %w", err)
+	}
+	rc := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(cfg.MemoryLimitMB) * 16). // 64 KiB pages
+		WithCloseOnContextDone(true)
+	w := &WASM{path: cfg.Path, timeout: cfg.Timeout()}
+	if cfg.CacheDir != "" {
+		// wazero keys cached code by module hash and its own version, so a
+		// changed module or an upgrade compiles afresh.
+		if w.cache, err = wazero.NewCompilationCacheWithDir(filepath.Join(cfg.CacheDir, "wasm")); err != nil {
+			return nil, fmt.Errorf("plugin %s: compile cache: %w", cfg.Path, err)
+		}
+		rc = rc.WithCompilationCache(w.cache)
+	}
+	w.rt = wazero.NewRuntimeWithConfig(ctx, rc)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, w.rt); err != nil {
+		_ = w.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %w", cfg.Path, err)
+	}
+	if w.compiled, err = w.rt.CompileModule(ctx, code); err != nil {
+		_ = w.Close(ctx)
+		return nil, fmt.Errorf("plugin %s: %w", cfg.Path, err)
+	}
+	for _, name := range []string{FuncAlloc, FuncTransform} {
+		if _, ok := w.compiled.ExportedFunctions()[name]; !ok {
+			_ = w.Close(ctx)
+			return nil, fmt.Errorf("plugin %s: missing export %s", cfg.Path, name)
+		}
+	}
+	return w, nil
+}
+
+// Run calls the plugin for one event.
+func (w *WASM) Run(ctx context.Context, in Input) (Output, error) {
+	var out Output
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	mod, err := w.rt.InstantiateModule(ctx, w.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithStdout(io.Discard).
+		WithStderr(io.Discard))
+	if err != nil {
+		return out, w.err(ctx, err)
+	}
+	defer mod.Close(context.Background())
+
+	req, err := json.Marshal(in)
+	if err != nil {
+		return out, err
+	}
+	res, err := mod.ExportedFunction(FuncAlloc).Call(ctx, uint64(len(req)))
+	if err != nil {
+		return out, w.err(ctx, err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, req) {
+		return out, fmt.Errorf("plugin %s: alloc returned an out of range buffer", w.path)
+	}
+
+	res, err = mod.ExportedFunction(FuncTransform).Call(ctx, uint64(ptr), uint64(len(req)))
+	if err != nil {
+		return out, w.err(ctx, err)
+	}
+	optr, olen := uint32(res[0]>>32), uint32(res[0])
+	b, ok := mod.Memory().Read(optr, olen)
+	if !ok {
+		return out, fmt.Errorf("plugin %s: transform returned an out of range result", w.path)
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return out, fmt.Errorf("plugin %s: decode output: %w", w.path, err)
+	}
+	return out, nil
+}
+
+func (w *WASM) err(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("plugin %s: timed out after %s", w.path, w.timeout)
+	}
+	return fmt.Errorf("plugin %s: %w", w.path, err)
+}
+
+// Close releases the runtime.
+func (w *WASM) Close(ctx context.Context) error {
+	err := w.rt.Close(ctx)
+	if w.cache != nil {
+		err = errors.Join(err, w.cache.Close(ctx))
+	}
+	return err
+}
+
+// LoadAll loads the plugin of every relay that has one, keyed by relay ID.
+func LoadAll(ctx context.Context, relays []config.ResolvedRelay) (map[string]*WASM, error) {
+	out := map[string]*WASM{}
+	for _, r := range relays {
+		if r.Plugin.Path == "" {
+			continue
+		}
+		w, err := Load(ctx, r.Plugin)
+		if err != nil {
+			for _, loaded := range out {
+				_ = loaded.Close(ctx)
+			}
+			return nil, err
+		}
+		out[r.ID] = w
+	}
+	return out, nil
+}
@@ -0,0 +1,117 @@
+// Package provider recognizes webhooks from well-known senders by their
+// headers and extracts the event type they carry.
+package provider
+
+import (
+	"context"
+	"net/http"
+
+	"webhookrelay/internal/payload"
+)
+
+// Info identifies the sender of an event. Both fields are empty when the
+// sender was not recognized; EventType may be empty on its own when the
+// provider does not say.
+type Info struct {
+	Provider  string `json:"provider,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+}
+
+// rule recognizes a provider by the presence of Header. The event type is
+// read from EventHeader or, failing that, from the body field EventField.
+type rule struct {
+	Provider    string
+	Header      string
+	EventHeader string
+	EventField  []string
+}
+
+// rules are tried in order. Gitea and Gogs also send GitHub's headers, so
+// they come first.
+var rules = []rule{
+	{Provider: "gitea", Header: "X-Gitea-Event", EventHeader: "X-Gitea-Event"},
+	{Provider: "gogs", Header: "X-Gogs-Event", EventHeader: "X-Gogs-Event"},
+	{Provider: "github", Header: "X-GitHub-Event", EventHeader: "X-GitHub-Event"},
+	{Provider: "gitlab", Header: "X-Gitlab-Event", EventHeader: "X-Gitlab-Event"},
+	{Provider: "bitbucket", Header: "X-Hook-UUID", EventHeader: "X-Event-Key"},
+	{Provider: "stripe", Header: "Stripe-Signature", EventField: []string{"type"}},
+	{Provider: "shopify", Header: "X-Shopify-Topic", EventHeader: "X-Shopify-Topic"},
+	{Provider: "slack", Header: "X-Slack-Signature", EventField: []string{"event.type", "type"}},
+	{Provider: "twilio", Header: "X-Twilio-Signature", EventField: []string{"EventType"}},
+	{Provider: "paddle", Header: "Paddle-Signature", EventField: []string{"event_type"}},
+	{Provider: "linear", Header: "Linear-Event", EventHeader: "Linear-Event"},
+	{Provider: "pagerduty", Header: "X-PagerDuty-Signature", EventField: []string{"event.event_type"}},
+	{Provider: "sentry", Header: "Sentry-Hook-Resource", EventHeader: "Sentry-Hook-Resource"},
+	{Provider: "square", Header: "X-Square-Hmacsha256-Signature", EventField: []string{"type"}},
+	{Provider: "jira", Header: "X-Atlassian-Webhook-Identifier", EventField: []string{"webhookEvent"}},
+	{Provider: "circleci", Header: "Circleci-Event-Type", EventHeader: "Circleci-Event-Type"},
+	{Provider: "typeform", Header: "Typeform-Signature", EventField: []string{"event_type"}},
+	{Provider: "zoom", Header: "X-Zm-Signature", EventField: []string{"event"}},
+	// Svix delivers for many services (Clerk, Resend, ...); it is checked
+	// last so that providers with their own headers win.
+	{Provider: "svix", Header: "Svix-Id", EventField: []string{"type"}},
+}
+
+// Names lists the providers Detect recognizes.
+func Names() []string {
+	out := make([]string, len(rules))
+	for i, r := range rules {
+		out[i] = r.Provider
+	}
+	return out
+}
+
+// Detect identifies the provider of an event from its headers. The body is
+// only decoded for providers that carry the event type in it.
+func Detect(h http.Header, body []byte) Info {
+	for _, r := range rules {
+		if h.Get(r.Header) == "" {
+			continue
+		}
+		info := Info{Provider: r.Provider}
+		if r.EventHeader != "" {
+			info.EventType = h.Get(r.EventHeader)
+		} else {
+			info.EventType = bodyField(h.Get("Content-Type"), body, r.EventField)
+		}
+		return info
+	}
+	return Info{}
+}
+
+// bodyField returns the first of fields that is a string in body.
+func bodyField(contentType string, body []byte, fields []string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	v, _, err := payload.Decode(contentType, body)
+	if err != nil {
+		return ""
+	}
+	for _, f := range fields {
+		segs, err := payload.SplitPath(f)
+		if err != nil {
+			continue
+		}
+		if s, ok := payload.Get(v, segs); ok {
+			if s, ok := s.(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying info, for the forwarder to tag the
+// event's deliveries with.
+func NewContext(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the Info stored in ctx by NewContext, if any.
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
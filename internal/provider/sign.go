@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers returns the headers provider sends with an event of eventType,
+// enough for Detect to recognize it. With a secret, they include the
+// signature the provider computes over body, for receivers that verify it.
+// Providers without a known signing scheme cannot take a secret.
+func Headers(provider, eventType, secret string, body []byte, now time.Time) (http.Header, error) {
+	var r *rule
+	for i := range rules {
+		if rules[i].Provider == provider {
+			r = &rules[i]
+		}
+	}
+	if r == nil {
+		return nil, fmt.Errorf("unknown provider %q (known: %s)", provider, strings.Join(Names(), ", "))
+	}
+	h := http.Header{}
+	if r.EventHeader != "" {
+		h.Set(r.EventHeader, eventType)
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	id := randomID()
+
+	switch provider {
+	case "github", "gitea", "gogs":
+		h.Set("X-GitHub-Delivery", id)
+		if secret != "" {
+			h.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac([]byte(secret), body)))
+		}
+	case "gitlab":
+		if secret != "" {
+			h.Set("X-Gitlab-Token", secret)
+		}
+	case "stripe":
+		h.Set("Stripe-Signature", "t="+ts)
+		if secret != "" {
+			h.Set("Stripe-Signature", "t="+ts+",v1="+hex.EncodeToString(mac([]byte(secret), []byte(ts+"."), body)))
+		}
+	case "slack":
+		h.Set("X-Slack-Request-Timestamp", ts)
+		h.Set("X-Slack-Signature", "v0=")
+		if secret != "" {
+			h.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac([]byte(secret), []byte("v0:"+ts+":"), body)))
+		}
+	case "shopify":
+		h.Set("X-Shopify-Webhook-Id", id)
+		if secret != "" {
+			h.Set("X-Shopify-Hmac-Sha256", base64.StdEncoding.EncodeToString(mac([]byte(secret), body)))
+		}
+	case "linear":
+		if secret != "" {
+			h.Set("Linear-Signature", hex.EncodeToString(mac([]byte(secret), body)))
+		}
+	case "svix":
+		id = "msg_" + id
+		h.Set("Svix-Id", id)
+		h.Set("Svix-Timestamp", ts)
+		if secret != "" {
+			key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+			if err != nil {
+				return nil, fmt.Errorf("svix secret must be base64, optionally prefixed with whsec_: %w", err)
+			}
+			h.Set("Svix-Signature", "v1,"+base64.StdEncoding.EncodeToString(mac(key, []byte(id+"."+ts+"."), body)))
+		}
+	default:
+		if secret != "" {
+			return nil, fmt.Errorf("signing is not supported for provider %q", provider)
+		}
+	}
+	if h.Get(r.Header) == "" {
+		// Providers identified by their signature header still need it
+		// present to be recognized.
+		h.Set(r.Header, id)
+	}
+	return h, nil
+}
+
+// SignedProviders lists the providers Headers can sign for.
+var SignedProviders = []string{"github", "gitea", "gogs", "gitlab", "stripe", "slack", "shopify", "linear", "svix"}
+
+func mac(key []byte, parts ...[]byte) []byte {
+	m := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		m.Write(p)
+	}
+	return m.Sum(nil)
+}
+
+func randomID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
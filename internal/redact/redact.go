@@ -0,0 +1,229 @@
+// Package redact masks or strips sensitive fields from JSON payloads and
+// headers before they are forwarded, persisted or logged.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"webhookrelay/internal/config"
+)
+
+// DefaultMask replaces masked values when no mask is configured.
+const DefaultMask = "[REDACTED]"
+
+// Enabled reports whether cfg redacts anything.
+func Enabled(cfg config.RedactConfig) bool {
+	return len(cfg.Fields) > 0 || len(cfg.Paths) > 0 || len(cfg.Headers) > 0
+}
+
+// Applies reports whether Body can apply the field rules of cfg to body:
+// there are none, or body is JSON.
+func Applies(body []byte, cfg config.RedactConfig) bool {
+	return len(cfg.Fields) == 0 && len(cfg.Paths) == 0 || json.Valid(body)
+}
+
+// Body applies cfg to a JSON body. Bodies that are not JSON are returned
+// unchanged since there are no fields to find in them.
+func Body(body []byte, cfg config.RedactConfig) []byte {
+	if len(cfg.Fields) == 0 && len(cfg.Paths) == 0 {
+		return body
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+
+	r := redactor{cfg: cfg, mask: cfg.Mask}
+	if r.mask == "" {
+		r.mask = DefaultMask
+	}
+	v = r.fields(v)
+	for _, p := range cfg.Paths {
+		segs, err := ParsePath(p)
+		if err != nil {
+			continue
+		}
+		v = r.path(v, segs)
+	}
+
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// Header returns a copy of h without the headers listed in cfg.Headers.
+func Header(h http.Header, cfg config.RedactConfig) http.Header {
+	if len(cfg.Headers) == 0 {
+		return h
+	}
+	out := h.Clone()
+	for _, k := range cfg.Headers {
+		out.Del(k)
+	}
+	return out
+}
+
+// Query applies cfg.Fields to the parameter names of a raw query string.
+func Query(rawQuery string, cfg config.RedactConfig) string {
+	if len(cfg.Fields) == 0 || rawQuery == "" {
+		return rawQuery
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	r := redactor{cfg: cfg, mask: cfg.Mask}
+	if r.mask == "" {
+		r.mask = DefaultMask
+	}
+	changed := false
+	for k, vs := range q {
+		if !r.matchField(k) {
+			continue
+		}
+		changed = true
+		if r.remove() {
+			delete(q, k)
+			continue
+		}
+		for i := range vs {
+			vs[i] = r.mask
+		}
+	}
+	if !changed {
+		return rawQuery
+	}
+	return q.Encode()
+}
+
+type redactor struct {
+	cfg  config.RedactConfig
+	mask string
+}
+
+func (r redactor) remove() bool { return r.cfg.Action == config.RedactRemove }
+
+// fields redacts every object key matching one of the field patterns, at any depth.
+func (r redactor) fields(v any) any {
+	if len(r.cfg.Fields) == 0 {
+		return v
+	}
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if r.matchField(k) {
+				if r.remove() {
+					delete(t, k)
+				} else {
+					t[k] = r.mask
+				}
+				continue
+			}
+			t[k] = r.fields(child)
+		}
+	case []any:
+		for i := range t {
+			t[i] = r.fields(t[i])
+		}
+	}
+	return v
+}
+
+func (r redactor) matchField(key string) bool {
+	key = strings.ToLower(key)
+	for _, pat := range r.cfg.Fields {
+		if ok, _ := path.Match(strings.ToLower(pat), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// path redacts the value(s) addressed by segs.
+func (r redactor) path(v any, segs []string) any {
+	if len(segs) == 0 {
+		return v
+	}
+	seg, last := segs[0], len(segs) == 1
+
+	switch t := v.(type) {
+	case map[string]any:
+		keys := []string{seg}
+		if seg == "*" {
+			keys = keys[:0]
+			for k := range t {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			child, ok := t[k]
+			if !ok {
+				continue
+			}
+			switch {
+			case !last:
+				t[k] = r.path(child, segs[1:])
+			case r.remove():
+				delete(t, k)
+			default:
+				t[k] = r.mask
+			}
+		}
+	case []any:
+		var idx []int
+		if seg == "*" {
+			for i := range t {
+				idx = append(idx, i)
+			}
+		} else if i, err := strconv.Atoi(seg); err == nil && i >= 0 && i < len(t) {
+			idx = append(idx, i)
+		}
+		if last && r.remove() {
+			// Removing array elements would shift positions; blank them instead.
+			for _, i := range idx {
+				t[i] = nil
+			}
+			return t
+		}
+		for _, i := range idx {
+			if last {
+				t[i] = r.mask
+			} else {
+				t[i] = r.path(t[i], segs[1:])
+			}
+		}
+	}
+	return v
+}
+
+// ParsePath splits a JSONPath subset ($.a.b, $.items[*].email, $.list[0],
+// a.b) into segments; "*" matches every key or index.
+func ParsePath(p string) ([]string, error) {
+	p = strings.TrimSpace(p)
+	p = strings.TrimPrefix(p, "$")
+	p = strings.TrimPrefix(p, ".")
+	if p == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	p = strings.ReplaceAll(p, "[", ".")
+	p = strings.ReplaceAll(p, "]", "")
+
+	segs := strings.Split(p, ".")
+	for _, s := range segs {
+		if s == "" {
+			return nil, fmt.Errorf("invalid path %q", p)
+		}
+	}
+	return segs, nil
+}
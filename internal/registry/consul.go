@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// consul reads the Consul KV store; Wait is a blocking query.
+type consul struct {
+	base   string
+	prefix string
+	token  string
+	hc     *http.Client
+}
+
+func (c *consul) get(ctx context.Context, index string) ([]byte, string, error) {
+	q := url.Values{"recurse": {"true"}}
+	if index != "" {
+		q.Set("index", index)
+		q.Set("wait", fmt.Sprintf("%ds", int(waitTimeout.Seconds())))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/v1/kv/"+c.prefix+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	// No keys under the prefix is a 404.
+	b, h, _, err := do(c.hc, req, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, h.Get("X-Consul-Index"), nil
+}
+
+func (c *consul) List(ctx context.Context) ([]Entry, string, error) {
+	b, index, err := c.get(ctx, "")
+	if err != nil || b == nil {
+		return nil, index, err
+	}
+	var pairs []struct {
+		Key   string
+		Value []byte // base64 in JSON; null for folders
+	}
+	if err := json.Unmarshal(b, &pairs); err != nil {
+		return nil, "", fmt.Errorf("registry: %w", err)
+	}
+	var out []Entry
+	for _, p := range pairs {
+		if strings.HasSuffix(p.Key, "/") || len(p.Value) == 0 {
+			continue
+		}
+		out = append(out, Entry{Key: p.Key, Value: p.Value})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, index, nil
+}
+
+func (c *consul) Wait(ctx context.Context, version string) error {
+	if version == "" {
+		// Without an index to wait from, there is nothing to block on.
+		version = "1"
+	}
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout+waitTimeout/16)
+	defer cancel()
+	_, _, err := c.get(ctx, version)
+	return err
+}
@@ -0,0 +1,163 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// etcd reads etcd through its v3 JSON gateway; Wait is a watch on the
+// prefix.
+type etcd struct {
+	base               string
+	prefix             string
+	username, password string
+	hc                 *http.Client
+}
+
+// rangeEnd is the end of the key range of a prefix query.
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Every byte is 0xff: to the end of the keyspace.
+	return []byte{0}
+}
+
+// post sends a JSON request to the gateway, authenticated when a user is
+// set.
+func (e *etcd) post(ctx context.Context, path string, body any) (*http.Request, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.base+path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.username != "" {
+		token, err := e.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", token)
+	}
+	return req, nil
+}
+
+// authenticate gets a token for the user. Tokens expire, so one is taken
+// per request rather than kept.
+func (e *etcd) authenticate(ctx context.Context) (string, error) {
+	b, _ := json.Marshal(map[string]string{"name": e.username, "password": e.password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.base+"/v3/auth/authenticate", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	body, _, _, err := do(e.hc, req, false)
+	if err != nil {
+		return "", fmt.Errorf("registry authenticate: %w", err)
+	}
+	var out struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || out.Token == "" {
+		return "", errors.New("registry authenticate: no token in the answer")
+	}
+	return out.Token, nil
+}
+
+func (e *etcd) List(ctx context.Context) ([]Entry, string, error) {
+	req, err := e.post(ctx, "/v3/kv/range", map[string]any{"key": []byte(e.prefix), "range_end": rangeEnd(e.prefix)})
+	if err != nil {
+		return nil, "", err
+	}
+	b, _, _, err := do(e.hc, req, false)
+	if err != nil {
+		return nil, "", err
+	}
+	// Keys and values are base64, int64s strings.
+	var out struct {
+		Header struct {
+			Revision string `json:"revision"`
+		} `json:"header"`
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, "", fmt.Errorf("registry: %w", err)
+	}
+	// etcd returns keys in order.
+	entries := make([]Entry, 0, len(out.KVs))
+	for _, kv := range out.KVs {
+		if len(kv.Value) > 0 {
+			entries = append(entries, Entry{Key: string(kv.Key), Value: kv.Value})
+		}
+	}
+	return entries, out.Header.Revision, nil
+}
+
+func (e *etcd) Wait(ctx context.Context, version string) error {
+	create := map[string]any{"key": []byte(e.prefix), "range_end": rangeEnd(e.prefix)}
+	if rev, err := strconv.ParseInt(version, 10, 64); err == nil {
+		create["start_revision"] = strconv.FormatInt(rev+1, 10)
+	}
+	ctx, cancel := context.WithTimeout(ctx, waitTimeout)
+	defer cancel()
+	req, err := e.post(ctx, "/v3/watch", map[string]any{"create_request": create})
+	if err != nil {
+		return err
+	}
+	resp, err := e.hc.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("registry answered %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	// The stream is one JSON object per message: the first confirms the
+	// watch, the next carry events (or say it was canceled, e.g. because
+	// the revision was compacted).
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Result struct {
+				Created  bool              `json:"created"`
+				Canceled bool              `json:"canceled"`
+				Events   []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			return fmt.Errorf("registry watch: %w", err)
+		}
+		switch {
+		case msg.Error != nil:
+			return fmt.Errorf("registry watch: %s", msg.Error.Message)
+		case len(msg.Result.Events) > 0, msg.Result.Canceled:
+			return nil
+		}
+	}
+}
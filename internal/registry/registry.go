@@ -0,0 +1,120 @@
+// Package registry reads relay definitions from a key-value store, etcd or
+// Consul, through its HTTP API: one relay config (a JSON object) per key
+// under a prefix. Instances watching the same prefix pick up relays an
+// external control plane adds, changes and removes.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Backends.
+const (
+	Etcd   = "etcd"
+	Consul = "consul"
+)
+
+// waitTimeout bounds one Wait, so a dropped connection is noticed.
+const waitTimeout = 5 * time.Minute
+
+// maxResponseBytes caps a listing.
+const maxResponseBytes = 16 << 20
+
+// Entry is one key under the prefix and its value.
+type Entry struct {
+	Key   string
+	Value []byte
+}
+
+// Client reads and watches the keys under a prefix.
+type Client interface {
+	// List returns the entries under the prefix, ordered by key, and the
+	// version of the store they were read at.
+	List(ctx context.Context) ([]Entry, string, error)
+	// Wait blocks until the entries may have changed since version, or
+	// for a few minutes at most.
+	Wait(ctx context.Context, version string) error
+}
+
+// Options say which store to read.
+type Options struct {
+	Backend string
+	// Address is the base URL of the store's HTTP API, e.g.
+	// http://127.0.0.1:2379 (etcd) or http://127.0.0.1:8500 (Consul).
+	Address string
+	Prefix  string
+	// Token is a Consul ACL token.
+	Token string
+	// Username and Password authenticate with etcd.
+	Username, Password string
+}
+
+// New returns a client for the store o names.
+func New(o Options) (Client, error) {
+	u, err := url.Parse(o.Address)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("registry address %q is not an http(s) URL", o.Address)
+	}
+	if o.Prefix == "" {
+		return nil, fmt.Errorf("registry prefix is empty")
+	}
+	base := strings.TrimSuffix(o.Address, "/")
+	// No client timeout: Wait holds requests open on purpose; contexts
+	// bound them instead.
+	hc := &http.Client{}
+	switch o.Backend {
+	case Etcd:
+		return &etcd{base: base, prefix: o.Prefix, username: o.Username, password: o.Password, hc: hc}, nil
+	case Consul:
+		return &consul{base: base, prefix: strings.TrimPrefix(o.Prefix, "/"), token: o.Token, hc: hc}, nil
+	}
+	return nil, fmt.Errorf("unknown registry backend %q (want %q or %q)", o.Backend, Etcd, Consul)
+}
+
+// Digest identifies a set of entries, for noticing that a Wait returned
+// without a change.
+func Digest(entries []Entry) string {
+	h := sha256.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%d:%s%d:", len(e.Key), e.Key, len(e.Value))
+		h.Write(e.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// do sends req and returns the response body of a 2xx, or of a 404 when
+// notFoundOK is set (with a nil error).
+func do(hc *http.Client, req *http.Request, notFoundOK bool) ([]byte, http.Header, int, error) {
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(b) > maxResponseBytes {
+		return nil, nil, 0, fmt.Errorf("registry response larger than %d bytes", maxResponseBytes)
+	}
+	if resp.StatusCode == http.StatusNotFound && notFoundOK {
+		return nil, resp.Header, resp.StatusCode, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		msg := strings.TrimSpace(string(bytes.ToValidUTF8(b, nil)))
+		if len(msg) > 200 {
+			msg = msg[:200]
+		}
+		return nil, nil, resp.StatusCode, fmt.Errorf("registry answered %s: %s", resp.Status, msg)
+	}
+	return b, resp.Header, resp.StatusCode, nil
+}
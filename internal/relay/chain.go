@@ -0,0 +1,97 @@
+package relay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"webhookrelay/internal/config"
+)
+
+// localRelayHost is the host of the URLs relay destinations are sent to;
+// the request never leaves the process.
+const localRelayHost = "http://webhookrelay.local"
+
+// SetLocalRelays lets "relay" destinations hand events to relays served by h
+// (the public handler) without an HTTP round trip. Call it before Start.
+func (f *Forwarder) SetLocalRelays(h http.Handler, relays []config.ResolvedRelay) {
+	f.local = &http.Client{Transport: handlerTransport{h}}
+	f.UpdateLocalRelays(relays)
+}
+
+// UpdateLocalRelays replaces the relays "relay" destinations can reach,
+// when the config is reloaded; h must serve the new ones by then.
+func (f *Forwarder) UpdateLocalRelays(relays []config.ResolvedRelay) {
+	paths := make(map[string]string, len(relays))
+	for _, r := range relays {
+		if r.Name != "" {
+			paths[r.Name] = r.ListenPath
+		}
+	}
+	f.relayPaths.Store(&paths)
+}
+
+// localRelayURL returns the URL for the relay named name, or "" when it is
+// not served in process.
+func (f *Forwarder) localRelayURL(name string) string {
+	paths := f.relayPaths.Load()
+	if paths == nil || f.local == nil {
+		return ""
+	}
+	p, ok := (*paths)[name]
+	if !ok {
+		return ""
+	}
+	return localRelayHost + p
+}
+
+// handlerTransport serves requests with an http.Handler instead of sending
+// them.
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	in := req.Clone(req.Context())
+	in.RequestURI = req.URL.RequestURI()
+	if in.Body == nil {
+		in.Body = http.NoBody
+	}
+	w := &recorder{header: http.Header{}}
+	t.h.ServeHTTP(w, in)
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+// recorder is the http.ResponseWriter of in-process requests.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
@@ -0,0 +1,150 @@
+package relay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/payload"
+	"webhookrelay/internal/tmpl"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+)
+
+// wrapCloudEvent turns the encoded body into a CloudEvent. In structured
+// mode it returns the event document; in binary mode the body is unchanged
+// and the attributes are returned as ce-* headers. contentType is the
+// body's type ("" when the inbound Content-Type applies).
+func wrapCloudEvent(cfg config.CloudEventsConfig, ev tmpl.Event, body []byte, contentType string) ([]byte, string, http.Header, error) {
+	data := tmpl.NewData(ev)
+	render := func(text, def string) (string, error) {
+		if text == "" {
+			return def, nil
+		}
+		return tmpl.Render(text, data)
+	}
+
+	attrs := map[string]string{
+		"specversion": cloudEventsSpecVersion,
+		"id":          ev.RequestID,
+		"time":        ev.ReceivedAt.UTC().Format(time.RFC3339Nano),
+	}
+	var err error
+	if attrs["source"], err = render(cfg.Source, "/webhookrelay/"+ev.Relay); err != nil {
+		return nil, "", nil, fmt.Errorf("cloudevents source: %w", err)
+	}
+	if attrs["type"], err = render(cfg.Type, "webhookrelay."+ev.Relay); err != nil {
+		return nil, "", nil, fmt.Errorf("cloudevents type: %w", err)
+	}
+	if attrs["source"] == "" || attrs["type"] == "" {
+		return nil, "", nil, fmt.Errorf("cloudevents source and type must not render empty")
+	}
+	if attrs["subject"], err = render(cfg.Subject, ""); err != nil {
+		return nil, "", nil, fmt.Errorf("cloudevents subject: %w", err)
+	}
+	for name, text := range cfg.Extensions {
+		if attrs[name], err = render(text, ""); err != nil {
+			return nil, "", nil, fmt.Errorf("cloudevents extension %s: %w", name, err)
+		}
+	}
+
+	if contentType == "" {
+		contentType = ev.Header.Get("Content-Type")
+	}
+
+	if cfg.Mode == config.CloudEventsBinary {
+		h := http.Header{}
+		for name, v := range attrs {
+			if v != "" {
+				h.Set("Ce-"+name, v)
+			}
+		}
+		return body, contentType, h, nil
+	}
+
+	doc := map[string]any{}
+	for name, v := range attrs {
+		if v != "" {
+			doc[name] = v
+		}
+	}
+	if contentType != "" {
+		doc["datacontenttype"] = contentType
+	}
+	switch {
+	case len(body) == 0:
+	case payload.FormatOf(contentType) == payload.FormatJSON && json.Valid(body):
+		doc["data"] = json.RawMessage(body)
+	case utf8.Valid(body) && (payload.FormatOf(contentType) != payload.FormatRaw || strings.HasPrefix(contentType, "text/")):
+		doc["data"] = string(body)
+	default:
+		doc["data_base64"] = base64.StdEncoding.EncodeToString(body)
+	}
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return out, cloudEventsContentType, nil, nil
+}
+
+// unwrapCloudEvent replaces an inbound CloudEvent in ev with its data:
+// structured events are parsed and binary events lose their ce-* headers.
+// Other requests are returned unchanged.
+func unwrapCloudEvent(ev tmpl.Event) (tmpl.Event, error) {
+	mt, _, _ := mime.ParseMediaType(ev.Header.Get("Content-Type"))
+	switch {
+	case mt == cloudEventsContentType:
+		var doc struct {
+			DataContentType string          `json:"datacontenttype"`
+			Data            json.RawMessage `json:"data"`
+			DataBase64      string          `json:"data_base64"`
+		}
+		if err := json.Unmarshal(ev.Body, &doc); err != nil {
+			return ev, fmt.Errorf("cloudevents: %w", err)
+		}
+		ct := doc.DataContentType
+		if ct == "" {
+			ct = "application/json"
+		}
+		var body []byte
+		switch {
+		case doc.DataBase64 != "":
+			b, err := base64.StdEncoding.DecodeString(doc.DataBase64)
+			if err != nil {
+				return ev, fmt.Errorf("cloudevents data_base64: %w", err)
+			}
+			body = b
+		case len(doc.Data) == 0 || string(doc.Data) == "null":
+		case payload.FormatOf(ct) != payload.FormatJSON && doc.Data[0] == '"':
+			// Non-JSON data (XML, text, ...) is carried as a JSON string.
+			var s string
+			if err := json.Unmarshal(doc.Data, &s); err != nil {
+				return ev, fmt.Errorf("cloudevents data: %w", err)
+			}
+			body = []byte(s)
+		default:
+			body = doc.Data
+		}
+		ev.Body = body
+		ev.Header = ev.Header.Clone()
+		ev.Header.Set("Content-Type", ct)
+	case ev.Header.Get("Ce-Specversion") != "":
+		h := ev.Header.Clone()
+		for k := range h {
+			if strings.HasPrefix(k, "Ce-") {
+				delete(h, k)
+			}
+		}
+		ev.Header = h
+	}
+	return ev, nil
+}
@@ -0,0 +1,118 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/tmpl"
+)
+
+// Discord message limits; longer values are truncated rather than rejected.
+const (
+	discordContentLimit     = 2000
+	discordTitleLimit       = 256
+	discordDescriptionLimit = 4096
+
+	// discordMaxAttempts bounds how often a rate-limited message is resent.
+	discordMaxAttempts = 3
+)
+
+// discordMessage renders a webhook message for a discord destination.
+func discordMessage(cfg config.DiscordConfig, data tmpl.Data) ([]byte, error) {
+	msg := map[string]any{}
+
+	if cfg.Content != "" {
+		content, err := tmpl.Render(cfg.Content, data)
+		if err != nil {
+			return nil, fmt.Errorf("discord content: %w", err)
+		}
+		msg["content"] = truncateRunes(content, discordContentLimit)
+	}
+
+	switch {
+	case cfg.Embeds != "":
+		embeds, err := tmpl.RenderJSON(cfg.Embeds, data)
+		if err != nil {
+			return nil, fmt.Errorf("discord embeds: %w", err)
+		}
+		msg["embeds"] = embeds
+	case cfg.Title != "" || cfg.Description != "":
+		embed := map[string]any{}
+		if cfg.Title != "" {
+			title, err := tmpl.Render(cfg.Title, data)
+			if err != nil {
+				return nil, fmt.Errorf("discord title: %w", err)
+			}
+			embed["title"] = truncateRunes(title, discordTitleLimit)
+		}
+		if cfg.Description != "" {
+			desc, err := tmpl.Render(cfg.Description, data)
+			if err != nil {
+				return nil, fmt.Errorf("discord description: %w", err)
+			}
+			embed["description"] = truncateRunes(desc, discordDescriptionLimit)
+		}
+		if cfg.Color != 0 {
+			embed["color"] = cfg.Color
+		}
+		msg["embeds"] = []any{embed}
+	}
+
+	if cfg.Username != "" {
+		msg["username"] = cfg.Username
+	}
+	if cfg.AvatarURL != "" {
+		msg["avatar_url"] = cfg.AvatarURL
+	}
+
+	return json.Marshal(msg)
+}
+
+// doDiscord sends req, waiting out and resending on 429 responses as long as
+// the request context allows.
+func (f *Forwarder) doDiscord(req *http.Request, logArgs ...any) (*http.Response, error) {
+	resp, err := f.client.Do(req)
+	for attempt := 1; err == nil && resp.StatusCode == http.StatusTooManyRequests && attempt < discordMaxAttempts; attempt++ {
+		wait := discordRetryAfter(resp)
+		_ = resp.Body.Close()
+		f.log.Warn("forward: rate limited", append(logArgs, "retry_after_ms", wait.Milliseconds(), "attempt", attempt)...)
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, req.Context().Err()
+		}
+
+		body, gerr := req.GetBody()
+		if gerr != nil {
+			return nil, gerr
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+		resp, err = f.client.Do(req)
+	}
+	return resp, err
+}
+
+// discordRetryAfter reads the wait time from a 429 response: the JSON
+// retry_after field (seconds, fractional) or the Retry-After header.
+func discordRetryAfter(resp *http.Response) time.Duration {
+	var body struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(b, &body) == nil && body.RetryAfter > 0 {
+		return time.Duration(body.RetryAfter * float64(time.Second))
+	}
+	if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil && secs > 0 {
+		return time.Duration(secs * float64(time.Second))
+	}
+	return time.Second
+}
@@ -0,0 +1,34 @@
+package relay
+
+import (
+	"fmt"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/tmpl"
+)
+
+// enrich adds cfg's static fields and relay metadata to an object body.
+// Metadata wins over static fields, and both overwrite fields already present.
+func enrich(v any, cfg config.EnrichConfig, ev tmpl.Event) (any, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("enrich: body is not an object")
+	}
+
+	for k, val := range cfg.Fields {
+		obj[k] = normalizeValue(val)
+	}
+
+	meta := map[string]string{
+		"relay":       ev.Relay,
+		"request_id":  ev.RequestID,
+		"received_at": ev.ReceivedAt.UTC().Format(time.RFC3339Nano),
+		"source_ip":   ev.SourceIP,
+		"method":      ev.Method,
+	}
+	for field, src := range cfg.Metadata {
+		obj[field] = meta[src]
+	}
+	return obj, nil
+}
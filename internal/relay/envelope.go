@@ -0,0 +1,24 @@
+package relay
+
+import (
+	"strings"
+	"time"
+
+	"webhookrelay/internal/tmpl"
+)
+
+// wrapEnvelope wraps a decoded body with the event's metadata, for
+// destinations with envelope set.
+func wrapEnvelope(v any, ev tmpl.Event) map[string]any {
+	headers := make(map[string]any, len(ev.Header))
+	for k, vs := range ev.Header {
+		headers[k] = strings.Join(vs, ", ")
+	}
+	return map[string]any{
+		"id":          ev.RequestID,
+		"relay":       ev.Relay,
+		"received_at": ev.ReceivedAt.UTC().Format(time.RFC3339Nano),
+		"headers":     headers,
+		"payload":     v,
+	}
+}
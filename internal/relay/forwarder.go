@@ -3,34 +3,113 @@ package relay
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"webhookrelay/internal/alert"
+	"webhookrelay/internal/capture"
 	"webhookrelay/internal/config"
+	"webhookrelay/internal/deliverylog"
+	"webhookrelay/internal/health"
+	"webhookrelay/internal/journal"
+	"webhookrelay/internal/metrics"
+	"webhookrelay/internal/payload"
+	"webhookrelay/internal/provider"
+	"webhookrelay/internal/redact"
+	"webhookrelay/internal/sentry"
+	"webhookrelay/internal/store"
+	"webhookrelay/internal/tail"
+	"webhookrelay/internal/tmpl"
+	"webhookrelay/internal/tracing"
 )
 
 type ForwarderConfig struct {
-	Logger         *slog.Logger
+	Logger  *slog.Logger
+	Store   store.Store
+	Journal *journal.Journal
+	Alerts  *alert.Monitor
+	Metrics *metrics.Metrics
+	// Tail, when set, is told about every delivery attempt.
+	Tail *tail.Hub
+	// DeliveryLog, when set, gets a line per delivery attempt.
+	DeliveryLog *deliverylog.Writer
+	// Capture logs the requests and responses of relays in debug mode.
+	Capture *capture.Capture
+	// Health, when set, steers first_success failover away from
+	// destinations whose health check is failing.
+	Health         *health.Checker
 	Concurrency    int
 	ForwardTimeout time.Duration
+	// Transport replaces the HTTP transport used for deliveries (the verify
+	// command uses it to capture deliveries in process). Nil uses the default.
+	Transport http.RoundTripper
+	// Sentry, when set, is told about dead-lettered jobs and panics.
+	Sentry *sentry.Reporter
+	// SlowForward flags slow destinations and limits their concurrency.
+	SlowForward config.SlowForwardConfig
 }
 
+// Forwarder queues accepted events, one job per destination, and runs a fixed
+// pool of workers that deliver them.
 type Forwarder struct {
-	log     *slog.Logger
-	client  *http.Client
-	sem     chan struct{}
-	timeout time.Duration
+	log    *slog.Logger
+	client *http.Client
+	// transformClient calls transform and lookup services; it never uses
+	// Transport.
+	transformClient *http.Client
+	lookups         lookups
+	store           store.Store
+	journal         *journal.Journal
+	alerts          *alert.Monitor
+	metrics         *metrics.Metrics
+	tail            *tail.Hub
+	deliveryLog     *deliverylog.Writer
+	capture         *capture.Capture
+	health          *health.Checker
+	sentry          *sentry.Reporter
+	slow            *slowTracker
+	limits          *relayLimits
+	logSamples      sync.Map // relay ID -> *atomic.Uint64, see logged
+	busy            atomic.Int64
+	workers         int
+	timeout         time.Duration
+	// longest is the longest relay forward timeout, see UpdateTimeouts.
+	longest atomic.Int64
+	// local serves "relay" destinations in process (see SetLocalRelays).
+	local      *http.Client
+	relayPaths atomic.Pointer[map[string]string]
+
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
 }
 
+// queuePollInterval is how often idle workers look for jobs they were not
+// woken for (leases that expired, jobs left over from a previous run).
+const queuePollInterval = time.Second
+
 func NewForwarder(cfg ForwarderConfig) *Forwarder {
 	log := cfg.Logger
 	if log == nil {
 		log = slog.New(slog.NewJSONHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	}
+	if cfg.Store == nil {
+		cfg.Store = store.NewMemory()
+	}
 	if cfg.Concurrency <= 0 {
 		cfg.Concurrency = 50
 	}
@@ -38,78 +117,707 @@ func NewForwarder(cfg ForwarderConfig) *Forwarder {
 		cfg.ForwardTimeout = 10 * time.Second
 	}
 
-	return &Forwarder{
-		log:     log,
-		client:  &http.Client{},
-		sem:     make(chan struct{}, cfg.Concurrency),
-		timeout: cfg.ForwardTimeout,
+	f := &Forwarder{
+		log:             log,
+		client:          &http.Client{Transport: cfg.Transport},
+		transformClient: &http.Client{},
+		store:           cfg.Store,
+		journal:         cfg.Journal,
+		alerts:          cfg.Alerts,
+		metrics:         cfg.Metrics,
+		tail:            cfg.Tail,
+		deliveryLog:     cfg.DeliveryLog,
+		capture:         cfg.Capture,
+		health:          cfg.Health,
+		sentry:          cfg.Sentry,
+		limits:          newRelayLimits(log),
+		workers:         cfg.Concurrency,
+		timeout:         cfg.ForwardTimeout,
+		wake:            make(chan struct{}, cfg.Concurrency),
+		stop:            make(chan struct{}),
+	}
+	// Parked jobs must get their turn within the lease: each delivery ahead
+	// of them takes up to the forward timeout.
+	f.slow = newSlowTracker(cfg.SlowForward, int(f.lease()/f.timeout)-1, log, cfg.Metrics)
+	return f
+}
+
+// lease is how long a dequeued job is claimed for. It outlives the forward
+// timeouts so a slow delivery is not handed to a second worker while it is
+// still running.
+func (f *Forwarder) lease() time.Duration {
+	return max(f.timeout, time.Duration(f.longest.Load())) + 30*time.Second
+}
+
+// UpdateTimeouts makes leases outlast the forward timeouts of relays. Call
+// it before Start and when the config is reloaded. Leases never get
+// shorter, since queued jobs may carry a longer timeout still.
+func (f *Forwarder) UpdateTimeouts(relays []config.ResolvedRelay) {
+	for _, r := range relays {
+		for cur := f.longest.Load(); int64(r.ForwardTimeout) > cur; cur = f.longest.Load() {
+			if f.longest.CompareAndSwap(cur, int64(r.ForwardTimeout)) {
+				break
+			}
+		}
+	}
+}
+
+// jobTimeout is the forward timeout of job's relay.
+func (f *Forwarder) jobTimeout(job store.Job) time.Duration {
+	if job.ForwardTimeoutMS > 0 {
+		return time.Duration(job.ForwardTimeoutMS) * time.Millisecond
+	}
+	return f.timeout
+}
+
+// Start launches the delivery workers.
+func (f *Forwarder) Start() {
+	for i := 0; i < f.workers; i++ {
+		f.wg.Add(1)
+		go f.worker()
+	}
+}
+
+// Stop lets in-flight deliveries finish and stops the workers. Jobs still in
+// the queue stay there for the next run (if the store is persistent).
+func (f *Forwarder) Stop() {
+	close(f.stop)
+	f.wg.Wait()
+}
+
+// Stats is the state of the delivery workers.
+type Stats struct {
+	Workers int `json:"workers"`
+	// Busy workers are delivering a job.
+	Busy int `json:"busy"`
+	// Parked jobs wait for a slot of a slow destination, see
+	// server.slow_forward, or of a relay at its concurrency.
+	Parked           int      `json:"parked"`
+	SlowDestinations []string `json:"slow_destinations"`
+}
+
+func (f *Forwarder) Stats() Stats {
+	st := Stats{Workers: f.workers, Busy: int(f.busy.Load()), SlowDestinations: []string{}}
+	f.slow.stats(&st)
+	f.limits.stats(&st)
+	return st
+}
+
+// ForwardAsync enqueues one job per destination and returns without waiting
+// for delivery; with the "first_success" strategy a single job carries the
+// other destinations as failovers (shadow destinations still get their own),
+// healthy ones first.
+// A deadline on ctx bounds the whole delivery of the event.
+// backfilledAt is the original time of a backfilled event, which becomes
+// its received_at and queues it behind live events; it is zero for live ones.
+func (f *Forwarder) ForwardAsync(ctx context.Context, reqID string, relay config.ResolvedRelay, inbound *http.Request, body []byte, backfilledAt time.Time) error {
+	acceptedAt := time.Now()
+	receivedAt := acceptedAt
+	if !backfilledAt.IsZero() {
+		receivedAt = backfilledAt
+	}
+	deadline, _ := ctx.Deadline()
+	detected := provider.FromContext(ctx)
+
+	dests := relay.Destinations
+	var failover []config.DestinationConfig
+	if relay.Strategy == config.StrategyFirstSuccess {
+		dests = nil
+		for _, d := range relay.Destinations {
+			if d.Shadow {
+				dests = append(dests, d)
+			} else {
+				failover = append(failover, d)
+			}
+		}
+		failover = f.health.Order(failover, reqID)
+		if len(failover) > 0 {
+			dests = append(dests, failover[0])
+			failover = failover[1:]
+		}
+	}
+
+	trace := tracing.Carrier(ctx)
+	if trace == nil {
+		trace = tracing.PassThrough(inbound.Header)
+	}
+	jobs := make([]store.Job, 0, len(dests))
+	for _, d := range dests {
+		job := store.Job{
+			ID:          newJobID(),
+			RequestID:   reqID,
+			Relay:       relay.Name,
+			RelayID:     relay.ID,
+			Method:      inbound.Method,
+			Query:       inbound.URL.RawQuery,
+			PathSuffix:  relay.PathSuffix(inbound.URL.EscapedPath()),
+			Params:      relay.Params(inbound),
+			SourceIP:    remoteIP(inbound.RemoteAddr),
+			Provider:    detected.Provider,
+			EventType:   detected.EventType,
+			Header:      inbound.Header.Clone(),
+			Body:        body,
+			Destination: d,
+			Redact:      relay.Redact,
+			Transform:   relay.Transform,
+			ReceivedAt:  receivedAt,
+			Deadline:    deadline,
+			Trace:       trace,
+		}
+		job.LogSampleRate = relay.LogSampleRate
+		job.ForwardTimeoutMS, job.Concurrency = int(relay.ForwardTimeout.Milliseconds()), relay.Concurrency
+		job.AcceptedAt, job.Backfill = acceptedAt, !backfilledAt.IsZero()
+		// The TTL runs from acceptance so backfilled events do not expire
+		// on arrival.
+		if relay.EventTTL > 0 {
+			job.ExpiresAt = acceptedAt.Add(relay.EventTTL)
+		}
+		if !d.Shadow {
+			job.Failover = failover
+		}
+		jobs = append(jobs, job)
+	}
+
+	if f.journal != nil {
+		if err := f.journal.Accept(journal.NewAccept(inbound.URL.Path, jobs)); err != nil {
+			return fmt.Errorf("journal: %w", err)
+		}
+	}
+	if err := f.store.Enqueue(ctx, jobs...); err != nil {
+		return fmt.Errorf("enqueue: %w", err)
+	}
+	if f.journal != nil {
+		if err := f.journal.Commit(reqID); err != nil {
+			f.log.Error("journal: commit failed", "request_id", reqID, "error", err)
+		}
+	}
+	for range jobs {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (f *Forwarder) worker() {
+	defer f.wg.Done()
+
+	poll := time.NewTicker(queuePollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+
+		job, ok, err := f.store.Dequeue(context.Background(), f.lease())
+		if err != nil {
+			f.log.Error("queue: dequeue failed", "error", err)
+		}
+		if ok {
+			// A slow destination or a relay at its limit takes the job
+			// later, from the worker that frees a slot.
+			if f.slow.acquire(job) && f.acquireRelay(job) {
+				f.run(job)
+			}
+			continue
+		}
+
+		select {
+		case <-f.stop:
+			return
+		case <-f.wake:
+		case <-poll.C:
+		}
 	}
 }
 
-func (f *Forwarder) ForwardAsync(ctx context.Context, reqID string, relayName string, relayID string, inbound *http.Request, body []byte, destinations []config.DestinationConfig) {
-	// We intentionally do not wait. Each destination forward runs in its own goroutine.
-	for _, d := range destinations {
-		dest := d
-		go f.forwardOne(ctx, reqID, relayName, relayID, inbound, body, dest)
+// acquireRelay takes a slot of job's relay for a job that holds a slot of
+// its destination, which a job left in the queue gives back. A parked job
+// keeps it.
+func (f *Forwarder) acquireRelay(job store.Job) bool {
+	ok, parked := f.limits.acquire(job, f.lease(), f.jobTimeout(job))
+	if !ok && !parked {
+		f.slow.release(job.Relay, job.Destination.URL, false, 0)
 	}
+	return ok
 }
 
-func (f *Forwarder) forwardOne(parentCtx context.Context, reqID string, relayName string, relayID string, inbound *http.Request, body []byte, dest config.DestinationConfig) {
-	select {
-	case f.sem <- struct{}{}:
-		defer func() { <-f.sem }()
-	case <-parentCtx.Done():
-		return
+// run delivers job, which holds slots of its destination and relay, and
+// then the parked jobs the slots it frees let go.
+func (f *Forwarder) run(job store.Job) {
+	ready := []store.Job{job}
+	for len(ready) > 0 {
+		job := ready[0]
+		ready = ready[1:]
+		f.deliver(job)
+		f.limits.release(job)
+		for next, ok := f.limits.unpark(job.RelayID); ok; next, ok = f.limits.unpark(job.RelayID) {
+			ready = append(ready, next)
+		}
+		for next, ok := f.slow.unpark(job.Destination.URL); ok; next, ok = f.slow.unpark(job.Destination.URL) {
+			if f.acquireRelay(next) {
+				ready = append(ready, next)
+			}
+		}
 	}
+}
 
+// deliver attempts job once, then records the outcome, hands it to the next
+// failover destination or dead-letters it if it was not delivered (unless it
+// is a shadow delivery) and removes it from the queue.
+func (f *Forwarder) deliver(job store.Job) {
+	defer f.sentry.Recover("forward worker")
+	f.busy.Add(1)
+	defer f.busy.Add(-1)
+	dest := job.Destination
+	log := f.log.With("request_id", job.RequestID, "relay", job.Relay, "dest_url", dest.URL)
+	sent := false
+
+	d := store.Delivery{
+		ID:         job.ID,
+		RequestID:  job.RequestID,
+		Relay:      job.Relay,
+		DestURL:    dest.URL,
+		Provider:   job.Provider,
+		EventType:  job.EventType,
+		ReceivedAt: job.ReceivedAt,
+	}
+	reason := ""
+
+	ctx, span := tracing.Tracer.Start(tracing.FromCarrier(context.Background(), job.Trace), "forward "+job.Relay,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("webhookrelay.relay", job.Relay),
+			attribute.String("webhookrelay.request_id", job.RequestID),
+			attribute.String("webhookrelay.destination", dest.URL),
+			attribute.String("webhookrelay.destination_type", dest.Type),
+		))
+	defer span.End()
+	done := f.metrics.ForwardStarted(ctx)
+	start := time.Now()
+	switch {
+	case !job.ExpiresAt.IsZero() && start.After(job.ExpiresAt):
+		// Events that waited in the queue past their TTL are expired rather
+		// than delivered late.
+		log.Warn("forward: expired", "reason", store.ReasonExpired, "expires_at", job.ExpiresAt)
+		d.Outcome, reason = store.OutcomeExpired, store.ReasonExpired
+	case !job.Deadline.IsZero() && start.After(job.Deadline):
+		log.Warn("forward: deadline exceeded before send", "deadline", job.Deadline)
+		d.Outcome, reason = store.OutcomeExpired, store.ReasonDeadlineExceeded
+	case len(job.Failover) > 0 && !f.health.Admits(dest, job.RequestID) && f.health.Admits(f.health.Order(job.Failover, job.RequestID)[0], job.RequestID):
+		// The destination went down, or was ejected as an outlier, while
+		// the job was queued; a failover is up, so do not wait for this one
+		// to time out.
+		log.Warn("forward: skipping unhealthy destination", "reason", store.ReasonUnhealthy)
+		d.Outcome, reason, d.Error = store.OutcomeFailed, store.ReasonUnhealthy, "health check failing or ejected as an outlier"
+	default:
+		d.Status, d.Outcome, reason, d.Error = f.send(ctx, log, job)
+		sent = true
+	}
+	d.At = time.Now()
+	d.LatencyMS = d.At.Sub(start).Milliseconds()
+	f.slow.release(job.Relay, dest.URL, sent, d.At.Sub(start))
+	done(d)
+	span.SetAttributes(attribute.String("webhookrelay.outcome", d.Outcome))
+	if d.Status != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", d.Status))
+	}
+	if reason != "" {
+		span.SetStatus(codes.Error, reason)
+	}
+
+	ctx = context.Background()
+	if err := f.store.RecordDelivery(ctx, d); err != nil {
+		log.Error("store: record delivery failed", "error", err)
+	}
+	f.deliveryLog.Write(d)
+	f.tail.Publish(tail.Delivery(d))
+	if dest.Shadow {
+		// Mirrored traffic must not page anyone or fill the DLQ.
+		if reason != "" {
+			log.Info("forward: shadow delivery not delivered", "outcome", d.Outcome, "reason", reason)
+		}
+		reason = ""
+	} else {
+		f.alerts.Observe(job.Relay, dest.URL, d.Outcome)
+		if sent && (d.Outcome == store.OutcomeDelivered || reason == store.ReasonFailed) {
+			// 4xx answers come from a destination that is up.
+			f.health.Observe(dest, reason != "" && (d.Status == 0 || d.Status >= 500))
+		}
+	}
+	// An expired event is just as expired at the next destination.
+	expired := reason == store.ReasonExpired || reason == store.ReasonDeadlineExceeded
+	if reason != "" && !expired && len(job.Failover) > 0 {
+		next := job
+		next.ID = newJobID()
+		rest := f.health.Order(job.Failover, job.RequestID)
+		next.Destination, next.Failover = rest[0], rest[1:]
+		if err := f.store.Enqueue(ctx, next); err != nil {
+			log.Error("queue: enqueue failover failed", "error", err)
+		} else {
+			log.Warn("forward: failing over", "next_url", next.Destination.URL)
+			reason = ""
+			select {
+			case f.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if reason != "" {
+		kept := job
+		kept.Body = redact.Body(job.Body, job.Redact)
+		kept.Header = redact.Header(job.Header, job.Redact)
+		dl := store.DeadLetter{Job: kept, Reason: reason, Error: d.Error, At: d.At}
+		if err := f.store.PutDeadLetter(ctx, dl); err != nil {
+			log.Error("store: dead letter failed", "error", err)
+		} else {
+			f.metrics.DeadLettered(job.Relay, reason)
+		}
+		f.sentry.ForwardFailed(job, d, reason)
+	}
+	if err := f.store.Ack(ctx, job.ID); err != nil {
+		log.Error("queue: ack failed", "error", err)
+	}
+}
+
+// send performs the HTTP request for job, continuing the trace of ctx.
+// reason is empty when the destination accepted the event.
+func (f *Forwarder) send(ctx context.Context, log *slog.Logger, job store.Job) (status int, outcome, reason, errMsg string) {
+	dest := job.Destination
 	start := time.Now()
 
-	method := inbound.Method
+	method := job.Method
+	if dest.Type != config.DestinationHTTP {
+		method = http.MethodPost
+	}
 	if dest.Method != "" {
 		method = dest.Method
 	}
 
-	ctx, cancel := context.WithTimeout(parentCtx, f.timeout)
+	if job.Transform.HTTP.URL != "" {
+		out, drop, err := f.transformHTTP(job)
+		switch {
+		case err == nil && drop:
+			log.Info("forward: dropped by transform service", "transform_url", job.Transform.HTTP.URL)
+			return 0, store.OutcomeDropped, "", ""
+		case err == nil:
+			job = out
+		case job.Transform.HTTP.OnFailure == config.TransformFailForwardOriginal:
+			log.Warn("forward: transform failed, forwarding original", "transform_url", job.Transform.HTTP.URL, "error", err)
+		case job.Transform.HTTP.OnFailure == config.TransformFailDrop:
+			log.Warn("forward: transform failed, dropping", "transform_url", job.Transform.HTTP.URL, "error", err)
+			return 0, store.OutcomeFailed, "", err.Error()
+		default:
+			log.Error("forward: transform failed", "transform_url", job.Transform.HTTP.URL, "error", err)
+			return 0, store.OutcomeFailed, store.ReasonTransformFailed, err.Error()
+		}
+	}
+
+	header := redact.Header(job.Header, dest.Redact)
+	ev := tmpl.Event{
+		RequestID:  job.RequestID,
+		Relay:      job.Relay,
+		Method:     job.Method,
+		SourceIP:   job.SourceIP,
+		Header:     header,
+		Body:       redact.Body(job.Body, dest.Redact),
+		ReceivedAt: job.ReceivedAt,
+		Params:     job.Params,
+		Provider:   job.Provider,
+		EventType:  job.EventType,
+	}
+	var target string
+	var err error
+	if dest.Lookup != nil {
+		target, err = f.lookupURL(dest, ev)
+	} else {
+		target, err = renderURL(dest, ev)
+	}
+	if err != nil {
+		log.Error("forward: destination url rejected", "error", err)
+		return 0, store.OutcomeFailed, store.ReasonURLRejected, err.Error()
+	}
+	if dest.CloudEvents.Mode == config.CloudEventsUnwrap {
+		if ev, err = unwrapCloudEvent(ev); err != nil {
+			log.Error("forward: unwrap cloudevent failed", "error", err)
+			return 0, store.OutcomeFailed, store.ReasonEncodeFailed, err.Error()
+		}
+	}
+	payload, contentType, err := encodeBody(dest, ev)
+	if err != nil {
+		log.Error("forward: encode body failed", "type", dest.Type, "error", err)
+		return 0, store.OutcomeFailed, store.ReasonEncodeFailed, err.Error()
+	}
+	headers, err := renderHeaders(dest.Headers, ev)
+	if err != nil {
+		log.Error("forward: render headers failed", "error", err)
+		return 0, store.OutcomeFailed, store.ReasonEncodeFailed, err.Error()
+	}
+	var ceHeader http.Header
+	if m := dest.CloudEvents.Mode; m == config.CloudEventsStructured || m == config.CloudEventsBinary {
+		if payload, contentType, ceHeader, err = wrapCloudEvent(dest.CloudEvents, ev, payload, contentType); err != nil {
+			log.Error("forward: wrap cloudevent failed", "error", err)
+			return 0, store.OutcomeFailed, store.ReasonEncodeFailed, err.Error()
+		}
+	}
+
+	if dest.Compress {
+		if payload, err = gzipBody(payload); err != nil {
+			log.Error("forward: compress body failed", "error", err)
+			return 0, store.OutcomeFailed, store.ReasonEncodeFailed, err.Error()
+		}
+	}
+
+	if dest.Type == config.DestinationDrop {
+		// The pipeline ran, so a dark-launched config is exercised, but
+		// nothing is sent.
+		if f.logged(job) {
+			log.Info("forward: dropped by drop destination", "bytes", len(payload))
+		}
+		return 0, store.OutcomeDelivered, "", ""
+	}
+
+	timeout := f.jobTimeout(job)
+	if dest.TimeoutMS > 0 {
+		timeout = time.Duration(dest.TimeoutMS) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	if !job.Deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, job.Deadline)
+		defer cancelDeadline()
+	}
 
-	outReq, err := http.NewRequestWithContext(ctx, method, dest.URL, bytes.NewReader(body))
+	switch dest.Type {
+	case config.DestinationHTTP:
+		target = withPathSuffix(target, job.PathSuffix)
+	case config.DestinationRelay:
+		if target = f.localRelayURL(dest.Relay); target == "" {
+			log.Error("forward: relay not served in process", "target_relay", dest.Relay)
+			return 0, store.OutcomeFailed, store.ReasonURLRejected, "relay " + dest.Relay + " is not served in process"
+		}
+	}
+	outReq, err := http.NewRequestWithContext(ctx, method, withQuery(target, dest.Query, job.Query), bytes.NewReader(payload))
 	if err != nil {
-		f.log.Error("forward: build request failed", "request_id", reqID, "relay", relayName, "dest_url", dest.URL, "error", err)
-		return
+		log.Error("forward: build request failed", "error", err)
+		return 0, store.OutcomeFailed, store.ReasonFailed, err.Error()
 	}
 
-	copyHeaders(outReq.Header, inbound.Header)
+	// Formatter destinations build their own message, so the inbound headers
+	// (content type, provider signatures, ...) would only be misleading there.
+	if dest.Type == config.DestinationHTTP || dest.Type == config.DestinationRelay {
+		copyHeaders(outReq.Header, ev.Header)
+	}
+	for k, v := range ceHeader {
+		outReq.Header[k] = v
+	}
 	outReq.Host = ""
 	outReq.Header.Del("Host")
-	applyHeaderOverrides(outReq.Header, dest.Headers)
+	if contentType != "" {
+		outReq.Header.Set("Content-Type", contentType)
+	}
+	applyHeaderOverrides(outReq.Header, headers)
+	if dest.Compress {
+		outReq.Header.Set("Content-Encoding", "gzip")
+	}
 
 	// Loop prevention / trace propagation:
 	// - Each relay appends its relay id to X-WebhookRelay-Trace.
 	// - This lets any relay detect that it's seeing a relayed request it already processed.
-	relayID = strings.TrimSpace(relayID)
-	if relayID != "" {
+	if relayID := strings.TrimSpace(job.RelayID); relayID != "" {
 		outReq.Header.Set(HeaderTrace, appendTrace(outReq.Header.Get(HeaderTrace), relayID))
 	}
-	outReq.Header.Set(HeaderRequestID, reqID)
+	outReq.Header.Set(HeaderRequestID, job.RequestID)
+	outReq.Header.Set(HeaderRelayRequestID, job.RequestID)
+	if outReq.Header.Get("X-Request-Id") == "" {
+		// Formatter destinations did not get the inbound one.
+		if id := job.Header.Get("X-Request-Id"); id != "" {
+			outReq.Header.Set("X-Request-Id", id)
+		} else {
+			outReq.Header.Set("X-Request-Id", job.RequestID)
+		}
+	}
+	// The trace context comes from the job, not the inbound headers: the
+	// delivery span when tracing, else what PassThrough kept.
+	outReq.Header.Del("Traceparent")
+	outReq.Header.Del("Tracestate")
+	tracing.Inject(ctx, outReq.Header)
+	if outReq.Header.Get("Traceparent") == "" {
+		for k, v := range job.Trace {
+			if k == "traceparent" || k == "tracestate" {
+				outReq.Header.Set(k, v)
+			}
+		}
+	}
 
-	resp, err := f.client.Do(outReq)
+	var resp *http.Response
+	switch dest.Type {
+	case config.DestinationDiscord:
+		resp, err = f.doDiscord(outReq, "request_id", job.RequestID, "relay", job.Relay, "dest_url", dest.URL)
+	case config.DestinationRelay:
+		resp, err = f.local.Do(outReq)
+	default:
+		resp, err = f.client.Do(outReq)
+	}
 	latencyMS := time.Since(start).Milliseconds()
+	f.capture.Outbound(log, job.RelayID, job.Redact, outReq, payload, resp, err)
 	if err != nil {
 		// Distinguish timeouts/cancel for better logs.
 		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			f.log.Warn("forward: timeout", "request_id", reqID, "relay", relayName, "dest_url", dest.URL, "latency_ms", latencyMS, "error", err)
-			return
+			log.Warn("forward: timeout", "latency_ms", latencyMS, "error", err)
+			return 0, store.OutcomeTimeout, store.ReasonFailed, err.Error()
 		}
 		if errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled) {
-			f.log.Warn("forward: canceled", "request_id", reqID, "relay", relayName, "dest_url", dest.URL, "latency_ms", latencyMS, "error", err)
-			return
+			log.Warn("forward: canceled", "latency_ms", latencyMS, "error", err)
+			return 0, store.OutcomeFailed, store.ReasonFailed, err.Error()
 		}
-		f.log.Error("forward: request failed", "request_id", reqID, "relay", relayName, "dest_url", dest.URL, "latency_ms", latencyMS, "error", err)
-		return
+		log.Error("forward: request failed", "latency_ms", latencyMS, "error", err)
+		return 0, store.OutcomeFailed, store.ReasonFailed, err.Error()
 	}
 	_ = resp.Body.Close()
 
-	f.log.Info("forward: completed", "request_id", reqID, "relay", relayName, "dest_url", dest.URL, "status", resp.StatusCode, "latency_ms", latencyMS)
+	if resp.StatusCode/100 != 2 {
+		log.Info("forward: completed", "status", resp.StatusCode, "latency_ms", latencyMS)
+		return resp.StatusCode, store.OutcomeFailed, store.ReasonFailed, resp.Status
+	}
+	if f.logged(job) {
+		log.Info("forward: completed", "status", resp.StatusCode, "latency_ms", latencyMS)
+	}
+	return resp.StatusCode, store.OutcomeDelivered, "", ""
+}
+
+// logged reports whether a successful delivery of job is logged: one in
+// its relay's log_sample_rate, counting per relay.
+func (f *Forwarder) logged(job store.Job) bool {
+	if job.LogSampleRate <= 1 {
+		return true
+	}
+	n, _ := f.logSamples.LoadOrStore(job.RelayID, new(atomic.Uint64))
+	return n.(*atomic.Uint64).Add(1)%uint64(job.LogSampleRate) == 1
+}
+
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func newJobID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// encodeBody renders the body sent to dest. contentType is empty when the
+// inbound Content-Type still applies.
+func encodeBody(dest config.DestinationConfig, ev tmpl.Event) (body []byte, contentType string, err error) {
+	switch dest.Type {
+	case config.DestinationSlack:
+		body, err = slackMessage(dest.Slack, tmpl.NewData(ev))
+		return body, "application/json", err
+	case config.DestinationDiscord:
+		body, err = discordMessage(dest.Discord, tmpl.NewData(ev))
+		return body, "application/json", err
+	case config.DestinationTeams:
+		body, err = teamsMessage(dest.Teams, tmpl.NewData(ev))
+		return body, "application/json", err
+	}
+
+	if !restructures(dest, ev.Header.Get("Content-Type")) {
+		return ev.Body, "", nil
+	}
+
+	var v any
+	format := payload.FormatRaw
+	switch {
+	case len(ev.Body) == 0:
+	case dest.Decode != "":
+		format = dest.Decode
+		v, err = payload.DecodeAs(format, ev.Body)
+	default:
+		v, format, err = payload.Decode(ev.Header.Get("Content-Type"), ev.Body)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if format == payload.FormatMultipart {
+		v = shapeMultipart(v, dest.Multipart)
+	}
+
+	if len(dest.Enrich.Fields) > 0 || len(dest.Enrich.Metadata) > 0 {
+		if v, err = enrich(v, dest.Enrich, ev); err != nil {
+			return nil, "", err
+		}
+	}
+	if len(dest.Transform) > 0 {
+		if v, err = applyTransforms(v, dest.Transform); err != nil {
+			return nil, "", err
+		}
+	}
+
+	out := dest.EncodeAs
+	if out == "" && format == payload.FormatMultipart && dest.Multipart.Mode == config.MultipartJSON {
+		out = payload.FormatJSON
+	}
+	toXML := out == payload.FormatXML || out == "" && !dest.Envelope && format == payload.FormatXML
+	if obj, ok := v.(map[string]any); ok && !toXML {
+		// Only XML output uses the name of the document element.
+		delete(obj, payload.XMLName)
+	}
+	if dest.Envelope {
+		v = wrapEnvelope(v, ev)
+		if out == "" {
+			out = payload.FormatJSON
+		}
+	}
+	if out == "" {
+		// Re-encode in the inbound format and keep its Content-Type.
+		out = format
+		if out == payload.FormatRaw {
+			out = payload.FormatJSON
+		}
+	}
+	if out != format || dest.Envelope {
+		contentType = payload.ContentType(out)
+	}
+
+	switch out {
+	case payload.FormatMultipart:
+		// A new boundary always needs a new Content-Type.
+		return payload.EncodeMultipart(v)
+	case payload.FormatXML:
+		body, err = encodeXML(v, dest.XML)
+	case payload.FormatForm:
+		body, err = payload.EncodeForm(v)
+	case payload.FormatProtobuf:
+		md, perr := payload.ProtoMessage(dest.Protobuf.DescriptorSet, dest.Protobuf.Message)
+		if perr != nil {
+			return nil, "", perr
+		}
+		body, err = payload.EncodeProtobuf(v, md, dest.Protobuf.DiscardUnknown)
+	default:
+		body, err = payload.EncodeJSON(v)
+	}
+	return body, contentType, err
+}
+
+// restructures reports whether dest needs the body decoded. Otherwise it is
+// forwarded byte for byte.
+func restructures(dest config.DestinationConfig, contentType string) bool {
+	if len(dest.Enrich.Fields) > 0 || len(dest.Enrich.Metadata) > 0 ||
+		len(dest.Transform) > 0 || dest.Envelope || dest.EncodeAs != "" || dest.Decode != "" {
+		return true
+	}
+	mp := dest.Multipart
+	return payload.FormatOf(contentType) == payload.FormatMultipart &&
+		((mp.Mode != "" && mp.Mode != config.MultipartForward) || mp.MaxAttachmentBytes > 0)
 }
 
 func copyHeaders(dst http.Header, src http.Header) {
@@ -153,6 +861,9 @@ func isHopByHopHeader(k string) bool {
 const (
 	HeaderTrace     = "X-WebhookRelay-Trace"
 	HeaderRequestID = "X-WebhookRelay-Request-Id"
+	// HeaderRelayRequestID repeats the request ID under the name of the
+	// header on the response to the sender.
+	HeaderRelayRequestID = "X-Relay-Request-Id"
 )
 
 func appendTrace(existing string, instanceID string) string {
@@ -0,0 +1,36 @@
+package relay
+
+import (
+	"fmt"
+	"strings"
+
+	"webhookrelay/internal/tmpl"
+)
+
+// renderHeaders returns dest header overrides with template values (those
+// containing "{{") rendered for ev.
+func renderHeaders(headers map[string]string, ev tmpl.Event) (map[string]string, error) {
+	var data tmpl.Data
+	out := headers
+	for k, v := range headers {
+		if !strings.Contains(v, "{{") {
+			continue
+		}
+		if data == nil {
+			data = tmpl.NewData(ev)
+			out = make(map[string]string, len(headers))
+			for k2, v2 := range headers {
+				out[k2] = v2
+			}
+		}
+		rendered, err := tmpl.Render(v, data)
+		if err != nil {
+			return nil, fmt.Errorf("header %s: %w", k, err)
+		}
+		if strings.ContainsAny(rendered, "\r\n") {
+			return nil, fmt.Errorf("header %s: rendered value contains a line break", k)
+		}
+		out[k] = rendered
+	}
+	return out, nil
+}
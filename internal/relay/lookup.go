@@ -0,0 +1,159 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/tmpl"
+)
+
+// lookupCacheMax bounds the number of cached lookup service answers; the
+// cache is emptied when it fills up.
+const lookupCacheMax = 10_000
+
+// errLookupMissing is returned for keys the table does not contain.
+var errLookupMissing = errors.New("not in lookup table")
+
+type lookups struct {
+	mu      sync.Mutex
+	answers map[string]lookupAnswer // service URL -> answer
+	files   map[string]lookupFile   // path -> table
+}
+
+type lookupAnswer struct {
+	url     string
+	err     error
+	expires time.Time
+}
+
+type lookupFile struct {
+	modTime time.Time
+	size    int64
+	table   map[string]string
+}
+
+// lookupURL resolves the URL of a lookup destination for ev and checks it
+// against the destination's allowed_urls.
+func (f *Forwarder) lookupURL(dest config.DestinationConfig, ev tmpl.Event) (string, error) {
+	l := dest.Lookup
+	key, err := tmpl.Render(l.Key, tmpl.NewData(ev))
+	if err != nil {
+		return "", fmt.Errorf("lookup key: %w", err)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" || strings.Contains(key, "<no value>") {
+		return "", fmt.Errorf("lookup key %q references a missing field", key)
+	}
+
+	var raw string
+	if l.File != "" {
+		raw, err = f.lookups.file(l.File, key)
+	} else {
+		raw, err = f.lookups.service(f.transformClient, l.HTTP, key, l.CacheTTL())
+	}
+	if err != nil {
+		return "", fmt.Errorf("lookup %q: %w", key, err)
+	}
+	return allowURL(dest, raw)
+}
+
+// file looks key up in the table at path, re-reading it when it changed.
+func (c *lookups) file(path, key string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lf, ok := c.files[path]
+	if !ok || !lf.modTime.Equal(st.ModTime()) || lf.size != st.Size() {
+		table, err := config.LoadLookupFile(path)
+		if err != nil {
+			return "", err
+		}
+		lf = lookupFile{modTime: st.ModTime(), size: st.Size(), table: table}
+		if c.files == nil {
+			c.files = map[string]lookupFile{}
+		}
+		c.files[path] = lf
+	}
+	u, ok := lf.table[key]
+	if !ok {
+		return "", errLookupMissing
+	}
+	return u, nil
+}
+
+// service asks the lookup service for key. Answers, including unknown keys,
+// are cached for ttl; failures are not.
+func (c *lookups) service(client *http.Client, pattern, key string, ttl time.Duration) (string, error) {
+	target := strings.ReplaceAll(pattern, "{key}", url.PathEscape(key))
+	now := time.Now()
+	c.mu.Lock()
+	a, ok := c.answers[target]
+	c.mu.Unlock()
+	if ok && now.Before(a.expires) {
+		return a.url, a.err
+	}
+
+	u, err := fetchLookup(client, target)
+	if err != nil && !errors.Is(err, errLookupMissing) {
+		return "", err
+	}
+	c.mu.Lock()
+	if c.answers == nil || len(c.answers) >= lookupCacheMax {
+		c.answers = map[string]lookupAnswer{}
+	}
+	c.answers[target] = lookupAnswer{url: u, err: err, expires: now.Add(ttl)}
+	c.mu.Unlock()
+	return u, err
+}
+
+func fetchLookup(client *http.Client, target string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", errLookupMissing
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("lookup service answered %s", resp.Status)
+	}
+	text := strings.TrimSpace(string(body))
+	if strings.HasPrefix(text, "{") {
+		var doc struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", fmt.Errorf("lookup service answer: %w", err)
+		}
+		text = doc.URL
+	}
+	if text == "" {
+		return "", errLookupMissing
+	}
+	return text, nil
+}
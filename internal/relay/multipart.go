@@ -0,0 +1,51 @@
+package relay
+
+import (
+	"encoding/json"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/payload"
+)
+
+// shapeMultipart applies cfg to a decoded multipart body: attachments are
+// removed (strip_attachments), reduced to metadata (json without
+// include_attachments) or lose their content when over the size limit.
+func shapeMultipart(v any, cfg config.MultipartConfig) any {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	for k, field := range obj {
+		items, isList := field.([]any)
+		if !isList {
+			items = []any{field}
+		}
+		kept := items[:0]
+		for _, item := range items {
+			if !payload.IsAttachment(item) {
+				kept = append(kept, item)
+				continue
+			}
+			if cfg.Mode == config.MultipartStripAttachments {
+				continue
+			}
+			att := item.(map[string]any)
+			n, _ := att[payload.AttachmentSize].(json.Number)
+			size, _ := n.Int64()
+			if (cfg.Mode == config.MultipartJSON && !cfg.IncludeAttachments) ||
+				(cfg.MaxAttachmentBytes > 0 && size > cfg.MaxAttachmentBytes) {
+				delete(att, payload.AttachmentContent)
+			}
+			kept = append(kept, att)
+		}
+		switch {
+		case len(kept) == 0:
+			delete(obj, k)
+		case isList:
+			obj[k] = kept
+		default:
+			obj[k] = kept[0]
+		}
+	}
+	return obj
+}
@@ -0,0 +1,26 @@
+package relay
+
+import (
+	"net/url"
+	"strings"
+)
+
+// withPathSuffix appends the escaped path suffix matched by a wildcard relay
+// to target's path, keeping target's query.
+func withPathSuffix(target, suffix string) string {
+	if suffix == "" {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		// Let request construction report the bad URL.
+		return target
+	}
+	escaped := strings.TrimSuffix(u.EscapedPath(), "/") + suffix
+	p, err := url.PathUnescape(escaped)
+	if err != nil {
+		return target
+	}
+	u.Path, u.RawPath = p, escaped
+	return u.String()
+}
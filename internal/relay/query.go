@@ -0,0 +1,46 @@
+package relay
+
+import (
+	"net/url"
+	"strings"
+
+	"webhookrelay/internal/config"
+)
+
+// withQuery returns target with the parts of the inbound query string that
+// qc asks for.
+func withQuery(target string, qc config.QueryConfig, rawQuery string) string {
+	if rawQuery == "" || (qc.Mode != config.QueryAppend && qc.Mode != config.QueryMerge && len(qc.Params) == 0) {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		// Let request construction report the bad URL.
+		return target
+	}
+	inbound, _ := url.ParseQuery(rawQuery)
+
+	if qc.Mode == config.QueryAppend {
+		// Keep the inbound query byte-for-byte; senders sometimes sign it.
+		u.RawQuery = strings.TrimPrefix(u.RawQuery+"&"+rawQuery, "&")
+	}
+	if qc.Mode != config.QueryMerge && len(qc.Params) == 0 {
+		return u.String()
+	}
+
+	q := u.Query()
+	if qc.Mode == config.QueryMerge {
+		for k, vs := range inbound {
+			if !q.Has(k) {
+				q[k] = vs
+			}
+		}
+	}
+	for to, from := range qc.Params {
+		if vs, ok := inbound[from]; ok {
+			q[to] = vs
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,102 @@
+package relay
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/store"
+)
+
+// relayLimits keeps relays with a concurrency setting to that many
+// deliveries in flight. Jobs over the limit are parked, without holding a
+// worker, until a delivery of their relay ends.
+type relayLimits struct {
+	log *slog.Logger
+
+	mu     sync.Mutex
+	relays map[string]*relaySlots // by relay ID
+}
+
+type relaySlots struct {
+	inFlight int
+	// parked jobs stay leased in the queue, like the slow tracker's.
+	parked []store.Job
+}
+
+func newRelayLimits(log *slog.Logger) *relayLimits {
+	return &relayLimits{log: log, relays: map[string]*relaySlots{}}
+}
+
+// acquire takes a delivery slot of job's relay. At the relay's limit, it
+// parks the job for unpark or, with as many parked as can go before their
+// lease ends (each waits up to timeout per delivery ahead of it), leaves it
+// leased in the queue to be dequeued again; it then returns false, and
+// whether the job was parked.
+func (l *relayLimits) acquire(job store.Job, lease, timeout time.Duration) (ok, parked bool) {
+	if job.Concurrency <= 0 {
+		return true, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.relay(job.RelayID)
+	if r.inFlight < job.Concurrency {
+		r.inFlight++
+		return true, false
+	}
+	if len(r.parked) < max(int(lease/timeout)-1, 1)*job.Concurrency {
+		r.parked = append(r.parked, job)
+		l.log.Debug("forward: relay at its concurrency, job parked", "request_id", job.RequestID, "relay", job.Relay)
+		return false, true
+	}
+	l.log.Debug("forward: relay at its concurrency, job deferred", "request_id", job.RequestID, "relay", job.Relay)
+	return false, false
+}
+
+// unpark returns a parked job of the relay with its slot taken, if one can
+// go.
+func (l *relayLimits) unpark(relayID string) (store.Job, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r, ok := l.relays[relayID]
+	if !ok || len(r.parked) == 0 || r.inFlight >= r.parked[0].Concurrency {
+		return store.Job{}, false
+	}
+	job := r.parked[0]
+	r.parked = r.parked[1:]
+	r.inFlight++
+	return job, true
+}
+
+// release returns the slot job took.
+func (l *relayLimits) release(job store.Job) {
+	if job.Concurrency <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := l.relay(job.RelayID)
+	r.inFlight--
+	if r.inFlight <= 0 && len(r.parked) == 0 {
+		// Relays come and go with reloads.
+		delete(l.relays, job.RelayID)
+	}
+}
+
+func (l *relayLimits) relay(id string) *relaySlots {
+	r, ok := l.relays[id]
+	if !ok {
+		r = &relaySlots{}
+		l.relays[id] = r
+	}
+	return r
+}
+
+// stats adds the parked jobs to st.
+func (l *relayLimits) stats(st *Stats) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, r := range l.relays {
+		st.Parked += len(r.parked)
+	}
+}
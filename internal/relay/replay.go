@@ -0,0 +1,91 @@
+package relay
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/store"
+)
+
+// Why a dead letter cannot be replayed.
+var (
+	ErrRelayGone       = errors.New("relay is no longer configured")
+	ErrDestinationGone = errors.New("destination is no longer configured for the relay")
+	ErrRedacted        = errors.New("dead letter was kept redacted by the relay's redact rules; its body and headers are no longer those received")
+)
+
+// ReplayJob turns a dead-lettered job into a new one for the current config
+// of its relay and destination, found in relays by name and by URL (or
+// target relay). The job keeps its request ID and gains the
+// X-WebhookRelay-Replay header with it, like replays from the admin API; its
+// TTL starts now. The body and headers are those kept in the DLQ, so dead
+// letters kept with redact rules applied are not replayed (ErrRedacted):
+// their masked values would be sent.
+func ReplayJob(dl store.DeadLetter, relays []config.ResolvedRelay, now time.Time) (store.Job, error) {
+	old := dl.Job
+	if r := old.Redact; len(r.Fields) > 0 || len(r.Paths) > 0 || len(r.Headers) > 0 {
+		return store.Job{}, ErrRedacted
+	}
+	var relay *config.ResolvedRelay
+	for i := range relays {
+		if relays[i].Name == old.Relay {
+			relay = &relays[i]
+			break
+		}
+	}
+	if relay == nil {
+		return store.Job{}, ErrRelayGone
+	}
+	dest, ok := currentDestination(*relay, old.Destination)
+	if !ok {
+		return store.Job{}, ErrDestinationGone
+	}
+
+	job := old
+	job.ID = newJobID()
+	job.RelayID = relay.ID
+	job.Header = old.Header.Clone()
+	if job.Header == nil {
+		job.Header = http.Header{}
+	}
+	job.Header.Set(headerReplay, old.RequestID)
+	job.Destination = dest
+	job.Failover = nil
+	job.Redact = relay.Redact
+	job.Transform = relay.Transform
+	job.LogSampleRate = relay.LogSampleRate
+	job.ForwardTimeoutMS, job.Concurrency = int(relay.ForwardTimeout.Milliseconds()), relay.Concurrency
+	job.ReceivedAt, job.AcceptedAt, job.Backfill = now, now, false
+	job.Deadline = time.Time{}
+	job.ExpiresAt = time.Time{}
+	if relay.EventTTL > 0 {
+		job.ExpiresAt = now.Add(relay.EventTTL)
+	}
+	return job, nil
+}
+
+// headerReplay marks replayed requests, as server.HeaderReplay does.
+const headerReplay = "X-WebhookRelay-Replay"
+
+// currentDestination finds the destination of relay, or of one of its
+// routes, with the URL (or target relay) of old.
+func currentDestination(relay config.ResolvedRelay, old config.DestinationConfig) (config.DestinationConfig, bool) {
+	same := func(d config.DestinationConfig) bool {
+		return d.URL == old.URL && d.Relay == old.Relay
+	}
+	for _, d := range relay.Destinations {
+		if same(d) {
+			return d, true
+		}
+	}
+	for _, rt := range relay.Routes {
+		for _, d := range rt.Destinations {
+			if same(d) {
+				return d, true
+			}
+		}
+	}
+	return config.DestinationConfig{}, false
+}
@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/tmpl"
+)
+
+// Slack rejects section blocks whose text is longer than this.
+const slackSectionTextLimit = 3000
+
+// slackMessage renders an incoming-webhook message for a slack destination.
+// Without a blocks template, the rendered text is wrapped in a single mrkdwn
+// section block.
+func slackMessage(cfg config.SlackConfig, data tmpl.Data) ([]byte, error) {
+	msg := map[string]any{}
+
+	var text string
+	if cfg.Text != "" {
+		var err error
+		text, err = tmpl.Render(cfg.Text, data)
+		if err != nil {
+			return nil, fmt.Errorf("slack text: %w", err)
+		}
+		msg["text"] = text
+	}
+
+	switch {
+	case cfg.Blocks != "":
+		blocks, err := tmpl.RenderJSON(cfg.Blocks, data)
+		if err != nil {
+			return nil, fmt.Errorf("slack blocks: %w", err)
+		}
+		msg["blocks"] = blocks
+	case text != "":
+		msg["blocks"] = []any{map[string]any{
+			"type": "section",
+			"text": map[string]any{"type": "mrkdwn", "text": truncateRunes(text, slackSectionTextLimit)},
+		}}
+	}
+
+	if cfg.Attachments != "" {
+		attachments, err := tmpl.RenderJSON(cfg.Attachments, data)
+		if err != nil {
+			return nil, fmt.Errorf("slack attachments: %w", err)
+		}
+		msg["attachments"] = attachments
+	}
+
+	for k, v := range map[string]string{
+		"username":   cfg.Username,
+		"icon_emoji": cfg.IconEmoji,
+		"icon_url":   cfg.IconURL,
+		"channel":    cfg.Channel,
+	} {
+		if v != "" {
+			msg[k] = v
+		}
+	}
+
+	return json.Marshal(msg)
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
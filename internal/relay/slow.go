@@ -0,0 +1,148 @@
+package relay
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/metrics"
+	"webhookrelay/internal/store"
+)
+
+// slowTracker watches delivery latency per destination URL (see
+// config.SlowForwardConfig) and keeps slow destinations to their share of
+// the workers. A nil *slowTracker tracks nothing.
+type slowTracker struct {
+	cfg     config.SlowForwardConfig
+	maxPark int
+	log     *slog.Logger
+	metrics *metrics.Metrics
+
+	mu    sync.Mutex
+	dests map[string]*slowDest
+}
+
+type slowDest struct {
+	slow     bool
+	run      int // attempts in a row on the other side of the threshold
+	inFlight int
+	// parked jobs wait for a slot without holding a worker. They stay
+	// leased in the queue.
+	parked []store.Job
+}
+
+// newSlowTracker parks up to rounds times max_concurrency jobs per slow
+// destination: as many as it delivers in rounds deliveries per slot.
+func newSlowTracker(cfg config.SlowForwardConfig, rounds int, log *slog.Logger, m *metrics.Metrics) *slowTracker {
+	if cfg.ThresholdMS <= 0 {
+		return nil
+	}
+	return &slowTracker{cfg: cfg, maxPark: max(rounds, 1) * cfg.MaxConcurrency, log: log, metrics: m, dests: map[string]*slowDest{}}
+}
+
+// acquire takes a delivery slot for job's destination. While the
+// destination is slow and has max_concurrency deliveries in flight, it
+// parks the job for unpark instead, or, with as many parked as can go
+// before their lease ends, leaves it leased in the queue to be dequeued
+// again when the lease runs out; it then returns false.
+func (t *slowTracker) acquire(job store.Job) bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.dest(job.Destination.URL)
+	if !t.full(d) {
+		d.inFlight++
+		return true
+	}
+	if len(d.parked) < t.maxPark {
+		d.parked = append(d.parked, job)
+		t.log.Debug("forward: slow destination at max_concurrency, job parked", "request_id", job.RequestID, "dest_url", job.Destination.URL)
+	} else {
+		t.log.Debug("forward: slow destination at max_concurrency, job deferred", "request_id", job.RequestID, "dest_url", job.Destination.URL)
+	}
+	return false
+}
+
+// unpark returns a parked job of url with its slot taken, if one can go.
+func (t *slowTracker) unpark(url string) (store.Job, bool) {
+	if t == nil {
+		return store.Job{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.dest(url)
+	if len(d.parked) == 0 || t.full(d) {
+		return store.Job{}, false
+	}
+	job := d.parked[0]
+	d.parked = d.parked[1:]
+	d.inFlight++
+	return job, true
+}
+
+func (t *slowTracker) full(d *slowDest) bool {
+	return d.slow && t.cfg.MaxConcurrency > 0 && d.inFlight >= t.cfg.MaxConcurrency
+}
+
+// release returns the slot and, when the destination was called (sent),
+// accounts for how long it took.
+func (t *slowTracker) release(relay, url string, sent bool, latency time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.dest(url)
+	d.inFlight--
+	if !sent {
+		return
+	}
+	over := latency >= t.cfg.Threshold()
+	if over {
+		t.metrics.SlowForward(relay, url)
+	}
+	if over == d.slow {
+		d.run = 0
+		return
+	}
+	d.run++
+	if d.run < t.cfg.Consecutive {
+		return
+	}
+	d.slow, d.run = over, 0
+	t.metrics.SetDestinationSlow(url, over)
+	if over {
+		t.log.Warn("forward: destination is slow", "dest_url", url, "threshold_ms", t.cfg.ThresholdMS, "consecutive", t.cfg.Consecutive, "latency_ms", latency.Milliseconds())
+	} else {
+		t.log.Info("forward: destination is no longer slow", "dest_url", url, "latency_ms", latency.Milliseconds())
+	}
+}
+
+func (t *slowTracker) dest(url string) *slowDest {
+	d, ok := t.dests[url]
+	if !ok {
+		d = &slowDest{}
+		t.dests[url] = d
+	}
+	return d
+}
+
+// stats adds the parked jobs and slow destinations to st.
+func (t *slowTracker) stats(st *Stats) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for url, d := range t.dests {
+		st.Parked += len(d.parked)
+		if d.slow {
+			st.SlowDestinations = append(st.SlowDestinations, url)
+		}
+	}
+	sort.Strings(st.SlowDestinations)
+}
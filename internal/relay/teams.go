@@ -0,0 +1,61 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/tmpl"
+)
+
+// Teams rejects webhook messages larger than roughly 28 KB.
+const teamsMaxMessageBytes = 28 << 10
+
+// teamsMessage renders a Teams webhook message wrapping one Adaptive Card.
+func teamsMessage(cfg config.TeamsConfig, data tmpl.Data) ([]byte, error) {
+	var card any
+	if cfg.Card != "" {
+		raw, err := tmpl.RenderJSON(cfg.Card, data)
+		if err != nil {
+			return nil, fmt.Errorf("teams card: %w", err)
+		}
+		card = raw
+	} else {
+		var body []any
+		if cfg.Title != "" {
+			title, err := tmpl.Render(cfg.Title, data)
+			if err != nil {
+				return nil, fmt.Errorf("teams title: %w", err)
+			}
+			body = append(body, map[string]any{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "wrap": true})
+		}
+		if cfg.Text != "" {
+			text, err := tmpl.Render(cfg.Text, data)
+			if err != nil {
+				return nil, fmt.Errorf("teams text: %w", err)
+			}
+			body = append(body, map[string]any{"type": "TextBlock", "text": text, "wrap": true})
+		}
+		card = map[string]any{
+			"type":    "AdaptiveCard",
+			"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+			"version": "1.4",
+			"body":    body,
+		}
+	}
+
+	b, err := json.Marshal(map[string]any{
+		"type": "message",
+		"attachments": []any{map[string]any{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > teamsMaxMessageBytes {
+		return nil, fmt.Errorf("teams message is %d bytes, over the %d byte limit", len(b), teamsMaxMessageBytes)
+	}
+	return b, nil
+}
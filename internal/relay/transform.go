@@ -0,0 +1,60 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/payload"
+)
+
+// applyTransforms runs steps over a decoded body in order.
+func applyTransforms(v any, steps []config.TransformStep) (any, error) {
+	for i, st := range steps {
+		segs, err := payload.SplitPath(st.Path)
+		if err != nil {
+			return nil, fmt.Errorf("transform[%d]: %w", i, err)
+		}
+		switch st.Op {
+		case config.TransformSet:
+			if v, err = payload.Set(v, segs, normalizeValue(st.Value)); err != nil {
+				return nil, fmt.Errorf("transform[%d] set %s: %w", i, st.Path, err)
+			}
+		case config.TransformDelete:
+			v = payload.Delete(v, segs)
+		case config.TransformRename, config.TransformCopy:
+			val, ok := payload.Get(v, segs)
+			if !ok {
+				// Nothing to move; senders often omit optional fields.
+				continue
+			}
+			to, err := payload.SplitPath(st.To)
+			if err != nil {
+				return nil, fmt.Errorf("transform[%d]: %w", i, err)
+			}
+			if st.Op == config.TransformRename {
+				v = payload.Delete(v, segs)
+			} else {
+				val = normalizeValue(val)
+			}
+			if v, err = payload.Set(v, to, val); err != nil {
+				return nil, fmt.Errorf("transform[%d] %s %s: %w", i, st.Op, st.To, err)
+			}
+		}
+	}
+	return v, nil
+}
+
+// normalizeValue deep-copies v into the payload representation (json.Number
+// for numbers), so config values and copies never alias each other.
+func normalizeValue(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	out, err := payload.DecodeAs(payload.FormatJSON, b)
+	if err != nil {
+		return v
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"webhookrelay/internal/store"
+)
+
+// maxTransformResponseBytes bounds what a transform service may return.
+const maxTransformResponseBytes = 64 << 20
+
+// transformHTTP posts job's body to the relay's transform service and
+// returns the job with the response as its body (and Content-Type). drop is
+// true when the service answered 204 No Content.
+func (f *Forwarder) transformHTTP(job store.Job) (out store.Job, drop bool, err error) {
+	t := job.Transform.HTTP
+	ctx, cancel := context.WithTimeout(context.Background(), t.Timeout())
+	defer cancel()
+	if !job.Deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, job.Deadline)
+		defer cancelDeadline()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(job.Body))
+	if err != nil {
+		return job, false, err
+	}
+	if ct := job.Header.Get("Content-Type"); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	req.Header.Set(HeaderRequestID, job.RequestID)
+	req.Header.Set("X-WebhookRelay-Relay", job.Relay)
+	applyHeaderOverrides(req.Header, t.Headers)
+
+	resp, err := f.transformClient.Do(req)
+	if err != nil {
+		return job, false, fmt.Errorf("transform: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return job, true, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return job, false, fmt.Errorf("transform: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformResponseBytes+1))
+	if err != nil {
+		return job, false, fmt.Errorf("transform: %w", err)
+	}
+	if len(body) > maxTransformResponseBytes {
+		return job, false, fmt.Errorf("transform: response larger than %d bytes", maxTransformResponseBytes)
+	}
+
+	out = job
+	out.Body = body
+	out.Header = job.Header.Clone()
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		out.Header.Set("Content-Type", ct)
+	} else {
+		out.Header.Del("Content-Type")
+	}
+	out.Header.Del("Content-Length")
+	return out, false, nil
+}
@@ -0,0 +1,72 @@
+package relay
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/tmpl"
+)
+
+var urlPatterns sync.Map // pattern -> *regexp.Regexp
+
+// renderURL returns the URL to send to. Templated URLs are rendered from the
+// event and must match one of dest.AllowedURLs, so payload values cannot steer
+// requests to arbitrary hosts or paths.
+func renderURL(dest config.DestinationConfig, ev tmpl.Event) (string, error) {
+	if !config.URLTemplated(dest.URL) {
+		return dest.URL, nil
+	}
+	raw, err := tmpl.Render(dest.URL, tmpl.NewData(ev))
+	if err != nil {
+		return "", err
+	}
+	raw = strings.TrimSpace(raw)
+	if strings.Contains(raw, "<no value>") {
+		return "", fmt.Errorf("rendered url %q references a missing field", raw)
+	}
+	return allowURL(dest, raw)
+}
+
+// allowURL returns raw if it is an absolute http(s) URL without dot segments
+// that matches one of dest.AllowedURLs.
+func allowURL(dest config.DestinationConfig, raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("rendered url %q: %w", raw, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("rendered url %q is not an absolute http(s) url", raw)
+	}
+	for _, seg := range strings.Split(u.Path, "/") {
+		if seg == "." || seg == ".." {
+			return "", fmt.Errorf("rendered url %q contains dot segments", raw)
+		}
+	}
+
+	for _, p := range dest.AllowedURLs {
+		re, err := urlPattern(p)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(raw) {
+			return raw, nil
+		}
+	}
+	return "", fmt.Errorf("rendered url %q is not in allowed_urls", raw)
+}
+
+func urlPattern(p string) (*regexp.Regexp, error) {
+	if re, ok := urlPatterns.Load(p); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := config.CompileURLPattern(p)
+	if err != nil {
+		return nil, err
+	}
+	urlPatterns.Store(p, re)
+	return re, nil
+}
@@ -0,0 +1,139 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"strings"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/payload"
+)
+
+// encodeXML renders a decoded body as XML.
+//
+// Objects become nested elements (keys sorted so output is stable), arrays
+// repeat the configured item element, scalar fields listed in
+// cfg.Attributes (or prefixed with "@") are rendered as attributes on their
+// parent element instead of child elements, and "#text" becomes the
+// element's text. The document element is cfg.RootElement or else the one
+// a decoded XML body kept (payload.XMLName), "payload" otherwise; names
+// keep their namespace prefix and declarations.
+func encodeXML(v any, cfg config.XMLConfig) ([]byte, error) {
+	attrs := make(map[string]bool, len(cfg.Attributes))
+	for _, a := range cfg.Attributes {
+		attrs[a] = true
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	x := xmlWriter{enc: enc, attrs: attrs, item: xmlName(cfg.ItemElement)}
+	root := cfg.RootElement
+	if obj, ok := v.(map[string]any); ok && root == "" {
+		root, _ = obj[payload.XMLName].(string)
+	}
+	if root == "" {
+		root = "payload"
+	}
+	if err := x.element(xmlName(root), v); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type xmlWriter struct {
+	enc   *xml.Encoder
+	attrs map[string]bool
+	item  string
+}
+
+func (x xmlWriter) element(name string, v any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch t := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var children []string
+		text := ""
+		for _, k := range keys {
+			if k == payload.XMLName {
+				continue
+			}
+			if s, ok := payload.Scalar(t[k]); ok && k == "#text" {
+				text = s
+				continue
+			}
+			if s, ok := payload.Scalar(t[k]); ok && (x.attrs[k] || strings.HasPrefix(k, "@")) {
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: xmlName(strings.TrimPrefix(k, "@"))}, Value: s})
+				continue
+			}
+			children = append(children, k)
+		}
+
+		if err := x.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if text != "" {
+			if err := x.enc.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+		for _, k := range children {
+			if err := x.element(xmlName(k), t[k]); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if err := x.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range t {
+			if err := x.element(x.item, item); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := x.enc.EncodeToken(start); err != nil {
+			return err
+		}
+		if s, _ := payload.Scalar(v); s != "" {
+			if err := x.enc.EncodeToken(xml.CharData(s)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return x.enc.EncodeToken(start.End())
+}
+
+// xmlName maps an arbitrary JSON key onto a valid XML element name. A
+// namespace prefix ("soap:Body") is kept.
+func xmlName(k string) string {
+	var b strings.Builder
+	for i, r := range k {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			b.WriteRune(r)
+		case i > 0 && (r == '-' || r == '.' || r == ':' || r >= '0' && r <= '9'):
+			b.WriteRune(r)
+		case i == 0 && r >= '0' && r <= '9':
+			b.WriteRune('_')
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
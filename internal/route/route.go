@@ -0,0 +1,361 @@
+// Package route selects the destinations an inbound event is delivered to.
+package route
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/payload"
+	"webhookrelay/internal/schema"
+)
+
+// Event is what conditions are evaluated against. The body is only decoded
+// when a condition looks into it.
+type Event struct {
+	RequestID string
+	Method    string
+	Query     url.Values
+	Header    http.Header
+	Body      []byte
+	// Time is when the event arrived; schedules are evaluated against it.
+	Time time.Time
+	// Provider and EventType are set for relays with detect_provider.
+	Provider  string
+	EventType string
+
+	decoded any
+	format  string
+	once    sync.Once
+}
+
+// NewEvent returns the event for req with the (already read) body.
+func NewEvent(req *http.Request, reqID string, body []byte) *Event {
+	return &Event{RequestID: reqID, Method: req.Method, Query: req.URL.Query(), Header: req.Header, Body: body, Time: time.Now()}
+}
+
+func (ev *Event) decode() {
+	ev.once.Do(func() {
+		if len(ev.Body) == 0 {
+			return
+		}
+		if v, f, err := payload.Decode(ev.Header.Get("Content-Type"), ev.Body); err == nil {
+			ev.decoded, ev.format = v, f
+		}
+	})
+}
+
+func (ev *Event) field(path string) (any, bool) {
+	ev.decode()
+	segs, err := payload.SplitPath(path)
+	if err != nil || ev.decoded == nil {
+		return nil, false
+	}
+	return payload.Get(ev.decoded, segs)
+}
+
+// validates reports whether the body is structured (not raw text) and
+// satisfies the schema at path.
+func (ev *Event) validates(path string) bool {
+	ev.decode()
+	if ev.decoded == nil || ev.format == payload.FormatRaw {
+		return false
+	}
+	s, err := schema.Load(path)
+	return err == nil && s.Valid(ev.decoded)
+}
+
+// Select returns the destinations of the first route whose match holds and
+// its name, or dests (the default route) and "" when none does.
+func Select(routes []config.RouteConfig, dests []config.DestinationConfig, ev *Event) (string, []config.DestinationConfig) {
+	for i, r := range routes {
+		if Match(r.Match, ev) {
+			name := r.Name
+			if name == "" {
+				name = fmt.Sprintf("routes[%d]", i)
+			}
+			return name, r.Destinations
+		}
+	}
+	return "", dests
+}
+
+// Destinations returns the destinations ev goes to: those without a
+// condition, those whose When matches and, when no When matched, the
+// fallbacks. Of those with a weight, only the one split picks remains, and
+// sampled destinations are kept for their share of events. admits, if not
+// nil, tells which destinations of hash sets may take an event by its key.
+func Destinations(dests []config.DestinationConfig, ev *Event, admits func(d config.DestinationConfig, key string) bool) []config.DestinationConfig {
+	var out, fallbacks []config.DestinationConfig
+	matched := false
+	for _, d := range dests {
+		switch {
+		case d.Fallback:
+			fallbacks = append(fallbacks, d)
+		case d.When == nil:
+			out = append(out, d)
+		case Match(*d.When, ev):
+			out = append(out, d)
+			matched = true
+		}
+	}
+	if !matched {
+		out = append(out, fallbacks...)
+	}
+	return sample(split(stick(out, ev, admits), ev.RequestID), ev.RequestID)
+}
+
+// stick keeps one destination of each hash set: the one whose URL scores
+// highest for the event's key (rendezvous hashing), so that all events of
+// an entity reach the same destination and removing a destination only
+// moves the entities it had. Events without the key are spread by request
+// ID. Destinations admits turns down for the key are passed over, unless
+// the set has no other.
+func stick(dests []config.DestinationConfig, ev *Event, admits func(config.DestinationConfig, string) bool) []config.DestinationConfig {
+	best := map[string]int{}
+	up := map[string]bool{}
+	var scores []uint64
+	for i, d := range dests {
+		score := uint64(0)
+		if d.HashSet != "" {
+			key := hashKey(*d.HashKey, ev)
+			score = mix(hash64(key) ^ hash64(d.URL))
+			ok := admits == nil || admits(d, key)
+			if j, seen := best[d.HashSet]; !seen || ok && !up[d.HashSet] || ok == up[d.HashSet] && score > scores[j] {
+				best[d.HashSet], up[d.HashSet] = i, ok
+			}
+		}
+		scores = append(scores, score)
+	}
+	if len(best) == 0 {
+		return dests
+	}
+	out := make([]config.DestinationConfig, 0, len(dests))
+	for i, d := range dests {
+		if d.HashSet == "" || best[d.HashSet] == i {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix is the splitmix64 finalizer; FNV alone spreads keys that differ in
+// their last bytes poorly over the high bits compared here.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// hashKey returns the entity key of ev, or its request ID when ev has none.
+func hashKey(k config.HashKeyConfig, ev *Event) string {
+	if k.Header != "" {
+		if v := ev.Header.Get(k.Header); v != "" {
+			return v
+		}
+		return ev.RequestID
+	}
+	if v, ok := ev.field(k.Field); ok {
+		if s, ok := payload.Scalar(v); ok && s != "" {
+			return s
+		}
+	}
+	return ev.RequestID
+}
+
+// sample drops destinations whose sample rate leaves the event out. Like
+// split it hashes the request ID, together with the destination URL so that
+// destinations are sampled independently.
+func sample(dests []config.DestinationConfig, requestID string) []config.DestinationConfig {
+	out := dests[:0:0]
+	for _, d := range dests {
+		if d.SampleRate == nil {
+			out = append(out, d)
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(requestID))
+		h.Write([]byte{0})
+		h.Write([]byte(d.URL))
+		if float64(h.Sum64())/float64(math.MaxUint64) < *d.SampleRate {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// split keeps one of the weighted destinations, chosen by hashing the
+// request ID so that an event is always assigned the same way.
+func split(dests []config.DestinationConfig, requestID string) []config.DestinationConfig {
+	total := 0
+	for _, d := range dests {
+		total += d.Weight
+	}
+	if total == 0 {
+		return dests
+	}
+	h := fnv.New64a()
+	h.Write([]byte(requestID))
+	n := int(h.Sum64() % uint64(total))
+	out := make([]config.DestinationConfig, 0, len(dests))
+	for _, d := range dests {
+		if d.Weight == 0 {
+			out = append(out, d)
+			continue
+		}
+		if n >= 0 && n < d.Weight {
+			out = append(out, d)
+		}
+		n -= d.Weight
+	}
+	return out
+}
+
+// Match reports whether ev satisfies every condition of m.
+func Match(m config.MatchConfig, ev *Event) bool {
+	if len(m.Methods) > 0 && !slices.Contains(m.Methods, ev.Method) {
+		return false
+	}
+	if m.Schedule != nil && !inSchedule(*m.Schedule, ev.Time) {
+		return false
+	}
+	if len(m.Providers) > 0 && !slices.Contains(m.Providers, ev.Provider) {
+		return false
+	}
+	if len(m.EventTypes) > 0 && !matchValues([]string{ev.EventType}, m.EventTypes) {
+		return false
+	}
+	for name, accepted := range m.Query {
+		if !matchValues(ev.Query[name], accepted) {
+			return false
+		}
+	}
+	for name, accepted := range m.Headers {
+		if !matchValues(ev.Header.Values(name), accepted) {
+			return false
+		}
+	}
+	for name, expr := range m.HeaderRegex {
+		if !matchRegex(ev.Header.Values(name), expr) {
+			return false
+		}
+	}
+	for path, accepted := range m.Fields {
+		v, ok := ev.field(path)
+		if !ok || !matchValues(fieldValues(v), accepted) {
+			return false
+		}
+	}
+	for path, expr := range m.FieldRegex {
+		v, ok := ev.field(path)
+		if !ok || !matchRegex(fieldValues(v), expr) {
+			return false
+		}
+	}
+	if m.Schema != "" && !ev.validates(m.Schema) {
+		return false
+	}
+	return true
+}
+
+// fieldValues renders a body field as text: a scalar gives one value, an
+// array its scalar elements, and an object a single empty value so that
+// "*" still sees it as present.
+func fieldValues(v any) []string {
+	if arr, ok := v.([]any); ok {
+		var out []string
+		for _, e := range arr {
+			if s, ok := payload.Scalar(e); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	if s, ok := payload.Scalar(v); ok {
+		return []string{s}
+	}
+	return []string{""}
+}
+
+func matchValues(values, accepted []string) bool {
+	for _, a := range accepted {
+		for _, v := range values {
+			if a == "*" || a == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchRegex(values []string, expr string) bool {
+	re := compile(expr)
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSchedule reports whether t falls in the window of sc.
+func inSchedule(sc config.ScheduleConfig, t time.Time) bool {
+	t = t.In(location(sc.Timezone))
+	from, _ := config.ScheduleMinutes(sc.From)
+	to, _ := config.ScheduleMinutes(sc.To)
+	now := t.Hour()*60 + t.Minute()
+	onDay := func(d time.Weekday) bool {
+		return len(sc.Days) == 0 || slices.Contains(sc.Days, config.ScheduleDays[d])
+	}
+	if from <= to {
+		return onDay(t.Weekday()) && now >= from && now < to
+	}
+	// The window wraps past midnight: it is either the evening part of
+	// today's window or the morning part of yesterday's.
+	return (now >= from && onDay(t.Weekday())) || (now < to && onDay((t.Weekday()+6)%7))
+}
+
+var locationCache sync.Map // name -> *time.Location
+
+// location returns the named time zone; config validation has already
+// rejected unknown ones.
+func location(name string) *time.Location {
+	if loc, ok := locationCache.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc = time.UTC
+	}
+	locationCache.Store(name, loc)
+	return loc
+}
+
+var regexCache sync.Map // expr -> *regexp.Regexp
+
+// compile returns the compiled expression; config validation has already
+// rejected invalid ones.
+func compile(expr string) *regexp.Regexp {
+	if re, ok := regexCache.Load(expr); ok {
+		return re.(*regexp.Regexp)
+	}
+	re := regexp.MustCompile(expr)
+	regexCache.Store(expr, re)
+	return re
+}
@@ -0,0 +1,92 @@
+// Package schema compiles the JSON Schema files that route conditions
+// validate event bodies against.
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	s *jsonschema.Schema
+}
+
+var cache sync.Map // path -> *Schema
+
+// Load compiles the schema file at path (drafts 4 to 2020-12, chosen by its
+// $schema; 2020-12 without one). Schemas are compiled once per path. $refs
+// may point at other local files; remote URLs are not fetched.
+func Load(path string) (*Schema, error) {
+	if s, ok := cache.Load(path); ok {
+		return s.(*Schema), nil
+	}
+	c := jsonschema.NewCompiler()
+	compiled, err := c.Compile(path)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", path, err)
+	}
+	s, _ := cache.LoadOrStore(path, &Schema{s: compiled})
+	return s.(*Schema), nil
+}
+
+// Valid reports whether v, a decoded body (see package payload), satisfies
+// the schema.
+func (s *Schema) Valid(v any) bool {
+	return s.s.Validate(v) == nil
+}
+
+// Compile compiles a schema held in memory; name stands for it in errors and
+// in the $refs of other schemas.
+func Compile(name string, data []byte) (*Schema, error) {
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource(name, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("schema %s: %w", name, err)
+	}
+	compiled, err := c.Compile(name)
+	if err != nil {
+		return nil, fmt.Errorf("schema %s: %w", name, err)
+	}
+	return &Schema{s: compiled}, nil
+}
+
+// Error is one reason a value does not satisfy a schema.
+type Error struct {
+	// Location is the JSON pointer of the offending value, "" for the
+	// value itself.
+	Location string
+	// Keyword is the absolute location of the schema keyword that failed,
+	// e.g. "schema.json#/$defs/Item/additionalProperties".
+	Keyword string
+	Message string
+}
+
+// Errors lists why v does not satisfy the schema, most specific first; it
+// is empty when v does.
+func (s *Schema) Errors(v any) []Error {
+	err := s.s.Validate(v)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		if err != nil {
+			return []Error{{Message: err.Error()}}
+		}
+		return nil
+	}
+	var out []Error
+	var leaves func(*jsonschema.ValidationError)
+	leaves = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			out = append(out, Error{Location: e.InstanceLocation, Keyword: e.AbsoluteKeywordLocation, Message: e.Message})
+			return
+		}
+		for _, c := range e.Causes {
+			leaves(c)
+		}
+	}
+	leaves(ve)
+	return out
+}
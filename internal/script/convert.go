@@ -0,0 +1,110 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toLua converts a payload value to Lua. Arrays get arrayMeta so that empty
+// arrays survive the round trip; JSON null becomes the null sentinel.
+func (v *vm) toLua(x any) lua.LValue {
+	switch x := x.(type) {
+	case nil:
+		return v.null
+	case string:
+		return lua.LString(x)
+	case bool:
+		return lua.LBool(x)
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return lua.LString(x.String())
+		}
+		return lua.LNumber(f)
+	case float64:
+		return lua.LNumber(x)
+	case map[string]any:
+		t := v.L.NewTable()
+		for k, e := range x {
+			t.RawSetString(k, v.toLua(e))
+		}
+		return t
+	case []any:
+		t := v.L.NewTable()
+		for _, e := range x {
+			t.Append(v.toLua(e))
+		}
+		v.L.SetMetatable(t, v.arrayMeta)
+		return t
+	default:
+		return lua.LString(fmt.Sprint(x))
+	}
+}
+
+// fromLua converts a Lua value back to a payload value. Tables whose keys
+// are exactly 1..n (or empty tables carrying arrayMeta) become arrays, other
+// tables objects.
+func (v *vm) fromLua(lv lua.LValue, depth int) (any, error) {
+	if depth > 100 {
+		return nil, fmt.Errorf("value nested too deeply")
+	}
+	switch lv := lv.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LString:
+		return string(lv), nil
+	case lua.LBool:
+		return bool(lv), nil
+	case lua.LNumber:
+		return json.Number(strconv.FormatFloat(float64(lv), 'f', -1, 64)), nil
+	case *lua.LUserData:
+		if lv == v.null {
+			return nil, nil
+		}
+	case *lua.LTable:
+		n := lv.MaxN()
+		keys := 0
+		lv.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+		if (n > 0 && keys == n) || (keys == 0 && v.L.GetMetatable(lv) == v.arrayMeta) {
+			arr := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				e, err := v.fromLua(lv.RawGetInt(i), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, e)
+			}
+			return arr, nil
+		}
+		obj := make(map[string]any, keys)
+		var err error
+		lv.ForEach(func(k, e lua.LValue) {
+			if err != nil {
+				return
+			}
+			obj[k.String()], err = v.fromLua(e, depth+1)
+		})
+		return obj, err
+	}
+	return nil, fmt.Errorf("unsupported value of type %s", lv.Type())
+}
+
+// headersToLua exposes headers as name -> value, repeated values joined
+// with ", ".
+func (v *vm) headersToLua(h map[string][]string) *lua.LTable {
+	t := v.L.NewTable()
+	names := make([]string, 0, len(h))
+	for k := range h {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		t.RawSetString(k, lua.LString(strings.Join(h[k], ", ")))
+	}
+	return t
+}
@@ -0,0 +1,388 @@
+// Package script runs per-relay Lua scripts (gopher-lua) over inbound
+// events: a lighter-weight alternative to WASM plugins for filtering,
+// routing and small payload edits.
+//
+// A script defines handle(req). It may edit req.headers and req.body in
+// place, call reject(status, message) or drop(reason), and return the
+// destinations to deliver to (see Run).
+package script
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/payload"
+)
+
+// Script is a compiled relay script. States are pooled; a state is used by
+// one event at a time.
+type Script struct {
+	path    string
+	timeout time.Duration
+	proto   *lua.FunctionProto
+	log     *slog.Logger
+	pool    sync.Pool
+}
+
+// Input is the event passed to handle.
+type Input struct {
+	Relay        string
+	RequestID    string
+	Request      *http.Request
+	Params       map[string]string
+	Body         []byte
+	Destinations []config.DestinationConfig
+}
+
+// Result is what the script decided. Nil Header and Body mean unchanged;
+// ContentType is set when the body changed format. Destinations holds the
+// selected indexes into Input.Destinations, nil meaning all of them.
+type Result struct {
+	Reject  bool
+	Status  int
+	Message string
+
+	Drop   bool
+	Reason string
+
+	Header       http.Header
+	Body         []byte
+	ContentType  string
+	Destinations []int
+}
+
+type vm struct {
+	L         *lua.LState
+	handle    *lua.LFunction
+	null      *lua.LUserData
+	arrayMeta *lua.LTable
+
+	relay    string
+	decision Result
+}
+
+// Load compiles the script at cfg.Path and checks that it defines handle.
+func Load(cfg config.ScriptConfig, log *slog.Logger) (*Script, error) {
+	src, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("script: %w", err)
+	}
+	chunk, err := parse.Parse(bytes.NewReader(src), cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %w", cfg.Path, err)
+	}
+	proto, err := lua.Compile(chunk, cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("script %s: %w", cfg.Path, err)
+	}
+	s := &Script{path: cfg.Path, timeout: cfg.Timeout(), proto: proto, log: log}
+	v, err := s.newVM()
+	if err != nil {
+		return nil, err
+	}
+	s.pool.Put(v)
+	return s, nil
+}
+
+// LoadAll loads the script of every relay that has one, keyed by relay ID.
+func LoadAll(relays []config.ResolvedRelay, log *slog.Logger) (map[string]*Script, error) {
+	out := map[string]*Script{}
+	for _, r := range relays {
+		if r.Script.Path == "" {
+			continue
+		}
+		s, err := Load(r.Script, log)
+		if err != nil {
+			return nil, err
+		}
+		out[r.ID] = s
+	}
+	return out, nil
+}
+
+// newVM creates a state with the base, table, string and math libraries
+// (without file loading) and runs the script's top level.
+func (s *Script) newVM() (*vm, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 200})
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		if err := L.CallByParam(lua.P{Fn: L.NewFunction(lib.open), Protect: true}, lua.LString(lib.name)); err != nil {
+			L.Close()
+			return nil, fmt.Errorf("script %s: %w", s.path, err)
+		}
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	v := &vm{L: L, null: L.NewUserData(), arrayMeta: L.NewTable()}
+	L.SetGlobal("null", v.null)
+	L.SetGlobal("reject", L.NewFunction(v.reject))
+	L.SetGlobal("drop", L.NewFunction(v.drop))
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		s.log.Info("script log", "relay", v.relay, "message", luaArgs(L))
+		return 0
+	}))
+	L.SetGlobal("print", L.GetGlobal("log"))
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, fmt.Errorf("script %s: %w", s.path, err)
+	}
+	handle, ok := L.GetGlobal("handle").(*lua.LFunction)
+	if !ok {
+		L.Close()
+		return nil, fmt.Errorf("script %s: does not define function handle(req)", s.path)
+	}
+	v.handle = handle
+	return v, nil
+}
+
+func luaArgs(L *lua.LState) string {
+	parts := make([]string, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// reject(status, message) answers the sender with status (default 400).
+func (v *vm) reject(L *lua.LState) int {
+	status := L.OptInt(1, http.StatusBadRequest)
+	if status < 400 || status > 599 {
+		L.ArgError(1, "status must be 4xx or 5xx")
+	}
+	v.decision = Result{Reject: true, Status: status, Message: L.OptString(2, http.StatusText(status))}
+	return 0
+}
+
+// drop(reason) accepts the event without forwarding it.
+func (v *vm) drop(L *lua.LState) int {
+	v.decision = Result{Drop: true, Reason: L.OptString(1, "")}
+	return 0
+}
+
+// Run calls handle for one event. handle returns nothing to deliver to
+// every destination, or a destination selector or list of selectors: a
+// 1-based index, or a string matched against the destination's description
+// or url. Selecting no destination drops the event.
+func (s *Script) Run(ctx context.Context, in Input) (Result, error) {
+	v, _ := s.pool.Get().(*vm)
+	if v == nil {
+		var err error
+		if v, err = s.newVM(); err != nil {
+			return Result{}, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	v.L.SetContext(ctx)
+	v.relay, v.decision = in.Relay, Result{}
+
+	res, err := s.call(v, in)
+	v.L.RemoveContext()
+	if err != nil {
+		// The state may be left mid-call; do not reuse it.
+		v.L.Close()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return res, fmt.Errorf("script %s: timed out after %s", s.path, s.timeout)
+		}
+		return res, fmt.Errorf("script %s: %w", s.path, err)
+	}
+	s.pool.Put(v)
+	return res, nil
+}
+
+func (s *Script) call(v *vm, in Input) (Result, error) {
+	L := v.L
+	ct := in.Request.Header.Get("Content-Type")
+
+	// JSON and form bodies are tables; everything else is a string.
+	var body lua.LValue = lua.LString(in.Body)
+	format := payload.FormatRaw
+	if len(in.Body) > 0 {
+		if decoded, f, err := payload.Decode(ct, in.Body); err == nil && (f == payload.FormatJSON || f == payload.FormatForm) {
+			body, format = v.toLua(decoded), f
+		}
+	}
+	var before any
+	if format != payload.FormatRaw {
+		var err error
+		if before, err = v.fromLua(body, 0); err != nil {
+			return Result{}, err
+		}
+	}
+
+	dests := L.NewTable()
+	for _, d := range in.Destinations {
+		t := L.NewTable()
+		t.RawSetString("url", lua.LString(d.URL))
+		t.RawSetString("type", lua.LString(d.Type))
+		t.RawSetString("description", lua.LString(d.Description))
+		dests.Append(t)
+	}
+
+	req := L.NewTable()
+	req.RawSetString("relay", lua.LString(in.Relay))
+	req.RawSetString("request_id", lua.LString(in.RequestID))
+	req.RawSetString("method", lua.LString(in.Request.Method))
+	req.RawSetString("path", lua.LString(in.Request.URL.Path))
+	req.RawSetString("query", lua.LString(in.Request.URL.RawQuery))
+	params := L.NewTable()
+	for k, val := range in.Params {
+		params.RawSetString(k, lua.LString(val))
+	}
+	req.RawSetString("params", params)
+	req.RawSetString("headers", v.headersToLua(in.Request.Header))
+	req.RawSetString("format", lua.LString(format))
+	req.RawSetString("body", body)
+	req.RawSetString("destinations", dests)
+
+	if err := L.CallByParam(lua.P{Fn: v.handle, NRet: 1, Protect: true}, req); err != nil {
+		return Result{}, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	if v.decision.Reject || v.decision.Drop {
+		return v.decision, nil
+	}
+	var res Result
+	var err error
+	if res.Destinations, err = selectDestinations(ret, in.Destinations); err != nil {
+		return Result{}, err
+	}
+	if res.Destinations != nil && len(res.Destinations) == 0 {
+		return Result{Drop: true, Reason: "no destinations selected"}, nil
+	}
+	if res.Header, err = changedHeaders(req.RawGetString("headers"), in.Request.Header); err != nil {
+		return Result{}, err
+	}
+
+	switch after := req.RawGetString("body").(type) {
+	case *lua.LNilType:
+		if len(in.Body) > 0 {
+			res.Body = []byte{}
+		}
+	case lua.LString:
+		if format != payload.FormatRaw || string(after) != string(in.Body) {
+			res.Body = []byte(after)
+		}
+	default:
+		out, err := v.fromLua(after, 0)
+		if err != nil {
+			return Result{}, fmt.Errorf("req.body: %w", err)
+		}
+		if format != payload.FormatRaw && reflect.DeepEqual(out, before) {
+			break
+		}
+		if format == payload.FormatForm {
+			res.Body, err = payload.EncodeForm(out)
+		} else {
+			res.Body, err = payload.EncodeJSON(out)
+			if format == payload.FormatRaw {
+				res.ContentType = payload.ContentType(payload.FormatJSON)
+			}
+		}
+		if err != nil {
+			return Result{}, fmt.Errorf("req.body: %w", err)
+		}
+	}
+	return res, nil
+}
+
+func selectDestinations(ret lua.LValue, dests []config.DestinationConfig) ([]int, error) {
+	var sels []lua.LValue
+	switch ret := ret.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case *lua.LTable:
+		for i := 1; i <= ret.Len(); i++ {
+			sels = append(sels, ret.RawGetInt(i))
+		}
+	default:
+		sels = []lua.LValue{ret}
+	}
+
+	picked := make([]bool, len(dests))
+	for _, sel := range sels {
+		found := false
+		switch sel := sel.(type) {
+		case lua.LNumber:
+			if i := int(sel); float64(i) == float64(sel) && i >= 1 && i <= len(dests) {
+				picked[i-1], found = true, true
+			}
+		case lua.LString:
+			for i, d := range dests {
+				if string(sel) == d.URL || (d.Description != "" && string(sel) == d.Description) {
+					picked[i], found = true, true
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("handle returned unknown destination %s", sel.String())
+		}
+	}
+	out := []int{}
+	for i, ok := range picked {
+		if ok {
+			out = append(out, i)
+		}
+	}
+	return out, nil
+}
+
+// changedHeaders returns the headers after the script, or nil when the
+// script left them as they were. Unchanged headers keep all their values.
+func changedHeaders(lv lua.LValue, orig http.Header) (http.Header, error) {
+	t, ok := lv.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("req.headers must be a table")
+	}
+	out := http.Header{}
+	changed := false
+	var err error
+	t.ForEach(func(k, val lua.LValue) {
+		s, ok := val.(lua.LString)
+		if !ok {
+			err = fmt.Errorf("req.headers[%s] must be a string", k.String())
+			return
+		}
+		name := http.CanonicalHeaderKey(k.String())
+		if vals, ok := orig[name]; ok && strings.Join(vals, ", ") == string(s) {
+			out[name] = vals
+			return
+		}
+		out.Set(name, string(s))
+		changed = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !changed && len(out) == len(orig) {
+		return nil, nil
+	}
+	return out, nil
+}
@@ -0,0 +1,350 @@
+// Package sentry reports dead-lettered deliveries and panics to Sentry
+// through its envelope endpoint. Events are sent in the background; a full
+// queue drops them rather than slowing deliveries down. A nil *Reporter
+// reports nothing.
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/redact"
+	"webhookrelay/internal/store"
+)
+
+// maxBody caps the event payload attached to a report.
+const maxBody = 16 << 10
+
+// sendTimeout bounds each call to Sentry.
+const sendTimeout = 10 * time.Second
+
+type Reporter struct {
+	log         *slog.Logger
+	client      *http.Client
+	dsn         string
+	endpoint    string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+
+	events chan *event
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New returns nil when cfg has no DSN. The DSN is assumed validated.
+func New(cfg config.SentryConfig, log *slog.Logger) (*Reporter, error) {
+	if cfg.DSN == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("sentry: %w", err)
+	}
+	path := strings.Trim(u.Path, "/")
+	prefix, project := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	auth := "Sentry sentry_version=7, sentry_client=webhookrelay, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	host, _ := os.Hostname()
+	r := &Reporter{
+		log:         log,
+		client:      &http.Client{Timeout: sendTimeout},
+		dsn:         cfg.DSN,
+		endpoint:    u.Scheme + "://" + u.Host + prefix + "/api/" + project + "/envelope/",
+		auth:        auth,
+		environment: cfg.Environment,
+		release:     cfg.Release,
+		serverName:  host,
+		events:      make(chan *event, 100),
+		stop:        make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+// Close sends the queued events, waiting up to ctx's deadline.
+func (r *Reporter) Close(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		r.log.Warn("sentry: events left unsent on shutdown", "count", len(r.events))
+	}
+}
+
+func (r *Reporter) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case ev := <-r.events:
+			r.send(ev)
+		case <-r.stop:
+			for {
+				select {
+				case ev := <-r.events:
+					r.send(ev)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// ForwardFailed reports a job dead-lettered after its delivery d failed.
+// Events are grouped per relay, destination and reason. The job's body and
+// headers are sent with its relay's redact rules applied.
+func (r *Reporter) ForwardFailed(job store.Job, d store.Delivery, reason string) {
+	if r == nil {
+		return
+	}
+	ev := r.newEvent("error")
+	ev.Message = &message{Formatted: fmt.Sprintf("forward to %s failed: %s", d.DestURL, firstNonEmpty(d.Error, reason))}
+	ev.Fingerprint = []string{"forward", job.Relay, d.DestURL, reason}
+	ev.Tags = map[string]string{
+		"relay":       job.Relay,
+		"destination": d.DestURL,
+		"reason":      reason,
+		"outcome":     d.Outcome,
+	}
+	if d.Status != 0 {
+		ev.Tags["status"] = fmt.Sprint(d.Status)
+	}
+	if job.Provider != "" {
+		ev.Tags["provider"] = job.Provider
+	}
+	ev.Extra = map[string]any{
+		"request_id": job.RequestID,
+		"job_id":     job.ID,
+		"error":      d.Error,
+		"latency_ms": d.LatencyMS,
+		"headers":    redact.Header(job.Header, job.Redact),
+	}
+	if job.EventType != "" {
+		ev.Extra["event_type"] = job.EventType
+	}
+	body := redact.Body(job.Body, job.Redact)
+	if len(body) > maxBody {
+		body = body[:maxBody]
+		ev.Extra["body_truncated"] = true
+	}
+	if utf8.Valid(body) {
+		ev.Extra["body"] = string(body)
+	} else {
+		ev.Extra["body"] = fmt.Sprintf("(%d bytes of binary data)", len(body))
+	}
+	r.queue(ev)
+}
+
+// Recover, deferred, reports a panic of the calling goroutine, waits for
+// the report to be sent, and panics again. where names what panicked, such
+// as "forward worker". Panics aborting an HTTP handler on purpose are not
+// reported.
+func (r *Reporter) Recover(where string) {
+	if r == nil {
+		return
+	}
+	p := recover()
+	if p == nil {
+		return
+	}
+	if p != http.ErrAbortHandler {
+		ev := r.newEvent("fatal")
+		ev.Tags = map[string]string{"component": where}
+		ev.Exception = &exceptions{Values: []exception{{
+			Type:  "panic",
+			Value: fmt.Sprint(p),
+			// Skip Callers, stacktrace, Recover and runtime.gopanic.
+			Stacktrace: stacktrace(4),
+			Mechanism:  &mechanism{Type: "recover", Handled: false},
+		}}}
+		r.send(ev)
+	}
+	panic(p)
+}
+
+func (r *Reporter) newEvent(level string) *event {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return &event{
+		EventID:     hex.EncodeToString(id[:]),
+		Timestamp:   time.Now().UTC(),
+		Platform:    "go",
+		Level:       level,
+		Logger:      "webhookrelay",
+		ServerName:  r.serverName,
+		Environment: r.environment,
+		Release:     r.release,
+	}
+}
+
+func (r *Reporter) queue(ev *event) {
+	select {
+	case r.events <- ev:
+	default:
+		r.log.Warn("sentry: queue full, dropping event", "message", ev.Message.Formatted)
+	}
+}
+
+func (r *Reporter) send(ev *event) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		r.log.Error("sentry: encode event failed", "error", err)
+		return
+	}
+	header, _ := json.Marshal(map[string]any{"event_id": ev.EventID, "dsn": r.dsn, "sent_at": time.Now().UTC()})
+	item, _ := json.Marshal(map[string]any{"type": "event", "length": len(payload)})
+	var b bytes.Buffer
+	b.Write(header)
+	b.WriteByte('\n')
+	b.Write(item)
+	b.WriteByte('\n')
+	b.Write(payload)
+	b.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &b)
+	if err != nil {
+		r.log.Error("sentry: send failed", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		r.log.Error("sentry: send failed", "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		r.log.Error("sentry: send failed", "status", resp.StatusCode, "response", string(msg))
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+}
+
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     *message          `json:"message,omitempty"`
+	Exception   *exceptions       `json:"exception,omitempty"`
+	Fingerprint []string          `json:"fingerprint,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+}
+
+type message struct {
+	Formatted string `json:"formatted"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string     `json:"type"`
+	Value      string     `json:"value"`
+	Stacktrace *frames    `json:"stacktrace,omitempty"`
+	Mechanism  *mechanism `json:"mechanism,omitempty"`
+}
+
+type mechanism struct {
+	Type    string `json:"type"`
+	Handled bool   `json:"handled"`
+}
+
+type frames struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// stacktrace returns the caller's stack, skipping skip frames, oldest
+// first as Sentry expects.
+func stacktrace(skip int) *frames {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	it := runtime.CallersFrames(pcs[:n])
+	var fs []frame
+	for {
+		f, more := it.Next()
+		module, function := splitFunction(f.Function)
+		fs = append(fs, frame{
+			Function: function,
+			Module:   module,
+			Filename: f.File[strings.LastIndex(f.File, "/")+1:],
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    strings.HasPrefix(module, "webhookrelay"),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(fs)-1; i < j; i, j = i+1, j-1 {
+		fs[i], fs[j] = fs[j], fs[i]
+	}
+	return &frames{Frames: fs}
+}
+
+// splitFunction turns "webhookrelay/internal/relay.(*Forwarder).deliver"
+// into its package and function.
+func splitFunction(name string) (module, function string) {
+	slash := strings.LastIndex(name, "/")
+	if dot := strings.Index(name[slash+1:], "."); dot >= 0 {
+		return name[:slash+1+dot], name[slash+1+dot+1:]
+	}
+	return "", name
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
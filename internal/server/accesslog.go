@@ -0,0 +1,98 @@
+package server
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"webhookrelay/internal/sentry"
+)
+
+// accessLog wraps the handler of a relay listener to log every request it
+// answers, including those no relay took (404, 405) or that a relay
+// rejected. A nil logger logs nothing.
+func accessLog(log *slog.Logger, listener string, next http.Handler) http.Handler {
+	if log == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		body := &countingBody{ReadCloser: req.Body}
+		req.Body = body
+		aw := &accessWriter{statusWriter: statusWriter{ResponseWriter: w}}
+		next.ServeHTTP(aw, req)
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+
+		// Requests rejected before their body was read still show its
+		// announced size.
+		reqBytes := body.n
+		if reqBytes == 0 && req.ContentLength > 0 {
+			reqBytes = req.ContentLength
+		}
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		attrs := []slog.Attr{
+			slog.String("listener", listener),
+			slog.String("remote_ip", host),
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.String("proto", req.Proto),
+			slog.Int("status", aw.status),
+			slog.Int64("request_bytes", reqBytes),
+			slog.Int64("response_bytes", aw.n),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.String("user_agent", req.UserAgent()),
+		}
+		if v := req.Header.Get("X-Forwarded-For"); v != "" {
+			attrs = append(attrs, slog.String("forwarded_for", v))
+		}
+		if v := aw.Header().Get("X-Relay-Request-Id"); v != "" {
+			attrs = append(attrs, slog.String("request_id", v))
+		}
+		if v := aw.Header().Get("X-Relay-Dropped"); v != "" {
+			attrs = append(attrs, slog.String("dropped", v))
+		}
+		log.LogAttrs(req.Context(), slog.LevelInfo, "access", attrs...)
+	})
+}
+
+// reportPanics wraps the handler of a relay listener to report its panics
+// to Sentry; net/http still logs them and closes the connection.
+func reportPanics(rep *sentry.Reporter, listener string, next http.Handler) http.Handler {
+	if rep == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		defer rep.Recover("listener " + listener)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// accessWriter counts the response bytes.
+type accessWriter struct {
+	statusWriter
+	n int64
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	n, err := w.statusWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
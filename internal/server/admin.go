@@ -0,0 +1,890 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/loglevel"
+	"webhookrelay/internal/store"
+)
+
+// adminHandler serves the admin API on the admin listener, metrics at
+// metricsPath unless it is empty, and the pprof and expvar endpoints if
+// debug is set.
+func (s *Server) adminHandler(token, metricsPath string, debug bool) http.Handler {
+	mux := http.NewServeMux()
+	if metricsPath != "" {
+		mux.Handle("GET "+metricsPath, s.metrics.Handler())
+	}
+	mux.HandleFunc("GET /admin/{$}", s.adminDashboard)
+	mux.Handle("GET /admin", http.RedirectHandler("/admin/", http.StatusMovedPermanently))
+	mux.HandleFunc("GET /admin/stats", s.adminStats)
+	mux.HandleFunc("GET /admin/relays", s.adminListRelays)
+	mux.HandleFunc("POST /admin/relays", s.adminCreateRelay)
+	mux.HandleFunc("PUT /admin/relays/{relay}", s.adminUpdateRelay)
+	mux.HandleFunc("DELETE /admin/relays/{relay}", s.adminDeleteRelay)
+	mux.HandleFunc("GET /admin/relays/{relay}/stats", s.adminRelayStats)
+	mux.HandleFunc("GET /admin/relays/{relay}/status", s.adminRelayStatus)
+	mux.HandleFunc("POST /admin/relays/{relay}/stop", s.adminSetRelay(true))
+	mux.HandleFunc("POST /admin/relays/{relay}/start", s.adminSetRelay(false))
+	mux.HandleFunc("POST /admin/relays/{relay}/debug", s.adminStartDebug)
+	mux.HandleFunc("DELETE /admin/relays/{relay}/debug", s.adminStopDebug)
+	mux.HandleFunc("GET /admin/destinations/health", s.adminDestinationHealth)
+	mux.HandleFunc("GET /admin/destinations/outliers", s.adminDestinationOutliers)
+	mux.HandleFunc("GET /admin/deliveries", s.adminListDeliveries)
+	mux.HandleFunc("GET /admin/requests/{request_id}", s.adminGetRequest)
+	mux.HandleFunc("GET /admin/tail", s.adminTail)
+	mux.HandleFunc("GET /admin/events", s.adminListEvents)
+	mux.HandleFunc("GET /admin/events/{id}", s.adminGetEvent)
+	mux.HandleFunc("POST /admin/events/{id}/replay", s.adminReplayEvent)
+	mux.HandleFunc("GET /admin/listeners", s.adminListListeners)
+	mux.HandleFunc("POST /admin/listeners/{name}/close", s.adminSetListener(false))
+	mux.HandleFunc("POST /admin/listeners/{name}/open", s.adminSetListener(true))
+	mux.HandleFunc("GET /admin/config/schema", s.adminConfigSchema)
+	mux.HandleFunc("GET /admin/log-level", s.adminGetLogLevel)
+	mux.HandleFunc("PUT /admin/log-level", s.adminSetLogLevel)
+	mux.HandleFunc("DELETE /admin/log-level", s.adminResetLogLevel)
+	if debug {
+		mux.HandleFunc("GET /debug/pprof/", pprof.Index)
+		mux.HandleFunc("GET /debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("GET /debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("GET /debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("POST /debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("GET /debug/pprof/trace", pprof.Trace)
+		mux.Handle("GET /debug/vars", expvar.Handler())
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Browsers viewing the dashboard authenticate with Basic auth, the
+		// token as password.
+		bearer := false
+		if token != "" {
+			got, basic := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "), false
+			if _, pw, ok := req.BasicAuth(); ok {
+				got, basic = pw, true
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				if req.URL.Path == "/admin/" || req.URL.Path == "/admin" {
+					w.Header().Set("WWW-Authenticate", `Basic realm="webhookrelay admin"`)
+				} else {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+				}
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			bearer = !basic
+		} else if msg := foreignHost(req); msg != "" {
+			writeJSONError(w, http.StatusForbidden, msg)
+			return
+		}
+		if msg := crossSiteChange(req, bearer); msg != "" {
+			writeJSONError(w, http.StatusForbidden, msg)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// crossSiteChange returns why req, if it changes state through the admin
+// API, may have been sent by a cross-site page, or "". Browsers resend
+// Basic credentials on their own, and reach a loopback listener without a
+// token from any page, so changes must carry X-Requested-By, which a
+// cross-site form cannot set, unless they carry the bearer token; and none
+// may come from another origin.
+func crossSiteChange(req *http.Request, bearer bool) string {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead || !strings.HasPrefix(req.URL.Path, "/admin/") {
+		return ""
+	}
+	if origin := req.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != req.Host {
+			return fmt.Sprintf("cross-origin request from %s refused", origin)
+		}
+	}
+	if !bearer && req.Header.Get("X-Requested-By") == "" {
+		return "X-Requested-By header required"
+	}
+	return ""
+}
+
+// foreignHost returns why req, on a listener without a token, may have
+// been sent by a web page, or "". Such a listener is on a loopback address
+// or a Unix socket, but a page whose host name was rebound to 127.0.0.1
+// reaches it as its own origin, with its own name as Host; so unless req
+// came over a Unix socket, it must be addressed to localhost or a loopback
+// IP.
+func foreignHost(req *http.Request) string {
+	if _, ok := req.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		host = strings.Trim(req.Host, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return ""
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.IsLoopback() {
+		return ""
+	}
+	return fmt.Sprintf("request for host %q refused: without admin.token, the admin listener only answers requests to localhost or a loopback address", req.Host)
+}
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// adminDashboard serves the single-page dashboard, which reads the admin
+// API from the browser.
+func (s *Server) adminDashboard(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	_, _ = w.Write(dashboardHTML)
+}
+
+// adminConfigSchema serves the JSON Schema of the config file.
+func (s *Server) adminConfigSchema(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	_, _ = w.Write(config.Schema())
+}
+
+type relayState struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name,omitempty"`
+	ListenPath   string            `json:"listen_path"`
+	Methods      []string          `json:"methods"`
+	State        string            `json:"state"`
+	DebugUntil   *time.Time        `json:"debug_until,omitempty"`
+	Destinations []destinationInfo `json:"destinations"`
+}
+
+// destinationInfo describes a destination without its headers, which often
+// carry credentials.
+type destinationInfo struct {
+	URL         string `json:"url"`
+	Type        string `json:"type"`
+	Relay       string `json:"relay,omitempty"`
+	Route       string `json:"route,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Shadow      bool   `json:"shadow,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func (s *Server) relayState(r config.ResolvedRelay) relayState {
+	state := "running"
+	switch {
+	case r.Disabled:
+		state = "disabled"
+	case s.relayStopped(r.ID):
+		state = "stopped"
+	}
+	st := relayState{ID: r.ID, Name: r.Name, ListenPath: r.ListenPath, Methods: r.Methods, State: state, Destinations: destinationInfos(r)}
+	if until, ok := s.capture.Until(r.ID); ok {
+		st.DebugUntil = &until
+	}
+	return st
+}
+
+// destinationInfos lists the relay's destinations, then those of its routes.
+func destinationInfos(r config.ResolvedRelay) []destinationInfo {
+	out := []destinationInfo{}
+	add := func(route string, dests []config.DestinationConfig) {
+		for _, d := range dests {
+			out = append(out, destinationInfo{URL: d.URL, Type: d.Type, Relay: d.Relay, Route: route, Group: d.HashSet, Shadow: d.Shadow, Description: d.Description})
+		}
+	}
+	add("", r.Destinations)
+	for i, rt := range r.Routes {
+		name := rt.Name
+		if name == "" {
+			name = fmt.Sprintf("routes[%d]", i)
+		}
+		add(name, rt.Destinations)
+	}
+	return out
+}
+
+func (s *Server) adminListRelays(w http.ResponseWriter, _ *http.Request) {
+	relays := s.Relays()
+	out := make([]relayState, 0, len(relays))
+	for _, r := range relays {
+		out = append(out, s.relayState(r))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// adminDestinationHealth reports the health checks of destinations; it is
+// empty when none are configured.
+func (s *Server) adminDestinationHealth(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.health.Statuses())
+}
+
+// adminDestinationOutliers reports the outlier detection state of group
+// destinations; it is empty when no group reference has outlier_detection.
+func (s *Server) adminDestinationOutliers(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.health.Outliers())
+}
+
+// defaultStatsWindow is how far back relay statistics look unless the
+// request says otherwise.
+const defaultStatsWindow = time.Hour
+
+// deliveryStats counts delivery attempts. Failures are attempts that were not
+// delivered (failed, timed out or expired); events dropped by a transform
+// service count as neither.
+type deliveryStats struct {
+	Attempts      int            `json:"attempts"`
+	Successes     int            `json:"successes"`
+	Failures      int            `json:"failures"`
+	Outcomes      map[string]int `json:"outcomes,omitempty"`
+	LastAttemptAt *time.Time     `json:"last_attempt_at,omitempty"`
+	LastSuccessAt *time.Time     `json:"last_success_at,omitempty"`
+	LastFailureAt *time.Time     `json:"last_failure_at,omitempty"`
+	LastStatus    int            `json:"last_status,omitempty"`
+}
+
+// add counts d. Deliveries are added most recent first.
+func (st *deliveryStats) add(d store.Delivery) {
+	at := d.At
+	st.Attempts++
+	if st.Outcomes == nil {
+		st.Outcomes = map[string]int{}
+	}
+	st.Outcomes[d.Outcome]++
+	if st.LastAttemptAt == nil {
+		st.LastAttemptAt, st.LastStatus = &at, d.Status
+	}
+	switch d.Outcome {
+	case store.OutcomeDelivered:
+		st.Successes++
+		if st.LastSuccessAt == nil {
+			st.LastSuccessAt = &at
+		}
+	case store.OutcomeDropped:
+	default:
+		st.Failures++
+		if st.LastFailureAt == nil {
+			st.LastFailureAt = &at
+		}
+	}
+}
+
+type destinationStats struct {
+	URL string `json:"url"`
+	deliveryStats
+}
+
+type relayStats struct {
+	Relay  relayState `json:"relay"`
+	Window string     `json:"window"`
+	Since  time.Time  `json:"since"`
+	deliveryStats
+	// LastDeliveryAt is the last attempt at any time, also before Since.
+	LastDeliveryAt *time.Time         `json:"last_delivery_at,omitempty"`
+	Destinations   []destinationStats `json:"destinations"`
+}
+
+// adminRelayStats counts the relay's delivery attempts over the last
+// ?window= (a Go duration, default 1h), overall and per destination URL.
+func (s *Server) adminRelayStats(w http.ResponseWriter, req *http.Request) {
+	r, ok := s.findRelay(req.PathValue("relay"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no relay with that name or id")
+		return
+	}
+	if s.deliveries == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no delivery log")
+		return
+	}
+	window := defaultStatsWindow
+	if v := req.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "window must be a positive duration such as \"15m\"")
+			return
+		}
+		window = d
+	}
+
+	since := time.Now().Add(-window)
+	recent, err := s.deliveries.ListDeliveries(req.Context(), store.DeliveryFilter{Relay: r.Name, Since: since})
+	if err != nil {
+		s.log.Error("admin: list deliveries failed", "relay", r.Name, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := relayStats{Relay: s.relayState(r), Window: window.String(), Since: since.UTC(), Destinations: []destinationStats{}}
+	byURL := map[string]int{}
+	for _, d := range recent {
+		// An empty filter matches every relay, so unnamed relays are
+		// filtered here.
+		if d.Relay != r.Name {
+			continue
+		}
+		out.add(d)
+		i, ok := byURL[d.DestURL]
+		if !ok {
+			i = len(out.Destinations)
+			byURL[d.DestURL] = i
+			out.Destinations = append(out.Destinations, destinationStats{URL: d.DestURL})
+		}
+		out.Destinations[i].add(d)
+	}
+	if out.LastAttemptAt != nil {
+		out.LastDeliveryAt = out.LastAttemptAt
+	} else if r.Name != "" {
+		last, err := s.deliveries.ListDeliveries(req.Context(), store.DeliveryFilter{Relay: r.Name, Limit: 1})
+		if err != nil {
+			s.log.Error("admin: list deliveries failed", "relay", r.Name, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(last) > 0 {
+			out.LastDeliveryAt = &last[0].At
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+type relayStatus struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Status is "ok", "degraded" (some destination is unhealthy) or "down"
+	// (stopped, or no destination is healthy).
+	Status string `json:"status"`
+	State  string `json:"state"`
+	// Backlog is the number of queued jobs, or null when the storage
+	// backend cannot count them per relay.
+	Backlog       *int                `json:"backlog"`
+	LastSuccessAt *time.Time          `json:"last_success_at"`
+	Destinations  []destinationStatus `json:"destinations"`
+}
+
+type destinationStatus struct {
+	URL    string `json:"url"`
+	Route  string `json:"route,omitempty"`
+	Shadow bool   `json:"shadow,omitempty"`
+	// Health is "healthy" or "unhealthy" for destinations with a health
+	// check, "unchecked" otherwise.
+	Health        string     `json:"health"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+}
+
+// adminRelayStatus reports whether the relay is working, for uptime
+// monitors: 200 when it is ok or degraded, 503 when it is down.
+func (s *Server) adminRelayStatus(w http.ResponseWriter, req *http.Request) {
+	r, ok := s.findRelay(req.PathValue("relay"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no relay with that name or id")
+		return
+	}
+	ctx := req.Context()
+	st := s.relayState(r)
+	out := relayStatus{ID: r.ID, Name: r.Name, State: st.State, Destinations: []destinationStatus{}}
+
+	if rq, ok := s.queue.(store.RelayQueue); ok {
+		n, err := rq.RelayQueueLen(ctx, r.ID)
+		switch {
+		case err == nil:
+			out.Backlog = &n
+		case !errors.Is(err, errors.ErrUnsupported):
+			s.log.Error("admin: count relay backlog failed", "relay", r.Name, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	// The delivery log is keyed by relay name, so unnamed relays have no
+	// delivery times.
+	lastSuccess := func(destURL string) (*time.Time, error) {
+		if s.deliveries == nil || r.Name == "" {
+			return nil, nil
+		}
+		last, err := s.deliveries.ListDeliveries(ctx, store.DeliveryFilter{Relay: r.Name, DestURL: destURL, Outcome: store.OutcomeDelivered, Limit: 1})
+		if err != nil || len(last) == 0 {
+			return nil, err
+		}
+		return &last[0].At, nil
+	}
+	var err error
+	if out.LastSuccessAt, err = lastSuccess(""); err != nil {
+		s.log.Error("admin: list deliveries failed", "relay", r.Name, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	healthy, unhealthy := 0, 0
+	add := func(route string, dests []config.DestinationConfig) error {
+		for _, d := range dests {
+			ds := destinationStatus{URL: d.URL, Route: route, Shadow: d.Shadow, Health: "unchecked"}
+			if d.HealthCheck != nil {
+				ds.Health = "unhealthy"
+				if s.health.Healthy(d) {
+					ds.Health = "healthy"
+				}
+			}
+			// Mirrored traffic does not make the relay work or not.
+			if !d.Shadow {
+				if ds.Health == "unhealthy" {
+					unhealthy++
+				} else {
+					healthy++
+				}
+			}
+			var err error
+			if ds.LastSuccessAt, err = lastSuccess(d.URL); err != nil {
+				return err
+			}
+			out.Destinations = append(out.Destinations, ds)
+		}
+		return nil
+	}
+	err = add("", r.Destinations)
+	for i, rt := range r.Routes {
+		if err != nil {
+			break
+		}
+		name := rt.Name
+		if name == "" {
+			name = fmt.Sprintf("routes[%d]", i)
+		}
+		err = add(name, rt.Destinations)
+	}
+	if err != nil {
+		s.log.Error("admin: list deliveries failed", "relay", r.Name, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	code := http.StatusOK
+	switch {
+	case st.State != "running" || (unhealthy > 0 && healthy == 0):
+		out.Status, code = "down", http.StatusServiceUnavailable
+	case unhealthy > 0:
+		out.Status = "degraded"
+	default:
+		out.Status = "ok"
+	}
+	writeJSON(w, code, out)
+}
+
+func (s *Server) adminSetRelay(stop bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r, ok := s.findRelay(req.PathValue("relay"))
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no relay with that name or id")
+			return
+		}
+		if r.Disabled {
+			writeJSONError(w, http.StatusConflict, "relay is disabled in its config (enabled: false)")
+			return
+		}
+		if s.relayEditor != nil && r.Name != "" {
+			if err := s.relayEditor.SetStopped(r.Name, stop); err != nil {
+				s.log.Error("admin: relay state change failed", "relay", r.Name, "error", err)
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		s.setRelayStopped(r.ID, stop)
+		s.log.Info("admin: relay state changed", "relay", r.Name, "relay_id", r.ID, "stopped", stop)
+		writeJSON(w, http.StatusOK, s.relayState(r))
+	}
+}
+
+// Debug capture periods, in minutes.
+const (
+	defaultDebugMinutes = 15
+	maxDebugMinutes     = 24 * 60
+)
+
+// adminStartDebug puts a relay in debug mode for ?minutes= (default 15):
+// its inbound requests and outbound requests and responses are logged in
+// full until then.
+func (s *Server) adminStartDebug(w http.ResponseWriter, req *http.Request) {
+	if s.capture == nil {
+		writeJSONError(w, http.StatusNotFound, "debug capture is not available")
+		return
+	}
+	r, ok := s.findRelay(req.PathValue("relay"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no relay with that name or id")
+		return
+	}
+	minutes := defaultDebugMinutes
+	if v := req.URL.Query().Get("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDebugMinutes {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("minutes must be between 1 and %d", maxDebugMinutes))
+			return
+		}
+		minutes = n
+	}
+	until := s.capture.Enable(r.ID, time.Duration(minutes)*time.Minute)
+	s.log.Info("admin: debug capture started", "relay", r.Name, "relay_id", r.ID, "until", until)
+	writeJSON(w, http.StatusOK, s.relayState(r))
+}
+
+func (s *Server) adminStopDebug(w http.ResponseWriter, req *http.Request) {
+	if s.capture == nil {
+		writeJSONError(w, http.StatusNotFound, "debug capture is not available")
+		return
+	}
+	r, ok := s.findRelay(req.PathValue("relay"))
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "no relay with that name or id")
+		return
+	}
+	s.capture.Disable(r.ID)
+	s.log.Info("admin: debug capture stopped", "relay", r.Name, "relay_id", r.ID)
+	writeJSON(w, http.StatusOK, s.relayState(r))
+}
+
+func (s *Server) adminGetLogLevel(w http.ResponseWriter, req *http.Request) {
+	if s.logLevel == nil {
+		writeJSONError(w, http.StatusNotFound, "the log level is not adjustable")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.logLevel.State())
+}
+
+// adminSetLogLevel sets the log level to ?level=, for ?minutes= and then
+// back, or for good without minutes.
+func (s *Server) adminSetLogLevel(w http.ResponseWriter, req *http.Request) {
+	if s.logLevel == nil {
+		writeJSONError(w, http.StatusNotFound, "the log level is not adjustable")
+		return
+	}
+	q := req.URL.Query()
+	level, err := loglevel.Parse(q.Get("level"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var d time.Duration
+	if v := q.Get("minutes"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDebugMinutes {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("minutes must be between 1 and %d", maxDebugMinutes))
+			return
+		}
+		d = time.Duration(n) * time.Minute
+	}
+	until := s.logLevel.Set(level, d)
+	if d > 0 {
+		s.log.Warn("admin: log level changed", "level", level.String(), "until", until)
+	} else {
+		s.log.Warn("admin: log level changed", "level", level.String())
+	}
+	writeJSON(w, http.StatusOK, s.logLevel.State())
+}
+
+// adminResetLogLevel ends a temporary log level change.
+func (s *Server) adminResetLogLevel(w http.ResponseWriter, req *http.Request) {
+	if s.logLevel == nil {
+		writeJSONError(w, http.StatusNotFound, "the log level is not adjustable")
+		return
+	}
+	s.logLevel.Reset()
+	st := s.logLevel.State()
+	s.log.Warn("admin: log level reset", "level", st.Level)
+	writeJSON(w, http.StatusOK, st)
+}
+
+// Page sizes of the delivery listing.
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 1000
+)
+
+type deliveryPage struct {
+	Deliveries []store.Delivery `json:"deliveries"`
+	// NextCursor, passed as ?cursor=, lists the following page. It is
+	// empty on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// adminListDeliveries searches the delivery log, most recent first.
+func (s *Server) adminListDeliveries(w http.ResponseWriter, req *http.Request) {
+	if s.deliveries == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no delivery log")
+		return
+	}
+	q := req.URL.Query()
+	f := store.DeliveryFilter{
+		Relay:     q.Get("relay"),
+		Outcome:   q.Get("status"),
+		RequestID: q.Get("request_id"),
+		DestURL:   q.Get("destination"),
+		Provider:  q.Get("provider"),
+		EventType: q.Get("event_type"),
+		Limit:     defaultDeliveriesLimit,
+	}
+	// Relays may be given by ID too; names not in the config are searched
+	// as they are, for deliveries of relays since removed.
+	if r, ok := s.findRelay(f.Relay); ok {
+		f.Relay = r.Name
+	}
+	switch f.Outcome {
+	case "", store.OutcomeDelivered, store.OutcomeFailed, store.OutcomeTimeout, store.OutcomeExpired, store.OutcomeDropped:
+	default:
+		writeJSONError(w, http.StatusBadRequest, "status must be one of delivered, failed, timeout, expired, dropped")
+		return
+	}
+	now := time.Now()
+	var err error
+	if f.Since, err = parseSince(q.Get("since"), now); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "since: "+err.Error())
+		return
+	}
+	if f.Until, err = parseSince(q.Get("until"), now); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "until: "+err.Error())
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDeliveriesLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDeliveriesLimit))
+			return
+		}
+		f.Limit = n
+	}
+	if v := q.Get("cursor"); v != "" {
+		if f.After, err = decodeCursor(v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	// One more than asked tells whether there is a next page.
+	limit := f.Limit
+	f.Limit++
+	list, err := s.deliveries.ListDeliveries(req.Context(), f)
+	if err != nil {
+		s.log.Error("admin: list deliveries failed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := deliveryPage{Deliveries: list}
+	if len(list) > limit {
+		out.Deliveries = list[:limit]
+		out.NextCursor = encodeCursor(list[limit-1].Cursor())
+	}
+	if out.Deliveries == nil {
+		out.Deliveries = []store.Delivery{}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// parseSince reads a time as RFC 3339 or as a duration before now, e.g.
+// "1h".
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("must be an RFC 3339 time or a duration such as \"1h\" (got %q)", v)
+	}
+	return t, nil
+}
+
+func encodeCursor(c *store.DeliveryCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.At.UnixNano(), 10) + "." + c.ID))
+}
+
+func decodeCursor(v string) (*store.DeliveryCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	at, id, ok := strings.Cut(string(b), ".")
+	if !ok {
+		return nil, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &store.DeliveryCursor{At: time.Unix(0, n), ID: id}, nil
+}
+
+// tailHeartbeat is how often an idle event stream gets a comment, so
+// proxies do not time it out.
+const tailHeartbeat = 15 * time.Second
+
+// adminTail streams inbound requests and delivery attempts as server-sent
+// events, for one relay (?relay= name or id) or all of them. Events a slow
+// client could not take are reported as a "missed" event with their count.
+func (s *Server) adminTail(w http.ResponseWriter, req *http.Request) {
+	if s.tail == nil {
+		writeJSONError(w, http.StatusNotFound, "live tail is disabled")
+		return
+	}
+	relay := ""
+	if v := req.URL.Query().Get("relay"); v != "" {
+		r, ok := s.findRelay(v)
+		if !ok {
+			writeJSONError(w, http.StatusNotFound, "no relay with that name or id")
+			return
+		}
+		if r.Name == "" {
+			// Deliveries only record the relay's name.
+			writeJSONError(w, http.StatusBadRequest, "only relays with a name can be followed")
+			return
+		}
+		relay = r.Name
+	}
+
+	sub, cancel := s.tail.Subscribe(relay)
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, ": tailing\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(tailHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-req.Context().Done():
+			return
+		case <-s.done:
+			return
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case ev := <-sub.Events():
+			if n := sub.TakeMissed(); n > 0 {
+				_, err = fmt.Fprintf(w, "event: missed\ndata: {\"count\":%d}\n\n", n)
+			}
+			if err == nil {
+				b, _ := json.Marshal(ev)
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+			}
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+type listenerState struct {
+	Name  string `json:"name"`
+	Addr  string `json:"addr"`
+	TLS   bool   `json:"tls"`
+	State string `json:"state"`
+}
+
+func listenerStateOf(l *listener) listenerState {
+	state := "closed"
+	if l.isOpen() {
+		state = "open"
+	}
+	return listenerState{Name: l.name, Addr: l.addr, TLS: l.tls.Enabled(), State: state}
+}
+
+func (s *Server) adminListListeners(w http.ResponseWriter, _ *http.Request) {
+	out := make([]listenerState, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		out = append(out, listenerStateOf(l))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) adminSetListener(open bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("name")
+		if name == ListenerAdmin {
+			writeJSONError(w, http.StatusBadRequest, "the admin listener cannot be changed through itself")
+			return
+		}
+		l := s.listener(name)
+		if l == nil {
+			writeJSONError(w, http.StatusNotFound, "no listener with that name")
+			return
+		}
+
+		var err error
+		if open {
+			err = l.open()
+		} else {
+			ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+			defer cancel()
+			err = l.close(ctx)
+		}
+		if err != nil {
+			s.log.Error("admin: listener change failed", "listener", name, "open", open, "error", err)
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s.log.Info("admin: listener state changed", "listener", name, "addr", l.addr, "open", open)
+		writeJSON(w, http.StatusOK, listenerStateOf(l))
+	}
+}
+
+func (s *Server) findRelay(key string) (config.ResolvedRelay, bool) {
+	for _, r := range s.Relays() {
+		if r.ID == key || (r.Name != "" && r.Name == key) {
+			return r, true
+		}
+	}
+	return config.ResolvedRelay{}, false
+}
+
+func (s *Server) relayStopped(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stopped[id]
+}
+
+func (s *Server) setRelayStopped(id string, stop bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped[id] = stop
+}
+
+func (s *Server) listener(name string) *listener {
+	for _, l := range s.listeners {
+		if l.name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// deadlinePolicy decides whether an inbound request carries a delivery
+// deadline we should honor.
+type deadlinePolicy struct {
+	header  string
+	max     time.Duration
+	trusted []netip.Prefix
+}
+
+func newDeadlinePolicy(cfg config.DeadlineConfig) deadlinePolicy {
+	p := deadlinePolicy{header: cfg.Header, max: cfg.Max()}
+	for _, c := range cfg.TrustedCIDRs {
+		if pfx, err := netip.ParsePrefix(strings.TrimSpace(c)); err == nil {
+			p.trusted = append(p.trusted, pfx.Masked())
+		}
+	}
+	return p
+}
+
+// deadline returns the absolute deadline for the delivery of req, bounded by
+// the configured maximum. ok is false when the request is not from a trusted
+// producer or carries no (parseable) deadline header.
+func (p deadlinePolicy) deadline(req *http.Request, now time.Time) (time.Time, bool) {
+	if len(p.trusted) == 0 || p.header == "" {
+		return time.Time{}, false
+	}
+	raw := strings.TrimSpace(req.Header.Get(p.header))
+	if raw == "" || !p.isTrusted(req.RemoteAddr) {
+		return time.Time{}, false
+	}
+
+	dl, ok := parseDeadline(raw)
+	if !ok {
+		return time.Time{}, false
+	}
+	if limit := now.Add(p.max); dl.After(limit) {
+		dl = limit
+	}
+	return dl, true
+}
+
+func (p deadlinePolicy) isTrusted(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, pfx := range p.trusted {
+		if pfx.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseDeadline accepts either an RFC 3339 timestamp or Unix epoch milliseconds.
+// It is also used for backfill timestamps.
+func parseDeadline(raw string) (time.Time, bool) {
+	if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return time.UnixMilli(ms), true
+	}
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
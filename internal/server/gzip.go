@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxDecompressedBytes bounds how far a gzip body may expand, so a small
+// request cannot balloon into gigabytes of queued payload.
+const maxDecompressedBytes = 256 << 20
+
+var errBodyTooLarge = errors.New("decompressed body too large")
+
+// decodeBody returns the plain body of an inbound request. gzip-encoded
+// bodies are decompressed (up to limit bytes when set) and their
+// Content-Encoding removed from req, so everything downstream (redaction,
+// templates, forwarded headers) sees the decoded payload. Other encodings are
+// passed through untouched.
+func decodeBody(req *http.Request, body []byte, limit int64) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+	default:
+		return body, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	if limit <= 0 || limit > maxDecompressedBytes {
+		limit = maxDecompressedBytes
+	}
+	plain, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(plain)) > limit {
+		return nil, errBodyTooLarge
+	}
+
+	req.Header.Del("Content-Encoding")
+	req.Header.Del("Content-Length")
+	return plain, nil
+}
@@ -4,34 +4,120 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"webhookrelay/internal/buildinfo"
+	"webhookrelay/internal/capture"
 	"webhookrelay/internal/config"
+	"webhookrelay/internal/health"
+	"webhookrelay/internal/loglevel"
+	"webhookrelay/internal/metrics"
+	"webhookrelay/internal/plugin"
+	"webhookrelay/internal/provider"
+	"webhookrelay/internal/route"
+	"webhookrelay/internal/script"
+	"webhookrelay/internal/sentry"
+	"webhookrelay/internal/store"
+	"webhookrelay/internal/tail"
+	"webhookrelay/internal/tracing"
 )
 
 type Forwarder interface {
-	ForwardAsync(ctx context.Context, reqID string, relayName string, relayID string, inbound *http.Request, body []byte, destinations []config.DestinationConfig)
+	ForwardAsync(ctx context.Context, reqID string, relay config.ResolvedRelay, inbound *http.Request, body []byte, backfilledAt time.Time) error
 }
 
 type Config struct {
 	Logger     *slog.Logger
 	ListenAddr string
-	Relays     []config.ResolvedRelay
-	Forwarder  Forwarder
+	TLS        config.TLSConfig
+	SocketMode string
+	// Listeners are served next to ListenAddr, which may then be empty.
+	Listeners []config.ListenerConfig
+	Relays    []config.ResolvedRelay
+	Forwarder Forwarder
+	Deadline  config.DeadlineConfig
+	// AccessLog, when set, logs every request on the relay listeners.
+	AccessLog *slog.Logger
+	Admin     config.AdminConfig
+	// Deliveries is read by the admin API.
+	Deliveries store.DeliveryLog
+	// Queue is read by the admin API for relay backlogs.
+	Queue store.Queue
+	// Tail, when set, is told about every inbound request and streamed by
+	// the admin API.
+	Tail *tail.Hub
+	// Health is the destination health checker, reported by the admin API.
+	Health *health.Checker
+	// Capture logs the traffic of relays the admin API puts in debug mode.
+	Capture *capture.Capture
+	// Sentry, when set, is told about panics on the relay listeners.
+	Sentry *sentry.Reporter
+	// LogLevel, when set, is the level of Logger, which the admin API can
+	// change.
+	LogLevel *loglevel.Level
+	// Plugins are the loaded WASM plugins, keyed by relay ID.
+	Plugins map[string]*plugin.WASM
+	// Scripts are the loaded Lua scripts, keyed by relay ID.
+	Scripts map[string]*script.Script
+	// Metrics, when set, records inbound requests. It is served as
+	// MetricsConfig says if that is enabled.
+	Metrics       *metrics.Metrics
+	MetricsConfig config.MetricsConfig
 }
 
 type Server struct {
-	log *slog.Logger
-	srv *http.Server
+	log      *slog.Logger
+	fwd      Forwarder
+	deadline deadlinePolicy
+	metrics  *metrics.Metrics
+	// routing holds the relays and their handlers, replaced as a whole
+	// by SetRelays.
+	routing atomic.Pointer[routing]
+	// listenerRelays are the relay names of listeners serving a subset.
+	listenerRelays map[string][]string
+	// deliveries is the delivery log, for the admin API; nil without one.
+	deliveries store.DeliveryLog
+	queue      store.Queue
+	inspector  *inspector
+	tail       *tail.Hub
+	health     *health.Checker
+	capture    *capture.Capture
+	logLevel   *loglevel.Level
+	// startedAt and inFlight (inbound requests being handled) are
+	// reported by GET /admin/stats.
+	startedAt time.Time
+	inFlight  atomic.Int64
+	// done is closed on shutdown, to end streaming admin responses.
+	done     chan struct{}
+	doneOnce sync.Once
+
+	handler   http.Handler // every relay
+	listeners []*listener
+	errs      chan error
+
+	// relayEditor, when set, creates, updates and deletes relays for the
+	// admin API.
+	relayEditor RelayEditor
+
+	mu      sync.RWMutex
+	stopped map[string]bool // relay ID -> stopped through the admin API
 }
 
 func New(cfg Config) *Server {
@@ -40,36 +126,129 @@ func New(cfg Config) *Server {
 		log = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	}
 
+	s := &Server{
+		log:        log,
+		fwd:        cfg.Forwarder,
+		deadline:   newDeadlinePolicy(cfg.Deadline),
+		metrics:    cfg.Metrics,
+		deliveries: cfg.Deliveries,
+		queue:      cfg.Queue,
+		inspector:  newInspector(cfg.Admin),
+		tail:       cfg.Tail,
+		health:     cfg.Health,
+		capture:    cfg.Capture,
+		logLevel:   cfg.LogLevel,
+		startedAt:  time.Now(),
+		done:       make(chan struct{}),
+		errs:       make(chan error, 4),
+		stopped:    map[string]bool{},
+	}
+
+	s.listenerRelays = map[string][]string{}
+	for _, lc := range cfg.Listeners {
+		if len(lc.Relays) > 0 {
+			s.listenerRelays[lc.Name] = lc.Relays
+		}
+	}
+	s.SetRelays(cfg.Relays, cfg.Plugins, cfg.Scripts)
+	for _, r := range cfg.Relays {
+		if r.Name != "" && slices.Contains(cfg.Admin.StoppedRelays, r.Name) {
+			s.stopped[r.ID] = true
+		}
+	}
+	s.handler = s.listenerHandler(ListenerPublic)
+	if cfg.ListenAddr != "" {
+		s.listeners = append(s.listeners, &listener{name: ListenerPublic, addr: cfg.ListenAddr, tls: cfg.TLS, mode: socketMode(cfg.SocketMode), handler: accessLog(cfg.AccessLog, ListenerPublic, reportPanics(cfg.Sentry, ListenerPublic, s.handler)), errs: s.errs})
+	}
+	for _, lc := range cfg.Listeners {
+		h := s.listenerHandler(lc.Name)
+		s.listeners = append(s.listeners, &listener{name: lc.Name, addr: lc.ListenAddr, tls: lc.TLS, mode: socketMode(lc.SocketMode), handler: accessLog(cfg.AccessLog, lc.Name, reportPanics(cfg.Sentry, lc.Name, h)), errs: s.errs})
+	}
+	metricsPath := ""
+	if cfg.Metrics != nil && cfg.MetricsConfig.Enabled {
+		metricsPath = cfg.MetricsConfig.Path
+		if m := cfg.MetricsConfig; m.ListenAddr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("GET "+m.Path, cfg.Metrics.Handler())
+			s.listeners = append(s.listeners, &listener{name: ListenerMetrics, addr: m.ListenAddr, tls: m.TLS, mode: socketMode(m.SocketMode), handler: mux, errs: s.errs})
+			metricsPath = ""
+		}
+	}
+	if cfg.Admin.ListenAddr != "" {
+		s.listeners = append(s.listeners, &listener{name: ListenerAdmin, addr: cfg.Admin.ListenAddr, tls: cfg.Admin.TLS, mode: socketMode(cfg.Admin.SocketMode), handler: s.adminHandler(cfg.Admin.Token, metricsPath, cfg.Admin.Debug), errs: s.errs})
+	}
+	return s
+}
+
+// socketMode returns the permissions for Unix socket listeners; config
+// validation has already rejected invalid modes.
+func socketMode(s string) os.FileMode {
+	m, err := config.ParseSocketMode(s)
+	if err != nil {
+		return config.DefaultSocketMode
+	}
+	return m
+}
+
+// relayHandler serves the paths of relays and /healthz.
+func (s *Server) relayHandler(relays []config.ResolvedRelay) http.Handler {
 	mux := http.NewServeMux()
 
-	// Health endpoint for convenience.
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+	// Health endpoint for convenience; ?verbose adds the build.
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Has("verbose") {
+			writeJSON(w, http.StatusOK, struct {
+				Status string `json:"status"`
+				buildinfo.Info
+			}{"ok", buildinfo.Get()})
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
 	})
 
-	for _, r := range cfg.Relays {
+	// Relays were checked by config.ResolveRelays, so routes do not fail
+	// (a subset of them cannot conflict either).
+	routes, _ := config.Routes(relays)
+	var regex []config.ResolvedRelay
+	for i, r := range relays {
 		relay := r
-		p := cleanPath(relay.ListenPath)
-		mux.HandleFunc(p, func(w http.ResponseWriter, req *http.Request) {
-			handleRelay(log, cfg.Forwarder, relay, w, req)
+		if relay.PathRegex != nil {
+			regex = append(regex, relay)
+		}
+		for _, p := range routes[i] {
+			mux.HandleFunc(p, func(w http.ResponseWriter, req *http.Request) {
+				s.serveRelay(relay, w, req)
+			})
+		}
+	}
+	if len(regex) > 0 {
+		mux.HandleFunc(config.RegexRoute, func(w http.ResponseWriter, req *http.Request) {
+			for _, relay := range regex {
+				if relay.PathRegex.MatchString(req.URL.Path) {
+					s.serveRelay(relay, w, req)
+					return
+				}
+			}
+			http.NotFound(w, req)
 		})
 	}
+	return mux
+}
 
-	return &Server{
-		log: log,
-		srv: &http.Server{
-			Addr:    cfg.ListenAddr,
-			Handler: mux,
-		},
-	}
+// Handler returns the handler of every relay path and /healthz, as served
+// by the public listener.
+func (s *Server) Handler() http.Handler {
+	return s.handler
 }
 
 func (s *Server) Run() error {
-	errCh := make(chan error, 1)
-	go func() {
-		errCh <- s.srv.ListenAndServe()
-	}()
+	for _, l := range s.listeners {
+		if err := l.open(); err != nil {
+			s.shutdown()
+			return err
+		}
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
@@ -77,18 +256,123 @@ func (s *Server) Run() error {
 	select {
 	case sig := <-sigCh:
 		s.log.Info("shutdown signal received", "signal", sig.String())
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		return s.srv.Shutdown(ctx)
-	case err := <-errCh:
-		if err == http.ErrServerClosed {
-			return nil
-		}
+		return s.shutdown()
+	case err := <-s.errs:
+		_ = s.shutdown()
 		return err
 	}
 }
 
-func handleRelay(log *slog.Logger, fwd Forwarder, relay config.ResolvedRelay, w http.ResponseWriter, req *http.Request) {
+// shutdown closes every listener, waiting up to 10s for in-flight requests.
+func (s *Server) shutdown() error {
+	s.doneOnce.Do(func() { close(s.done) })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var first error
+	for _, l := range s.listeners {
+		if err := l.close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// serveRelay handles a request for relay in a span continuing the caller's
+// trace, and records it in the metrics, the inspector and the tail.
+func (s *Server) serveRelay(relay config.ResolvedRelay, w http.ResponseWriter, req *http.Request) {
+	ctx, span := tracing.Tracer.Start(tracing.Extract(req.Context(), req.Header), "relay "+relay.Name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("webhookrelay.relay", relay.Name),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		))
+	defer span.End()
+	s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	done := s.metrics.InboundStarted(relay.Name)
+	ev := s.inspector.begin(relay, req)
+	sw := &statusWriter{ResponseWriter: w}
+	s.handleRelay(relay, sw, req.WithContext(withInspected(ctx, ev)))
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	s.inspector.finish(ev, sw.status, sw.Header())
+	s.tail.Publish(tail.Event{
+		Type:      tail.TypeInbound,
+		At:        time.Now(),
+		Relay:     relay.Name,
+		RequestID: sw.Header().Get("X-Relay-Request-Id"),
+		Status:    sw.status,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Dropped:   sw.Header().Get("X-Relay-Dropped"),
+	})
+	result := metrics.ResultRejected
+	switch {
+	case sw.status/100 == 2 && sw.Header().Get("X-Relay-Dropped") != "":
+		result = metrics.ResultDropped
+	case sw.status/100 == 2:
+		result = metrics.ResultAccepted
+	}
+	done(result, sw.status)
+	span.SetAttributes(attribute.Int("http.response.status_code", sw.status), attribute.String("webhookrelay.result", result))
+	if dropped := sw.Header().Get("X-Relay-Dropped"); dropped != "" {
+		span.SetAttributes(attribute.String("webhookrelay.dropped", dropped))
+	}
+	if sw.status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(sw.status))
+	}
+}
+
+// statusWriter remembers the status code written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (s *Server) handleRelay(relay config.ResolvedRelay, w http.ResponseWriter, req *http.Request) {
+	log := s.log
+
+	if relay.Disabled {
+		if relay.DisabledStatus == http.StatusNotFound {
+			// As if no relay served the path.
+			http.NotFound(w, req)
+			return
+		}
+		// A little of the body is read so a small request's connection can
+		// be reused; a large one is not worth reading to throw away.
+		_, _ = io.Copy(io.Discard, io.LimitReader(req.Body, 64<<10))
+		w.Header().Set("X-Relay-Dropped", "relay_disabled")
+		http.Error(w, "relay disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.relayStopped(relay.ID) {
+		_, _ = io.Copy(io.Discard, io.LimitReader(req.Body, 64<<10))
+		w.Header().Set("X-Relay-Dropped", "relay_stopped")
+		http.Error(w, "relay stopped", http.StatusServiceUnavailable)
+		return
+	}
+
 	if !methodAllowed(req.Method, relay.Methods) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -107,21 +391,155 @@ func handleRelay(log *slog.Logger, fwd Forwarder, relay config.ResolvedRelay, w
 		return
 	}
 
+	if !contentTypeAllowed(req, relay.ContentTypes) {
+		_ = req.Body.Close()
+		log.Warn("content type not allowed", "relay", relay.Name, "path", relay.ListenPath, "content_type", req.Header.Get("Content-Type"))
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
 	// Read the entire body so we can fan-out to multiple destinations.
+	if relay.MaxBodyBytes > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, relay.MaxBodyBytes)
+	}
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			log.Warn("body too large", "relay", relay.Name, "path", relay.ListenPath, "limit", relay.MaxBodyBytes)
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		log.Error("read body failed", "relay", relay.Name, "path", relay.ListenPath, "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	_ = req.Body.Close()
 
+	body, err = decodeBody(req, body, relay.MaxBodyBytes)
+	if err != nil {
+		log.Warn("decode body failed", "relay", relay.Name, "path", relay.ListenPath, "error", err)
+		status := http.StatusBadRequest
+		if errors.Is(err, errBodyTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, "invalid gzip body: "+err.Error(), status)
+		return
+	}
+	inspectedFrom(req.Context()).setBody(body)
+
 	reqID, _ := newRequestID()
+	trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("webhookrelay.request_id", reqID))
+	s.capture.Inbound(relay, req, body, reqID)
+
+	var backfilledAt time.Time
+	if relay.Backfill {
+		if ts, ok, err := backfillTimestamp(req, time.Now()); err != nil {
+			log.Warn("invalid backfill timestamp", "relay", relay.Name, "path", relay.ListenPath, "error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if ok {
+			log.Info("backfilled event", "relay", relay.Name, "request_id", reqID, "original_timestamp", ts)
+			backfilledAt = ts
+		}
+	}
+
+	body, drop, reason, err := s.runPlugin(req.Context(), relay, reqID, req, body)
+	switch {
+	case err != nil && relay.Plugin.FailOpen:
+		log.Warn("plugin failed: forwarding unchanged", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "error", err)
+	case err != nil:
+		log.Error("plugin failed", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case drop:
+		log.Info("plugin dropped event", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "reason", reason)
+		w.Header().Set("X-Relay-Request-Id", reqID)
+		w.Header().Set("X-Relay-Dropped", "plugin")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("accepted"))
+		return
+	}
+
+	var detected provider.Info
+	if relay.DetectProvider {
+		detected = provider.Detect(req.Header, body)
+	}
+	newEvent := func(body []byte) *route.Event {
+		ev := route.NewEvent(req, reqID, body)
+		ev.Provider, ev.EventType = detected.Provider, detected.EventType
+		return ev
+	}
+
+	if len(relay.Routes) > 0 {
+		var name string
+		name, relay.Destinations = route.Select(relay.Routes, relay.Destinations, newEvent(body))
+		if name != "" {
+			log.Info("route matched", "relay", relay.Name, "request_id", reqID, "route", name)
+		}
+	}
+
+	res, err := s.runScript(req.Context(), relay, reqID, req, body)
+	switch {
+	case err != nil && relay.Script.FailOpen:
+		log.Warn("script failed: forwarding unchanged", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "error", err)
+	case err != nil:
+		log.Error("script failed", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case res.Reject:
+		log.Info("script rejected event", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "status", res.Status, "message", res.Message)
+		http.Error(w, res.Message, res.Status)
+		return
+	case res.Drop:
+		log.Info("script dropped event", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "reason", res.Reason)
+		w.Header().Set("X-Relay-Request-Id", reqID)
+		w.Header().Set("X-Relay-Dropped", "script")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("accepted"))
+		return
+	default:
+		relay, body = applyScript(res, relay, req, body)
+	}
+
+	relay.Destinations = route.Destinations(relay.Destinations, newEvent(body), s.health.Admits)
+	if len(relay.Destinations) == 0 {
+		log.Info("no destination matched: dropping forwarding", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID)
+		w.Header().Set("X-Relay-Request-Id", reqID)
+		w.Header().Set("X-Relay-Dropped", "no_route")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("accepted"))
+		return
+	}
 
-	// Fire-and-forget forwarding. We do NOT tie it to req.Context() because that
-	// context is canceled when the handler returns.
-	if fwd != nil {
-		fwd.ForwardAsync(context.Background(), reqID, relay.Name, relay.ID, req, body, relay.Destinations)
+	// Fire-and-forget forwarding. We do NOT tie it to req.Context()'s
+	// cancellation because that context is canceled when the handler
+	// returns; only its trace is kept.
+	ctx := provider.NewContext(context.WithoutCancel(req.Context()), detected)
+
+	// Trusted producers may bound the whole delivery with an absolute deadline,
+	// carried to the forwarder on the context. ForwardAsync copies it into the
+	// queued jobs, so the context is done with once it returns.
+	if dl, ok := s.deadline.deadline(req, time.Now()); ok {
+		if !dl.After(time.Now()) {
+			log.Warn("deadline already passed: dropping forwarding", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "deadline", dl)
+			w.Header().Set("X-Relay-Request-Id", reqID)
+			w.Header().Set("X-Relay-Dropped", "deadline_exceeded")
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("accepted"))
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, dl)
+		defer cancel()
+	}
+
+	if s.fwd != nil {
+		if err := s.fwd.ForwardAsync(ctx, reqID, relay, req, body, backfilledAt); err != nil {
+			log.Error("enqueue failed", "relay", relay.Name, "path", relay.ListenPath, "request_id", reqID, "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
 	}
 
 	w.Header().Set("X-Relay-Request-Id", reqID)
@@ -129,6 +547,27 @@ func handleRelay(log *slog.Logger, fwd Forwarder, relay config.ResolvedRelay, w
 	_, _ = w.Write([]byte("accepted"))
 }
 
+// HeaderBackfillTimestamp carries the original time of a backfilled event.
+const HeaderBackfillTimestamp = "X-WebhookRelay-Backfill-Timestamp"
+
+// backfillTimestamp parses the original event time (RFC 3339 or Unix
+// milliseconds) from req. Timestamps in the future are rejected.
+func backfillTimestamp(req *http.Request, now time.Time) (time.Time, bool, error) {
+	raw := strings.TrimSpace(req.Header.Get(HeaderBackfillTimestamp))
+	if raw == "" {
+		return time.Time{}, false, nil
+	}
+	ts, ok := parseDeadline(raw)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%s must be RFC 3339 or Unix milliseconds", HeaderBackfillTimestamp)
+	}
+	// Allow a little clock skew between sender and relay.
+	if ts.After(now.Add(time.Minute)) {
+		return time.Time{}, false, fmt.Errorf("%s is in the future", HeaderBackfillTimestamp)
+	}
+	return ts, true, nil
+}
+
 func methodAllowed(method string, allowed []string) bool {
 	if len(allowed) == 0 {
 		return true
@@ -142,6 +581,35 @@ func methodAllowed(method string, allowed []string) bool {
 	return false
 }
 
+// contentTypeAllowed reports whether req's media type is one of allowed
+// ("type/*" and "*+suffix" match families). Requests without a body and
+// Content-Type, such as GET pings, are always allowed.
+func contentTypeAllowed(req *http.Request, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	ct := req.Header.Get("Content-Type")
+	if ct == "" {
+		return req.ContentLength == 0
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	typ, sub, _ := strings.Cut(mt, "/")
+	for _, a := range allowed {
+		switch {
+		case a == mt:
+			return true
+		case strings.HasSuffix(a, "/*") && strings.TrimSuffix(a, "/*") == typ:
+			return true
+		case strings.HasPrefix(a, "*+") && strings.HasSuffix(sub, a[1:]):
+			return true
+		}
+	}
+	return false
+}
+
 func traceContains(trace string, instanceID string) bool {
 	instanceID = strings.TrimSpace(instanceID)
 	if instanceID == "" {
@@ -155,21 +623,6 @@ func traceContains(trace string, instanceID string) bool {
 	return false
 }
 
-func cleanPath(p string) string {
-	p = strings.TrimSpace(p)
-	if p == "" {
-		return "/"
-	}
-	if !strings.HasPrefix(p, "/") {
-		p = "/" + p
-	}
-	p = path.Clean(p)
-	if p == "." {
-		return "/"
-	}
-	return p
-}
-
 func newRequestID() (string, error) {
 	var b [12]byte
 	if _, err := rand.Read(b[:]); err != nil {
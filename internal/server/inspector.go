@@ -0,0 +1,338 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/redact"
+)
+
+// HeaderReplay marks a request replayed from the inspector; its value is the
+// ID of the inspected event.
+const HeaderReplay = "X-WebhookRelay-Replay"
+
+// inspector keeps the most recent inbound requests in memory so the
+// dashboard can show and replay them. A nil *inspector keeps nothing.
+type inspector struct {
+	maxBody int64
+
+	mu     sync.Mutex
+	events []*inspectedEvent // ring, oldest at next once full
+	next   int
+}
+
+func newInspector(cfg config.AdminConfig) *inspector {
+	if cfg.ListenAddr == "" || cfg.InspectEvents <= 0 {
+		return nil
+	}
+	return &inspector{maxBody: cfg.InspectMaxBodyBytes, events: make([]*inspectedEvent, 0, cfg.InspectEvents)}
+}
+
+// inspectedEvent is one inbound request. header and body are kept as
+// received for replay, except a truncated body, which cannot be replayed
+// and is kept redacted; the API only shows them redacted, and withholds
+// bodies the relay's redact rules cannot be applied to.
+type inspectedEvent struct {
+	id         string
+	relay      config.ResolvedRelay
+	method     string
+	path       string
+	query      string
+	remoteAddr string
+	replayOf   string
+	receivedAt time.Time
+	header     http.Header
+	maxBody    int64
+	body       []byte
+	bodyLen    int
+	truncated  bool
+	withheld   bool
+
+	requestID string
+	status    int
+	dropped   string
+	duration  time.Duration
+}
+
+type inspectedView struct {
+	ID         string      `json:"id"`
+	RequestID  string      `json:"request_id,omitempty"`
+	Relay      string      `json:"relay,omitempty"`
+	RelayID    string      `json:"relay_id"`
+	Method     string      `json:"method"`
+	Path       string      `json:"path"`
+	Query      string      `json:"query,omitempty"`
+	RemoteAddr string      `json:"remote_addr"`
+	Status     int         `json:"status"`
+	Dropped    string      `json:"dropped,omitempty"`
+	ReplayOf   string      `json:"replay_of,omitempty"`
+	ReceivedAt time.Time   `json:"received_at"`
+	DurationMS int64       `json:"duration_ms"`
+	BodyBytes  int         `json:"body_bytes"`
+	Truncated  bool        `json:"body_truncated,omitempty"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	// BodyEncoding is "base64" for bodies that are not UTF-8 text.
+	BodyEncoding string `json:"body_encoding,omitempty"`
+	// BodyWithheld is set for bodies that are not JSON, which the relay's
+	// redact rules cannot be applied to.
+	BodyWithheld bool `json:"body_withheld,omitempty"`
+}
+
+func (e *inspectedEvent) view(full bool) inspectedView {
+	v := inspectedView{
+		ID:         e.id,
+		RequestID:  e.requestID,
+		Relay:      e.relay.Name,
+		RelayID:    e.relay.ID,
+		Method:     e.method,
+		Path:       e.path,
+		Query:      redact.Query(e.query, e.relay.Redact),
+		RemoteAddr: e.remoteAddr,
+		Status:     e.status,
+		Dropped:    e.dropped,
+		ReplayOf:   e.replayOf,
+		ReceivedAt: e.receivedAt,
+		DurationMS: e.duration.Milliseconds(),
+		BodyBytes:  e.bodyLen,
+		Truncated:  e.truncated,
+	}
+	if !full {
+		return v
+	}
+	v.Header = redact.Header(e.header, e.relay.Redact)
+	body := e.body
+	switch {
+	case e.withheld || !redact.Applies(body, e.relay.Redact) && !e.truncated:
+		v.BodyWithheld = true
+		return v
+	case !e.truncated:
+		// Truncated bodies were redacted before they were cut.
+		body = redact.Body(body, e.relay.Redact)
+	}
+	if utf8.Valid(body) {
+		v.Body = string(body)
+	} else {
+		v.Body, v.BodyEncoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return v
+}
+
+// begin starts recording req for relay. The event is kept once finish is
+// called.
+func (in *inspector) begin(relay config.ResolvedRelay, req *http.Request) *inspectedEvent {
+	if in == nil {
+		return nil
+	}
+	id, _ := newRequestID()
+	return &inspectedEvent{
+		id:         id,
+		relay:      relay,
+		method:     req.Method,
+		path:       req.URL.Path,
+		query:      req.URL.RawQuery,
+		remoteAddr: req.RemoteAddr,
+		replayOf:   req.Header.Get(HeaderReplay),
+		receivedAt: time.Now(),
+		header:     req.Header.Clone(),
+		maxBody:    in.maxBody,
+	}
+}
+
+// setBody records the (decompressed) body the relay read.
+func (e *inspectedEvent) setBody(body []byte) {
+	if e == nil {
+		return
+	}
+	e.bodyLen = len(body)
+	if int64(len(body)) > e.maxBody {
+		// A cut body is no longer valid JSON and could not be redacted
+		// field by field, so it is redacted whole first.
+		e.truncated = true
+		if !redact.Applies(body, e.relay.Redact) {
+			e.withheld = true
+			return
+		}
+		body = redact.Body(body, e.relay.Redact)
+		body = body[:min(int64(len(body)), e.maxBody)]
+	}
+	e.body = bytes.Clone(body)
+}
+
+// finish records the response and keeps the event, replacing the oldest
+// one once the inspector is full.
+func (in *inspector) finish(e *inspectedEvent, status int, h http.Header) {
+	if in == nil || e == nil {
+		return
+	}
+	e.status = status
+	e.requestID = h.Get("X-Relay-Request-Id")
+	e.dropped = h.Get("X-Relay-Dropped")
+	e.duration = time.Since(e.receivedAt)
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.events) < cap(in.events) {
+		in.events = append(in.events, e)
+		return
+	}
+	in.events[in.next] = e
+	in.next = (in.next + 1) % len(in.events)
+}
+
+// list returns the kept events of relay (all relays if empty), most recent
+// first.
+func (in *inspector) list(relay string) []*inspectedEvent {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	out := make([]*inspectedEvent, 0, len(in.events))
+	for i := range in.events {
+		e := in.events[(in.next+len(in.events)-1-i)%len(in.events)]
+		if relay == "" || e.relay.Name == relay || e.relay.ID == relay {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (in *inspector) get(id string) *inspectedEvent {
+	if in == nil {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, e := range in.events {
+		if e.id == id {
+			return e
+		}
+	}
+	return nil
+}
+
+// byRequestID returns the kept event the relay answered with request ID
+// id, if any.
+func (in *inspector) byRequestID(id string) *inspectedEvent {
+	if in == nil || id == "" {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	for _, e := range in.events {
+		if e.requestID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+type inspectedKey struct{}
+
+func withInspected(ctx context.Context, e *inspectedEvent) context.Context {
+	if e == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, inspectedKey{}, e)
+}
+
+func inspectedFrom(ctx context.Context) *inspectedEvent {
+	e, _ := ctx.Value(inspectedKey{}).(*inspectedEvent)
+	return e
+}
+
+func (s *Server) adminListEvents(w http.ResponseWriter, req *http.Request) {
+	if s.inspector == nil {
+		writeJSONError(w, http.StatusNotFound, "the inspector is disabled")
+		return
+	}
+	events := s.inspector.list(req.URL.Query().Get("relay"))
+	out := make([]inspectedView, len(events))
+	for i, e := range events {
+		out[i] = e.view(false)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) adminGetEvent(w http.ResponseWriter, req *http.Request) {
+	e := s.inspector.get(req.PathValue("id"))
+	if e == nil {
+		writeJSONError(w, http.StatusNotFound, "no such event (the inspector keeps only recent ones)")
+		return
+	}
+	writeJSON(w, http.StatusOK, e.view(true))
+}
+
+type replayResult struct {
+	Status    int    `json:"status"`
+	RequestID string `json:"request_id,omitempty"`
+	Dropped   string `json:"dropped,omitempty"`
+	Response  string `json:"response,omitempty"`
+}
+
+// adminReplayEvent sends an inspected request through its relay again, as
+// if it had just arrived. The replay is inspected too, with replay_of set.
+func (s *Server) adminReplayEvent(w http.ResponseWriter, req *http.Request) {
+	e := s.inspector.get(req.PathValue("id"))
+	if e == nil {
+		writeJSONError(w, http.StatusNotFound, "no such event (the inspector keeps only recent ones)")
+		return
+	}
+	if e.truncated {
+		writeJSONError(w, http.StatusConflict, "the event's body was cut at admin.inspect_max_body_bytes and cannot be replayed")
+		return
+	}
+
+	target := e.path
+	if e.query != "" {
+		target += "?" + e.query
+	}
+	r, err := http.NewRequestWithContext(req.Context(), e.method, target, bytes.NewReader(e.body))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.Header = e.header.Clone()
+	// The body was kept decompressed.
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.Header.Set(HeaderReplay, e.id)
+	r.RemoteAddr = e.remoteAddr
+
+	rec := &replayRecorder{header: http.Header{}}
+	s.serveRelay(e.relay, rec, r)
+	s.log.Info("admin: replayed event", "event_id", e.id, "relay", e.relay.Name, "status", rec.status, "request_id", rec.header.Get("X-Relay-Request-Id"))
+	writeJSON(w, http.StatusOK, replayResult{
+		Status:    rec.status,
+		RequestID: rec.header.Get("X-Relay-Request-Id"),
+		Dropped:   rec.header.Get("X-Relay-Dropped"),
+		Response:  strings.TrimSpace(rec.body.String()),
+	})
+}
+
+// replayRecorder collects the relay's response to a replayed request.
+type replayRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *replayRecorder) Header() http.Header { return r.header }
+
+func (r *replayRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *replayRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
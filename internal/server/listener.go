@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"webhookrelay/internal/config"
+)
+
+// Listener names.
+const (
+	ListenerPublic  = "public"
+	ListenerAdmin   = "admin"
+	ListenerMetrics = "metrics"
+)
+
+// listener is one HTTP listener that can be closed and reopened at runtime.
+type listener struct {
+	name string
+	addr string
+	tls  config.TLSConfig
+	// mode is the permissions of a Unix socket address.
+	mode    os.FileMode
+	handler http.Handler
+	// errs receives serve errors other than a requested close.
+	errs chan<- error
+
+	mu  sync.Mutex
+	srv *http.Server
+}
+
+func (l *listener) open() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.srv != nil {
+		return nil
+	}
+	var tc *tls.Config
+	if l.tls.Enabled() {
+		// Loaded on every open so that reopening picks up renewed
+		// certificates.
+		var err error
+		if tc, err = l.tls.Load(); err != nil {
+			return fmt.Errorf("%s listener: %w", l.name, err)
+		}
+	}
+	ln, err := l.listen()
+	if err != nil {
+		return fmt.Errorf("%s listener: %w", l.name, err)
+	}
+	if tc != nil {
+		ln = tls.NewListener(ln, tc)
+	}
+	srv := &http.Server{Handler: l.handler}
+	l.srv = srv
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			select {
+			case l.errs <- fmt.Errorf("%s listener: %w", l.name, err):
+			default:
+			}
+		}
+	}()
+	return nil
+}
+
+// listen opens a TCP port or a Unix socket. A socket file left behind by a
+// process that did not shut down cleanly is replaced.
+func (l *listener) listen() (net.Listener, error) {
+	network, addr := config.ListenNetwork(l.addr)
+	if network != "unix" {
+		return net.Listen(network, addr)
+	}
+	if fi, err := os.Lstat(addr); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", addr); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s is in use", addr)
+		}
+		_ = os.Remove(addr)
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, l.mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// close stops accepting connections and waits for in-flight requests.
+func (l *listener) close(ctx context.Context) error {
+	l.mu.Lock()
+	srv := l.srv
+	l.srv = nil
+	l.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+func (l *listener) isOpen() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.srv != nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/plugin"
+)
+
+// runPlugin passes the event through the relay's WASM plugin, if any. It
+// replaces req's headers and returns the (possibly modified) body; drop is
+// true when the plugin asked to discard the event.
+func (s *Server) runPlugin(ctx context.Context, relay config.ResolvedRelay, reqID string, req *http.Request, body []byte) (out []byte, drop bool, reason string, err error) {
+	p := s.routing.Load().plugins[relay.ID]
+	if p == nil {
+		return body, false, "", nil
+	}
+	res, err := p.Run(ctx, plugin.Input{
+		Relay:     relay.Name,
+		RequestID: reqID,
+		Method:    req.Method,
+		Path:      req.URL.Path,
+		Query:     req.URL.RawQuery,
+		Params:    relay.Params(req),
+		Headers:   req.Header,
+		Body:      body,
+	})
+	if err != nil {
+		return body, false, "", err
+	}
+	if res.Drop {
+		return body, true, res.Reason, nil
+	}
+	if res.Headers != nil {
+		req.Header = res.Headers
+	}
+	if res.Body != nil {
+		body = res.Body
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	return body, false, "", nil
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"webhookrelay/internal/config"
+)
+
+// RelayEditor creates, replaces and deletes relays for the admin API,
+// keeping them in admin.relays_file so they survive restarts. Relays are
+// relay configs as JSON objects; errors that are config.Problems describe
+// a config that was not applied.
+type RelayEditor interface {
+	// CreateRelay adds a relay with a name no other relay has.
+	CreateRelay(ctx context.Context, relay map[string]any) error
+	// UpdateRelay replaces the relay named name, which must have been
+	// created through the editor.
+	UpdateRelay(ctx context.Context, name string, relay map[string]any) error
+	// DeleteRelay removes the relay named name, which must have been
+	// created through the editor.
+	DeleteRelay(ctx context.Context, name string) error
+	// SetStopped records that the relay named name was stopped or started,
+	// for the next start.
+	SetStopped(name string, stopped bool) error
+}
+
+// Errors of a RelayEditor.
+var (
+	ErrRelayExists     = errors.New("a relay with that name already exists")
+	ErrRelayNotFound   = errors.New("no relay with that name")
+	ErrRelayNotManaged = errors.New("the relay is defined in the config files; only relays created through the admin API can be changed")
+)
+
+// maxRelayBytes bounds the relay config of a create or update request.
+const maxRelayBytes = 1 << 20
+
+// SetRelayEditor turns on creating, updating and deleting relays through
+// the admin API; it must be called before Run.
+func (s *Server) SetRelayEditor(e RelayEditor) {
+	s.relayEditor = e
+}
+
+// adminCreateRelay adds the relay config in the body.
+func (s *Server) adminCreateRelay(w http.ResponseWriter, req *http.Request) {
+	relay, name, ok := s.readRelay(w, req)
+	if !ok {
+		return
+	}
+	if err := s.relayEditor.CreateRelay(req.Context(), relay); err != nil {
+		s.writeRelayEditError(w, "create", name, err)
+		return
+	}
+	s.log.Info("admin: relay created", "relay", name)
+	s.writeEditedRelay(w, http.StatusCreated, name)
+}
+
+// adminUpdateRelay replaces a relay created through the API with the
+// relay config in the body, whose name may be left out. A stopped relay
+// stays stopped.
+func (s *Server) adminUpdateRelay(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("relay")
+	relay, bodyName, ok := s.readRelay(w, req)
+	if !ok {
+		return
+	}
+	if bodyName != name {
+		writeJSONError(w, http.StatusBadRequest, "the relay's name cannot be changed; create a new relay instead")
+		return
+	}
+	prev, _ := s.findRelay(name)
+	if err := s.relayEditor.UpdateRelay(req.Context(), name, relay); err != nil {
+		s.writeRelayEditError(w, "update", name, err)
+		return
+	}
+	if r, ok := s.findRelay(name); ok && prev.ID != "" && s.relayStopped(prev.ID) {
+		s.setRelayStopped(r.ID, true)
+	}
+	s.log.Info("admin: relay updated", "relay", name)
+	s.writeEditedRelay(w, http.StatusOK, name)
+}
+
+// adminDeleteRelay removes a relay created through the API.
+func (s *Server) adminDeleteRelay(w http.ResponseWriter, req *http.Request) {
+	if s.relayEditor == nil {
+		writeJSONError(w, http.StatusNotFound, "relays are not editable: set admin.relays_file")
+		return
+	}
+	name := req.PathValue("relay")
+	if err := s.relayEditor.DeleteRelay(req.Context(), name); err != nil {
+		s.writeRelayEditError(w, "delete", name, err)
+		return
+	}
+	s.log.Info("admin: relay deleted", "relay", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readRelay decodes the relay config in the body of a create or update
+// request. An update's name defaults to the one in its path.
+func (s *Server) readRelay(w http.ResponseWriter, req *http.Request) (map[string]any, string, bool) {
+	if s.relayEditor == nil {
+		writeJSONError(w, http.StatusNotFound, "relays are not editable: set admin.relays_file")
+		return nil, "", false
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRelayBytes))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "relay config too large")
+		return nil, "", false
+	}
+	var relay map[string]any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	// Numbers stay as written, like in config files.
+	dec.UseNumber()
+	if err := dec.Decode(&relay); err != nil || relay == nil {
+		writeJSONError(w, http.StatusBadRequest, "the body must be a relay config as a JSON object")
+		return nil, "", false
+	}
+	if _, ok := relay["name"]; !ok && req.PathValue("relay") != "" {
+		relay["name"] = req.PathValue("relay")
+	}
+	name, _ := relay["name"].(string)
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "the relay needs a name")
+		return nil, "", false
+	}
+	return relay, name, true
+}
+
+func (s *Server) writeRelayEditError(w http.ResponseWriter, op, name string, err error) {
+	var problems config.Problems
+	switch {
+	case errors.Is(err, ErrRelayExists), errors.Is(err, ErrRelayNotManaged):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrRelayNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.As(err, &problems):
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "the config with this change is not valid; nothing was changed", "problems": problems})
+	default:
+		s.log.Error("admin: relay "+op+" failed", "relay", name, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// writeEditedRelay answers with the state of the relay named name.
+func (s *Server) writeEditedRelay(w http.ResponseWriter, status int, name string) {
+	r, ok := s.findRelay(name)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "relay not found after the change")
+		return
+	}
+	writeJSON(w, status, s.relayState(r))
+}
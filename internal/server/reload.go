@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/plugin"
+	"webhookrelay/internal/script"
+)
+
+// routing is a set of relays and the handlers serving them.
+type routing struct {
+	relays  []config.ResolvedRelay
+	plugins map[string]*plugin.WASM
+	scripts map[string]*script.Script
+	// handlers are keyed by listener name; listeners serving every relay
+	// share the one of ListenerPublic.
+	handlers map[string]http.Handler
+}
+
+// SetRelays replaces the relays served, with their plugins and scripts
+// keyed by relay ID. Requests already being handled finish with the relay
+// they started with; the next ones see the new set. Relays must have been
+// checked by config.ResolveRelays.
+func (s *Server) SetRelays(relays []config.ResolvedRelay, plugins map[string]*plugin.WASM, scripts map[string]*script.Script) {
+	rt := &routing{
+		relays:   relays,
+		plugins:  plugins,
+		scripts:  scripts,
+		handlers: map[string]http.Handler{ListenerPublic: s.relayHandler(relays)},
+	}
+	for name, names := range s.listenerRelays {
+		var subset []config.ResolvedRelay
+		for _, r := range relays {
+			if slices.Contains(names, r.Name) {
+				subset = append(subset, r)
+			}
+		}
+		rt.handlers[name] = s.relayHandler(subset)
+	}
+	s.routing.Store(rt)
+}
+
+// Relays returns the relays served.
+func (s *Server) Relays() []config.ResolvedRelay {
+	return s.routing.Load().relays
+}
+
+// listenerHandler serves the relays of the named listener, as of the
+// latest SetRelays.
+func (s *Server) listenerHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rt := s.routing.Load()
+		h, ok := rt.handlers[name]
+		if !ok {
+			h = rt.handlers[ListenerPublic]
+		}
+		h.ServeHTTP(w, req)
+	})
+}
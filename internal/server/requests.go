@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/store"
+)
+
+// Summary states of a request, see adminGetRequest.
+const (
+	requestDelivered = "delivered"
+	requestPartial   = "partial"
+	requestFailed    = "failed"
+	requestPending   = "pending"
+	requestDropped   = "dropped"
+	requestRejected  = "rejected"
+)
+
+// requestReport is what happened to one inbound request.
+type requestReport struct {
+	RequestID string `json:"request_id"`
+	Status    string `json:"status"`
+	Relay     string `json:"relay,omitempty"`
+	// Inbound is the request as the inspector kept it, while it does.
+	Inbound      *inspectedView       `json:"inbound,omitempty"`
+	Destinations []requestDestination `json:"destinations"`
+	// Deliveries are every attempt, oldest first.
+	Deliveries []store.Delivery `json:"deliveries"`
+}
+
+// requestDestination is the last attempt to deliver the request to one URL.
+type requestDestination struct {
+	URL           string    `json:"url"`
+	Shadow        bool      `json:"shadow,omitempty"`
+	Outcome       string    `json:"outcome"`
+	Status        int       `json:"status,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Attempts      int       `json:"attempts"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+}
+
+// adminGetRequest looks up a request by the ID its sender got in
+// X-Relay-Request-Id, for "what happened to my webhook" questions.
+func (s *Server) adminGetRequest(w http.ResponseWriter, req *http.Request) {
+	if s.deliveries == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "no delivery log")
+		return
+	}
+	id := req.PathValue("request_id")
+	list, err := s.deliveries.ListDeliveries(req.Context(), store.DeliveryFilter{RequestID: id, Limit: maxDeliveriesLimit})
+	if err != nil {
+		s.log.Error("admin: list deliveries failed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	inbound := s.inspector.byRequestID(id)
+	if len(list) == 0 && inbound == nil {
+		writeJSONError(w, http.StatusNotFound, "no such request (deliveries are kept for storage.retention_hours)")
+		return
+	}
+	slices.Reverse(list)
+
+	out := requestReport{RequestID: id, Deliveries: list, Destinations: []requestDestination{}}
+	var relay config.ResolvedRelay
+	var known bool
+	if inbound != nil {
+		v := inbound.view(false)
+		out.Inbound = &v
+		relay, known = inbound.relay, true
+		out.Relay = relay.Name
+	} else {
+		out.Relay = list[0].Relay
+		relay, known = s.findRelay(out.Relay)
+	}
+
+	shadow := map[string]bool{}
+	if known {
+		for _, d := range relay.Destinations {
+			shadow[d.URL] = d.Shadow
+		}
+		for _, rt := range relay.Routes {
+			for _, d := range rt.Destinations {
+				shadow[d.URL] = d.Shadow
+			}
+		}
+	}
+	byURL := map[string]int{}
+	for _, d := range list {
+		i, ok := byURL[d.DestURL]
+		if !ok {
+			i = len(out.Destinations)
+			byURL[d.DestURL] = i
+			out.Destinations = append(out.Destinations, requestDestination{URL: d.DestURL, Shadow: shadow[d.DestURL]})
+		}
+		rd := &out.Destinations[i]
+		rd.Outcome, rd.Status, rd.Error, rd.LastAttemptAt = d.Outcome, d.Status, d.Error, d.At
+		rd.Attempts++
+	}
+	out.Status = requestStatus(out.Destinations, inbound, known && relay.Strategy == config.StrategyFirstSuccess)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// requestStatus sums up dests, leaving shadow deliveries out. With
+// firstSuccess, one delivered destination is enough: the others failed
+// over to it.
+func requestStatus(dests []requestDestination, inbound *inspectedEvent, firstSuccess bool) string {
+	if inbound != nil {
+		switch {
+		case inbound.dropped != "":
+			return requestDropped
+		case inbound.status/100 != 2:
+			return requestRejected
+		}
+	}
+	delivered, failed := 0, 0
+	for _, d := range dests {
+		if d.Shadow {
+			continue
+		}
+		switch d.Outcome {
+		case store.OutcomeDelivered, store.OutcomeDropped:
+			delivered++
+		default:
+			failed++
+		}
+	}
+	switch {
+	case delivered == 0 && failed == 0:
+		return requestPending
+	case failed == 0 || (firstSuccess && delivered > 0):
+		return requestDelivered
+	case delivered == 0:
+		return requestFailed
+	}
+	return requestPartial
+}
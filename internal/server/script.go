@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/script"
+)
+
+// runScript passes the event through the relay's Lua script, if any.
+func (s *Server) runScript(ctx context.Context, relay config.ResolvedRelay, reqID string, req *http.Request, body []byte) (script.Result, error) {
+	sc := s.routing.Load().scripts[relay.ID]
+	if sc == nil {
+		return script.Result{}, nil
+	}
+	return sc.Run(ctx, script.Input{
+		Relay:        relay.Name,
+		RequestID:    reqID,
+		Request:      req,
+		Params:       relay.Params(req),
+		Body:         body,
+		Destinations: relay.Destinations,
+	})
+}
+
+// applyScript applies the script's edits to req and returns the relay
+// narrowed to the selected destinations and the body to forward.
+func applyScript(res script.Result, relay config.ResolvedRelay, req *http.Request, body []byte) (config.ResolvedRelay, []byte) {
+	if res.Header != nil {
+		req.Header = res.Header
+	}
+	if res.Body != nil {
+		body = res.Body
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	if res.ContentType != "" {
+		req.Header.Set("Content-Type", res.ContentType)
+	}
+	if res.Destinations != nil {
+		dests := make([]config.DestinationConfig, 0, len(res.Destinations))
+		for _, i := range res.Destinations {
+			dests = append(dests, relay.Destinations[i])
+		}
+		relay.Destinations = dests
+	}
+	return relay, body
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"webhookrelay/internal/relay"
+)
+
+// stats is a snapshot of the relay's load for GET /admin/stats.
+type stats struct {
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	Inbound       struct {
+		InFlight int64 `json:"in_flight"`
+	} `json:"inbound"`
+	// Forward is absent for forwarders that do not report their workers.
+	Forward *forwardStats `json:"forward,omitempty"`
+	Queue   struct {
+		// Pending is null when the store cannot count its queue.
+		Pending *int   `json:"pending"`
+		Error   string `json:"error,omitempty"`
+	} `json:"queue"`
+	Runtime runtimeStats `json:"runtime"`
+}
+
+type forwardStats struct {
+	relay.Stats
+	// Saturation is the busy share of the workers, from 0 to 1.
+	Saturation float64 `json:"saturation"`
+}
+
+type runtimeStats struct {
+	GoVersion      string  `json:"go_version"`
+	GOMAXPROCS     int     `json:"gomaxprocs"`
+	Goroutines     int     `json:"goroutines"`
+	HeapAllocBytes uint64  `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64  `json:"heap_inuse_bytes"`
+	SysBytes       uint64  `json:"sys_bytes"`
+	NumGC          uint32  `json:"num_gc"`
+	LastGCPauseMS  float64 `json:"last_gc_pause_ms"`
+}
+
+// adminStats reports in-flight work, worker saturation, the queue and the
+// Go runtime, for triage without a metrics stack.
+func (s *Server) adminStats(w http.ResponseWriter, req *http.Request) {
+	var out stats
+	out.StartedAt = s.startedAt
+	out.UptimeSeconds = int64(time.Since(s.startedAt).Seconds())
+	out.Inbound.InFlight = s.inFlight.Load()
+	if f, ok := s.fwd.(interface{ Stats() relay.Stats }); ok {
+		st := f.Stats()
+		out.Forward = &forwardStats{Stats: st}
+		if st.Workers > 0 {
+			out.Forward.Saturation = float64(st.Busy) / float64(st.Workers)
+		}
+	}
+	if s.queue != nil {
+		n, err := s.queue.QueueLen(req.Context())
+		if err != nil {
+			out.Queue.Error = err.Error()
+		} else {
+			out.Queue.Pending = &n
+		}
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	out.Runtime = runtimeStats{
+		GoVersion:      runtime.Version(),
+		GOMAXPROCS:     runtime.GOMAXPROCS(0),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: ms.HeapAlloc,
+		HeapInuseBytes: ms.HeapInuse,
+		SysBytes:       ms.Sys,
+		NumGC:          ms.NumGC,
+		LastGCPauseMS:  float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e6,
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Maintainer is implemented by backends that support offline maintenance.
+// The relay must not be running against the store while these run.
+type Maintainer interface {
+	// Vacuum reclaims space left behind by deleted rows.
+	Vacuum(ctx context.Context) error
+	// Check reports problems without changing anything.
+	Check(ctx context.Context) ([]string, error)
+	// Repair fixes what Check reports where possible and describes what it did.
+	Repair(ctx context.Context) ([]string, error)
+}
+
+func (s *SQL) Vacuum(ctx context.Context) error {
+	stmt := "VACUUM"
+	if s.postgres {
+		stmt = "VACUUM ANALYZE"
+	}
+	_, err := s.db.ExecContext(ctx, stmt)
+	return err
+}
+
+func (s *SQL) Check(ctx context.Context) ([]string, error) {
+	var problems []string
+
+	if !s.postgres {
+		rows, err := s.db.QueryContext(ctx, "PRAGMA integrity_check")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var msg string
+			if err := rows.Scan(&msg); err != nil {
+				_ = rows.Close()
+				return nil, err
+			}
+			if msg != "ok" {
+				problems = append(problems, "integrity: "+msg)
+			}
+		}
+		_ = rows.Close()
+	}
+
+	bad, err := s.corruptJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range bad {
+		problems = append(problems, fmt.Sprintf("queue: job %s cannot be decoded", id))
+	}
+
+	var leased int
+	if err := s.db.QueryRowContext(ctx, s.q(`SELECT COUNT(*) FROM queue WHERE available_at > ?`), time.Now().UnixNano()).Scan(&leased); err != nil {
+		return nil, err
+	}
+	if leased > 0 {
+		problems = append(problems, fmt.Sprintf("queue: %d job(s) still leased (relay running, or it stopped mid-delivery)", leased))
+	}
+	return problems, nil
+}
+
+func (s *SQL) Repair(ctx context.Context) ([]string, error) {
+	var actions []string
+
+	if !s.postgres {
+		if _, err := s.db.ExecContext(ctx, "REINDEX"); err != nil {
+			return actions, fmt.Errorf("reindex: %w", err)
+		}
+		actions = append(actions, "rebuilt indexes")
+	}
+
+	bad, err := s.corruptJobs(ctx)
+	if err != nil {
+		return actions, err
+	}
+	for _, id := range bad {
+		if _, err := s.db.ExecContext(ctx, s.q(`DELETE FROM queue WHERE id = ?`), id); err != nil {
+			return actions, err
+		}
+		actions = append(actions, fmt.Sprintf("removed undecodable job %s", id))
+	}
+
+	// Offline, nobody holds a lease, so stale ones only delay redelivery.
+	res, err := s.db.ExecContext(ctx, `UPDATE queue SET available_at = 0 WHERE available_at <> 0`)
+	if err != nil {
+		return actions, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		actions = append(actions, fmt.Sprintf("released %d stale lease(s)", n))
+	}
+	return actions, nil
+}
+
+func (s *SQL) corruptJobs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, job FROM queue`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bad []string
+	for rows.Next() {
+		var id, raw string
+		if err := rows.Scan(&id, &raw); err != nil {
+			return nil, err
+		}
+		var j Job
+		if json.Unmarshal([]byte(raw), &j) != nil {
+			bad = append(bad, id)
+		}
+	}
+	return bad, rows.Err()
+}
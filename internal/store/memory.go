@@ -0,0 +1,225 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The memory backend keeps only the most recent log entries so a busy relay
+// does not grow without bound between retention sweeps.
+const (
+	memoryMaxDeliveries  = 10_000
+	memoryMaxDeadLetters = 10_000
+)
+
+// Memory keeps everything in process memory. It is the default backend and
+// loses all state on restart.
+type Memory struct {
+	mu          sync.Mutex
+	queue       []Job // sorted by queuedBefore, then insertion order
+	leases      map[string]time.Time
+	deliveries  []Delivery
+	deadLetters []DeadLetter
+	snapshots   []Snapshot
+	alerts      map[string]AlertState // by rule
+}
+
+func NewMemory() *Memory {
+	return &Memory{leases: make(map[string]time.Time), alerts: make(map[string]AlertState)}
+}
+
+func (m *Memory) Enqueue(_ context.Context, jobs ...Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, j := range jobs {
+		i := sort.Search(len(m.queue), func(i int) bool { return j.queuedBefore(m.queue[i]) })
+		m.queue = append(m.queue, Job{})
+		copy(m.queue[i+1:], m.queue[i:])
+		m.queue[i] = j
+	}
+	return nil
+}
+
+func (m *Memory) Dequeue(_ context.Context, lease time.Duration) (Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, j := range m.queue {
+		if until, ok := m.leases[j.ID]; ok && now.Before(until) {
+			continue
+		}
+		m.leases[j.ID] = now.Add(lease)
+		return j, true, nil
+	}
+	return Job{}, false, nil
+}
+
+func (m *Memory) Ack(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.leases, id)
+	for i, j := range m.queue {
+		if j.ID == id {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *Memory) QueueLen(context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.queue), nil
+}
+
+func (m *Memory) RelayQueueLen(_ context.Context, relayID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, j := range m.queue {
+		if j.RelayID == relayID {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) RecordDelivery(_ context.Context, d Delivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deliveries = append(m.deliveries, d)
+	if len(m.deliveries) > memoryMaxDeliveries {
+		m.deliveries = append([]Delivery(nil), m.deliveries[len(m.deliveries)-memoryMaxDeliveries:]...)
+	}
+	return nil
+}
+
+func (m *Memory) ListDeliveries(_ context.Context, f DeliveryFilter) ([]Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Delivery
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if f.match(m.deliveries[i]) {
+			out = append(out, m.deliveries[i])
+		}
+	}
+	// Workers record deliveries slightly out of order; paging with After
+	// relies on the exact order.
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].At.Equal(out[j].At) {
+			return out[i].At.After(out[j].At)
+		}
+		return out[i].ID > out[j].ID
+	})
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[:f.Limit]
+	}
+	return out, nil
+}
+
+func (m *Memory) PruneDeliveries(_ context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.deliveries[:0]
+	for _, d := range m.deliveries {
+		if d.At.Before(before) {
+			continue
+		}
+		kept = append(kept, d)
+	}
+	n := len(m.deliveries) - len(kept)
+	m.deliveries = kept
+	return n, nil
+}
+
+func (m *Memory) PutDeadLetter(_ context.Context, dl DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadLetters = append(m.deadLetters, dl)
+	if len(m.deadLetters) > memoryMaxDeadLetters {
+		m.deadLetters = append([]DeadLetter(nil), m.deadLetters[len(m.deadLetters)-memoryMaxDeadLetters:]...)
+	}
+	return nil
+}
+
+func (m *Memory) ListDeadLetters(_ context.Context, limit int) ([]DeadLetter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []DeadLetter
+	for i := len(m.deadLetters) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		out = append(out, m.deadLetters[i])
+	}
+	return out, nil
+}
+
+func (m *Memory) CountDeadLetters(context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.deadLetters), nil
+}
+
+func (m *Memory) DeleteDeadLetter(_ context.Context, dl DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.deadLetters {
+		if d.Job.ID == dl.Job.ID {
+			m.deadLetters = append(m.deadLetters[:i:i], m.deadLetters[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) SaveSnapshot(_ context.Context, s Snapshot) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots = append(m.snapshots, s)
+	return nil
+}
+
+func (m *Memory) LatestSnapshot(context.Context) (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.snapshots) == 0 {
+		return Snapshot{}, ErrNotFound
+	}
+	return m.snapshots[len(m.snapshots)-1], nil
+}
+
+func (m *Memory) PruneSnapshots(_ context.Context, keep int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := max(len(m.snapshots)-keep, 0)
+	m.snapshots = slices.Delete(m.snapshots, 0, n)
+	return n, nil
+}
+
+func (m *Memory) PutAlertState(_ context.Context, s AlertState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alerts[s.Rule] = s
+	return nil
+}
+
+func (m *Memory) DeleteAlertState(_ context.Context, rule string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.alerts, rule)
+	return nil
+}
+
+func (m *Memory) ListAlertStates(context.Context) ([]AlertState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]AlertState, 0, len(m.alerts))
+	for _, s := range m.alerts {
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (m *Memory) Close() error { return nil }
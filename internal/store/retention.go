@@ -0,0 +1,32 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// retentionInterval is how often expired delivery log entries are pruned.
+const retentionInterval = time.Hour
+
+// RunRetention prunes delivery log entries older than retention until ctx is
+// done.
+func RunRetention(ctx context.Context, s DeliveryLog, retention time.Duration, log *slog.Logger) {
+	t := time.NewTicker(retentionInterval)
+	defer t.Stop()
+	for {
+		n, err := s.PruneDeliveries(ctx, time.Now().Add(-retention))
+		switch {
+		case err != nil:
+			log.Error("store: prune deliveries failed", "error", err)
+		case n > 0:
+			log.Info("store: pruned deliveries", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// S3 stores every record as a JSON object in an S3 (or S3-compatible) bucket.
+// Object keys start with a zero-padded timestamp so listings come back in
+// time order. Queue leases are tracked in process, so a bucket prefix must
+// only be served by one relay instance at a time.
+type S3 struct {
+	cfg    config.S3Config
+	client *http.Client
+	base   *url.URL
+
+	accessKey, secretKey, sessionToken string
+
+	mu     sync.Mutex
+	leases map[string]s3Lease // by job ID
+	// cursor is the queue key dequeueing lists after: the jobs before it
+	// are leased or gone. Jobs queued before it and leases that run out
+	// move it back, counted by rewinds.
+	cursor  string
+	rewinds int
+	// queued counts the queue, as of the last listing at countedAt plus
+	// the jobs queued and acknowledged since.
+	queued    int
+	countedAt time.Time
+}
+
+type s3Lease struct {
+	key   string
+	until time.Time
+}
+
+// s3CountInterval is how long QueueLen counts the queue from the jobs this
+// process queued and acknowledged before listing it again, to take in
+// those queued by others (such as replay).
+const s3CountInterval = time.Minute
+
+// OpenS3 checks the bucket is reachable and returns a store backed by it.
+// Credentials fall back to the standard AWS_* environment variables.
+func OpenS3(ctx context.Context, cfg config.S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	s := &S3{
+		cfg:          cfg,
+		client:       &http.Client{Timeout: 30 * time.Second},
+		accessKey:    firstNonEmpty(cfg.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey:    firstNonEmpty(cfg.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		sessionToken: firstNonEmpty(cfg.SessionToken, os.Getenv("AWS_SESSION_TOKEN")),
+		leases:       make(map[string]s3Lease),
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("s3: credentials are required (access_key_id/secret_access_key or AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY)")
+	}
+
+	switch {
+	case cfg.Endpoint != "":
+		u, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/") + "/" + cfg.Bucket)
+		if err != nil {
+			return nil, fmt.Errorf("s3: endpoint: %w", err)
+		}
+		s.base = u
+	case cfg.PathStyle:
+		s.base = &url.URL{Scheme: "https", Host: "s3." + cfg.Region + ".amazonaws.com", Path: "/" + cfg.Bucket}
+	default:
+		s.base = &url.URL{Scheme: "https", Host: cfg.Bucket + ".s3." + cfg.Region + ".amazonaws.com"}
+	}
+
+	if _, _, err := s.list(ctx, s.key("snapshots/"), ""); err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	return s, nil
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *S3) key(rel string) string {
+	p := strings.Trim(s.cfg.Prefix, "/")
+	if p == "" {
+		return rel
+	}
+	return p + "/" + rel
+}
+
+// tsKey builds an object key ordered by t.
+func (s *S3) tsKey(kind string, t time.Time, rest string) string {
+	return s.key(fmt.Sprintf("%s/%020d-%s.json", kind, nanos(t), rest))
+}
+
+// queueKey is the object key of j. Backfilled jobs are keyed under a
+// "backfill-" prefix, which lists after every timestamp, so they are
+// dequeued behind live ones.
+func (s *S3) queueKey(j Job) string {
+	if j.Backfill {
+		return s.key(fmt.Sprintf("queue/backfill-%020d-%s.json", nanos(j.queuedAt()), j.ID))
+	}
+	return s.tsKey("queue", j.queuedAt(), j.ID)
+}
+
+// keyParts splits the file name of a tsKey object into its dash-separated parts.
+func keyParts(key string) []string {
+	name := key[strings.LastIndex(key, "/")+1:]
+	return strings.Split(strings.TrimSuffix(name, ".json"), "-")
+}
+
+func (s *S3) Enqueue(ctx context.Context, jobs ...Job) error {
+	for _, j := range jobs {
+		key := s.queueKey(j)
+		if err := s.putJSON(ctx, key, j); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.queued++
+		s.rewind(key)
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+// Dequeue lists the queue from the cursor, so the jobs in flight before it
+// are not listed again and again.
+func (s *S3) Dequeue(ctx context.Context, lease time.Duration) (Job, bool, error) {
+	s.mu.Lock()
+	now := time.Now()
+	for id, l := range s.leases {
+		if !now.Before(l.until) {
+			delete(s.leases, id)
+			s.rewind(l.key)
+		}
+	}
+	after, gen := s.cursor, s.rewinds
+	s.mu.Unlock()
+
+	token := ""
+	for {
+		keys, next, err := s.listAfter(ctx, s.key("queue/"), after, token)
+		if err != nil {
+			return Job{}, false, err
+		}
+		for _, k := range keys {
+			parts := keyParts(k)
+			id := parts[len(parts)-1]
+			if !s.claim(id, k, lease, gen) {
+				continue
+			}
+			var j Job
+			if err := s.getJSON(ctx, k, &j); err != nil {
+				// A job acknowledged since the listing is gone for good.
+				s.release(id, !errors.Is(err, ErrNotFound))
+				if errors.Is(err, ErrNotFound) {
+					continue
+				}
+				return Job{}, false, err
+			}
+			return j, true, nil
+		}
+		if next == "" {
+			return Job{}, false, nil
+		}
+		token = next
+	}
+}
+
+// claim leases the job with id, at key. Every job listed before it was
+// leased, so the cursor moves past it, unless it was moved back since the
+// listing began (at rewinds gen).
+func (s *S3) claim(id, key string, lease time.Duration, gen int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if l, ok := s.leases[id]; ok && now.Before(l.until) {
+		return false
+	}
+	s.leases[id] = s3Lease{key: key, until: now.Add(lease)}
+	if s.rewinds == gen {
+		s.cursor = max(s.cursor, key)
+	}
+	return true
+}
+
+// release gives up the lease of id; with relist, its job is listed again.
+func (s *S3) release(id string, relist bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l, ok := s.leases[id]; ok {
+		delete(s.leases, id)
+		if relist {
+			s.rewind(l.key)
+		}
+	}
+}
+
+// rewind moves the cursor back so that key is listed. s.mu is held.
+func (s *S3) rewind(key string) {
+	if key <= s.cursor {
+		// Listings start after the cursor; any prefix of key sorts before
+		// it.
+		s.cursor = key[:len(key)-1]
+		s.rewinds++
+	}
+}
+
+// Ack deletes the job's object, whose key the lease kept.
+func (s *S3) Ack(ctx context.Context, id string) error {
+	s.mu.Lock()
+	l, ok := s.leases[id]
+	delete(s.leases, id)
+	s.mu.Unlock()
+	if !ok {
+		// Not leased by this process (or for too long): find it.
+		keys, err := s.listAll(ctx, s.key("queue/"))
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if parts := keyParts(k); parts[len(parts)-1] == id {
+				l.key = k
+				break
+			}
+		}
+		if l.key == "" {
+			return nil
+		}
+	}
+	if err := s.delete(ctx, l.key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.queued = max(s.queued-1, 0)
+	s.mu.Unlock()
+	return nil
+}
+
+// QueueLen lists the queue at most every s3CountInterval.
+func (s *S3) QueueLen(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	if time.Since(s.countedAt) < s3CountInterval {
+		defer s.mu.Unlock()
+		return s.queued, nil
+	}
+	s.mu.Unlock()
+	keys, err := s.listAll(ctx, s.key("queue/"))
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued, s.countedAt = len(keys), time.Now()
+	return s.queued, nil
+}
+
+func (s *S3) RecordDelivery(ctx context.Context, d Delivery) error {
+	// The key carries both timestamps so filtering and pruning can skip
+	// objects without fetching them.
+	return s.putJSON(ctx, s.tsKey("deliveries", d.At, fmt.Sprintf("%020d-%s", nanos(d.ReceivedAt), d.ID)), d)
+}
+
+func (s *S3) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error) {
+	keys, err := s.listAll(ctx, s.key("deliveries/"))
+	if err != nil {
+		return nil, err
+	}
+	var out []Delivery
+	for i := len(keys) - 1; i >= 0; i-- {
+		at, _ := strconv.ParseInt(keyParts(keys[i])[0], 10, 64)
+		if !f.Since.IsZero() && at < nanos(f.Since) {
+			break
+		}
+		if !f.Until.IsZero() && at >= nanos(f.Until) || f.After != nil && at > nanos(f.After.At) {
+			continue
+		}
+		var d Delivery
+		if err := s.getJSON(ctx, keys[i], &d); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		if !f.match(d) {
+			continue
+		}
+		out = append(out, d)
+		if f.Limit > 0 && len(out) == f.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (s *S3) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	keys, err := s.listAll(ctx, s.key("deliveries/"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, k := range keys {
+		parts := keyParts(k)
+		if len(parts) < 3 {
+			continue
+		}
+		// Keys sort by the time the entry was recorded.
+		at, _ := strconv.ParseInt(parts[0], 10, 64)
+		if at >= nanos(before) {
+			break
+		}
+		if err := s.delete(ctx, k); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *S3) PutDeadLetter(ctx context.Context, dl DeadLetter) error {
+	return s.putJSON(ctx, s.tsKey("dead_letters", dl.At, dl.Job.ID), dl)
+}
+
+func (s *S3) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	keys, err := s.listAll(ctx, s.key("dead_letters/"))
+	if err != nil {
+		return nil, err
+	}
+	var out []DeadLetter
+	for i := len(keys) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		var dl DeadLetter
+		if err := s.getJSON(ctx, keys[i], &dl); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, dl)
+	}
+	return out, nil
+}
+
+func (s *S3) CountDeadLetters(ctx context.Context) (int, error) {
+	keys, err := s.listAll(ctx, s.key("dead_letters/"))
+	return len(keys), err
+}
+
+func (s *S3) DeleteDeadLetter(ctx context.Context, dl DeadLetter) error {
+	return s.delete(ctx, s.tsKey("dead_letters", dl.At, dl.Job.ID))
+}
+
+func (s *S3) SaveSnapshot(ctx context.Context, snap Snapshot) error {
+	return s.putJSON(ctx, s.tsKey("snapshots", snap.At, snap.Hash), snap)
+}
+
+func (s *S3) LatestSnapshot(ctx context.Context) (Snapshot, error) {
+	keys, err := s.listAll(ctx, s.key("snapshots/"))
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(keys) == 0 {
+		return Snapshot{}, ErrNotFound
+	}
+	var snap Snapshot
+	err = s.getJSON(ctx, keys[len(keys)-1], &snap)
+	return snap, err
+}
+
+func (s *S3) PruneSnapshots(ctx context.Context, keep int) (int, error) {
+	keys, err := s.listAll(ctx, s.key("snapshots/"))
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	// Keys sort by the time the snapshot was taken.
+	for _, k := range keys[:max(len(keys)-keep, 0)] {
+		if err := s.delete(ctx, k); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Alert states are kept one object per rule, under its escaped name.
+func (s *S3) alertKey(rule string) string {
+	return s.key("alerts/" + url.PathEscape(rule) + ".json")
+}
+
+func (s *S3) PutAlertState(ctx context.Context, st AlertState) error {
+	return s.putJSON(ctx, s.alertKey(st.Rule), st)
+}
+
+func (s *S3) DeleteAlertState(ctx context.Context, rule string) error {
+	return s.delete(ctx, s.alertKey(rule))
+}
+
+func (s *S3) ListAlertStates(ctx context.Context) ([]AlertState, error) {
+	keys, err := s.listAll(ctx, s.key("alerts/"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]AlertState, 0, len(keys))
+	for _, k := range keys {
+		var st AlertState
+		if err := s.getJSON(ctx, k, &st); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+func (s *S3) Close() error { return nil }
+
+func (s *S3) putJSON(ctx context.Context, key string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, nil, b)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+func (s *S3) getJSON(ctx context.Context, key string, v any) error {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *S3) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if resp != nil {
+		_ = resp.Body.Close()
+	}
+	return nil
+}
+
+// list returns one page of keys under prefix and the continuation token for
+// the next page ("" when done).
+func (s *S3) list(ctx context.Context, prefix, token string) ([]string, string, error) {
+	return s.listAfter(ctx, prefix, "", token)
+}
+
+// listAfter is list starting after the key after, if not empty.
+func (s *S3) listAfter(ctx context.Context, prefix, after, token string) ([]string, string, error) {
+	q := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if after != "" {
+		q.Set("start-after", after)
+	}
+	if token != "" {
+		q.Set("continuation-token", token)
+	}
+	resp, err := s.do(ctx, http.MethodGet, "", q, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, "", fmt.Errorf("decode list response: %w", err)
+	}
+	keys := make([]string, 0, len(out.Contents))
+	for _, c := range out.Contents {
+		keys = append(keys, c.Key)
+	}
+	if !out.IsTruncated {
+		return keys, "", nil
+	}
+	return keys, out.NextContinuationToken, nil
+}
+
+func (s *S3) listAll(ctx context.Context, prefix string) ([]string, error) {
+	var all []string
+	token := ""
+	for {
+		keys, next, err := s.list(ctx, prefix, token)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, keys...)
+		if next == "" {
+			sort.Strings(all)
+			return all, nil
+		}
+		token = next
+	}
+}
+
+// do sends a SigV4-signed request for key (the bucket itself when empty).
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.base
+	if key != "" {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + key
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		_ = resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, key, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": payloadHash,
+		"x-amz-date":           amzDate,
+	}
+	if s.sessionToken != "" {
+		headers["x-amz-security-token"] = s.sessionToken
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, sig))
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spoolRetryInterval is how often spooled batches are offered back to the
+// backend.
+const spoolRetryInterval = 5 * time.Second
+
+// Spool wraps a Store so that jobs the backend fails to enqueue (e.g. while
+// Postgres is unreachable) are written to a local directory instead, and
+// moved into the backend once it recovers.
+type Spool struct {
+	Store
+
+	dir      string
+	maxBytes int64
+	log      *slog.Logger
+
+	mu    sync.Mutex
+	bytes int64
+	files int
+}
+
+// NewSpool wraps st with a spool in dir, picking up batches left there by a
+// previous run.
+func NewSpool(st Store, dir string, maxBytes int64, log *slog.Logger) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	s := &Spool{Store: st, dir: dir, maxBytes: maxBytes, log: log}
+	names, err := s.batches()
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		if fi, err := os.Stat(filepath.Join(dir, n)); err == nil {
+			s.bytes += fi.Size()
+			s.files++
+		}
+	}
+	return s, nil
+}
+
+// Enqueue hands jobs to the backend, spooling them to disk if it fails.
+func (s *Spool) Enqueue(ctx context.Context, jobs ...Job) error {
+	err := s.Store.Enqueue(ctx, jobs...)
+	if err == nil {
+		return nil
+	}
+	if serr := s.write(jobs); serr != nil {
+		return fmt.Errorf("%w (spool: %v)", err, serr)
+	}
+	s.log.Warn("store: enqueue failed, spooled to disk", "jobs", len(jobs), "error", err)
+	return nil
+}
+
+// QueueLen includes jobs still waiting in the spool.
+func (s *Spool) QueueLen(ctx context.Context) (int, error) {
+	n, err := s.Store.QueueLen(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return n + s.spooledJobs(), nil
+}
+
+// RelayQueueLen includes the relay's jobs still waiting in the spool. It
+// returns errors.ErrUnsupported when the backend cannot count per relay.
+func (s *Spool) RelayQueueLen(ctx context.Context, relayID string) (int, error) {
+	rq, ok := s.Store.(RelayQueue)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+	n, err := rq.RelayQueueLen(ctx, relayID)
+	if err != nil {
+		return 0, err
+	}
+	names, err := s.batches()
+	if err != nil {
+		return n, nil
+	}
+	for _, name := range names {
+		b, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			continue
+		}
+		var jobs []struct {
+			RelayID string `json:"relay_id"`
+		}
+		if json.Unmarshal(b, &jobs) != nil {
+			continue
+		}
+		for _, j := range jobs {
+			if j.RelayID == relayID {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *Spool) spooledJobs() int {
+	names, err := s.batches()
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, name := range names {
+		// Batch files are named <nanos>-<jobs>-<random>.json.
+		var ts int64
+		var jobs int
+		if _, err := fmt.Sscanf(name, "%d-%d-", &ts, &jobs); err == nil {
+			n += jobs
+		}
+	}
+	return n
+}
+
+func (s *Spool) write(jobs []Job) error {
+	b, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxBytes > 0 && s.bytes+int64(len(b)) > s.maxBytes {
+		return fmt.Errorf("spool is full (%d bytes)", s.bytes)
+	}
+
+	var rnd [6]byte
+	_, _ = rand.Read(rnd[:])
+	name := fmt.Sprintf("%020d-%d-%s.json", time.Now().UnixNano(), len(jobs), hex.EncodeToString(rnd[:]))
+	tmp := filepath.Join(s.dir, name+".tmp")
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	s.bytes += int64(len(b))
+	s.files++
+	return nil
+}
+
+// batches lists spooled batch files, oldest first.
+func (s *Spool) batches() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Run moves spooled batches into the backend until ctx is done.
+func (s *Spool) Run(ctx context.Context) {
+	t := time.NewTicker(spoolRetryInterval)
+	defer t.Stop()
+	for {
+		s.drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (s *Spool) drain(ctx context.Context) {
+	names, err := s.batches()
+	if err != nil {
+		s.log.Error("spool: list failed", "error", err)
+		return
+	}
+	moved := 0
+	for _, name := range names {
+		p := filepath.Join(s.dir, name)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			s.log.Error("spool: read failed", "file", name, "error", err)
+			continue
+		}
+		var jobs []Job
+		if err := json.Unmarshal(b, &jobs); err != nil {
+			s.log.Error("spool: undecodable batch, leaving it in place", "file", name, "error", err)
+			continue
+		}
+		// Stop at the first failure: the backend is still down.
+		if err := s.Store.Enqueue(ctx, jobs...); err != nil {
+			return
+		}
+		if err := os.Remove(p); err != nil {
+			s.log.Error("spool: remove failed", "file", name, "error", err)
+			return
+		}
+		s.mu.Lock()
+		s.bytes -= int64(len(b))
+		s.files--
+		s.mu.Unlock()
+		moved += len(jobs)
+	}
+	if moved > 0 {
+		s.log.Info("spool: replayed jobs into storage", "jobs", moved)
+	}
+}
@@ -0,0 +1,416 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL stores everything in a database/sql database. The same schema and
+// queries serve SQLite and Postgres; only placeholders differ.
+type SQL struct {
+	db       *sql.DB
+	postgres bool
+}
+
+// Times are stored as Unix nanoseconds so both dialects compare them the same way.
+var sqlSchema = []string{
+	`CREATE TABLE IF NOT EXISTS queue (
+		id TEXT PRIMARY KEY,
+		received_at BIGINT NOT NULL,
+		available_at BIGINT NOT NULL,
+		job TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS queue_order ON queue (received_at, id)`,
+	`CREATE TABLE IF NOT EXISTS deliveries (
+		id TEXT PRIMARY KEY,
+		request_id TEXT NOT NULL,
+		relay TEXT NOT NULL,
+		dest_url TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		outcome TEXT NOT NULL,
+		error TEXT NOT NULL,
+		latency_ms BIGINT NOT NULL,
+		received_at BIGINT NOT NULL,
+		at BIGINT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS deliveries_at ON deliveries (at)`,
+	`CREATE INDEX IF NOT EXISTS deliveries_request_id ON deliveries (request_id)`,
+	`CREATE TABLE IF NOT EXISTS dead_letters (
+		id TEXT PRIMARY KEY,
+		reason TEXT NOT NULL,
+		error TEXT NOT NULL,
+		at BIGINT NOT NULL,
+		job TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS snapshots (
+		hash TEXT NOT NULL,
+		at BIGINT NOT NULL,
+		config TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS alert_states (
+		rule TEXT PRIMARY KEY,
+		since BIGINT NOT NULL,
+		details TEXT NOT NULL
+	)`,
+}
+
+// sqlColumns were added to existing tables after their first release.
+var sqlColumns = []struct{ table, column, def string }{
+	{"deliveries", "provider", "TEXT NOT NULL DEFAULT ''"},
+	{"deliveries", "event_type", "TEXT NOT NULL DEFAULT ''"},
+	// lane 1 holds backfilled jobs, dequeued after every live one.
+	{"queue", "lane", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// sqlIndexes cover sqlColumns, so they are created after them.
+var sqlIndexes = []string{
+	`CREATE INDEX IF NOT EXISTS queue_lane_order ON queue (lane, received_at, id)`,
+}
+
+func newSQL(ctx context.Context, db *sql.DB, postgres bool) (*SQL, error) {
+	s := &SQL{db: db, postgres: postgres}
+	for _, stmt := range sqlSchema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	for _, c := range sqlColumns {
+		if err := s.addColumn(ctx, c.table, c.column, c.def); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	for _, stmt := range sqlIndexes {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// addColumn adds a column unless the table already has it. SQLite has no
+// ADD COLUMN IF NOT EXISTS, so its "duplicate column" error is ignored.
+func (s *SQL) addColumn(ctx context.Context, table, column, def string) error {
+	if s.postgres {
+		_, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column, def))
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	if err != nil && strings.Contains(err.Error(), "duplicate column") {
+		return nil
+	}
+	return err
+}
+
+// q rewrites ? placeholders for the Postgres driver.
+func (s *SQL) q(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s *SQL) Enqueue(ctx context.Context, jobs ...Job) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, j := range jobs {
+		b, err := json.Marshal(j)
+		if err != nil {
+			return err
+		}
+		lane := 0
+		if j.Backfill {
+			lane = 1
+		}
+		// received_at, named before backfill, is the job's place in its lane.
+		if _, err := tx.ExecContext(ctx, s.q(`INSERT INTO queue (id, lane, received_at, available_at, job) VALUES (?, ?, ?, 0, ?)`), j.ID, lane, nanos(j.queuedAt()), string(b)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQL) Dequeue(ctx context.Context, lease time.Duration) (Job, bool, error) {
+	// Claim optimistically: pick the oldest available row, then move its
+	// available_at forward only if nobody else claimed it in between.
+	for attempt := 0; attempt < 5; attempt++ {
+		now := time.Now()
+		var (
+			id        string
+			available int64
+			raw       string
+		)
+		err := s.db.QueryRowContext(ctx, s.q(`SELECT id, available_at, job FROM queue WHERE available_at <= ? ORDER BY lane, received_at, id LIMIT 1`), now.UnixNano()).Scan(&id, &available, &raw)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, false, nil
+		}
+		if err != nil {
+			return Job{}, false, err
+		}
+
+		res, err := s.db.ExecContext(ctx, s.q(`UPDATE queue SET available_at = ? WHERE id = ? AND available_at = ?`), now.Add(lease).UnixNano(), id, available)
+		if err != nil {
+			return Job{}, false, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+
+		var j Job
+		if err := json.Unmarshal([]byte(raw), &j); err != nil {
+			// It could never be delivered, and would be dequeued again
+			// after every lease.
+			if _, derr := s.db.ExecContext(ctx, s.q(`DELETE FROM queue WHERE id = ?`), id); derr != nil {
+				return Job{}, false, fmt.Errorf("decode job %s: %w (and removing it: %v)", id, err, derr)
+			}
+			return Job{}, false, fmt.Errorf("decode job %s: %w; removed it from the queue", id, err)
+		}
+		return j, true, nil
+	}
+	return Job{}, false, nil
+}
+
+func (s *SQL) Ack(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM queue WHERE id = ?`), id)
+	return err
+}
+
+func (s *SQL) QueueLen(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM queue`).Scan(&n)
+	return n, err
+}
+
+func (s *SQL) RelayQueueLen(ctx context.Context, relayID string) (int, error) {
+	query := `SELECT COUNT(*) FROM queue WHERE json_extract(job, '$.relay_id') = ?`
+	if s.postgres {
+		query = `SELECT COUNT(*) FROM queue WHERE job::jsonb->>'relay_id' = ?`
+	}
+	var n int
+	err := s.db.QueryRowContext(ctx, s.q(query), relayID).Scan(&n)
+	return n, err
+}
+
+func (s *SQL) RecordDelivery(ctx context.Context, d Delivery) error {
+	_, err := s.db.ExecContext(ctx, s.q(`INSERT INTO deliveries (id, request_id, relay, dest_url, provider, event_type, status, outcome, error, latency_ms, received_at, at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		d.ID, d.RequestID, d.Relay, d.DestURL, d.Provider, d.EventType, d.Status, d.Outcome, d.Error, d.LatencyMS, nanos(d.ReceivedAt), nanos(d.At))
+	return err
+}
+
+func (s *SQL) ListDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if f.Relay != "" {
+		where, args = append(where, "relay = ?"), append(args, f.Relay)
+	}
+	if f.Outcome != "" {
+		where, args = append(where, "outcome = ?"), append(args, f.Outcome)
+	}
+	if f.RequestID != "" {
+		where, args = append(where, "request_id = ?"), append(args, f.RequestID)
+	}
+	if f.DestURL != "" {
+		where, args = append(where, "dest_url = ?"), append(args, f.DestURL)
+	}
+	if f.Provider != "" {
+		where, args = append(where, "provider = ?"), append(args, f.Provider)
+	}
+	if f.EventType != "" {
+		where, args = append(where, "event_type = ?"), append(args, f.EventType)
+	}
+	if !f.Since.IsZero() {
+		where, args = append(where, "at >= ?"), append(args, nanos(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where, args = append(where, "at < ?"), append(args, nanos(f.Until))
+	}
+	if c := f.After; c != nil {
+		where, args = append(where, "(at < ? OR (at = ? AND id < ?))"), append(args, nanos(c.At), nanos(c.At), c.ID)
+	}
+
+	query := `SELECT id, request_id, relay, dest_url, provider, event_type, status, outcome, error, latency_ms, received_at, at FROM deliveries`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY at DESC, id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Delivery
+	for rows.Next() {
+		var (
+			d            Delivery
+			received, at int64
+		)
+		if err := rows.Scan(&d.ID, &d.RequestID, &d.Relay, &d.DestURL, &d.Provider, &d.EventType, &d.Status, &d.Outcome, &d.Error, &d.LatencyMS, &received, &at); err != nil {
+			return nil, err
+		}
+		d.ReceivedAt, d.At = fromNanos(received), fromNanos(at)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQL) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM deliveries WHERE at < ?`), nanos(before))
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (s *SQL) PutDeadLetter(ctx context.Context, dl DeadLetter) error {
+	b, err := json.Marshal(dl.Job)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.q(`INSERT INTO dead_letters (id, reason, error, at, job) VALUES (?, ?, ?, ?, ?)`), dl.Job.ID, dl.Reason, dl.Error, nanos(dl.At), string(b))
+	return err
+}
+
+func (s *SQL) ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	query := `SELECT reason, error, at, job FROM dead_letters ORDER BY at DESC, id DESC`
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DeadLetter
+	for rows.Next() {
+		var (
+			dl  DeadLetter
+			at  int64
+			raw string
+		)
+		if err := rows.Scan(&dl.Reason, &dl.Error, &at, &raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(raw), &dl.Job); err != nil {
+			return nil, err
+		}
+		dl.At = fromNanos(at)
+		out = append(out, dl)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQL) CountDeadLetters(ctx context.Context) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM dead_letters`).Scan(&n)
+	return n, err
+}
+
+func (s *SQL) DeleteDeadLetter(ctx context.Context, dl DeadLetter) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM dead_letters WHERE id = ?`), dl.Job.ID)
+	return err
+}
+
+func (s *SQL) SaveSnapshot(ctx context.Context, snap Snapshot) error {
+	_, err := s.db.ExecContext(ctx, s.q(`INSERT INTO snapshots (hash, at, config) VALUES (?, ?, ?)`), snap.Hash, nanos(snap.At), string(snap.Config))
+	return err
+}
+
+func (s *SQL) LatestSnapshot(ctx context.Context) (Snapshot, error) {
+	var (
+		snap Snapshot
+		at   int64
+		raw  string
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT hash, at, config FROM snapshots ORDER BY at DESC LIMIT 1`).Scan(&snap.Hash, &at, &raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Snapshot{}, ErrNotFound
+	}
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snap.At, snap.Config = fromNanos(at), []byte(raw)
+	return snap, nil
+}
+
+func (s *SQL) PruneSnapshots(ctx context.Context, keep int) (int, error) {
+	// With keep or fewer rows the subquery is NULL and nothing matches.
+	res, err := s.db.ExecContext(ctx, s.q(`DELETE FROM snapshots WHERE at < (SELECT at FROM snapshots ORDER BY at DESC LIMIT 1 OFFSET ?)`), keep-1)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQL) PutAlertState(ctx context.Context, st AlertState) error {
+	b, err := json.Marshal(st.Details)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.q(`INSERT INTO alert_states (rule, since, details) VALUES (?, ?, ?)
+		ON CONFLICT (rule) DO UPDATE SET since = excluded.since, details = excluded.details`), st.Rule, nanos(st.Since), string(b))
+	return err
+}
+
+func (s *SQL) DeleteAlertState(ctx context.Context, rule string) error {
+	_, err := s.db.ExecContext(ctx, s.q(`DELETE FROM alert_states WHERE rule = ?`), rule)
+	return err
+}
+
+func (s *SQL) ListAlertStates(ctx context.Context) ([]AlertState, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT rule, since, details FROM alert_states`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []AlertState
+	for rows.Next() {
+		var (
+			st    AlertState
+			since int64
+			raw   string
+		)
+		if err := rows.Scan(&st.Rule, &since, &raw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(raw), &st.Details); err != nil {
+			return nil, err
+		}
+		st.Since = fromNanos(since)
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQL) Close() error { return s.db.Close() }
@@ -0,0 +1,27 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// OpenSQLite opens (creating if needed) a SQLite database file.
+func OpenSQLite(ctx context.Context, path string) (*SQL, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, fmt.Errorf("sqlite: path is required")
+	}
+	dsn := "file:" + url.PathEscape(path) + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	// SQLite allows one writer at a time; a single connection avoids
+	// "database is locked" errors between our own goroutines.
+	db.SetMaxOpenConns(1)
+	return newSQL(ctx, db, false)
+}
@@ -0,0 +1,297 @@
+// Package store persists relay state behind one interface: the delivery
+// queue, the delivery log, dead letters and config snapshots. Backends are
+// selected by the storage section of the config.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"webhookrelay/internal/config"
+)
+
+// ErrNotFound is returned when a requested record does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// Job is one pending delivery of an accepted event to one destination.
+type Job struct {
+	ID        string `json:"id"`
+	RequestID string `json:"request_id"`
+	Relay     string `json:"relay"`
+	RelayID   string `json:"relay_id"`
+	Method    string `json:"method"`
+	Query     string `json:"query,omitempty"`
+	// PathSuffix is the request path below a wildcard relay's prefix; it is
+	// appended to http destination URLs.
+	PathSuffix string `json:"path_suffix,omitempty"`
+	// Params are the relay's path parameters, e.g. {"tenant": "acme"}.
+	Params   map[string]string `json:"params,omitempty"`
+	SourceIP string            `json:"source_ip,omitempty"`
+	// Provider and EventType are set when the relay detects providers.
+	Provider    string                   `json:"provider,omitempty"`
+	EventType   string                   `json:"event_type,omitempty"`
+	Header      http.Header              `json:"header,omitempty"`
+	Body        []byte                   `json:"body,omitempty"`
+	Destination config.DestinationConfig `json:"destination"`
+	// Failover lists the destinations to try next, in order, if this one
+	// does not accept the event (the "first_success" strategy).
+	Failover []config.DestinationConfig `json:"failover,omitempty"`
+	// Redact is the relay's redaction for copies of the job kept in history.
+	Redact config.RedactConfig `json:"redact"`
+	// Transform is the relay's transform service, called before delivery.
+	Transform  config.RelayTransformConfig `json:"transform"`
+	ReceivedAt time.Time                   `json:"received_at"`
+	// ExpiresAt and Deadline are zero when unset. Jobs past ExpiresAt are
+	// dead-lettered as expired; Deadline also bounds the delivery attempt.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Deadline  time.Time `json:"deadline,omitempty"`
+	// Trace is the trace context (traceparent, ...) of the inbound request,
+	// continued by the delivery. Without tracing, it is the inbound (or a
+	// generated) traceparent, passed on as is.
+	Trace map[string]string `json:"trace,omitempty"`
+	// LogSampleRate is the relay's log sampling of successful deliveries.
+	LogSampleRate int `json:"log_sample_rate,omitempty"`
+	// ForwardTimeoutMS and Concurrency are the relay's forward timeout and
+	// limit of deliveries in flight.
+	ForwardTimeoutMS int `json:"forward_timeout_ms,omitempty"`
+	Concurrency      int `json:"concurrency,omitempty"`
+	// AcceptedAt is when the relay accepted the event, which orders the
+	// queue; ReceivedAt differs from it for backfilled events, which queue
+	// behind every live one.
+	AcceptedAt time.Time `json:"accepted_at,omitempty"`
+	Backfill   bool      `json:"backfill,omitempty"`
+}
+
+// queuedAt orders j within its lane of the queue. Jobs queued before
+// AcceptedAt was recorded fall back to ReceivedAt.
+func (j Job) queuedAt() time.Time {
+	if j.AcceptedAt.IsZero() {
+		return j.ReceivedAt
+	}
+	return j.AcceptedAt
+}
+
+// queuedBefore reports whether j is dequeued before k: live jobs first,
+// then backfilled ones, each in the order they were accepted.
+func (j Job) queuedBefore(k Job) bool {
+	if j.Backfill != k.Backfill {
+		return !j.Backfill
+	}
+	return j.queuedAt().Before(k.queuedAt())
+}
+
+// Delivery outcomes recorded in the delivery log.
+const (
+	OutcomeDelivered = "delivered"
+	OutcomeFailed    = "failed"
+	OutcomeTimeout   = "timeout"
+	OutcomeExpired   = "expired"
+	// OutcomeDropped marks events a transform service chose not to deliver.
+	OutcomeDropped = "dropped"
+)
+
+// Delivery is one entry of the delivery log.
+type Delivery struct {
+	ID         string    `json:"id"`
+	RequestID  string    `json:"request_id"`
+	Relay      string    `json:"relay"`
+	DestURL    string    `json:"dest_url"`
+	Provider   string    `json:"provider,omitempty"`
+	EventType  string    `json:"event_type,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	LatencyMS  int64     `json:"latency_ms"`
+	ReceivedAt time.Time `json:"received_at"`
+	At         time.Time `json:"at"`
+}
+
+// DeliveryFilter selects delivery log entries. Zero fields match everything.
+type DeliveryFilter struct {
+	Relay     string
+	Outcome   string
+	RequestID string
+	DestURL   string
+	Provider  string
+	EventType string
+	Since     time.Time
+	Until     time.Time
+	// After continues a listing after the entry it names.
+	After *DeliveryCursor
+	Limit int
+}
+
+// DeliveryCursor names an entry of the delivery log by its position in the
+// listing order: At, then ID, descending.
+type DeliveryCursor struct {
+	At time.Time
+	ID string
+}
+
+// Cursor returns the position of d, to list the entries after it.
+func (d Delivery) Cursor() *DeliveryCursor {
+	return &DeliveryCursor{At: d.At, ID: d.ID}
+}
+
+func (f DeliveryFilter) match(d Delivery) bool {
+	switch {
+	case f.Relay != "" && d.Relay != f.Relay:
+		return false
+	case f.Outcome != "" && d.Outcome != f.Outcome:
+		return false
+	case f.RequestID != "" && d.RequestID != f.RequestID:
+		return false
+	case f.DestURL != "" && d.DestURL != f.DestURL:
+		return false
+	case f.Provider != "" && d.Provider != f.Provider:
+		return false
+	case f.EventType != "" && d.EventType != f.EventType:
+		return false
+	case !f.Since.IsZero() && d.At.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !d.At.Before(f.Until):
+		return false
+	case f.After != nil && !(d.At.Before(f.After.At) || d.At.Equal(f.After.At) && d.ID < f.After.ID):
+		return false
+	}
+	return true
+}
+
+// Dead-letter reasons.
+const (
+	ReasonFailed           = "failed"
+	ReasonExpired          = "expired"
+	ReasonDeadlineExceeded = "deadline_exceeded"
+	ReasonEncodeFailed     = "encode_failed"
+	ReasonURLRejected      = "url_rejected"
+	ReasonTransformFailed  = "transform_failed"
+	// ReasonUnhealthy marks a destination skipped for a healthy failover
+	// because its health check is failing.
+	ReasonUnhealthy = "destination_unhealthy"
+)
+
+// DeadLetter is a job that will not be delivered, with the reason why.
+type DeadLetter struct {
+	Job    Job       `json:"job"`
+	Reason string    `json:"reason"`
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Snapshot is a copy of the config a relay instance started or reloaded
+// with, its credentials removed (see config.RedactedJSON). Hash identifies
+// the config; Config is empty when the config was decrypted on load.
+type Snapshot struct {
+	Hash   string    `json:"hash"`
+	Config []byte    `json:"config,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// Queue holds jobs waiting for delivery, oldest first, with backfilled
+// jobs behind live ones.
+type Queue interface {
+	Enqueue(ctx context.Context, jobs ...Job) error
+	// Dequeue claims the oldest available job for lease. A claimed job that
+	// is not acknowledged within lease becomes available again, so jobs are
+	// delivered at least once across crashes. ok is false when the queue has
+	// nothing available.
+	Dequeue(ctx context.Context, lease time.Duration) (job Job, ok bool, err error)
+	Ack(ctx context.Context, id string) error
+	QueueLen(ctx context.Context) (int, error)
+}
+
+// RelayQueue is implemented by backends that can count the queued jobs of
+// one relay. S3 cannot without fetching every job.
+type RelayQueue interface {
+	RelayQueueLen(ctx context.Context, relayID string) (int, error)
+}
+
+// DeliveryLog records the outcome of every delivery attempt.
+type DeliveryLog interface {
+	RecordDelivery(ctx context.Context, d Delivery) error
+	// ListDeliveries returns matching entries, most recent first.
+	ListDeliveries(ctx context.Context, f DeliveryFilter) ([]Delivery, error)
+	// PruneDeliveries removes entries recorded before the cutoff.
+	PruneDeliveries(ctx context.Context, before time.Time) (int, error)
+}
+
+// DeadLetters keeps jobs that were given up on.
+type DeadLetters interface {
+	PutDeadLetter(ctx context.Context, dl DeadLetter) error
+	// ListDeadLetters returns up to limit entries, most recent first.
+	ListDeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	CountDeadLetters(ctx context.Context) (int, error)
+	// DeleteDeadLetter removes an entry ListDeadLetters returned, once it
+	// has been replayed. An entry that is already gone is not an error.
+	DeleteDeadLetter(ctx context.Context, dl DeadLetter) error
+}
+
+// Snapshots keeps copies of the configs the relay started with.
+type Snapshots interface {
+	SaveSnapshot(ctx context.Context, s Snapshot) error
+	LatestSnapshot(ctx context.Context) (Snapshot, error)
+	// PruneSnapshots deletes all but the keep most recent snapshots.
+	PruneSnapshots(ctx context.Context, keep int) (int, error)
+}
+
+// AlertState is an alert rule that was notified as firing.
+type AlertState struct {
+	Rule string `json:"rule"`
+	// Since is when the trigger was notified.
+	Since   time.Time      `json:"since"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// AlertStates keeps the firing alert rules, so that a restarted relay
+// resolves their incidents once the condition clears.
+type AlertStates interface {
+	PutAlertState(ctx context.Context, s AlertState) error
+	DeleteAlertState(ctx context.Context, rule string) error
+	ListAlertStates(ctx context.Context) ([]AlertState, error)
+}
+
+// Store is implemented by every storage backend.
+type Store interface {
+	Queue
+	DeliveryLog
+	DeadLetters
+	Snapshots
+	AlertStates
+	Close() error
+}
+
+// Open returns the backend selected by cfg.
+func Open(ctx context.Context, cfg config.StorageConfig) (Store, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", config.StorageMemory:
+		return NewMemory(), nil
+	case config.StorageSQLite:
+		return OpenSQLite(ctx, cfg.Path)
+	case config.StoragePostgres:
+		return OpenPostgres(ctx, cfg.DSN)
+	case config.StorageS3:
+		return OpenS3(ctx, cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// nanos encodes t for backends that store times as integers; the zero time
+// is stored as 0.
+func nanos(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromNanos(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n).UTC()
+}
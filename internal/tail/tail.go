@@ -0,0 +1,128 @@
+// Package tail fans relay activity out to live subscribers, such as the
+// admin API's event stream. A nil *Hub publishes nothing.
+package tail
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"webhookrelay/internal/store"
+)
+
+// Event types.
+const (
+	TypeInbound          = "inbound"
+	TypeForwardCompleted = "forward_completed"
+	TypeForwardFailed    = "forward_failed"
+)
+
+// Event is an inbound request answered by a relay, or a delivery attempt.
+type Event struct {
+	Type      string    `json:"type"`
+	At        time.Time `json:"at"`
+	Relay     string    `json:"relay"`
+	RequestID string    `json:"request_id,omitempty"`
+	Status    int       `json:"status,omitempty"`
+
+	// Inbound requests.
+	Method  string `json:"method,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Dropped string `json:"dropped,omitempty"`
+
+	// Delivery attempts.
+	DestURL   string `json:"dest_url,omitempty"`
+	Outcome   string `json:"outcome,omitempty"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+	Provider  string `json:"provider,omitempty"`
+	EventType string `json:"event_type,omitempty"`
+}
+
+// Delivery describes a recorded delivery attempt. Events dropped by a
+// transform service count as completed.
+func Delivery(d store.Delivery) Event {
+	typ := TypeForwardFailed
+	if d.Outcome == store.OutcomeDelivered || d.Outcome == store.OutcomeDropped {
+		typ = TypeForwardCompleted
+	}
+	return Event{
+		Type:      typ,
+		At:        d.At,
+		Relay:     d.Relay,
+		RequestID: d.RequestID,
+		Status:    d.Status,
+		DestURL:   d.DestURL,
+		Outcome:   d.Outcome,
+		Error:     d.Error,
+		LatencyMS: d.LatencyMS,
+		Provider:  d.Provider,
+		EventType: d.EventType,
+	}
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it misses some.
+const subscriberBuffer = 256
+
+// Hub delivers published events to every subscriber, without ever blocking
+// the publisher.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func New() *Hub {
+	return &Hub{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events of one relay, or of all relays.
+type Subscription struct {
+	relay  string
+	ch     chan Event
+	missed atomic.Int64
+}
+
+// Events delivers the subscription's events.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// TakeMissed returns how many events were dropped because the subscriber
+// fell behind since the last call.
+func (s *Subscription) TakeMissed() int64 {
+	return s.missed.Swap(0)
+}
+
+// Subscribe starts receiving the events of relay (by name; empty for all).
+// cancel must be called once the subscriber is done.
+func (h *Hub) Subscribe(relay string) (sub *Subscription, cancel func()) {
+	sub = &Subscription{relay: relay, ch: make(chan Event, subscriberBuffer)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub, func() {
+		h.mu.Lock()
+		delete(h.subs, sub)
+		h.mu.Unlock()
+	}
+}
+
+// Publish hands ev to the subscribers interested in it.
+func (h *Hub) Publish(ev Event) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if sub.relay != "" && sub.relay != ev.Relay {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.missed.Add(1)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package tmpl renders the text/template strings used in relay configuration
+// (message formatters, URL templates, ...) against an inbound event.
+package tmpl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"webhookrelay/internal/payload"
+)
+
+// Data is the value templates are executed against. Keys are lower-case so
+// configs read naturally, e.g. {{ .body.tenant_id }} or {{ index .headers "X-Github-Event" }}.
+type Data map[string]any
+
+// Event describes the inbound request a template is rendered for.
+type Event struct {
+	RequestID  string
+	Relay      string
+	Method     string
+	SourceIP   string
+	Header     http.Header
+	Body       []byte
+	ReceivedAt time.Time
+	// Params are the relay's path parameters.
+	Params map[string]string
+	// Provider and EventType are what the relay's provider detection found.
+	Provider  string
+	EventType string
+}
+
+// NewData builds template data for ev. JSON, form and XML bodies are decoded
+// into .body (see package payload); the raw body is always available as .raw.
+func NewData(ev Event) Data {
+	headers := make(map[string]string, len(ev.Header))
+	for k, vv := range ev.Header {
+		if len(vv) > 0 {
+			headers[k] = vv[0]
+		}
+	}
+
+	params := make(map[string]string, len(ev.Params))
+	for k, v := range ev.Params {
+		params[k] = v
+	}
+
+	body, format, err := payload.Decode(ev.Header.Get("Content-Type"), ev.Body)
+	if err != nil || format == payload.FormatRaw {
+		body = nil
+	}
+
+	return Data{
+		"body":        body,
+		"raw":         string(ev.Body),
+		"headers":     headers,
+		"method":      ev.Method,
+		"source_ip":   ev.SourceIP,
+		"relay":       ev.Relay,
+		"request_id":  ev.RequestID,
+		"received_at": ev.ReceivedAt.UTC().Format(time.RFC3339Nano),
+		"params":      params,
+		"provider":    ev.Provider,
+		"event_type":  ev.EventType,
+	}
+}
+
+var funcs = template.FuncMap{
+	// json renders v as a JSON value, for embedding into JSON templates.
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"truncate": func(n int, s string) string {
+		if len([]rune(s)) <= n {
+			return s
+		}
+		return string([]rune(s)[:n])
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+var cache sync.Map // template text -> *template.Template
+
+// Parse compiles text, reusing a previous compilation of identical text.
+func Parse(text string) (*template.Template, error) {
+	if t, ok := cache.Load(text); ok {
+		return t.(*template.Template), nil
+	}
+	t, err := template.New("").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	cache.Store(text, t)
+	return t, nil
+}
+
+// Render executes text against data.
+func Render(text string, data Data) (string, error) {
+	t, err := Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// RenderJSON executes text against data and checks the result is valid JSON.
+func RenderJSON(text string, data Data) (json.RawMessage, error) {
+	s, err := Render(text, data)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimSpace(s)
+	if !json.Valid([]byte(s)) {
+		return nil, fmt.Errorf("template did not render valid JSON: %.200s", s)
+	}
+	return json.RawMessage(s), nil
+}
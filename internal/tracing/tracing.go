@@ -0,0 +1,130 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace context
+// from an inbound request, through the queue, to the deliveries it causes.
+// Until Setup runs, spans are not recorded and nothing is injected.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"webhookrelay/internal/config"
+)
+
+// Tracer starts the relay's spans.
+var Tracer = otel.Tracer("webhookrelay")
+
+// Setup installs a tracer provider exporting to the configured OTLP
+// collector and the W3C trace context propagator. The returned function
+// flushes pending spans.
+func Setup(ctx context.Context, cfg config.TracingConfig) (shutdown func(context.Context) error, err error) {
+	exp, err := newExporter(ctx, cfg.OTLP)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return tp.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, o config.OTLPConfig) (sdktrace.SpanExporter, error) {
+	if o.Protocol == config.OTLPHTTP {
+		var opts []otlptracehttp.Option
+		if o.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(o.Endpoint))
+		}
+		if len(o.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(o.Headers))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+	var opts []otlptracegrpc.Option
+	if o.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(o.Endpoint))
+	}
+	if len(o.Headers) > 0 {
+		opts = append(opts, otlptracegrpc.WithHeaders(o.Headers))
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+// Extract returns ctx with the trace context of an inbound request's
+// headers.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(h))
+}
+
+// Inject sets traceparent (and tracestate, baggage) on an outgoing request
+// to continue the trace of ctx.
+func Inject(ctx context.Context, h http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Carrier serializes the trace context of ctx for a queued job. It is nil
+// when there is nothing to carry.
+func Carrier(ctx context.Context) map[string]string {
+	c := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, c)
+	if len(c) == 0 {
+		return nil
+	}
+	return c
+}
+
+// FromCarrier restores a trace context saved by Carrier.
+func FromCarrier(ctx context.Context, c map[string]string) context.Context {
+	if len(c) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(c))
+}
+
+// traceparentRE matches a W3C traceparent this relay can pass on: version
+// 00, non-zero trace and parent IDs.
+var traceparentRE = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// PassThrough returns the trace context to send with the deliveries of an
+// inbound request when tracing is not set up: its traceparent and
+// tracestate, or a new sampled traceparent if it has no valid one.
+func PassThrough(h http.Header) map[string]string {
+	tp := h.Get("Traceparent")
+	if m := traceparentRE.FindStringSubmatch(tp); m != nil && m[1] != strings.Repeat("0", 32) && m[2] != strings.Repeat("0", 16) {
+		c := map[string]string{"traceparent": tp}
+		if ts := h.Get("Tracestate"); ts != "" {
+			c["tracestate"] = ts
+		}
+		return c
+	}
+	return map[string]string{"traceparent": "00-" + randomHex(16) + "-" + randomHex(8) + "-01"}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,354 @@
+package verify
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"webhookrelay/internal/config"
+	"webhookrelay/internal/plugin"
+	"webhookrelay/internal/relay"
+	"webhookrelay/internal/script"
+	"webhookrelay/internal/server"
+	"webhookrelay/internal/store"
+)
+
+// drainTimeout bounds how long a scenario waits for its deliveries.
+const drainTimeout = 30 * time.Second
+
+// Result is the outcome of one scenario; it passed when Problems is empty.
+type Result struct {
+	Name     string
+	Problems []string
+}
+
+// Run executes every scenario in f against cfg. Storage, journal, alerts and
+// the admin API are replaced or disabled so nothing outside the process is
+// touched, and every delivery goes to the embedded receiver.
+func Run(cfg config.Config, f File, log *slog.Logger) ([]Result, error) {
+	cfg.Storage = config.StorageConfig{Backend: config.StorageMemory}
+	cfg.Journal = config.JournalConfig{}
+	cfg.Alerts = config.AlertsConfig{}
+	cfg.Admin = config.AdminConfig{}
+
+	relays, err := config.ResolveRelays(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	st := store.NewMemory()
+	rcv := &receiver{}
+	fwd := relay.NewForwarder(relay.ForwarderConfig{
+		Logger:         log,
+		Store:          st,
+		Concurrency:    cfg.Server.Concurrency,
+		ForwardTimeout: cfg.Server.ForwardTimeout(),
+		Transport:      rcv,
+	})
+	plugins, err := plugin.LoadAll(context.Background(), relays)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plugins {
+		defer p.Close(context.Background())
+	}
+
+	scripts, err := script.LoadAll(relays, log)
+	if err != nil {
+		return nil, err
+	}
+
+	h := server.New(server.Config{
+		Logger:    log,
+		Relays:    relays,
+		Forwarder: fwd,
+		Deadline:  cfg.Server.Deadline,
+		Plugins:   plugins,
+		Scripts:   scripts,
+	}).Handler()
+	fwd.SetLocalRelays(h, relays)
+	fwd.UpdateTimeouts(relays)
+	fwd.Start()
+	defer fwd.Stop()
+
+	results := make([]Result, 0, len(f.Scenarios))
+	for _, sc := range f.Scenarios {
+		results = append(results, runScenario(sc, relays, h, st, rcv))
+	}
+	return results, nil
+}
+
+func runScenario(sc Scenario, relays []config.ResolvedRelay, h http.Handler, st store.Store, rcv *receiver) Result {
+	res := Result{Name: sc.Name}
+	fail := func(format string, args ...any) {
+		res.Problems = append(res.Problems, fmt.Sprintf(format, args...))
+	}
+
+	status := sc.ReceiverStatus
+	if status == 0 {
+		status = http.StatusOK
+	}
+	rcv.reset(status)
+
+	req, err := buildRequest(sc.Request, relays)
+	if err != nil {
+		fail("%v", err)
+		return res
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	want := sc.Expect.Status
+	if want == 0 {
+		want = http.StatusAccepted
+	}
+	if rec.Code != want {
+		fail("intake status: got %d, want %d (%s)", rec.Code, want, strings.TrimSpace(rec.Body.String()))
+	}
+
+	if err := drain(st); err != nil {
+		fail("%v", err)
+		return res
+	}
+	got := rcv.deliveries()
+
+	if sc.Expect.Total != nil && len(got) != *sc.Expect.Total {
+		fail("total deliveries: got %d, want %d (%s)", len(got), *sc.Expect.Total, urls(got))
+	}
+	for _, e := range sc.Expect.Deliveries {
+		checkDelivery(e, got, fail)
+	}
+	return res
+}
+
+func buildRequest(r Request, relays []config.ResolvedRelay) (*http.Request, error) {
+	target := r.Path
+	if r.Relay != "" {
+		for _, rl := range relays {
+			if rl.Name == r.Relay && rl.PathRegex != nil {
+				return nil, fmt.Errorf("request: relay %q has a listen_path_regex; give a path instead", r.Relay)
+			}
+			if rl.Name == r.Relay {
+				target = rl.ListenPath
+			}
+		}
+		if target == "" {
+			return nil, fmt.Errorf("request: no relay named %q", r.Relay)
+		}
+	}
+	if r.Query != "" {
+		target += "?" + strings.TrimPrefix(r.Query, "?")
+	}
+
+	body := []byte(r.Body)
+	if r.JSON != nil {
+		b, err := json.Marshal(r.JSON)
+		if err != nil {
+			return nil, fmt.Errorf("request json: %w", err)
+		}
+		body = b
+	}
+	method := r.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req := httptest.NewRequest(strings.ToUpper(method), target, bytes.NewReader(body))
+	if r.JSON != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// drain waits until every queued job has been delivered or dead-lettered.
+func drain(st store.Store) error {
+	deadline := time.Now().Add(drainTimeout)
+	for {
+		n, err := st.QueueLen(context.Background())
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d jobs still queued after %s", n, drainTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func checkDelivery(e Delivery, got []captured, fail func(string, ...any)) {
+	var match []captured
+	for _, d := range got {
+		if d.url == e.URL && (e.Method == "" || strings.EqualFold(d.method, e.Method)) {
+			match = append(match, d)
+		}
+	}
+	want := 1
+	if e.Count != nil {
+		want = *e.Count
+	}
+	if len(match) != want {
+		fail("deliveries to %s: got %d, want %d (delivered to: %s)", e.URL, len(match), want, urls(got))
+		return
+	}
+
+	var wantJSON any
+	if e.JSON != nil {
+		wantJSON = normalize(e.JSON)
+	}
+	for i, d := range match {
+		where := fmt.Sprintf("%s delivery %d", e.URL, i+1)
+		for _, k := range sortedKeys(e.Headers) {
+			if v := d.header.Get(k); v != e.Headers[k] {
+				fail("%s: header %s: got %q, want %q", where, k, v, e.Headers[k])
+			}
+		}
+		if e.Body != nil && string(d.body) != *e.Body {
+			fail("%s: body: got %s, want %s", where, d.body, *e.Body)
+		}
+		for _, sub := range e.Contains {
+			if !bytes.Contains(d.body, []byte(sub)) {
+				fail("%s: body does not contain %q", where, sub)
+			}
+		}
+		if wantJSON != nil {
+			var v any
+			if err := json.Unmarshal(d.body, &v); err != nil {
+				fail("%s: body is not JSON: %v", where, err)
+			} else if path, ok := contains(v, wantJSON, "$"); !ok {
+				fail("%s: json mismatch at %s: got %s", where, path, d.body)
+			}
+		}
+	}
+}
+
+// normalize round-trips a YAML value through JSON so it compares equal to
+// decoded delivery bodies (float64 numbers, map[string]any objects).
+func normalize(v any) any {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out any
+	_ = json.Unmarshal(b, &out)
+	return out
+}
+
+// contains reports whether want is contained in got, and where not.
+func contains(got, want any, path string) (string, bool) {
+	wm, ok := want.(map[string]any)
+	if !ok {
+		return path, reflect.DeepEqual(got, want)
+	}
+	gm, ok := got.(map[string]any)
+	if !ok {
+		return path, false
+	}
+	for _, k := range sortedKeys(wm) {
+		gv, ok := gm[k]
+		if !ok {
+			return path + "." + k, false
+		}
+		if p, ok := contains(gv, wm[k], path+"."+k); !ok {
+			return p, false
+		}
+	}
+	return "", true
+}
+
+func urls(ds []captured) string {
+	if len(ds) == 0 {
+		return "nothing"
+	}
+	out := make([]string, len(ds))
+	for i, d := range ds {
+		out[i] = d.method + " " + d.url
+	}
+	return strings.Join(out, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type captured struct {
+	method string
+	url    string
+	header http.Header
+	body   []byte
+}
+
+// receiver is the embedded destination: it records every delivery instead
+// of sending it.
+type receiver struct {
+	mu     sync.Mutex
+	status int
+	got    []captured
+}
+
+func (r *receiver) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		_ = req.Body.Close()
+		body = b
+	}
+	// Assertions are about content, so compressed bodies are compared
+	// decompressed.
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		if zr, err := gzip.NewReader(bytes.NewReader(body)); err == nil {
+			if plain, err := io.ReadAll(zr); err == nil {
+				body = plain
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.got = append(r.got, captured{method: req.Method, url: req.URL.String(), header: req.Header.Clone(), body: body})
+	status := r.status
+	r.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func (r *receiver) reset(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status, r.got = status, nil
+}
+
+func (r *receiver) deliveries() []captured {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]captured(nil), r.got...)
+}
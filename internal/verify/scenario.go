@@ -0,0 +1,95 @@
+// Package verify runs acceptance scenarios against a relay configuration:
+// each scenario sends an inbound request through the real intake and
+// delivery path and checks what an embedded receiver got.
+package verify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is a scenario file.
+type File struct {
+	Scenarios []Scenario `yaml:"scenarios"`
+}
+
+type Scenario struct {
+	Name string `yaml:"name"`
+	// ReceiverStatus is what the embedded receiver answers (default 200).
+	ReceiverStatus int     `yaml:"receiver_status"`
+	Request        Request `yaml:"request"`
+	Expect         Expect  `yaml:"expect"`
+}
+
+// Request is the inbound request. Relay (a relay name) or Path selects the
+// relay; Body and JSON are alternatives.
+type Request struct {
+	Relay   string            `yaml:"relay"`
+	Path    string            `yaml:"path"`
+	Method  string            `yaml:"method"`
+	Query   string            `yaml:"query"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	JSON    any               `yaml:"json"`
+}
+
+type Expect struct {
+	// Status is the expected intake response status (default 202).
+	Status int `yaml:"status"`
+	// Total, when set, is the expected number of deliveries overall.
+	Total      *int       `yaml:"total"`
+	Deliveries []Delivery `yaml:"deliveries"`
+}
+
+// Delivery describes the deliveries expected at one URL.
+type Delivery struct {
+	URL    string `yaml:"url"`
+	Method string `yaml:"method"`
+	// Count is the expected number of deliveries to URL (default 1).
+	Count   *int              `yaml:"count"`
+	Headers map[string]string `yaml:"headers"`
+	// Body must equal the delivered body exactly.
+	Body *string `yaml:"body"`
+	// JSON must be contained in the delivered JSON body: objects may have
+	// extra keys, everything else must be equal.
+	JSON any `yaml:"json"`
+	// Contains lists substrings of the delivered body.
+	Contains []string `yaml:"contains"`
+}
+
+// Load reads and checks a scenario file.
+func Load(path string) (File, error) {
+	var f File
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&f); err != nil {
+		return f, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if len(f.Scenarios) == 0 {
+		return f, fmt.Errorf("%s: no scenarios", path)
+	}
+	for i, sc := range f.Scenarios {
+		if sc.Name == "" {
+			return f, fmt.Errorf("%s: scenarios[%d].name is required", path, i)
+		}
+		if (sc.Request.Relay == "") == (sc.Request.Path == "") {
+			return f, fmt.Errorf("%s: scenario %q: request needs exactly one of relay or path", path, sc.Name)
+		}
+		if sc.Request.Body != "" && sc.Request.JSON != nil {
+			return f, fmt.Errorf("%s: scenario %q: request body and json are mutually exclusive", path, sc.Name)
+		}
+		for di, d := range sc.Expect.Deliveries {
+			if d.URL == "" {
+				return f, fmt.Errorf("%s: scenario %q: expect.deliveries[%d].url is required", path, sc.Name, di)
+			}
+		}
+	}
+	return f, nil
+}